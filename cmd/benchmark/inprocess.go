@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+
+	"orly.dev/pkg/app/relay"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/log"
+)
+
+// startRelay starts an orly relay in this process, with the default
+// configuration and its event store in a temporary directory, listening on a
+// free port of the loopback interface.
+//
+// # Return Values
+//
+//   - url: The websocket URL of the relay.
+//
+//   - stop: Shuts down the relay and removes the temporary directory.
+//
+//   - err: An error if the relay could not be started.
+//
+// # Expected Behaviour
+//
+// The spider is disabled, so the relay doesn't connect anywhere, and none of
+// the configuration of the user or the environment is read.
+func startRelay(logLevel string) (url string, stop func(), err error) {
+	var dir string
+	if dir, err = os.MkdirTemp("", "orly-benchmark-*"); chk.E(err) {
+		return
+	}
+	var server *relay.Server
+	if server, err = relay.StartEmbedded(
+		context.Bg(), dir, 0, logLevel,
+	); chk.E(err) {
+		chk.E(os.RemoveAll(dir))
+		return
+	}
+	url = "ws://" + server.Addr
+	log.I.F("started relay at %s with its data in %s", url, dir)
+	stop = func() {
+		server.Shutdown()
+		chk.E(os.RemoveAll(dir))
+	}
+	return
+}
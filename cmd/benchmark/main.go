@@ -1,157 +1,139 @@
+// Command benchmark measures the publishing, query and live delivery
+// performance of a nostr relay with workload profiles that resemble the
+// traffic of real clients, reporting the rates and latency percentiles of each
+// measurement as a table, and optionally as JSON for comparison across
+// commits.
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
-	"sync"
-	"sync/atomic"
-	"time"
+	"math/rand"
+	"os"
+	"strings"
 
-	"orly.dev/pkg/encoders/filter"
-	"orly.dev/pkg/encoders/kind"
-	"orly.dev/pkg/encoders/kinds"
-	"orly.dev/pkg/encoders/timestamp"
-	"orly.dev/pkg/protocol/ws"
+	"orly.dev/pkg/utils/chk"
 	"orly.dev/pkg/utils/context"
-	"orly.dev/pkg/utils/log"
 	"orly.dev/pkg/utils/lol"
 )
 
 func main() {
+	var names []string
+	for _, p := range profiles {
+		names = append(names, p.name)
+	}
 	var (
-		relayURL    = flag.String("relay", "ws://localhost:3334", "Relay URL")
-		eventCount  = flag.Int("events", 10000, "Number of events")
-		queryCount  = flag.Int("queries", 100, "Number of queries")
-		concurrency = flag.Int("concurrency", 10, "Concurrent publishers")
-		verbose     = flag.Bool("v", false, "Verbose output")
+		relayURL  = flag.String("relay", "ws://localhost:3334", "Relay URL")
+		inProcess = flag.Bool(
+			"inprocess", false,
+			"start an orly relay in this process with its data in a temporary directory instead of connecting to -relay",
+		)
+		profileList = flag.String(
+			"profiles", strings.Join(names, ","),
+			"comma separated workload profiles to run, in order",
+		)
+		out = flag.String(
+			"json", "", "file to write the results to as JSON, - for stdout",
+		)
+		verbose = flag.Bool("v", false, "Verbose output")
+		p       params
+	)
+	flag.IntVar(&p.Events, "events", 10000, "Number of events")
+	flag.IntVar(&p.Users, "users", 500, "Number of users of the social graph")
+	flag.IntVar(&p.Queries, "queries", 1000, "Number of queries of each profile")
+	flag.IntVar(
+		&p.Subscribers, "subscribers", 20,
+		"Concurrent subscribers that run queries and receive live events",
+	)
+	flag.IntVar(&p.Concurrency, "concurrency", 10, "Concurrent publishers")
+	flag.IntVar(
+		&p.Versions, "versions", 10,
+		"Versions of each replaceable event of the replaceable profile",
 	)
+	flag.IntVar(
+		&p.Fanout, "fanout", 100,
+		"Events published to the live subscribers of the fanout profile",
+	)
+	flag.Int64Var(&p.Seed, "seed", 1, "Seed of the generated workloads")
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(
+			flag.CommandLine.Output(), "Usage: %s [flags]\n\nProfiles:\n",
+			os.Args[0],
+		)
+		for _, p := range profiles {
+			_, _ = fmt.Fprintf(
+				flag.CommandLine.Output(), "  %-12s %s\n", p.name,
+				p.description,
+			)
+		}
+		_, _ = fmt.Fprintln(flag.CommandLine.Output(), "\nFlags:")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
-
+	p.Concurrency = max(p.Concurrency, 1)
+	p.Subscribers = max(p.Subscribers, 1)
+	// the websocket client logs the cancellation of every connection it closes
+	// as an error, so only fatal errors are logged unless -v is given; failed
+	// operations are counted in the results.
 	if *verbose {
 		lol.SetLogLevel("trace")
+	} else {
+		lol.SetLogLevel("fatal")
 	}
-
-	c := context.Bg()
-
-	if *eventCount > 0 {
-		fmt.Printf("Publishing %d events...\n", *eventCount)
-		publishEvents(c, *relayURL, *eventCount, *concurrency)
-	}
-
-	if *queryCount > 0 {
-		fmt.Printf("Executing %d queries...\n", *queryCount)
-		runQueries(c, *relayURL, *queryCount)
-	}
-}
-
-func publishEvents(c context.T, relayURL string, eventCount, concurrency int) {
-	signers := make([]*testSigner, concurrency)
-	for i := range signers {
-		signers[i] = newTestSigner()
-	}
-
-	var published atomic.Int64
-	var errors atomic.Int64
-	var wg sync.WaitGroup
-
-	eventsPerPublisher := eventCount / concurrency
-	extraEvents := eventCount % concurrency
-	startTime := time.Now()
-
-	for i := 0; i < concurrency; i++ {
-		wg.Add(1)
-		go func(id int) {
-			defer wg.Done()
-
-			relay, err := ws.RelayConnect(c, relayURL)
-			if err != nil {
-				log.E.F("Failed to connect: %v", err)
-				errors.Add(1)
-				return
-			}
-			defer relay.Close()
-
-			count := eventsPerPublisher
-			if id < extraEvents {
-				count++
-			}
-
-			for j := 0; j < count; j++ {
-				ev := generateSimpleEvent(signers[id], 1024)
-				if err := relay.Publish(c, ev); err != nil {
-					errors.Add(1)
-					continue
-				}
-				published.Add(1)
+	var run []profile
+	for _, name := range strings.Split(*profileList, ",") {
+		var found bool
+		for _, p := range profiles {
+			if p.name == strings.TrimSpace(name) {
+				run = append(run, p)
+				found = true
 			}
-		}(i)
+		}
+		if !found {
+			_, _ = fmt.Fprintf(os.Stderr, "unknown profile '%s'\n", name)
+			flag.Usage()
+			os.Exit(2)
+		}
 	}
-
-	wg.Wait()
-	duration := time.Since(startTime)
-	rate := float64(published.Load()) / duration.Seconds()
-	fmt.Printf("  Published: %d\n", published.Load())
-	fmt.Printf("  Duration: %.2fs\n", duration.Seconds())
-	fmt.Printf("  Rate: %.2f events/s\n", rate)
-	if errors.Load() > 0 {
-		fmt.Printf("  Errors: %d\n", errors.Load())
+	url := *relayURL
+	if *inProcess {
+		level := "fatal"
+		if *verbose {
+			level = "trace"
+		}
+		var stop func()
+		var err error
+		if url, stop, err = startRelay(level); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "failed to start relay: %v\n", err)
+			os.Exit(1)
+		}
+		defer stop()
 	}
-}
-
-func runQueries(c context.T, relayURL string, queryCount int) {
-	relay, err := ws.RelayConnect(c, relayURL)
-	if err != nil {
-		log.E.F("Failed to connect: %v", err)
-		return
+	b := &bench{
+		c:   context.Bg(),
+		url: url,
+		p:   p,
+		rng: rand.New(rand.NewSource(p.Seed)),
 	}
-	defer relay.Close()
-
-	var totalEvents atomic.Int64
-	startTime := time.Now()
-
-	for i := 0; i < queryCount; i++ {
-		f := generateQueryFilter(i)
-		events, err := relay.QuerySync(c, f)
-		if err != nil {
-			continue
+	report := newReport(url, *inProcess, p)
+	for _, pr := range run {
+		fmt.Printf("Running %s: %s...\n", pr.name, pr.description)
+		if err := pr.run(b); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "%s failed: %v\n", pr.name, err)
 		}
-		totalEvents.Add(int64(len(events)))
 	}
-
-	duration := time.Since(startTime)
-	rate := float64(queryCount) / duration.Seconds()
-	fmt.Printf("  Executed: %d\n", queryCount)
-	fmt.Printf("  Duration: %.2fs\n", duration.Seconds())
-	fmt.Printf("  Rate: %.2f queries/s\n", rate)
-	fmt.Printf("  Events returned: %d\n", totalEvents.Load())
-}
-
-func generateQueryFilter(index int) *filter.F {
-	limit := uint(100)
-	switch index % 5 {
-	case 0:
-		// Query all events by kind
-		return &filter.F{Kinds: kinds.New(kind.TextNote), Limit: &limit}
-	case 1:
-		// Query recent events
-		now := timestamp.Now()
-		since := timestamp.New(now.I64() - 3600)
-		return &filter.F{Since: since, Until: now, Limit: &limit}
-	case 2:
-		// Query all events (no filter)
-		return &filter.F{Limit: &limit}
-	case 3:
-		// Query by multiple kinds
-		return &filter.F{Kinds: kinds.New(kind.TextNote, kind.Repost, kind.Reaction), Limit: &limit}
-	default:
-		// Query older events
-		now := timestamp.Now()
-		until := timestamp.New(now.I64() - 1800)
-		since := timestamp.New(now.I64() - 7200)
-		return &filter.F{
-			Since: since,
-			Until: until,
-			Limit: &limit,
+	report.Results = b.results
+	printResults(os.Stdout, report.Results)
+	if *out != "" {
+		j, err := json.MarshalIndent(report, "", "  ")
+		if chk.E(err) {
+			return
+		}
+		if *out == "-" {
+			fmt.Printf("%s\n", j)
+		} else if err = os.WriteFile(*out, append(j, '\n'), 0644); chk.E(err) {
+			return
 		}
 	}
 }
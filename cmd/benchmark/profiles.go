@@ -0,0 +1,756 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/filters"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/protocol/ws"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/errorf"
+	"orly.dev/pkg/utils/log"
+)
+
+// params are the sizes of the workloads of the profiles.
+type params struct {
+	Events      int   `json:"events"`
+	Users       int   `json:"users"`
+	Queries     int   `json:"queries"`
+	Subscribers int   `json:"subscribers"`
+	Concurrency int   `json:"concurrency"`
+	Versions    int   `json:"versions"`
+	Fanout      int   `json:"fanout"`
+	Seed        int64 `json:"seed"`
+}
+
+// bench is a benchmark run against one relay.
+type bench struct {
+	c       context.T
+	url     string
+	p       params
+	rng     *rand.Rand
+	graph   *graph
+	results []Result
+}
+
+// profile is a workload that is run against the relay, adding the results of
+// its measurements to the run.
+type profile struct {
+	name        string
+	description string
+	run         func(b *bench) (err error)
+}
+
+var profiles = []profile{
+	{
+		"uniform",
+		"1 KiB kind 1 events from one signer per publisher, and simple queries",
+		(*bench).uniform,
+	},
+	{
+		"social",
+		"a social graph with a power-law follower distribution, with notes, replies and reactions",
+		(*bench).social,
+	},
+	{
+		"replaceable",
+		"repeated replacement of metadata, relay lists and articles, and queries for the latest versions",
+		(*bench).replaceable,
+	},
+	{
+		"tags",
+		"events with many tags, and queries by tag",
+		(*bench).tagHeavy,
+	},
+	{
+		"queries",
+		"a mix of home feed, profile, thread, notification and id queries from concurrent subscribers on the social graph",
+		(*bench).mixedQueries,
+	},
+	{
+		"fanout",
+		"latency of the delivery of new events to concurrent live subscribers",
+		(*bench).fanout,
+	},
+}
+
+// sign creates and signs an event.
+func sign(
+	s *testSigner, k *kind.T, createdAt int64, content string, tt ...*tag.T,
+) (ev *event.E) {
+	ev = &event.E{
+		Kind:      k,
+		Tags:      tags.New(tt...),
+		Content:   []byte(content),
+		CreatedAt: timestamp.FromUnix(createdAt),
+		Pubkey:    s.Pub(),
+	}
+	if err := ev.Sign(s); chk.E(err) {
+		panic(fmt.Sprintf("failed to sign event: %v", err))
+	}
+	return
+}
+
+// signers creates n signers.
+func signers(n int) (s []*testSigner) {
+	s = make([]*testSigner, n)
+	for i := range s {
+		s[i] = newTestSigner()
+	}
+	return
+}
+
+// split deals the events out to n publishers in turn.
+func split(evs []*event.E, n int) (batches [][]*event.E) {
+	batches = make([][]*event.E, n)
+	for i, ev := range evs {
+		batches[i%n] = append(batches[i%n], ev)
+	}
+	return
+}
+
+// publish publishes each batch of events in order on its own connection, all
+// of them at the same time, and measures the time until each event is
+// acknowledged with an OK.
+func (b *bench) publish(profile, name string, batches [][]*event.E) {
+	var l latencies
+	var wg sync.WaitGroup
+	start := time.Now()
+	for _, batch := range batches {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rl, err := ws.RelayConnect(b.c, b.url)
+			if err != nil {
+				log.E.F("failed to connect: %v", err)
+				for range batch {
+					l.fail()
+				}
+				return
+			}
+			defer rl.Close()
+			for _, ev := range batch {
+				t := time.Now()
+				if err = rl.Publish(b.c, ev); err != nil {
+					log.D.F("publish failed: %v", err)
+					l.fail()
+					continue
+				}
+				l.since(t)
+			}
+		}()
+	}
+	wg.Wait()
+	b.results = append(b.results, l.result(profile, name, time.Since(start)))
+}
+
+// query is a filter to run, the name of the measurement it is counted in, and
+// the number of events it must return, or -1 if any number is correct.
+type query struct {
+	name string
+	f    *filter.F
+	want int
+}
+
+// queries runs n queries made by next, spread over the subscribers, each of
+// which runs its queries one after the other on its own connection, and
+// measures the time until the end of the stored events of each.
+//
+// The results are one measurement for each name of query, and the total.
+func (b *bench) queries(profile string, n int, next func(rng *rand.Rand) query) {
+	workers := b.p.Subscribers
+	if workers > n {
+		workers = n
+	}
+	if workers < 1 {
+		return
+	}
+	var mx sync.Mutex
+	byName := make(map[string]*latencies)
+	var all latencies
+	get := func(name string) (l *latencies) {
+		mx.Lock()
+		defer mx.Unlock()
+		if l = byName[name]; l == nil {
+			l = new(latencies)
+			byName[name] = l
+		}
+		return
+	}
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < workers; i++ {
+		count := n / workers
+		if i < n%workers {
+			count++
+		}
+		rng := rand.New(rand.NewSource(b.p.Seed + int64(i)))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rl, err := ws.RelayConnect(b.c, b.url)
+			if err != nil {
+				log.E.F("failed to connect: %v", err)
+				for range count {
+					all.fail()
+				}
+				return
+			}
+			defer rl.Close()
+			for range count {
+				q := next(rng)
+				l := get(q.name)
+				c, cancel := context.Timeout(b.c, 10*time.Second)
+				t := time.Now()
+				var evs []*event.E
+				evs, err = rl.QuerySync(c, q.f)
+				d := time.Since(t)
+				cancel()
+				if err != nil || (q.want >= 0 && len(evs) != q.want) {
+					if err == nil {
+						log.D.F(
+							"%s returned %d events, expected %d", q.name,
+							len(evs), q.want,
+						)
+					}
+					l.fail()
+					all.fail()
+					continue
+				}
+				l.add(d)
+				l.returned(len(evs))
+				all.add(d)
+				all.returned(len(evs))
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b.results = append(
+			b.results, byName[name].result(profile, "query "+name, elapsed),
+		)
+	}
+	b.results = append(b.results, all.result(profile, "query total", elapsed))
+}
+
+func limit(n uint) *uint { return &n }
+
+// uniform publishes 1 KiB text notes from one signer per publisher, and runs
+// queries by kind and time range, which is the workload of earlier versions
+// of the benchmark.
+func (b *bench) uniform() (err error) {
+	s := signers(b.p.Concurrency)
+	evs := make([]*event.E, b.p.Events)
+	now := time.Now().Unix()
+	for i := range evs {
+		evs[i] = sign(
+			s[i%len(s)], kind.TextNote, now, generateContent(1024),
+		)
+	}
+	b.publish("uniform", "publish", split(evs, b.p.Concurrency))
+	b.queries(
+		"uniform", b.p.Queries, func(rng *rand.Rand) (q query) {
+			q.want = -1
+			now := timestamp.Now().I64()
+			switch rng.Intn(4) {
+			case 0:
+				q.name = "kind"
+				q.f = &filter.F{
+					Kinds: kinds.New(kind.TextNote), Limit: limit(100),
+				}
+			case 1:
+				q.name = "recent"
+				q.f = &filter.F{
+					Since: timestamp.FromUnix(now - 3600), Limit: limit(100),
+				}
+			case 2:
+				q.name = "kinds"
+				q.f = &filter.F{
+					Kinds: kinds.New(
+						kind.TextNote, kind.Repost, kind.Reaction,
+					),
+					Limit: limit(100),
+				}
+			default:
+				q.name = "unfiltered"
+				q.f = &filter.F{Limit: limit(100)}
+			}
+			return
+		},
+	)
+	return
+}
+
+// graph is a social graph of users whose follower counts follow a power law,
+// and the notes they published.
+type graph struct {
+	users   []*testSigner
+	follows [][]int
+	notes   []*event.E
+	// popular draws the index of a user, with the most followed users the
+	// most likely.
+	popular  func() int
+	hashtags []string
+}
+
+// zipf returns a function that draws numbers from 0 to n-1 with a power law
+// distribution, 0 the most likely.
+func zipf(rng *rand.Rand, n int) func() int {
+	if n < 2 {
+		return func() int { return 0 }
+	}
+	z := rand.NewZipf(rng, 1.1, 1, uint64(n-1))
+	return func() int { return int(z.Uint64()) }
+}
+
+// newGraph creates the users of a social graph and the events they publish:
+// their metadata, follow lists, and notes, replies and reactions spread over
+// the last week.
+//
+// # Return Values
+//
+//   - g: The graph.
+//
+//   - lists: The metadata and follow list events.
+//
+//   - activity: The notes, replies and reactions, oldest first.
+//
+// # Expected Behaviour
+//
+// Who is followed, and who posts and is replied to, are drawn from power law
+// distributions, so a few users have most of the followers and activity as on
+// real relays.
+func (b *bench) newGraph() (g *graph, lists, activity []*event.E) {
+	users := max(b.p.Users, 2)
+	g = &graph{
+		users:   signers(users),
+		follows: make([][]int, users),
+		popular: zipf(b.rng, users),
+	}
+	for i := range 50 {
+		g.hashtags = append(g.hashtags, fmt.Sprintf("topic%d", i))
+	}
+	hashtag := zipf(b.rng, len(g.hashtags))
+	now := time.Now().Unix()
+	week := int64(7 * 24 * 60 * 60)
+	for i, u := range g.users {
+		lists = append(
+			lists, sign(
+				u, kind.ProfileMetadata, now-week,
+				fmt.Sprintf(
+					`{"name":"user%d","about":"%s"}`, i, generateContent(100),
+				),
+			),
+		)
+		n := 5 + b.rng.Intn(min(users, 150))
+		seen := map[int]bool{i: true}
+		var pp []*tag.T
+		for range 2 * n {
+			if len(pp) >= n {
+				break
+			}
+			f := g.popular()
+			if seen[f] {
+				continue
+			}
+			seen[f] = true
+			g.follows[i] = append(g.follows[i], f)
+			pp = append(pp, tag.New("p", hex.Enc(g.users[f].Pub())))
+		}
+		lists = append(lists, sign(u, kind.FollowList, now-week, "", pp...))
+	}
+	count := max(b.p.Events, 1)
+	for i := range count {
+		createdAt := now - week + week*int64(i)/int64(count)
+		author := g.users[g.popular()]
+		r := b.rng.Intn(100)
+		switch {
+		case r < 60 || len(g.notes) == 0:
+			var tt []*tag.T
+			for range b.rng.Intn(3) {
+				tt = append(tt, tag.New("t", g.hashtags[hashtag()]))
+			}
+			ev := sign(
+				author, kind.TextNote, createdAt,
+				generateContent(50+b.rng.Intn(500)), tt...,
+			)
+			g.notes = append(g.notes, ev)
+			activity = append(activity, ev)
+		case r < 85:
+			parent := g.notes[b.rng.Intn(len(g.notes))]
+			ev := sign(
+				author, kind.TextNote, createdAt,
+				generateContent(20+b.rng.Intn(200)),
+				tag.New("e", parent.IdString(), "", "root"),
+				tag.New("p", hex.Enc(parent.Pubkey)),
+			)
+			g.notes = append(g.notes, ev)
+			activity = append(activity, ev)
+		default:
+			parent := g.notes[b.rng.Intn(len(g.notes))]
+			activity = append(
+				activity, sign(
+					author, kind.Reaction, createdAt, "+",
+					tag.New("e", parent.IdString()),
+					tag.New("p", hex.Enc(parent.Pubkey)),
+				),
+			)
+		}
+	}
+	return
+}
+
+// social publishes a social graph, and keeps it for the queries profile.
+func (b *bench) social() (err error) {
+	g, lists, activity := b.newGraph()
+	b.publish("social", "publish lists", split(lists, b.p.Concurrency))
+	b.publish("social", "publish activity", split(activity, b.p.Concurrency))
+	b.graph = g
+	return
+}
+
+// mixedQueries runs the queries of nostr clients on the social graph, which is
+// published first if the social profile wasn't run.
+func (b *bench) mixedQueries() (err error) {
+	if b.graph == nil {
+		g, lists, activity := b.newGraph()
+		b.publish("queries", "publish graph", split(lists, b.p.Concurrency))
+		b.publish(
+			"queries", "publish graph", split(activity, b.p.Concurrency),
+		)
+		b.graph = g
+	}
+	g := b.graph
+	b.queries(
+		"queries", b.p.Queries, func(rng *rand.Rand) (q query) {
+			q.want = -1
+			u := g.users[rng.Intn(len(g.users))]
+			note := g.notes[rng.Intn(len(g.notes))]
+			switch r := rng.Intn(100); {
+			case r < 30:
+				q.name = "home feed"
+				authors := tag.New[string]()
+				for _, f := range g.follows[rng.Intn(len(g.follows))] {
+					authors.Append(g.users[f].Pub())
+				}
+				q.f = &filter.F{
+					Authors: authors,
+					Kinds: kinds.New(
+						kind.TextNote, kind.Repost, kind.Reaction,
+					),
+					Limit: limit(50),
+				}
+			case r < 45:
+				q.name = "profile"
+				q.f = &filter.F{
+					Authors: tag.New(u.Pub()),
+					Kinds:   kinds.New(kind.ProfileMetadata, kind.FollowList),
+				}
+			case r < 60:
+				q.name = "author notes"
+				q.f = &filter.F{
+					Authors: tag.New(u.Pub()), Kinds: kinds.New(kind.TextNote),
+					Limit: limit(20),
+				}
+			case r < 70:
+				q.name = "thread"
+				q.f = &filter.F{
+					Kinds: kinds.New(kind.TextNote, kind.Reaction),
+					Tags:  tags.New(tag.New("e", note.IdString())),
+				}
+			case r < 80:
+				q.name = "notifications"
+				q.f = &filter.F{
+					Tags:  tags.New(tag.New("p", hex.Enc(u.Pub()))),
+					Limit: limit(50),
+				}
+			case r < 90:
+				q.name = "ids"
+				ids := tag.New[string]()
+				for range 5 {
+					ids.Append(g.notes[rng.Intn(len(g.notes))].ID)
+				}
+				q.f = &filter.F{Ids: ids}
+			case r < 95:
+				q.name = "hashtag"
+				q.f = &filter.F{
+					Kinds: kinds.New(kind.TextNote),
+					Tags: tags.New(
+						tag.New("t", g.hashtags[rng.Intn(len(g.hashtags))]),
+					),
+					Limit: limit(50),
+				}
+			default:
+				q.name = "global"
+				q.f = &filter.F{
+					Kinds: kinds.New(kind.TextNote),
+					Since: timestamp.FromUnix(time.Now().Unix() - 24*60*60),
+					Limit: limit(100),
+				}
+			}
+			return
+		},
+	)
+	return
+}
+
+// replaceable publishes many versions of the metadata, relay list and
+// articles of a set of users, each user's in the order they were created, and
+// then checks that queries only return the latest versions.
+func (b *bench) replaceable() (err error) {
+	users := signers(min(max(b.p.Users, 1), 100))
+	const articles = 3
+	versions := max(b.p.Versions, 1)
+	batches := make([][]*event.E, b.p.Concurrency)
+	start := time.Now().Unix() - int64(versions)
+	for v := range versions {
+		createdAt := start + int64(v)
+		for i, u := range users {
+			n := i % len(batches)
+			batches[n] = append(
+				batches[n],
+				sign(
+					u, kind.ProfileMetadata, createdAt,
+					fmt.Sprintf(`{"name":"user%d","version":%d}`, i, v),
+				),
+				sign(
+					u, kind.RelayListMetadata, createdAt, "",
+					tag.New("r", fmt.Sprintf("wss://relay%d.example.com", v)),
+				),
+			)
+			for a := range articles {
+				batches[n] = append(
+					batches[n], sign(
+						u, kind.LongFormContent, createdAt,
+						generateContent(2000),
+						tag.New("d", fmt.Sprintf("article%d", a)),
+						tag.New("title", fmt.Sprintf("version %d", v)),
+					),
+				)
+			}
+		}
+	}
+	b.publish("replaceable", "publish", batches)
+	b.queries(
+		"replaceable", b.p.Queries, func(rng *rand.Rand) (q query) {
+			u := users[rng.Intn(len(users))]
+			switch rng.Intn(3) {
+			case 0:
+				q.name = "latest metadata"
+				q.want = 1
+				q.f = &filter.F{
+					Authors: tag.New(u.Pub()),
+					Kinds:   kinds.New(kind.ProfileMetadata),
+				}
+			case 1:
+				q.name = "latest relay list"
+				q.want = 1
+				q.f = &filter.F{
+					Authors: tag.New(u.Pub()),
+					Kinds:   kinds.New(kind.RelayListMetadata),
+				}
+			default:
+				q.name = "latest articles"
+				q.want = articles
+				q.f = &filter.F{
+					Authors: tag.New(u.Pub()),
+					Kinds:   kinds.New(kind.LongFormContent),
+				}
+			}
+			return
+		},
+	)
+	return
+}
+
+// tagHeavy publishes events with tens of tags each, as in long threads and
+// curated lists, and runs queries by tag.
+func (b *bench) tagHeavy() (err error) {
+	users := signers(min(max(b.p.Users, 1), 50))
+	var hashtags []string
+	for i := range 200 {
+		hashtags = append(hashtags, fmt.Sprintf("tag%d", i))
+	}
+	hashtag := zipf(b.rng, len(hashtags))
+	count := max(b.p.Events/2, 1)
+	evs := make([]*event.E, count)
+	now := time.Now().Unix()
+	var ids []string
+	for i := range evs {
+		var tt []*tag.T
+		for range 10 + b.rng.Intn(20) {
+			tt = append(tt, tag.New("t", hashtags[hashtag()]))
+		}
+		for range 5 + b.rng.Intn(15) {
+			tt = append(
+				tt, tag.New("p", hex.Enc(users[b.rng.Intn(len(users))].Pub())),
+			)
+		}
+		for range b.rng.Intn(10) {
+			if len(ids) > 0 {
+				tt = append(tt, tag.New("e", ids[b.rng.Intn(len(ids))]))
+			}
+		}
+		evs[i] = sign(
+			users[i%len(users)], kind.TextNote, now-int64(count-i),
+			generateContent(200), tt...,
+		)
+		ids = append(ids, evs[i].IdString())
+	}
+	b.publish("tags", "publish", split(evs, b.p.Concurrency))
+	b.queries(
+		"tags", b.p.Queries, func(rng *rand.Rand) (q query) {
+			q.want = -1
+			switch rng.Intn(4) {
+			case 0:
+				q.name = "popular tag"
+				q.f = &filter.F{
+					Tags:  tags.New(tag.New("t", hashtags[rng.Intn(5)])),
+					Limit: limit(100),
+				}
+			case 1:
+				q.name = "rare tags"
+				q.f = &filter.F{
+					Tags: tags.New(
+						tag.New(
+							"t", hashtags[150+rng.Intn(50)],
+							hashtags[150+rng.Intn(50)],
+						),
+					),
+					Limit: limit(100),
+				}
+			case 2:
+				q.name = "kind and tag"
+				q.f = &filter.F{
+					Kinds: kinds.New(kind.TextNote),
+					Tags: tags.New(
+						tag.New(
+							"p", hex.Enc(users[rng.Intn(len(users))].Pub()),
+						),
+					),
+					Limit: limit(100),
+				}
+			default:
+				q.name = "referenced event"
+				q.f = &filter.F{
+					Tags:  tags.New(tag.New("e", ids[rng.Intn(len(ids))])),
+					Limit: limit(100),
+				}
+			}
+			return
+		},
+	)
+	return
+}
+
+// fanout opens a live subscription on each subscriber connection, publishes
+// events that match them, and measures the time from sending each event to
+// each subscriber receiving it.
+func (b *bench) fanout() (err error) {
+	count := max(b.p.Fanout, 1)
+	topic := fmt.Sprintf("fanout%d", b.rng.Int63())
+	ff := filters.New(
+		&filter.F{
+			Kinds: kinds.New(kind.TextNote),
+			Tags:  tags.New(tag.New("t", topic)),
+		},
+	)
+	var sent sync.Map
+	var delivery latencies
+	var subscribed, done sync.WaitGroup
+	c, cancel := context.Cancel(b.c)
+	defer cancel()
+	for range b.p.Subscribers {
+		subscribed.Add(1)
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			rl, err := ws.RelayConnect(c, b.url)
+			if err != nil {
+				log.E.F("failed to connect: %v", err)
+				subscribed.Done()
+				return
+			}
+			defer rl.Close()
+			sub, err := rl.Subscribe(c, ff)
+			if err != nil {
+				log.E.F("failed to subscribe: %v", err)
+				subscribed.Done()
+				return
+			}
+			defer sub.Unsub()
+			select {
+			case <-sub.EndOfStoredEvents:
+			case <-c.Done():
+			}
+			subscribed.Done()
+			timeout := time.After(30 * time.Second)
+			for received := 0; received < count; {
+				select {
+				case ev := <-sub.Events:
+					if t, ok := sent.Load(ev.IdString()); ok {
+						delivery.since(t.(time.Time))
+						received++
+					}
+				case <-timeout:
+					return
+				case <-c.Done():
+					return
+				}
+			}
+		}()
+	}
+	subscribed.Wait()
+	s := newTestSigner()
+	now := time.Now().Unix()
+	evs := make([]*event.E, count)
+	for i := range evs {
+		evs[i] = sign(
+			s, kind.TextNote, now, generateContent(200), tag.New("t", topic),
+		)
+	}
+	rl, err := ws.RelayConnect(c, b.url)
+	if err != nil {
+		err = errorf.E("failed to connect: %v", err)
+		return
+	}
+	defer rl.Close()
+	var publish latencies
+	start := time.Now()
+	for _, ev := range evs {
+		t := time.Now()
+		sent.Store(ev.IdString(), t)
+		if err = rl.Publish(c, ev); err != nil {
+			log.D.F("publish failed: %v", err)
+			publish.fail()
+			continue
+		}
+		publish.since(t)
+	}
+	done.Wait()
+	elapsed := time.Since(start)
+	err = nil
+	b.results = append(b.results, publish.result("fanout", "publish", elapsed))
+	r := delivery.result("fanout", "delivery", elapsed)
+	r.Errors = count*b.p.Subscribers - r.Count
+	b.results = append(b.results, r)
+	return
+}
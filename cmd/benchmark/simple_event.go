@@ -1,34 +1,9 @@
 package main
 
 import (
-	"fmt"
-
 	"lukechampine.com/frand"
-	"orly.dev/pkg/encoders/event"
-	"orly.dev/pkg/encoders/kind"
-	"orly.dev/pkg/encoders/tags"
-	"orly.dev/pkg/encoders/timestamp"
-	"orly.dev/pkg/utils/chk"
 )
 
-func generateSimpleEvent(signer *testSigner, contentSize int) *event.E {
-	content := generateContent(contentSize)
-
-	ev := &event.E{
-		Kind:      kind.TextNote,
-		Tags:      tags.New(),
-		Content:   []byte(content),
-		CreatedAt: timestamp.Now(),
-		Pubkey:    signer.Pub(),
-	}
-
-	if err := ev.Sign(signer); chk.E(err) {
-		panic(fmt.Sprintf("failed to sign event: %v", err))
-	}
-
-	return ev
-}
-
 func generateContent(size int) string {
 	words := []string{
 		"the", "be", "to", "of", "and", "a", "in", "that", "have", "I",
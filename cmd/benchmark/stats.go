@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"orly.dev/pkg/version"
+)
+
+// latencies collects the durations of the operations of one measurement from
+// concurrent workers, and the number of operations that failed.
+type latencies struct {
+	mx     sync.Mutex
+	d      []time.Duration
+	errors int
+	events int
+}
+
+// add records the duration of an operation that succeeded.
+func (l *latencies) add(d time.Duration) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	l.d = append(l.d, d)
+}
+
+// since records the duration since start of an operation that succeeded.
+func (l *latencies) since(start time.Time) { l.add(time.Since(start)) }
+
+// fail records an operation that failed.
+func (l *latencies) fail() {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	l.errors++
+}
+
+// returned records the number of events an operation returned.
+func (l *latencies) returned(n int) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	l.events += n
+}
+
+// Result is the outcome of one measurement of a profile, with the latencies
+// in milliseconds.
+type Result struct {
+	Profile string  `json:"profile"`
+	Name    string  `json:"name"`
+	Count   int     `json:"count"`
+	Errors  int     `json:"errors"`
+	Events  int     `json:"events,omitempty"`
+	Seconds float64 `json:"seconds"`
+	Rate    float64 `json:"rate"`
+	P50     float64 `json:"p50_ms"`
+	P95     float64 `json:"p95_ms"`
+	P99     float64 `json:"p99_ms"`
+	Max     float64 `json:"max_ms"`
+}
+
+// result summarises the latencies of a measurement that took elapsed.
+func (l *latencies) result(profile, name string, elapsed time.Duration) (
+	r Result,
+) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	r = Result{
+		Profile: profile,
+		Name:    name,
+		Count:   len(l.d),
+		Errors:  l.errors,
+		Events:  l.events,
+		Seconds: elapsed.Seconds(),
+	}
+	if elapsed > 0 {
+		r.Rate = float64(len(l.d)) / elapsed.Seconds()
+	}
+	if len(l.d) == 0 {
+		return
+	}
+	sort.Slice(l.d, func(i, j int) bool { return l.d[i] < l.d[j] })
+	r.P50 = ms(percentile(l.d, 50))
+	r.P95 = ms(percentile(l.d, 95))
+	r.P99 = ms(percentile(l.d, 99))
+	r.Max = ms(l.d[len(l.d)-1])
+	return
+}
+
+// percentile returns the nearest rank percentile p of the sorted durations d.
+func percentile(d []time.Duration, p int) time.Duration {
+	i := (len(d)*p + 99) / 100
+	if i < 1 {
+		i = 1
+	}
+	return d[i-1]
+}
+
+func ms(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+
+// Report is the JSON output of a benchmark run, which identifies the build
+// and the parameters so that runs on different commits can be compared.
+type Report struct {
+	Version   string    `json:"version"`
+	Commit    string    `json:"commit,omitempty"`
+	GoVersion string    `json:"go_version"`
+	Started   time.Time `json:"started"`
+	Relay     string    `json:"relay"`
+	InProcess bool      `json:"in_process"`
+	Params    params    `json:"params"`
+	Results   []Result  `json:"results"`
+}
+
+// newReport creates the report of a run, with the revision of the build if
+// it was built from a git checkout.
+func newReport(relay string, inProcess bool, p params) (r *Report) {
+	r = &Report{
+		Version:   version.V,
+		GoVersion: runtime.Version(),
+		Started:   time.Now().UTC(),
+		Relay:     relay,
+		InProcess: inProcess,
+		Params:    p,
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			if s.Key == "vcs.revision" {
+				r.Commit = s.Value
+			}
+		}
+	}
+	return
+}
+
+// printResults writes the results as a table.
+func printResults(w io.Writer, results []Result) {
+	_, _ = fmt.Fprintf(
+		w, "%-12s %-24s %8s %6s %10s %9s %9s %9s %9s\n", "profile",
+		"measurement", "count", "errors", "rate/s", "p50 ms", "p95 ms",
+		"p99 ms", "max ms",
+	)
+	for _, r := range results {
+		_, _ = fmt.Fprintf(
+			w, "%-12s %-24s %8d %6d %10.1f %9.2f %9.2f %9.2f %9.2f\n",
+			r.Profile, r.Name, r.Count, r.Errors, r.Rate, r.P50, r.P95, r.P99,
+			r.Max,
+		)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"path/filepath"
+
+	"orly.dev/pkg/app/relay"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/log"
+)
+
+// startLocalRelay runs an embedded orly relay on 127.0.0.1 for the bunker
+// and its clients to talk over, so the whole NIP-46 exchange can be tested
+// on one machine. The relay uses the defaults of orly without reading the
+// ORLY_ environment, and stores its events under the bunker data directory.
+func startLocalRelay(c context.T, cfg *C) (
+	url string, stop func(), err error,
+) {
+	var srv *relay.Server
+	if srv, err = relay.StartEmbedded(
+		c, filepath.Join(cfg.DataDir, "relay"), cfg.LocalPort, "",
+	); chk.E(err) {
+		return
+	}
+	url = "ws://" + srv.Addr
+	log.I.F("embedded relay listening at %s", url)
+	stop = srv.Shutdown
+	return
+}
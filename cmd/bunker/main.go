@@ -0,0 +1,305 @@
+// Package main is a NIP-46 remote signer. It holds a nostr secret key,
+// encrypted at rest with a NIP-49 passphrase, and answers the signing and
+// encryption requests of the clients it has granted permissions to over a
+// relay.
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/adrg/xdg"
+	"go-simpler.org/env"
+
+	"orly.dev/pkg/crypto/p256k"
+	"orly.dev/pkg/encoders/bech32encoding"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/protocol/bunker"
+	"orly.dev/pkg/utils/apputil"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/errorf"
+	"orly.dev/pkg/utils/interrupt"
+	"orly.dev/pkg/utils/keys"
+	"orly.dev/pkg/utils/log"
+	"orly.dev/pkg/utils/lol"
+	"orly.dev/pkg/utils/passphrase"
+)
+
+// C is the configuration of the bunker.
+type C struct {
+	DataDir   string        `env:"BUNKER_DATA_DIR" usage:"storage location for the encrypted key, the grants and the approval log" default:"~/.local/share/bunker"`
+	Relay     string        `env:"BUNKER_RELAY" usage:"relay to answer NIP-46 requests on, unused in local mode"`
+	Secret    string        `env:"BUNKER_SECRET" usage:"connection secret clients must present to connect, a random one is made at startup if empty"`
+	Methods   []string      `env:"BUNKER_METHODS" usage:"methods a connecting client may be granted, all if empty (comma separated)"`
+	Kinds     []int         `env:"BUNKER_KINDS" usage:"event kinds a connecting client may be granted to sign, any if empty (comma separated)"`
+	Rate      int           `env:"BUNKER_RATE" default:"60" usage:"requests per minute allowed to each client, 0 for unlimited"`
+	GrantTTL  time.Duration `env:"BUNKER_GRANT_TTL" default:"720h" usage:"how long the grant of a client lasts after it connects, 0 for no expiry"`
+	Local     bool          `env:"BUNKER_LOCAL" default:"false" usage:"run an embedded orly relay on 127.0.0.1 and answer on it instead of BUNKER_RELAY, for tests"`
+	LocalPort int           `env:"BUNKER_LOCAL_PORT" default:"0" usage:"port of the embedded relay of local mode, a free port if 0"`
+	PassFD    int           `env:"BUNKER_PASSPHRASE_FD" default:"-1" usage:"file descriptor to read the key passphrase from, prompted for if -1"`
+	LogLevel  string        `env:"BUNKER_LOG_LEVEL" default:"info" usage:"debug level: fatal error warn info debug trace"`
+}
+
+func fail(format string, a ...any) {
+	_, _ = fmt.Fprintf(os.Stderr, format+"\n", a...)
+	os.Exit(1)
+}
+
+func printHelp(cfg *C) {
+	_, _ = fmt.Fprintf(
+		os.Stderr, `bunker - NIP-46 remote signer
+
+usage:
+
+    bunker init [nsec|hex]    encrypt a new, or the given, secret key with a
+                              passphrase and store it in the data directory
+    bunker                    answer requests, printing the bunker:// URI
+    bunker grants             list the clients and what they may do
+    bunker revoke <pubkey>    remove the grant of a client
+    bunker help               print this information
+
+the passphrase is read from the file descriptor BUNKER_PASSPHRASE_FD if it is
+set, and otherwise prompted for on the terminal, or read from stdin if it is
+not a terminal.
+
+environment variables that configure bunker:
+
+`,
+	)
+	env.Usage(cfg, os.Stderr, &env.Options{SliceSep: ","})
+}
+
+func main() {
+	cfg := &C{}
+	if err := env.Load(cfg, &env.Options{SliceSep: ","}); chk.E(err) {
+		printHelp(cfg)
+		os.Exit(1)
+	}
+	if cfg.DataDir == "" || strings.Contains(cfg.DataDir, "~") {
+		cfg.DataDir = filepath.Join(xdg.DataHome, "bunker")
+	}
+	lol.SetLogLevel(cfg.LogLevel)
+	var cmd string
+	if len(os.Args) > 1 {
+		cmd = os.Args[1]
+	}
+	var err error
+	switch cmd {
+	case "help", "-h", "--help":
+		printHelp(cfg)
+	case "init":
+		var sk string
+		if len(os.Args) > 2 {
+			sk = os.Args[2]
+		}
+		err = initKey(cfg, sk)
+	case "grants":
+		err = listGrants(cfg)
+	case "revoke":
+		if len(os.Args) < 3 {
+			fail("usage: bunker revoke <pubkey>")
+		}
+		err = revoke(cfg, os.Args[2])
+	case "", "serve":
+		err = serve(cfg)
+	default:
+		printHelp(cfg)
+		os.Exit(1)
+	}
+	if err != nil {
+		fail(err.Error())
+	}
+}
+
+func (cfg *C) keyPath() string       { return filepath.Join(cfg.DataDir, "key") }
+func (cfg *C) grantsPath() string    { return filepath.Join(cfg.DataDir, "grants.json") }
+func (cfg *C) approvalsPath() string { return filepath.Join(cfg.DataDir, "approvals.log") }
+
+// initKey stores a secret key in the data directory as an ncryptsec. A key
+// given on the command line is imported, otherwise one is generated.
+func initKey(cfg *C, sk string) (err error) {
+	if apputil.FileExists(cfg.keyPath()) {
+		err = errorf.E("a key already exists at %s", cfg.keyPath())
+		return
+	}
+	sign := &p256k.Signer{}
+	security := bech32encoding.KeySecuritySecure
+	if sk == "" {
+		if err = sign.Generate(); chk.E(err) {
+			return
+		}
+	} else {
+		// a key that was handled in the clear before may have leaked.
+		security = bech32encoding.KeySecurityUnknown
+		var b []byte
+		if b, err = keys.DecodeSecret(sk, nil); err != nil {
+			return
+		}
+		if err = sign.InitSec(b); chk.E(err) {
+			return
+		}
+	}
+	var pass string
+	if pass, err = passphrase.GetNew(cfg.PassFD, "passphrase: "); err != nil {
+		return
+	}
+	var enc []byte
+	if enc, err = bech32encoding.EncryptSecretKey(
+		sign.Sec(), pass, 0, security,
+	); chk.E(err) {
+		return
+	}
+	if err = os.MkdirAll(cfg.DataDir, 0700); chk.E(err) {
+		return
+	}
+	if err = os.WriteFile(
+		cfg.keyPath(), append(enc, '\n'), 0600,
+	); chk.E(err) {
+		return
+	}
+	var npub []byte
+	if npub, err = bech32encoding.BinToNpub(sign.Pub()); chk.E(err) {
+		return
+	}
+	fmt.Printf("stored encrypted key for %s at %s\n", npub, cfg.keyPath())
+	return
+}
+
+// loadKey decrypts the stored key with the passphrase.
+func loadKey(cfg *C) (sign *p256k.Signer, err error) {
+	var b []byte
+	if b, err = os.ReadFile(cfg.keyPath()); err != nil {
+		err = errorf.E(
+			"no key found at %s, create one with 'bunker init'", cfg.keyPath(),
+		)
+		return
+	}
+	var pass string
+	if pass, err = passphrase.Get(cfg.PassFD, "passphrase: "); err != nil {
+		return
+	}
+	var sk []byte
+	if sk, _, err = bech32encoding.DecryptSecretKey(
+		[]byte(strings.TrimSpace(string(b))), pass,
+	); err != nil {
+		return
+	}
+	sign = &p256k.Signer{}
+	if err = sign.InitSec(sk); chk.E(err) {
+		return
+	}
+	return
+}
+
+func listGrants(cfg *C) (err error) {
+	var g *bunker.Grants
+	if g, err = bunker.NewGrants(cfg.grantsPath()); err != nil {
+		return
+	}
+	for _, gr := range g.List() {
+		expiry := "never"
+		if gr.Expiry != 0 {
+			expiry = time.Unix(gr.Expiry, 0).Format(time.RFC3339)
+		}
+		methods, kinds := "all", "any"
+		if gr.Methods != nil {
+			methods = strings.Join(gr.Methods, ",")
+		}
+		if gr.Kinds != nil {
+			kinds = strings.Trim(fmt.Sprint(gr.Kinds), "[]")
+		}
+		fmt.Printf(
+			"%s methods: %s kinds: %s rate: %d/min expires: %s\n",
+			gr.Client, methods, kinds, gr.Rate, expiry,
+		)
+	}
+	return
+}
+
+func revoke(cfg *C, client string) (err error) {
+	var pk []byte
+	if pk, err = keys.DecodeNpubOrHex(client); err != nil || len(pk) != 32 {
+		err = errorf.E("invalid client pubkey '%s'", client)
+		return
+	}
+	var g *bunker.Grants
+	if g, err = bunker.NewGrants(cfg.grantsPath()); err != nil {
+		return
+	}
+	return g.Revoke(pk)
+}
+
+// serve answers NIP-46 requests until interrupted.
+func serve(cfg *C) (err error) {
+	var sign *p256k.Signer
+	if sign, err = loadKey(cfg); err != nil {
+		return
+	}
+	c, cancel := context.Cancel(context.Bg())
+	relayURL := cfg.Relay
+	if cfg.Local {
+		var stop func()
+		if relayURL, stop, err = startLocalRelay(c, cfg); err != nil {
+			cancel()
+			return
+		}
+		interrupt.AddHandler(stop)
+	}
+	if relayURL == "" {
+		cancel()
+		err = errorf.E("BUNKER_RELAY must be set unless BUNKER_LOCAL is")
+		return
+	}
+	secret := cfg.Secret
+	if secret == "" {
+		b := make([]byte, 16)
+		if _, err = rand.Read(b); chk.E(err) {
+			cancel()
+			return
+		}
+		secret = hex.Enc(b)
+	}
+	var g *bunker.Grants
+	if g, err = bunker.NewGrants(cfg.grantsPath()); err != nil {
+		cancel()
+		return
+	}
+	var approvals *os.File
+	if approvals, err = os.OpenFile(
+		cfg.approvalsPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600,
+	); chk.E(err) {
+		cancel()
+		return
+	}
+	g.Log = approvals
+	g.Rate = cfg.Rate
+	g.TTL = cfg.GrantTTL
+	for _, m := range cfg.Methods {
+		if m = strings.TrimSpace(m); m != "" {
+			g.Methods = append(g.Methods, m)
+		}
+	}
+	g.Kinds = cfg.Kinds
+	svc := bunker.NewService(relayURL, sign, secret)
+	svc.Policy = g
+	if err = svc.Start(c); err != nil {
+		cancel()
+		return
+	}
+	interrupt.AddHandler(
+		func() {
+			svc.Stop()
+			cancel()
+			chk.E(approvals.Close())
+		},
+	)
+	log.I.F("answering NIP-46 requests on %s", relayURL)
+	fmt.Println(svc.ConnectURI())
+	<-c.Done()
+	return
+}
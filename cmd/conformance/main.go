@@ -0,0 +1,97 @@
+// Command conformance runs the protocol conformance suite of
+// pkg/tests/conformance against a relay, and prints whether each case passed,
+// failed or was skipped because the relay doesn't support its NIP. It exits
+// with status 1 if any case failed.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"orly.dev/pkg/tests/conformance"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/interrupt"
+	"orly.dev/pkg/utils/lol"
+)
+
+func main() {
+	var (
+		relayURL = flag.String("relay", "ws://localhost:3334", "Relay URL")
+		nipList  = flag.String(
+			"nips", "", "comma separated NIPs whose cases are run, all if empty",
+		)
+		run = flag.String(
+			"run", "",
+			"regular expression that selects the cases to run by their NIP and name, such as 'NIP-01 replaceable'",
+		)
+		out = flag.String(
+			"json", "", "file to write the results to as JSON, - for stdout",
+		)
+		verbose = flag.Bool("v", false, "Verbose output")
+	)
+	flag.Parse()
+	// the websocket client logs the cancellation of every connection it closes
+	// as an error, so only fatal errors are logged unless -v is given.
+	if *verbose {
+		lol.SetLogLevel("trace")
+	} else {
+		lol.SetLogLevel("fatal")
+	}
+	var nips []int
+	for _, s := range strings.Split(*nipList, ",") {
+		if s = strings.TrimPrefix(strings.TrimSpace(s), "NIP-"); s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "invalid NIP '%s'\n", s)
+			os.Exit(2)
+		}
+		nips = append(nips, n)
+	}
+	cases, err := conformance.Select(*run, nips...)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "invalid -run: %v\n", err)
+		os.Exit(2)
+	}
+	c, cancel := context.Cancel(context.Bg())
+	interrupt.AddHandler(cancel)
+	results, err := conformance.Run(c, *relayURL, cases)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	count := make(map[conformance.Status]int)
+	for _, r := range results {
+		count[r.Status]++
+		if *out != "-" {
+			fmt.Println(r)
+		}
+	}
+	if *out != "-" {
+		fmt.Printf(
+			"\n%d passed, %d failed, %d skipped\n", count[conformance.Pass],
+			count[conformance.Fail], count[conformance.Skip],
+		)
+	}
+	if *out != "" {
+		j, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		if *out == "-" {
+			fmt.Printf("%s\n", j)
+		} else if err = os.WriteFile(*out, append(j, '\n'), 0644); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+	}
+	if count[conformance.Fail] > 0 {
+		os.Exit(1)
+	}
+}
@@ -5,7 +5,6 @@ package main
 import (
 	"bufio"
 	"context"
-	"crypto/tls"
 	_ "embed"
 	"encoding/json"
 	"fmt"
@@ -20,7 +19,6 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/alexflint/go-arg"
@@ -33,6 +31,7 @@ import (
 	"orly.dev/cmd/lerproxy/util"
 	"orly.dev/pkg/utils/chk"
 	"orly.dev/pkg/utils/log"
+	"orly.dev/pkg/utils/tlsconfig"
 )
 
 //go:embed favicon.ico
@@ -146,57 +145,6 @@ func run(ctx context.Context, args runArgs) (err error) {
 	return group.Wait()
 }
 
-// TLSConfig returns a TLSConfig that works with a LetsEncrypt automatic SSL cert issuer as well
-// as any provided .pem certificates from providers.
-//
-// The certs are provided in the form "example.com:/path/to/cert.pem"
-func TLSConfig(m *autocert.Manager, certs ...string) (tc *tls.Config) {
-	certMap := make(map[string]*tls.Certificate)
-	var mx sync.Mutex
-	for _, cert := range certs {
-		split := strings.Split(cert, ":")
-		if len(split) != 2 {
-			log.E.F("invalid certificate parameter format: `%s`", cert)
-			continue
-		}
-		var err error
-		var c tls.Certificate
-		if c, err = tls.LoadX509KeyPair(
-			split[1]+".crt", split[1]+".key",
-		); chk.E(err) {
-			continue
-		}
-		certMap[split[0]] = &c
-	}
-	tc = m.TLSConfig()
-	tc.GetCertificate = func(helo *tls.ClientHelloInfo) (
-		cert *tls.Certificate, err error,
-	) {
-		mx.Lock()
-		var own string
-		for i := range certMap {
-			// to also handle explicit subdomain certs, prioritize over a root wildcard.
-			if helo.ServerName == i {
-				own = i
-				break
-			}
-			// if it got to us and ends in the same name dot tld assume the subdomain was
-			// redirected or it's a wildcard certificate, thus only the ending needs to match.
-			if strings.HasSuffix(helo.ServerName, i) {
-				own = i
-				break
-			}
-		}
-		if own != "" {
-			defer mx.Unlock()
-			return certMap[own], nil
-		}
-		mx.Unlock()
-		return m.GetCertificate(helo)
-	}
-	return
-}
-
 func setupServer(a runArgs) (s *http.Server, h http.Handler, err error) {
 	var mapping map[string]string
 	if mapping, err = readMapping(a.Conf); chk.E(err) {
@@ -226,7 +174,7 @@ func setupServer(a runArgs) (s *http.Server, h http.Handler, err error) {
 	s = &http.Server{
 		Handler:   proxy,
 		Addr:      a.Addr,
-		TLSConfig: TLSConfig(&m, a.Certs...),
+		TLSConfig: tlsconfig.New(&m, a.Certs...),
 	}
 	h = m.HTTPHandler(nil)
 	return
@@ -1,20 +1,27 @@
 package main
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
+	"io"
 	"orly.dev/pkg/crypto/p256k"
-	"orly.dev/pkg/encoders/bech32encoding"
+	"orly.dev/pkg/encoders/hex"
 	"orly.dev/pkg/interfaces/signer"
 	"orly.dev/pkg/protocol/httpauth"
 	"orly.dev/pkg/utils/chk"
 	"orly.dev/pkg/utils/errorf"
+	"orly.dev/pkg/utils/keys"
 	"orly.dev/pkg/utils/log"
+	"orly.dev/pkg/utils/passphrase"
 	"os"
 	"time"
 )
 
-const secEnv = "NOSTR_SECRET_KEY"
+const (
+	secEnv  = "NOSTR_SECRET_KEY"
+	passEnv = "NOSTR_PASSPHRASE_FD"
+)
 
 func fail(format string, a ...any) {
 	_, _ = fmt.Fprintf(os.Stderr, format+"\n", a...)
@@ -29,13 +36,17 @@ func main() {
 
 for generating extended expiration NIP-98 tokens:
 
-    nauth <url prefix> <duration in 0h0m0s format>
+    nauth <url prefix> <duration in 0h0m0s format> [body file]
+
+	* routes that write to the relay, such as import, require the token to have the sha256 hash of the request body in a payload tag, which is made from the body file if given.
 
 	* NIP-98 secret will be expected in the environment variable "%s" - if absent, will not be added to the header. Endpoint is assumed to not require it if absent. An error will be returned if it was needed.
 
+	* the secret may be an nsec, hex, or a NIP-49 ncryptsec. The passphrase of an ncryptsec is read from the file descriptor number in the environment variable "%s" if set, eg. %s=3 with 3<passfile, and is otherwise prompted for.
+
 	output will be rendered to stdout
 
-`, secEnv,
+`, secEnv, passEnv, passEnv,
 		)
 		os.Exit(0)
 	}
@@ -43,7 +54,7 @@ for generating extended expiration NIP-98 tokens:
 		fail(
 			`error: nauth requires minimum 2 args: <url> <duration in 0h0m0s format>
 
-    signing nsec (in bech32 format) is expected to be found in %s environment variable.
+    signing nsec, hex or ncryptsec is expected to be found in %s environment variable.
 
     use "help" to get usage information
 `, secEnv,
@@ -57,8 +68,22 @@ for generating extended expiration NIP-98 tokens:
 	if sign, err = GetNIP98Signer(); err != nil {
 		fail(err.Error())
 	}
+	var payload string
+	if len(os.Args) > 3 {
+		var f *os.File
+		if f, err = os.Open(os.Args[3]); err != nil {
+			fail(err.Error())
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			fail(err.Error())
+		}
+		payload = hex.Enc(h.Sum(nil))
+	}
 	exp := time.Now().Add(ex).Unix()
-	ev := httpauth.MakeNIP98Event(os.Args[1], "", "", exp)
+	ev := httpauth.MakeNIP98Event(os.Args[1], "", payload, exp)
 	if err = ev.Sign(sign); err != nil {
 		fail(err.Error())
 	}
@@ -81,8 +106,17 @@ func GetNIP98Signer() (sign signer.I, err error) {
 			"no bech32 secret key found in environment variable %s", secEnv,
 		)
 		return
-	} else if sk, err = bech32encoding.NsecToBytes([]byte(nsex)); chk.E(err) {
-		err = errorf.E("failed to decode nsec: '%s'", err.Error())
+	}
+	var fd int
+	if fd, err = passphrase.EnvFD(passEnv); err != nil {
+		return
+	}
+	if sk, err = keys.DecodeSecret(
+		nsex, func() (string, error) {
+			return passphrase.Get(fd, "passphrase: ")
+		},
+	); err != nil {
+		err = errorf.E("failed to decode secret key: '%s'", err.Error())
 		return
 	}
 	sign = &p256k.Signer{}
@@ -0,0 +1,208 @@
+// Package main is a nostr key management tool that generates secret keys and
+// converts them between the nsec, hex and NIP-49 ncryptsec forms.
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"orly.dev/pkg/crypto/p256k"
+	"orly.dev/pkg/encoders/bech32encoding"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/errorf"
+	"orly.dev/pkg/utils/keys"
+	"orly.dev/pkg/utils/passphrase"
+)
+
+const (
+	secEnv  = "NOSTR_SECRET_KEY"
+	passEnv = "NOSTR_PASSPHRASE_FD"
+	logNEnv = "NKEY_LOG_N"
+)
+
+func fail(format string, a ...any) {
+	_, _ = fmt.Fprintf(os.Stderr, format+"\n", a...)
+	os.Exit(1)
+}
+
+func printHelp() {
+	_, _ = fmt.Fprintf(
+		os.Stderr, `nkey - nostr secret key management
+
+usage:
+
+    nkey generate [encrypt]    make a new secret key and print it as nsec, or
+                               as ncryptsec with encrypt, and its npub
+    nkey encrypt [key]         print a nsec or hex secret key as ncryptsec
+    nkey decrypt [ncryptsec]   print an ncryptsec as nsec and hex
+    nkey pub [key]             print the npub of a secret key in any form
+    nkey help                  print this information
+
+a key not given as an argument is read from the environment variable %s.
+
+passphrases are read from the file descriptor number in the environment
+variable %s if it is set, eg. %s=3 with 3<passfile, and are
+otherwise prompted for on the terminal, or read from stdin if it is not a
+terminal.
+
+the scrypt cost of encryption is a power of two, given by %s, default %d.
+`, secEnv, passEnv, passEnv, logNEnv, bech32encoding.DefaultLogN,
+	)
+}
+
+func main() {
+	var cmd string
+	if len(os.Args) > 1 {
+		cmd = os.Args[1]
+	}
+	var arg string
+	if len(os.Args) > 2 {
+		arg = os.Args[2]
+	}
+	fd, err := passphrase.EnvFD(passEnv)
+	if err != nil {
+		fail(err.Error())
+	}
+	switch cmd {
+	case "generate":
+		err = generate(fd, arg == "encrypt")
+	case "encrypt":
+		err = encrypt(fd, key(arg))
+	case "decrypt":
+		err = decrypt(fd, key(arg))
+	case "pub":
+		err = pub(fd, key(arg))
+	case "help", "-h", "--help":
+		printHelp()
+	default:
+		printHelp()
+		os.Exit(1)
+	}
+	if err != nil {
+		fail(err.Error())
+	}
+}
+
+// key returns the key given as an argument, or from the environment.
+func key(arg string) (k string) {
+	if k = arg; k == "" {
+		k = os.Getenv(secEnv)
+	}
+	if k == "" {
+		fail("no key given as argument or in %s", secEnv)
+	}
+	return
+}
+
+// logN returns the scrypt cost from the environment.
+func logN() (n uint8, err error) {
+	v := os.Getenv(logNEnv)
+	if v == "" {
+		return
+	}
+	var i uint64
+	if i, err = strconv.ParseUint(v, 10, 8); err != nil {
+		err = errorf.E("%s must be a number from 1 to 22, got '%s'", logNEnv, v)
+		return
+	}
+	n = uint8(i)
+	return
+}
+
+// seal encrypts sk with a new passphrase into an ncryptsec.
+func seal(fd int, sk []byte, security byte) (enc []byte, err error) {
+	var n uint8
+	if n, err = logN(); err != nil {
+		return
+	}
+	var pass string
+	if pass, err = passphrase.GetNew(fd, "passphrase: "); err != nil {
+		return
+	}
+	return bech32encoding.EncryptSecretKey(sk, pass, n, security)
+}
+
+func printNpub(sk []byte) (err error) {
+	sign := &p256k.Signer{}
+	if err = sign.InitSec(sk); chk.E(err) {
+		return
+	}
+	var npub []byte
+	if npub, err = bech32encoding.BinToNpub(sign.Pub()); chk.E(err) {
+		return
+	}
+	fmt.Printf("%s\n", npub)
+	return
+}
+
+func generate(fd int, encrypted bool) (err error) {
+	sign := &p256k.Signer{}
+	if err = sign.Generate(); chk.E(err) {
+		return
+	}
+	var out []byte
+	if encrypted {
+		if out, err = seal(
+			fd, sign.Sec(), bech32encoding.KeySecuritySecure,
+		); err != nil {
+			return
+		}
+	} else if out, err = bech32encoding.BinToNsec(sign.Sec()); chk.E(err) {
+		return
+	}
+	fmt.Printf("%s\n", out)
+	return printNpub(sign.Sec())
+}
+
+func encrypt(fd int, k string) (err error) {
+	if bech32encoding.IsNcryptsec(k) {
+		err = errorf.E("key is already encrypted")
+		return
+	}
+	var sk []byte
+	if sk, err = keys.DecodeSecret(k, nil); err != nil {
+		return
+	}
+	var enc []byte
+	// the key was handled in the clear, so it may have leaked.
+	if enc, err = seal(fd, sk, bech32encoding.KeySecurityUnknown); err != nil {
+		return
+	}
+	fmt.Printf("%s\n", enc)
+	return
+}
+
+func decrypt(fd int, k string) (err error) {
+	if !bech32encoding.IsNcryptsec(k) {
+		err = errorf.E("key is not an ncryptsec")
+		return
+	}
+	var pass string
+	if pass, err = passphrase.Get(fd, "passphrase: "); err != nil {
+		return
+	}
+	var sk []byte
+	if sk, _, err = bech32encoding.DecryptSecretKey([]byte(k), pass); err != nil {
+		return
+	}
+	var nsec []byte
+	if nsec, err = bech32encoding.BinToNsec(sk); chk.E(err) {
+		return
+	}
+	fmt.Printf("%s\n%s\n", nsec, hex.Enc(sk))
+	return
+}
+
+func pub(fd int, k string) (err error) {
+	var sk []byte
+	if sk, err = keys.DecodeSecret(
+		k, func() (string, error) {
+			return passphrase.Get(fd, "passphrase: ")
+		},
+	); err != nil {
+		return
+	}
+	return printNpub(sk)
+}
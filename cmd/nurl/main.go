@@ -12,17 +12,21 @@ import (
 
 	"orly.dev/pkg/crypto/p256k"
 	"orly.dev/pkg/crypto/sha256"
-	"orly.dev/pkg/encoders/bech32encoding"
 	"orly.dev/pkg/encoders/hex"
 	"orly.dev/pkg/interfaces/signer"
 	"orly.dev/pkg/protocol/httpauth"
 	"orly.dev/pkg/utils/chk"
 	"orly.dev/pkg/utils/errorf"
+	"orly.dev/pkg/utils/keys"
 	"orly.dev/pkg/utils/log"
+	"orly.dev/pkg/utils/passphrase"
 	realy_lol "orly.dev/pkg/version"
 )
 
-const secEnv = "NOSTR_SECRET_KEY"
+const (
+	secEnv  = "NOSTR_SECRET_KEY"
+	passEnv = "NOSTR_PASSPHRASE_FD"
+)
 
 var userAgent = fmt.Sprintf("nurl/%s", realy_lol.V)
 
@@ -45,9 +49,11 @@ for nostr http using NIP-98 HTTP authentication:
 
 	* NIP-98 secret will be expected in the environment variable "%s" - if absent, will not be added to the header. Endpoint is assumed to not require it if absent. An error will be returned if it was needed.
 
+	* the secret may be an nsec, hex, or a NIP-49 ncryptsec. The passphrase of an ncryptsec is read from the file descriptor number in the environment variable "%s" if set, eg. %s=3 with 3<passfile, and is otherwise prompted for.
+
 	output will be rendered to stdout
 
-`, secEnv,
+`, secEnv, passEnv, passEnv,
 		)
 		os.Exit(0)
 	}
@@ -55,7 +61,7 @@ for nostr http using NIP-98 HTTP authentication:
 		fail(
 			`error: nurl requires minimum 1 arg:  <url> 
 
-    signing nsec (in bech32 format) is expected to be found in %s environment variable.
+    signing nsec, hex or ncryptsec is expected to be found in %s environment variable.
 
     use "help" to get usage information
 `, secEnv,
@@ -89,8 +95,17 @@ func GetNIP98Signer() (sign signer.I, err error) {
 			"no bech32 secret key found in environment variable %s", secEnv,
 		)
 		return
-	} else if sk, err = bech32encoding.NsecToBytes([]byte(nsex)); chk.E(err) {
-		err = errorf.E("failed to decode nsec: '%s'", err.Error())
+	}
+	var fd int
+	if fd, err = passphrase.EnvFD(passEnv); err != nil {
+		return
+	}
+	if sk, err = keys.DecodeSecret(
+		nsex, func() (string, error) {
+			return passphrase.Get(fd, "passphrase: ")
+		},
+	); err != nil {
+		err = errorf.E("failed to decode secret key: '%s'", err.Error())
 		return
 	}
 	sign = &p256k.Signer{}
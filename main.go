@@ -9,6 +9,7 @@ import (
 	"os"
 
 	"github.com/pkg/profile"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	app2 "orly.dev/pkg/app"
 	"orly.dev/pkg/app/config"
 	"orly.dev/pkg/app/relay"
@@ -21,24 +22,30 @@ import (
 	"orly.dev/pkg/utils/interrupt"
 	"orly.dev/pkg/utils/log"
 	"orly.dev/pkg/utils/lol"
+	"orly.dev/pkg/utils/tracing"
 	"orly.dev/pkg/version"
 )
 
 func main() {
 	var err error
 	var cfg *config.C
-	if cfg, err = config.New(); chk.T(err) {
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "ERROR: %s\n\n", err)
-		}
-		config.PrintHelp(cfg, os.Stderr)
+	cfg, err = config.New()
+	if config.GetEnv() {
+		config.PrintEnv(cfg, os.Stdout, err)
 		os.Exit(0)
 	}
-	log.I.F("starting %s %s", cfg.AppName, version.V)
-	if config.GetEnv() {
-		config.PrintEnv(cfg, os.Stdout)
+	if config.CheckRequested() {
+		if !config.Check(err, os.Stdout) {
+			os.Exit(1)
+		}
 		os.Exit(0)
 	}
+	if chk.T(err) {
+		fmt.Fprintf(os.Stderr, "ERROR: invalid configuration:\n%s\n\n", err)
+		config.PrintHelp(cfg, os.Stderr)
+		os.Exit(1)
+	}
+	log.I.F("starting %s %s", cfg.AppName, version.V)
 	if config.HelpRequested() {
 		config.PrintHelp(cfg, os.Stderr)
 		os.Exit(0)
@@ -58,6 +65,18 @@ func main() {
 		}
 	}
 	c, cancel := context.Cancel(context.Bg())
+	if cfg.OTLPEndpoint != "" {
+		var exp sdktrace.SpanExporter
+		if exp, err = tracing.OTLP(c, cfg.OTLPEndpoint); chk.E(err) {
+			os.Exit(1)
+		}
+		tp := tracing.Init(exp, cfg.AppName, cfg.TraceSamplePercent)
+		interrupt.AddHandler(func() { chk.E(tp.Shutdown(context.Bg())) })
+		log.I.F(
+			"tracing %d%% of requests to %s", cfg.TraceSamplePercent,
+			cfg.OTLPEndpoint,
+		)
+	}
 	var storage *database.D
 	if storage, err = database.New(
 		c, cancel, cfg.DataDir, cfg.DbLogLevel,
@@ -94,6 +113,7 @@ func main() {
 		log.F.F("failed to create server: %v", err)
 	}
 	interrupt.AddHandler(func() { server.Shutdown() })
+	interrupt.AddReloadHandler(func() { chk.E(server.Reload()) })
 	if err = server.Start(cfg.Listen, cfg.Port); chk.E(err) {
 		log.F.F("server terminated: %v", err)
 	}
@@ -3,6 +3,7 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -27,30 +28,63 @@ import (
 // and default values. It defines parameters for app behaviour, storage
 // locations, logging, and network settings used across the relay service.
 type C struct {
-	AppName             string        `env:"ORLY_APP_NAME" default:"ORLY"`
-	Config              string        `env:"ORLY_CONFIG_DIR" usage:"location for configuration file, which has the name '.env' to make it harder to delete, and is a standard environment KEY=value<newline>... style" default:"~/.config/orly"`
-	State               string        `env:"ORLY_STATE_DATA_DIR" usage:"storage location for state data affected by dynamic interactive interfaces" default:"~/.local/state/orly"`
-	DataDir             string        `env:"ORLY_DATA_DIR" usage:"storage location for the event store" default:"~/.local/cache/orly"`
-	Listen              string        `env:"ORLY_LISTEN" default:"0.0.0.0" usage:"network listen address"`
-	Port                int           `env:"ORLY_PORT" default:"3334" usage:"port to listen on"`
-	LogLevel            string        `env:"ORLY_LOG_LEVEL" default:"info" usage:"debug level: fatal error warn info debug trace"`
-	DbLogLevel          string        `env:"ORLY_DB_LOG_LEVEL" default:"info" usage:"debug level: fatal error warn info debug trace"`
-	Pprof               string        `env:"ORLY_PPROF" usage:"enable pprof on 127.0.0.1:6060" enum:"cpu,memory,allocation"`
-	AuthRequired        bool          `env:"ORLY_AUTH_REQUIRED" default:"false" usage:"require authentication for all requests"`
-	PublicReadable      bool          `env:"ORLY_PUBLIC_READABLE" default:"true" usage:"allow public read access to regardless of whether the client is authed"`
-	SpiderSeeds         []string      `env:"ORLY_SPIDER_SEEDS" usage:"seeds to use for the spider (relays that are looked up initially to find owner relay lists) (comma separated)" default:"wss://profiles.nostr1.com/,wss://relay.nostr.band/,wss://relay.damus.io/,wss://nostr.wine/,wss://nostr.land/,wss://theforest.nostr1.com/,wss://profiles.nostr1.com/"`
-	SpiderType          string        `env:"ORLY_SPIDER_TYPE" usage:"whether to spider, and what degree of spidering: none, directory, follows (follows means to the second degree of the follow graph)" default:"directory"`
-	SpiderTime          time.Duration `env:"ORLY_SPIDER_FREQUENCY" usage:"how often to run the spider, uses notation 0h0m0s" default:"1h"`
-	SpiderSecondDegree  bool          `env:"ORLY_SPIDER_SECOND_DEGREE" default:"true" usage:"whether to enable spidering the second degree of follows for non-directory events if ORLY_SPIDER_TYPE is set to 'follows'"`
-	Owners              []string      `env:"ORLY_OWNERS" usage:"list of users whose follow lists designate whitelisted users who can publish events, and who can read if public readable is false (comma separated)"`
-	Private             bool          `env:"ORLY_PRIVATE" usage:"do not spider for user metadata because the relay is private and this would leak relay memberships" default:"false"`
-	Whitelist           []string      `env:"ORLY_WHITELIST" usage:"only allow connections from this list of IP addresses"`
-	Blacklist           []string      `env:"ORLY_BLACKLIST" usage:"list of pubkeys to block when auth is not required (comma separated)"`
-	RelaySecret         string        `env:"ORLY_SECRET_KEY" usage:"secret key for relay cluster replication authentication"`
-	PeerRelays          []string      `env:"ORLY_PEER_RELAYS" usage:"list of peer relays URLs that new events are pushed to in format <pubkey>|<url>"`
-	NWCUri              string        `env:"ORLY_NWC_URI" usage:"NWC (Nostr Wallet Connect) connection string for Lightning payments"`
-	SubscriptionEnabled bool          `env:"ORLY_SUBSCRIPTION_ENABLED" default:"false" usage:"enable subscription-based access control requiring payment for non-directory events"`
-	MonthlyPriceSats    int64         `env:"ORLY_MONTHLY_PRICE_SATS" default:"6000" usage:"price in satoshis for one month subscription (default ~$2 USD)"`
+	AppName               string        `env:"ORLY_APP_NAME" default:"ORLY"`
+	Config                string        `env:"ORLY_CONFIG_DIR" usage:"location for configuration file, which has the name '.env' to make it harder to delete, and is a standard environment KEY=value<newline>... style" default:"~/.config/orly"`
+	State                 string        `env:"ORLY_STATE_DATA_DIR" usage:"storage location for state data affected by dynamic interactive interfaces" default:"~/.local/state/orly"`
+	DataDir               string        `env:"ORLY_DATA_DIR" usage:"storage location for the event store" default:"~/.local/cache/orly"`
+	Listen                string        `env:"ORLY_LISTEN" default:"0.0.0.0" usage:"network listen address"`
+	Port                  int           `env:"ORLY_PORT" default:"3334" usage:"port to listen on"`
+	TLSListen             string        `env:"ORLY_TLS_LISTEN" usage:"address to also serve TLS on, eg. 0.0.0.0:443, with the certificates of ORLY_TLS_CERTS or ORLY_ACME_DOMAINS"`
+	TLSCerts              []string      `env:"ORLY_TLS_CERTS" usage:"certificates and the domain they match, eg. example.com:/path/to/cert loads /path/to/cert.crt and /path/to/cert.key (comma separated)"`
+	ACMEDomains           []string      `env:"ORLY_ACME_DOMAINS" usage:"domains to get certificates for from LetsEncrypt, cached in the configuration directory (comma separated)"`
+	ACMEEmail             string        `env:"ORLY_ACME_EMAIL" usage:"contact email address presented to LetsEncrypt"`
+	ACMEHTTP              string        `env:"ORLY_ACME_HTTP" usage:"optional address to serve http to https redirects and ACME http-01 challenge responses on, eg. 0.0.0.0:80"`
+	UnixSocket            string        `env:"ORLY_UNIX_SOCKET" usage:"path of a unix domain socket to also serve the relay on"`
+	AdminListen           string        `env:"ORLY_ADMIN_LISTEN" usage:"address of a separate listener for the admin API, /metrics, /health and pprof, which are then not served on the other listeners, eg. 127.0.0.1:3335"`
+	LogLevel              string        `env:"ORLY_LOG_LEVEL" default:"info" usage:"debug level: fatal error warn info debug trace"`
+	DbLogLevel            string        `env:"ORLY_DB_LOG_LEVEL" default:"info" usage:"debug level: fatal error warn info debug trace"`
+	Pprof                 string        `env:"ORLY_PPROF" usage:"enable pprof on 127.0.0.1:6060" enum:"cpu,memory,allocation"`
+	OTLPEndpoint          string        `env:"ORLY_OTLP_ENDPOINT" usage:"URL of an OpenTelemetry collector to export tracing spans of requests to with OTLP over HTTP, eg. http://localhost:4318, tracing is off if empty"`
+	TraceSamplePercent    int           `env:"ORLY_TRACE_SAMPLE_PERCENT" default:"100" usage:"percentage of requests that are traced when ORLY_OTLP_ENDPOINT is set"`
+	SlowQuery             time.Duration `env:"ORLY_SLOW_QUERY" default:"1s" usage:"log queries that take longer than this with their filter, index ranges, keys scanned and events fetched, 0 to not log them"`
+	AuthRequired          bool          `env:"ORLY_AUTH_REQUIRED" default:"false" usage:"require authentication for all requests"`
+	PublicReadable        bool          `env:"ORLY_PUBLIC_READABLE" default:"true" usage:"allow public read access to regardless of whether the client is authed"`
+	GuestReadKinds        []int         `env:"ORLY_GUEST_READ_KINDS" usage:"event kinds unauthenticated clients may read, all kinds if empty (comma separated)"`
+	GuestMaxLimit         int           `env:"ORLY_GUEST_MAX_LIMIT" default:"500" usage:"maximum limit of a filter from an unauthenticated client, 0 for no maximum"`
+	UserMaxLimit          int           `env:"ORLY_USER_MAX_LIMIT" default:"5000" usage:"maximum limit of a filter from an authenticated client, 0 for no maximum"`
+	GuestMaxTimeRange     time.Duration `env:"ORLY_GUEST_MAX_TIME_RANGE" default:"0s" usage:"maximum span between since and until of a filter from an unauthenticated client, 0 for no maximum"`
+	UserMaxTimeRange      time.Duration `env:"ORLY_USER_MAX_TIME_RANGE" default:"0s" usage:"maximum span between since and until of a filter from an authenticated client, 0 for no maximum"`
+	RequireSelector       bool          `env:"ORLY_REQUIRE_SELECTOR" default:"false" usage:"reject filters that have no ids, authors or tags, except from owners"`
+	HideMuted             bool          `env:"ORLY_HIDE_MUTED" default:"false" usage:"do not serve events authored by pubkeys muted by the owners"`
+	Inbox                 bool          `env:"ORLY_INBOX" default:"false" usage:"run as a NIP-17 DM inbox relay: only accept gift wraps for pubkeys whose DM relay list (kind 10050) names this relay, serve them only to their authenticated recipient, and only accept other kinds from authenticated members"`
+	GiftWrapWhitelistOnly bool          `env:"ORLY_GIFT_WRAP_WHITELIST_ONLY" default:"false" usage:"only accept gift wraps addressed to the owners or users within the second degree of their follow lists"`
+	GiftWrapBackdate      time.Duration `env:"ORLY_GIFT_WRAP_BACKDATE" default:"48h" usage:"created_at tolerance for gift wraps, whose created_at NIP-59 randomizes into the past, added to the maximum event age and the maximum time range of filters for gift wraps"`
+	MaxFutureSkew         time.Duration `env:"ORLY_MAX_FUTURE_SKEW" default:"0s" usage:"reject events with a created_at further than this in the future, 0 for no maximum"`
+	MaxEventAge           time.Duration `env:"ORLY_MAX_EVENT_AGE" default:"0s" usage:"reject events with a created_at further than this in the past, 0 for no maximum"`
+	SpiderSeeds           []string      `env:"ORLY_SPIDER_SEEDS" usage:"seeds to use for the spider (relays that are looked up initially to find owner relay lists) (comma separated)" default:"wss://profiles.nostr1.com/,wss://relay.nostr.band/,wss://relay.damus.io/,wss://nostr.wine/,wss://nostr.land/,wss://theforest.nostr1.com/,wss://profiles.nostr1.com/"`
+	SpiderType            string        `env:"ORLY_SPIDER_TYPE" usage:"whether to spider, and what degree of spidering: none, directory, follows (follows means to the second degree of the follow graph)" default:"directory"`
+	SpiderTime            time.Duration `env:"ORLY_SPIDER_FREQUENCY" usage:"how often to run the spider, uses notation 0h0m0s" default:"1h"`
+	SpiderSecondDegree    bool          `env:"ORLY_SPIDER_SECOND_DEGREE" default:"true" usage:"whether to enable spidering the second degree of follows for non-directory events if ORLY_SPIDER_TYPE is set to 'follows'"`
+	SpiderConcurrency     int           `env:"ORLY_SPIDER_CONCURRENCY" default:"4" usage:"how many queries the spider sends to the seeds at once"`
+	SpiderTimeout         time.Duration `env:"ORLY_SPIDER_TIMEOUT" default:"30s" usage:"how long a seed may take to answer a spider query before it counts as failed and is backed off"`
+	WoTDepth              int           `env:"ORLY_WOT_DEPTH" default:"2" usage:"how many steps of follows from the owners are allowed to publish: 0 for only the owners, 1 for the users they follow, 2 for the users those follow too, up to 3"`
+	WoTMinFollows         int           `env:"ORLY_WOT_MIN_FOLLOWS" default:"1" usage:"how many allowed users one step closer to the owners must follow a pubkey beyond the owners' follows for it to be allowed"`
+	WoTMuteThreshold      int           `env:"ORLY_WOT_MUTE_THRESHOLD" default:"0" usage:"how many of the owners' follows must mute a pubkey beyond the owners' follows to exclude it, 0 to only exclude the pubkeys the owners mute"`
+	Owners                []string      `env:"ORLY_OWNERS" usage:"list of users whose follow lists designate whitelisted users who can publish events, and who can read if public readable is false (comma separated)"`
+	Private               bool          `env:"ORLY_PRIVATE" usage:"do not spider for user metadata because the relay is private and this would leak relay memberships" default:"false"`
+	Whitelist             []string      `env:"ORLY_WHITELIST" usage:"only allow connections from these IP addresses or CIDR blocks (comma separated)"`
+	Blacklist             []string      `env:"ORLY_BLACKLIST" usage:"list of pubkeys whose events are refused (comma separated)"`
+	BlockedIPs            []string      `env:"ORLY_BLOCKED_IPS" usage:"refuse connections from these IP addresses or CIDR blocks (comma separated)"`
+	NIP98RequireMethod    bool          `env:"ORLY_NIP98_REQUIRE_METHOD" default:"false" usage:"require the method tag of NIP-98 auth events to match the request even on expiring tokens, for routes that write to the relay"`
+	RelaySecret           string        `env:"ORLY_SECRET_KEY" usage:"secret key for relay cluster replication authentication, nsec, hex or NIP-49 ncryptsec"`
+	RelaySecretFD         int           `env:"ORLY_SECRET_KEY_PASSPHRASE_FD" default:"-1" usage:"file descriptor to read the passphrase of an ncryptsec ORLY_SECRET_KEY from, prompted for on the terminal if -1"`
+	Operators             []string      `env:"ORLY_OPERATORS" usage:"npubs or hex pubkeys of the relay operators, whose MuSig2 aggregate key becomes the relay identity in place of ORLY_SECRET_KEY, which must be the key of one of them (comma separated)"`
+	CosignRelay           string        `env:"ORLY_COSIGN_RELAY" usage:"relay the operators exchange MuSig2 signing sessions on, required with ORLY_OPERATORS"`
+	CosignKinds           []int         `env:"ORLY_COSIGN_KINDS" usage:"event kinds co-signed when another operator asks, others are refused, none if empty (comma separated)"`
+	PeerRelays            []string      `env:"ORLY_PEER_RELAYS" usage:"list of peer relays URLs that new events are pushed to in format <pubkey>@<url> (comma separated)"`
+	NWCUri                string        `env:"ORLY_NWC_URI" usage:"NWC (Nostr Wallet Connect) connection string for Lightning payments"`
+	SubscriptionEnabled   bool          `env:"ORLY_SUBSCRIPTION_ENABLED" default:"false" usage:"enable subscription-based access control requiring payment for non-directory events"`
+	MonthlyPriceSats      int64         `env:"ORLY_MONTHLY_PRICE_SATS" default:"6000" usage:"price in satoshis for one month subscription (default ~$2 USD)"`
 }
 
 // New creates and initializes a new configuration object for the relay
@@ -71,8 +105,28 @@ type C struct {
 // levels based on configuration values and returns the populated configuration
 // or an error if any step fails
 func New() (cfg *C, err error) {
+	if cfg, err = Load(); err != nil {
+		return
+	}
+	lol.SetLogLevel(cfg.LogLevel)
+	return
+}
+
+// Load reads the configuration from the environment and the .env file in the
+// configuration directory, like New, but without changing the log level, so
+// it can be used to read a changed configuration while running.
+//
+// If any values are invalid, cfg is still complete, with the defaults in
+// place of values that could not be parsed, and err is the Errors listing
+// every invalid value.
+func Load() (cfg *C, err error) {
 	cfg = &C{}
-	if err = env.Load(cfg, &env.Options{SliceSep: ","}); chk.T(err) {
+	var errs Errors
+	if err = env.Load(
+		cfg, &env.Options{
+			SliceSep: ",", Source: checked{env.OS, &errs},
+		},
+	); chk.T(err) {
 		return
 	}
 	if cfg.Config == "" || strings.Contains(cfg.State, "~") {
@@ -94,11 +148,10 @@ func New() (cfg *C, err error) {
 			return
 		}
 		if err = env.Load(
-			cfg, &env.Options{SliceSep: ",", Source: e},
+			cfg, &env.Options{SliceSep: ",", Source: checked{e, &errs}},
 		); chk.E(err) {
 			return
 		}
-		lol.SetLogLevel(cfg.LogLevel)
 		log.T.F("loaded configuration from %s", envPath)
 	}
 	// if spider seeds has no elements, there still is a single entry with an
@@ -112,6 +165,14 @@ func New() (cfg *C, err error) {
 		seeds = append(seeds, u)
 	}
 	cfg.SpiderSeeds = seeds
+	if err = cfg.Validate(); err != nil {
+		var invalid Errors
+		errors.As(err, &invalid)
+		errs = append(errs, invalid...)
+	}
+	if len(errs) > 0 {
+		err = errs
+	}
 	return
 }
 
@@ -232,13 +293,19 @@ func EnvKV(cfg any) (m KVSlice) {
 		switch v.(type) {
 		case string:
 			val = v.(string)
-		case int, bool, time.Duration:
+		case int, int64, bool, time.Duration:
 			val = fmt.Sprint(v)
 		case []string:
 			arr := v.([]string)
 			if len(arr) > 0 {
 				val = strings.Join(arr, ",")
 			}
+		case []int:
+			var arr []string
+			for _, n := range v.([]int) {
+				arr = append(arr, fmt.Sprint(n))
+			}
+			val = strings.Join(arr, ",")
 		}
 		// this can happen with embedded structs
 		if k == "" {
@@ -258,18 +325,85 @@ func EnvKV(cfg any) (m KVSlice) {
 //
 //   - printer: Destination for the output, typically an io.Writer implementation
 //
+//   - err: The error from loading the configuration, whose Errors are shown
+//     with the values they are about
+//
 // # Expected Behaviour
 //
 // Outputs each environment variable derived from the config's struct tags in
-// sorted order, formatted as "key=value\n" to the specified writer
-func PrintEnv(cfg *C, printer io.Writer) {
+// sorted order, formatted as "key=value\n" to the specified writer. Values that
+// are invalid, or are the default because they are not set, are preceded by a
+// comment line saying so, which the .env file loader skips.
+func PrintEnv(cfg *C, printer io.Writer, err error) {
+	var errs Errors
+	errors.As(err, &errs)
+	set := explicit(cfg)
 	kvs := EnvKV(*cfg)
 	sort.Sort(kvs)
 	for _, v := range kvs {
+		for _, e := range errs.Of(v.Key) {
+			_, _ = fmt.Fprintf(
+				printer, "# invalid: '%s' %s\n", e.Value, e.Reason,
+			)
+		}
+		if !set[v.Key] {
+			_, _ = fmt.Fprintf(printer, "# default\n")
+		}
 		_, _ = fmt.Fprintf(printer, "%s=%s\n", v.Key, v.Value)
 	}
 }
 
+// explicit returns the keys that are set in the .env file, or in the
+// environment if there is no .env file, which are the values Load uses
+// rather than the defaults.
+func explicit(cfg *C) (set map[string]bool) {
+	set = make(map[string]bool)
+	envPath := filepath.Join(cfg.Config, ".env")
+	var e env2.Env
+	if apputil.FileExists(envPath) {
+		var err error
+		if e, err = env2.GetEnv(envPath); chk.E(err) {
+			return
+		}
+	}
+	for _, kv := range EnvKV(*cfg) {
+		if e != nil {
+			_, set[kv.Key] = e[kv.Key]
+		} else {
+			_, set[kv.Key] = os.LookupEnv(kv.Key)
+		}
+	}
+	return
+}
+
+// CheckRequested returns true if the command line arguments are "config
+// check".
+func CheckRequested() (requested bool) {
+	return len(os.Args) > 2 && strings.ToLower(os.Args[1]) == "config" &&
+		strings.ToLower(os.Args[2]) == "check"
+}
+
+// Check prints the result of loading the configuration, each invalid value
+// on a line of its own, and returns false if there were any.
+func Check(err error, printer io.Writer) (ok bool) {
+	var errs Errors
+	if errors.As(err, &errs) {
+		_, _ = fmt.Fprintf(
+			printer, "%d invalid configuration values:\n\n", len(errs),
+		)
+		for _, e := range errs {
+			_, _ = fmt.Fprintf(printer, "  %s\n", e)
+		}
+		return false
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(printer, "invalid configuration: %s\n", err)
+		return false
+	}
+	_, _ = fmt.Fprintln(printer, "configuration is valid")
+	return true
+}
+
 // PrintHelp prints help information including application version, environment
 // variable configuration, and details about .env file handling to the provided
 // writer
@@ -303,14 +437,15 @@ func PrintHelp(cfg *C, printer io.Writer) {
 			"loaded for configuration.\nset these two variables for a custom load path,"+
 			" this file will be created on first startup.\nenvironment overrides it and "+
 			"you can also edit the file to set configuration options\n\n"+
-			"use the parameter 'env' to print out the current configuration to the terminal\n\n"+
+			"use the parameter 'env' to print out the current configuration to the terminal\n"+
+			"and 'config check' to check it for invalid values\n\n"+
 			"set the environment using\n\n\t%s env > %s/.env\n",
 		cfg.Config,
 		os.Args[0],
 		cfg.Config,
 	)
 	fmt.Fprintf(printer, "\ncurrent configuration:\n\n")
-	PrintEnv(cfg, printer)
+	PrintEnv(cfg, printer, cfg.Validate())
 	fmt.Fprintln(printer)
 	return
 }
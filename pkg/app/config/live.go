@@ -0,0 +1,237 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"orly.dev/pkg/utils/apputil"
+	"orly.dev/pkg/utils/chk"
+	env2 "orly.dev/pkg/utils/env"
+	"orly.dev/pkg/utils/errorf"
+)
+
+// Live is the list of the env keys of the fields of C that can be changed
+// while the relay is running. Changes to any other field only take effect
+// after a restart.
+var Live = []string{
+	"ORLY_LOG_LEVEL",
+	"ORLY_DB_LOG_LEVEL",
+	"ORLY_SLOW_QUERY",
+	"ORLY_AUTH_REQUIRED",
+	"ORLY_OWNERS",
+	"ORLY_WHITELIST",
+	"ORLY_BLACKLIST",
+	"ORLY_BLOCKED_IPS",
+	"ORLY_PUBLIC_READABLE",
+	"ORLY_GUEST_READ_KINDS",
+	"ORLY_GUEST_MAX_LIMIT",
+	"ORLY_USER_MAX_LIMIT",
+	"ORLY_REQUIRE_SELECTOR",
+	"ORLY_HIDE_MUTED",
+	"ORLY_MAX_FUTURE_SKEW",
+	"ORLY_MAX_EVENT_AGE",
+	"ORLY_SPIDER_SEEDS",
+	"ORLY_SPIDER_TYPE",
+	"ORLY_SPIDER_FREQUENCY",
+	"ORLY_SPIDER_SECOND_DEGREE",
+	"ORLY_SPIDER_CONCURRENCY",
+	"ORLY_SPIDER_TIMEOUT",
+	"ORLY_WOT_DEPTH",
+	"ORLY_WOT_MIN_FOLLOWS",
+	"ORLY_WOT_MUTE_THRESHOLD",
+	"ORLY_PEER_RELAYS",
+	"ORLY_MONTHLY_PRICE_SATS",
+}
+
+// IsLive returns true if the env key names a field in Live.
+func IsLive(key string) bool {
+	for _, k := range Live {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Set parses value into the field of cfg with the env key, in the same format
+// as it is read from the environment.
+//
+// # Parameters
+//
+//   - cfg: The configuration to change.
+//
+//   - key: The env key of the field, eg. ORLY_LOG_LEVEL.
+//
+//   - value: The new value, with slices comma separated.
+//
+// # Return Values
+//
+//   - err: An error if there is no field with the key, or the value does not
+//     parse as the type of the field.
+func Set(cfg *C, key, value string) (err error) {
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("env") != key {
+			continue
+		}
+		if err = parseField(v.Field(i), value); err != nil {
+			err = errorf.E("%s: %v", key, err)
+		}
+		return
+	}
+	err = errorf.E("no configuration field %s", key)
+	return
+}
+
+// parseField parses value into a field of C.
+func parseField(f reflect.Value, value string) (err error) {
+	switch f.Interface().(type) {
+	case string:
+		f.SetString(value)
+	case time.Duration:
+		var d time.Duration
+		if d, err = time.ParseDuration(value); err != nil {
+			return
+		}
+		f.SetInt(int64(d))
+	case int, int64:
+		var n int64
+		if n, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return
+		}
+		f.SetInt(n)
+	case bool:
+		var b bool
+		if b, err = strconv.ParseBool(value); err != nil {
+			return
+		}
+		f.SetBool(b)
+	case []string:
+		var arr []string
+		for _, s := range strings.Split(value, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				arr = append(arr, s)
+			}
+		}
+		f.Set(reflect.ValueOf(arr))
+	case []int:
+		var arr []int
+		for _, s := range strings.Split(value, ",") {
+			if s = strings.TrimSpace(s); s == "" {
+				continue
+			}
+			var n int
+			if n, err = strconv.Atoi(s); err != nil {
+				return
+			}
+			arr = append(arr, n)
+		}
+		f.Set(reflect.ValueOf(arr))
+	default:
+		err = errorf.E("unsupported field type %s", f.Type())
+	}
+	return
+}
+
+// Changed returns the env keys of the fields whose values differ between a
+// and b, sorted.
+func Changed(a, b *C) (keys []string) {
+	ka, kb := EnvKV(*a), EnvKV(*b)
+	for i := range ka {
+		if ka[i].Value != kb[i].Value {
+			keys = append(keys, ka[i].Key)
+		}
+	}
+	sort.Strings(keys)
+	return
+}
+
+// Update copies the fields of src with the given env keys into dst.
+func Update(dst, src *C, keys ...string) {
+	d, s := reflect.ValueOf(dst).Elem(), reflect.ValueOf(src).Elem()
+	t := d.Type()
+	for i := 0; i < t.NumField(); i++ {
+		k := t.Field(i).Tag.Get("env")
+		for _, key := range keys {
+			if k == key {
+				d.Field(i).Set(s.Field(i))
+				break
+			}
+		}
+	}
+}
+
+// WriteEnv merges key/value pairs into the .env file at path, creating it if
+// it doesn't exist.
+//
+// # Parameters
+//
+//   - path: Location of the .env file.
+//
+//   - kvs: The pairs to set, which replace any existing values of the same
+//     keys.
+//
+// # Return Values
+//
+//   - err: An error if the existing file can't be read or the new one can't be
+//     written.
+//
+// # Expected Behaviour
+//
+// The new file is written next to the old one and renamed over it, so that a
+// crash while writing never leaves a truncated configuration behind. Keys are
+// written sorted, and the file mode is 0600 because it may hold secrets.
+func WriteEnv(path string, kvs KVSlice) (err error) {
+	var existing KVSlice
+	if apputil.FileExists(path) {
+		var e env2.Env
+		if e, err = env2.GetEnv(path); chk.E(err) {
+			return
+		}
+		for k, v := range e {
+			existing = append(existing, KV{k, v})
+		}
+	}
+	out := existing.Compose(kvs)
+	sort.Sort(out)
+	var b strings.Builder
+	for _, kv := range out {
+		_, _ = fmt.Fprintf(&b, "%s=%s\n", kv.Key, kv.Value)
+	}
+	dir := filepath.Dir(path)
+	if err = os.MkdirAll(dir, 0700); chk.E(err) {
+		return
+	}
+	var f *os.File
+	if f, err = os.CreateTemp(dir, ".env-*"); chk.E(err) {
+		return
+	}
+	tmp := f.Name()
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmp)
+		}
+	}()
+	if _, err = f.WriteString(b.String()); chk.E(err) {
+		_ = f.Close()
+		return
+	}
+	if err = f.Sync(); chk.E(err) {
+		_ = f.Close()
+		return
+	}
+	if err = f.Close(); chk.E(err) {
+		return
+	}
+	if err = os.Rename(tmp, path); chk.E(err) {
+		return
+	}
+	return
+}
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	env2 "orly.dev/pkg/utils/env"
+)
+
+func TestSet(t *testing.T) {
+	c := &C{}
+	for _, kv := range []KV{
+		{"ORLY_LOG_LEVEL", "debug"},
+		{"ORLY_GUEST_MAX_LIMIT", "20"},
+		{"ORLY_HIDE_MUTED", "true"},
+		{"ORLY_MAX_EVENT_AGE", "1h"},
+		{"ORLY_BLACKLIST", "a, b,"},
+		{"ORLY_GUEST_READ_KINDS", "0,3"},
+	} {
+		if err := Set(c, kv.Key, kv.Value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.LogLevel != "debug" || c.GuestMaxLimit != 20 || !c.HideMuted ||
+		c.MaxEventAge != time.Hour ||
+		!slices.Equal(c.Blacklist, []string{"a", "b"}) ||
+		!slices.Equal(c.GuestReadKinds, []int{0, 3}) {
+		t.Fatalf("unexpected configuration %+v", c)
+	}
+	if err := Set(c, "ORLY_GUEST_MAX_LIMIT", "x"); err == nil {
+		t.Fatal("invalid int was accepted")
+	}
+	if err := Set(c, "ORLY_NOPE", "x"); err == nil {
+		t.Fatal("unknown key was accepted")
+	}
+}
+
+func TestChangedUpdate(t *testing.T) {
+	a := &C{LogLevel: "info", Port: 1}
+	b := &C{LogLevel: "debug", Port: 2, Owners: []string{"x"}}
+	changed := Changed(a, b)
+	if !slices.Equal(
+		changed, []string{"ORLY_LOG_LEVEL", "ORLY_OWNERS", "ORLY_PORT"},
+	) {
+		t.Fatalf("changed %v", changed)
+	}
+	Update(a, b, "ORLY_LOG_LEVEL", "ORLY_OWNERS")
+	if a.LogLevel != "debug" || a.Port != 1 || len(a.Owners) != 1 {
+		t.Fatalf("unexpected update %+v", a)
+	}
+}
+
+func TestWriteEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orly", ".env")
+	if err := WriteEnv(path, KVSlice{{"B", "1"}, {"A", "2"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteEnv(path, KVSlice{{"B", "3"}}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "A=2\nB=3\n" {
+		t.Fatalf("unexpected .env file %q", b)
+	}
+	var e env2.Env
+	if e, err = env2.GetEnv(path); err != nil || e["B"] != "3" {
+		t.Fatalf("could not read back .env file: %v %v", e, err)
+	}
+	files, _ := os.ReadDir(filepath.Dir(path))
+	if len(files) != 1 {
+		t.Fatalf("temporary file left behind: %v", files)
+	}
+}
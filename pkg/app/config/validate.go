@@ -0,0 +1,332 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+
+	"go-simpler.org/env"
+
+	"orly.dev/pkg/encoders/bech32encoding"
+	"orly.dev/pkg/utils/keys"
+	"orly.dev/pkg/utils/lol"
+)
+
+// Error is an invalid value of a configuration key.
+type Error struct {
+	Key    string
+	Value  string
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s=%s: %s", e.Key, e.Value, e.Reason)
+}
+
+// Errors is the list of all the invalid values of a configuration.
+type Errors []*Error
+
+func (e Errors) Error() string {
+	s := make([]string, len(e))
+	for i := range e {
+		s[i] = e[i].Error()
+	}
+	return strings.Join(s, "\n")
+}
+
+// Of returns the errors of the value of a key.
+func (e Errors) Of(key string) (errs Errors) {
+	for _, err := range e {
+		if err.Key == key {
+			errs = append(errs, err)
+		}
+	}
+	return
+}
+
+// checked is an env.Source that leaves out the values that don't parse as the
+// type of their field, collecting them in errs, so the defaults are used in
+// their place and the other values can still be loaded and validated.
+type checked struct {
+	env.Source
+	errs *Errors
+}
+
+func (c checked) LookupEnv(key string) (value string, ok bool) {
+	if value, ok = c.Source.LookupEnv(key); !ok {
+		return
+	}
+	v := reflect.ValueOf(&C{}).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("env") != key {
+			continue
+		}
+		if err := parseField(v.Field(i), value); err != nil {
+			*c.errs = append(
+				*c.errs, &Error{
+					Key: key, Value: value,
+					Reason: fmt.Sprintf(
+						"%v, using the default '%s'", err,
+						t.Field(i).Tag.Get("default"),
+					),
+				},
+			)
+			return "", false
+		}
+	}
+	return
+}
+
+// Validate checks the values of the configuration, and returns all the
+// problems found as Errors, or nil if there are none.
+//
+// # Expected Behaviour
+//
+// - Log levels, ORLY_PPROF and ORLY_SPIDER_TYPE must be one of their allowed
+// values.
+//
+// - Numbers and durations must not be negative, the port and frequency of the
+// spider must be more than zero, and the trace sample percentage must be from
+// 0 to 100.
+//
+// - Pubkeys must be npub or 64 character hex, ORLY_SECRET_KEY must be a nsec,
+// ncryptsec or 64 character hex key, and peer relays must be
+// <pubkey>@<ws or wss url>.
+//
+// - Listen addresses must be <host>:<port>, TLS certificates <domain>:<path>,
+// and the OTLP endpoint a http or https url.
+//
+// - Settings that need another setting must have it.
+func (cfg *C) Validate() (err error) {
+	var errs Errors
+	bad := func(key, value, format string, a ...any) {
+		errs = append(
+			errs, &Error{
+				Key: key, Value: value, Reason: fmt.Sprintf(format, a...),
+			},
+		)
+	}
+	oneOf := func(key, value string, allowed ...string) {
+		if !slices.Contains(allowed, value) {
+			bad(
+				key, value, "must be one of %s", strings.Join(allowed, ", "),
+			)
+		}
+	}
+	oneOf("ORLY_LOG_LEVEL", cfg.LogLevel, lol.LevelNames...)
+	oneOf("ORLY_DB_LOG_LEVEL", cfg.DbLogLevel, lol.LevelNames...)
+	oneOf("ORLY_PPROF", cfg.Pprof, "", "cpu", "memory", "allocation")
+	oneOf("ORLY_SPIDER_TYPE", cfg.SpiderType, "none", "directory", "follows")
+	if cfg.Port < 1 || cfg.Port > 65535 {
+		bad("ORLY_PORT", fmt.Sprint(cfg.Port), "must be from 1 to 65535")
+	}
+	if cfg.TraceSamplePercent < 0 || cfg.TraceSamplePercent > 100 {
+		bad(
+			"ORLY_TRACE_SAMPLE_PERCENT", fmt.Sprint(cfg.TraceSamplePercent),
+			"must be from 0 to 100",
+		)
+	}
+	if cfg.OTLPEndpoint != "" {
+		if u, e := url.Parse(cfg.OTLPEndpoint); e != nil ||
+			(u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			bad(
+				"ORLY_OTLP_ENDPOINT", cfg.OTLPEndpoint,
+				"must be a http or https url",
+			)
+		}
+	}
+	if cfg.SpiderTime <= 0 {
+		bad(
+			"ORLY_SPIDER_FREQUENCY", cfg.SpiderTime.String(),
+			"must be more than zero",
+		)
+	}
+	if cfg.SpiderConcurrency < 1 {
+		bad(
+			"ORLY_SPIDER_CONCURRENCY", fmt.Sprint(cfg.SpiderConcurrency),
+			"must be at least 1",
+		)
+	}
+	if cfg.WoTDepth < 0 || cfg.WoTDepth > 3 {
+		bad("ORLY_WOT_DEPTH", fmt.Sprint(cfg.WoTDepth), "must be from 0 to 3")
+	}
+	if cfg.WoTMinFollows < 1 {
+		bad(
+			"ORLY_WOT_MIN_FOLLOWS", fmt.Sprint(cfg.WoTMinFollows),
+			"must be at least 1",
+		)
+	}
+	if cfg.SpiderTimeout <= 0 {
+		bad(
+			"ORLY_SPIDER_TIMEOUT", cfg.SpiderTimeout.String(),
+			"must be more than zero",
+		)
+	}
+	for key, n := range map[string]int64{
+		"ORLY_GUEST_MAX_LIMIT":      int64(cfg.GuestMaxLimit),
+		"ORLY_USER_MAX_LIMIT":       int64(cfg.UserMaxLimit),
+		"ORLY_GUEST_MAX_TIME_RANGE": int64(cfg.GuestMaxTimeRange),
+		"ORLY_USER_MAX_TIME_RANGE":  int64(cfg.UserMaxTimeRange),
+		"ORLY_GIFT_WRAP_BACKDATE":   int64(cfg.GiftWrapBackdate),
+		"ORLY_MAX_FUTURE_SKEW":      int64(cfg.MaxFutureSkew),
+		"ORLY_MAX_EVENT_AGE":        int64(cfg.MaxEventAge),
+		"ORLY_SLOW_QUERY":           int64(cfg.SlowQuery),
+		"ORLY_MONTHLY_PRICE_SATS":   cfg.MonthlyPriceSats,
+		"ORLY_WOT_MUTE_THRESHOLD":   int64(cfg.WoTMuteThreshold),
+	} {
+		if n < 0 {
+			bad(key, value(cfg, key), "must not be negative")
+		}
+	}
+	if cfg.RelaySecretFD < -1 {
+		bad(
+			"ORLY_SECRET_KEY_PASSPHRASE_FD", fmt.Sprint(cfg.RelaySecretFD),
+			"must be a file descriptor number, or -1 to prompt",
+		)
+	}
+	for key, list := range map[string][]int{
+		"ORLY_GUEST_READ_KINDS": cfg.GuestReadKinds,
+		"ORLY_COSIGN_KINDS":     cfg.CosignKinds,
+	} {
+		for _, k := range list {
+			if k < 0 || k > 65535 {
+				bad(key, fmt.Sprint(k), "kinds must be from 0 to 65535")
+			}
+		}
+	}
+	for key, list := range map[string][]string{
+		"ORLY_OWNERS":    cfg.Owners,
+		"ORLY_BLACKLIST": cfg.Blacklist,
+		"ORLY_OPERATORS": cfg.Operators,
+	} {
+		for _, v := range list {
+			if v != "" && !isPubkey(v) {
+				bad(key, v, "must be an npub or 64 character hex pubkey")
+			}
+		}
+	}
+	for _, v := range cfg.PeerRelays {
+		if v == "" {
+			continue
+		}
+		split := strings.Split(v, "@")
+		if len(split) != 2 || !isPubkey(split[0]) || !isRelayURL(split[1]) {
+			bad(
+				"ORLY_PEER_RELAYS", v,
+				"must be <pubkey>@<url> with a npub or hex pubkey and a ws or wss url",
+			)
+		}
+	}
+	for _, v := range cfg.SpiderSeeds {
+		if v != "" && !isRelayURL(v) {
+			bad("ORLY_SPIDER_SEEDS", v, "must be a ws or wss url")
+		}
+	}
+	if cfg.CosignRelay != "" && !isRelayURL(cfg.CosignRelay) {
+		bad("ORLY_COSIGN_RELAY", cfg.CosignRelay, "must be a ws or wss url")
+	}
+	for key, addr := range map[string]string{
+		"ORLY_TLS_LISTEN":   cfg.TLSListen,
+		"ORLY_ACME_HTTP":    cfg.ACMEHTTP,
+		"ORLY_ADMIN_LISTEN": cfg.AdminListen,
+	} {
+		if _, _, e := net.SplitHostPort(addr); addr != "" && e != nil {
+			bad(key, addr, "must be <host>:<port>")
+		}
+	}
+	for key, list := range map[string][]string{
+		"ORLY_WHITELIST":   cfg.Whitelist,
+		"ORLY_BLOCKED_IPS": cfg.BlockedIPs,
+	} {
+		for _, v := range list {
+			if v != "" && !isIPOrCIDR(v) {
+				bad(key, v, "must be an IP address or a CIDR block")
+			}
+		}
+	}
+	for _, v := range cfg.TLSCerts {
+		if split := strings.SplitN(v, ":", 2); len(split) != 2 ||
+			split[0] == "" || split[1] == "" {
+			bad("ORLY_TLS_CERTS", v, "must be <domain>:<path>")
+		}
+	}
+	if cfg.TLSListen != "" && len(cfg.TLSCerts) == 0 && len(cfg.ACMEDomains) == 0 {
+		bad(
+			"ORLY_TLS_LISTEN", cfg.TLSListen,
+			"needs ORLY_TLS_CERTS or ORLY_ACME_DOMAINS",
+		)
+	}
+	if cfg.ACMEHTTP != "" && len(cfg.ACMEDomains) == 0 {
+		bad("ORLY_ACME_HTTP", cfg.ACMEHTTP, "needs ORLY_ACME_DOMAINS")
+	}
+	if cfg.RelaySecret != "" && !bech32encoding.IsNcryptsec(cfg.RelaySecret) {
+		if _, err = keys.DecodeSecret(cfg.RelaySecret, nil); err != nil {
+			// the secret itself is not repeated in the error.
+			bad(
+				"ORLY_SECRET_KEY", "...",
+				"must be a nsec, ncryptsec or 64 character hex secret key",
+			)
+		}
+		err = nil
+	}
+	if len(cfg.Operators) > 0 {
+		if cfg.CosignRelay == "" {
+			bad(
+				"ORLY_COSIGN_RELAY", "", "is required when ORLY_OPERATORS is set",
+			)
+		}
+		if cfg.RelaySecret == "" {
+			bad(
+				"ORLY_SECRET_KEY", "",
+				"is required when ORLY_OPERATORS is set",
+			)
+		}
+	}
+	if cfg.SubscriptionEnabled && cfg.NWCUri == "" {
+		bad(
+			"ORLY_NWC_URI", "", "is required when ORLY_SUBSCRIPTION_ENABLED is true",
+		)
+	}
+	if len(errs) > 0 {
+		slices.SortStableFunc(
+			errs, func(a, b *Error) int { return strings.Compare(a.Key, b.Key) },
+		)
+		err = errs
+	}
+	return
+}
+
+// value returns the value of a key as it is written in the environment.
+func value(cfg *C, key string) string {
+	for _, kv := range EnvKV(*cfg) {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return ""
+}
+
+// isPubkey returns true if v is an npub or a 64 character hex pubkey.
+func isPubkey(v string) bool {
+	pk, err := keys.DecodeNpubOrHex(v)
+	return err == nil && len(pk) == 32
+}
+
+// isIPOrCIDR returns true if v is an IP address or a CIDR block.
+func isIPOrCIDR(v string) bool {
+	if _, _, err := net.ParseCIDR(v); err == nil {
+		return true
+	}
+	return net.ParseIP(v) != nil
+}
+
+// isRelayURL returns true if v is a ws or wss url with a host.
+func isRelayURL(v string) bool {
+	u, err := url.Parse(v)
+	return err == nil && (u.Scheme == "ws" || u.Scheme == "wss") &&
+		u.Host != ""
+}
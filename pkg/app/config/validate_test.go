@@ -0,0 +1,69 @@
+package config
+
+import (
+	"errors"
+	"testing"
+
+	"go-simpler.org/env"
+)
+
+func TestValidate(t *testing.T) {
+	cfg := &C{}
+	if err := env.Load(cfg, &env.Options{SliceSep: ","}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("defaults are invalid: %v", err)
+	}
+	cfg.SpiderType = "foo"
+	cfg.Owners = []string{"npub1nope"}
+	cfg.PeerRelays = []string{
+		"0000000000000000000000000000000000000000000000000000000000000001|wss://relay.example.com",
+	}
+	cfg.MonthlyPriceSats = -1
+	cfg.TraceSamplePercent = 101
+	cfg.OTLPEndpoint = "localhost:4318"
+	cfg.BlockedIPs = []string{"192.0.2.0/24", "2001:db8::1", "192.0.2."}
+	err := cfg.Validate()
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected Errors, got %v", err)
+	}
+	for _, key := range []string{
+		"ORLY_SPIDER_TYPE", "ORLY_OWNERS", "ORLY_PEER_RELAYS",
+		"ORLY_MONTHLY_PRICE_SATS", "ORLY_TRACE_SAMPLE_PERCENT",
+		"ORLY_OTLP_ENDPOINT", "ORLY_BLOCKED_IPS",
+	} {
+		if len(errs.Of(key)) != 1 {
+			t.Errorf("expected an error for %s in %v", key, errs)
+		}
+	}
+	if len(errs) != 7 {
+		t.Errorf("expected 7 errors, got %d: %v", len(errs), errs)
+	}
+}
+
+func TestChecked(t *testing.T) {
+	var errs Errors
+	cfg := &C{}
+	src := checked{
+		env.Map(
+			map[string]string{
+				"ORLY_PORT":             "abc",
+				"ORLY_LISTEN":           "127.0.0.1",
+				"ORLY_GUEST_READ_KINDS": "1,x",
+			},
+		), &errs,
+	}
+	if err := env.Load(
+		cfg, &env.Options{SliceSep: ",", Source: src},
+	); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != 3334 || cfg.Listen != "127.0.0.1" {
+		t.Errorf("unexpected values port %d listen %s", cfg.Port, cfg.Listen)
+	}
+	if len(errs.Of("ORLY_PORT")) != 1 || len(errs.Of("ORLY_GUEST_READ_KINDS")) != 1 {
+		t.Errorf("expected errors for ORLY_PORT and ORLY_GUEST_READ_KINDS, got %v", errs)
+	}
+}
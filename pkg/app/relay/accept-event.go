@@ -2,7 +2,6 @@ package relay
 
 import (
 	"net/http"
-	"orly.dev/pkg/utils"
 	"time"
 
 	"orly.dev/pkg/database"
@@ -37,17 +36,62 @@ import (
 //
 // # Expected Behaviour:
 //
-// - If subscriptions are enabled, check subscription status for non-directory events
+// - Reject events with a created_at outside of MaxFutureSkew and MaxEventAge,
+// with GiftWrapBackdate extra tolerance for backdated gift wraps.
+//
+// - Apply the relay management lists of NIP-86 with acceptManaged.
+//
+// - If the relay is a NIP-17 inbox, reject events by blacklisted authors and
+// from muted users, and otherwise only the acceptInbox policy applies.
+//
+// - If GiftWrapWhitelistOnly is set, reject gift wraps not addressed to a
+// whitelisted pubkey.
+//
+// - If subscriptions are enabled, check subscription status for non-directory
+// events other than gift wraps
 //
 // - If authentication is required and no public key is provided, reject the event.
 //
+// - Reject events by blacklisted authors.
+//
+// - Accept events from authed users on the NIP-86 allowed pubkeys.
+//
 // - Otherwise, accept the event for processing.
 func (s *Server) AcceptEvent(
 	c context.T, ev *event.E, hr *http.Request, authedPubkey []byte,
 	remote string,
 ) (accept bool, notice string, afterSave func()) {
-	// Check subscription if enabled
-	if s.C.SubscriptionEnabled {
+	k := kindLabel(ev)
+	eventsReceived.Inc(k)
+	defer func() {
+		if !accept {
+			eventsRejected.Inc(rejectReason(notice, "blocked"), k)
+		}
+	}()
+	if accept, notice = s.acceptCreatedAt(ev); !accept {
+		return
+	}
+	if accept, notice = s.acceptManaged(ev, authedPubkey, remote); !accept {
+		return
+	}
+	if s.Config().Inbox {
+		if s.blacklisted(ev.Pubkey) {
+			return false, "event author is blacklisted", nil
+		}
+		if s.IsMuted(authedPubkey) {
+			return false, "event author is banned from this relay", nil
+		}
+		accept, notice = s.acceptInbox(c, ev, hr, authedPubkey)
+		return
+	}
+	if accept, notice = s.acceptGiftWrap(ev); !accept {
+		return
+	}
+	accept = false
+	// Check subscription if enabled. Gift wraps are signed by a random
+	// single-use key that can never have a subscription, so they are exempt;
+	// use GiftWrapWhitelistOnly to restrict them to the users of the relay.
+	if s.Config().SubscriptionEnabled && !ev.Kind.IsGiftWrap() {
 		// Skip subscription check for directory events (kinds 0, 3, 10002)
 		kindInt := ev.Kind.ToInt()
 		isDirectoryEvent := kindInt == 0 || kindInt == 3 || kindInt == 10002
@@ -103,14 +147,10 @@ func (s *Server) AcceptEvent(
 
 	if !s.AuthRequired() {
 		// Check blacklist for public relay mode
-		if len(s.blacklistPubkeys) > 0 {
-			for _, blockedPubkey := range s.blacklistPubkeys {
-				if utils.FastEqual(blockedPubkey, ev.Pubkey) {
-					notice = "event author is blacklisted"
-					accept = false
-					return
-				}
-			}
+		if s.blacklisted(ev.Pubkey) {
+			notice = "event author is blacklisted"
+			accept = false
+			return
 		}
 		accept = true
 		return
@@ -121,21 +161,21 @@ func (s *Server) AcceptEvent(
 		accept = false
 		return
 	}
-	for _, u := range s.OwnersMuted() {
-		if utils.FastEqual(u, authedPubkey) {
-			notice = "event author is banned from this relay"
-			accept = false
-			return
-		}
+	if s.blacklisted(ev.Pubkey) {
+		notice = "event author is blacklisted"
+		accept = false
+		return
 	}
-	// check if the authed user is on the lists
-	list := append(s.OwnersFollowed(), s.FollowedFollows()...)
-	for _, u := range list {
-		if utils.FastEqual(u, authedPubkey) {
-			accept = true
-			return
-		}
+	if s.IsMuted(authedPubkey) {
+		notice = "event author is banned from this relay"
+		accept = false
+		return
 	}
-	accept = false
+	if s.Management.Has(database.AllowedPubkeys, hex.Enc(authedPubkey)) {
+		accept = true
+		return
+	}
+	// check if the authed user is on the lists
+	accept = s.IsTrusted(authedPubkey)
 	return
 }
@@ -337,7 +337,7 @@ func TestAcceptEventWithBlacklist(t *testing.T) {
 		)
 	}
 
-	// Test with auth required - blacklist should not apply
+	// Test with auth required - the client must auth before the blacklist applies
 	s.C.AuthRequired = true
 	accept, notice, _ = s.AcceptEvent(ctx, blockedEvent, req, nil, "127.0.0.1")
 	if accept {
@@ -2,12 +2,24 @@ package relay
 
 import (
 	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"orly.dev/pkg/encoders/filter"
 	"orly.dev/pkg/encoders/filters"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/timestamp"
 	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/log"
+	"orly.dev/pkg/utils/tracing"
 )
 
 // AcceptReq determines whether a request should be accepted based on
-// authentication and public readability settings.
+// authentication and public readability settings, and rewrites its filters
+// according to the read policy of the relay.
 //
 // # Parameters
 //
@@ -32,19 +44,191 @@ import (
 //
 // # Expected Behaviour:
 //
+// - Reject requests from blocked IP addresses and banned pubkeys.
+//
 // - If authentication is required and there's no authenticated public key,
 // reject the request.
 //
-// - Otherwise, accept the request.
+// - Owners are exempt from the read policy and their filters are accepted
+// unchanged.
+//
+// - Otherwise, each filter is rewritten by readPolicy, dropping filters that
+// cannot be permitted at all. If no filters remain the request is rejected.
 func (s *Server) AcceptReq(
 	c context.T, hr *http.Request, ff *filters.T,
 	authedPubkey []byte, remote string,
 ) (allowed *filters.T, accept bool, modified bool) {
+	_, span := tracing.Start(c, "relay.AcceptReq")
+	defer func() {
+		span.SetAttributes(
+			attribute.Bool("accept", accept),
+			attribute.Bool("modified", modified),
+		)
+		span.End()
+	}()
+	if !s.acceptManagedReq(authedPubkey, remote) {
+		return
+	}
 	// if auth is required, and not public readable, reject
 	if s.AuthRequired() && len(authedPubkey) == 0 && !s.PublicReadable() {
 		return
 	}
-	allowed = ff
+	if s.isOwner(authedPubkey) {
+		allowed = ff
+		accept = true
+		return
+	}
+	kept := filters.New()
+	for _, f := range ff.F {
+		keep, changed := s.readPolicy(f, authedPubkey)
+		if changed {
+			modified = true
+		}
+		if !keep {
+			log.T.F("dropped filter %s from %s", f.Marshal(nil), remote)
+			continue
+		}
+		kept.F = append(kept.F, f)
+	}
+	switch {
+	case len(kept.F) == len(ff.F):
+		// filters may have been narrowed in place, but none were dropped.
+		allowed = ff
+	case len(kept.F) == 0:
+		return
+	default:
+		allowed = kept
+	}
 	accept = true
 	return
 }
+
+// AuthorHidden returns true if events authored by pubkey should not be served
+// to readers, because the relay is configured to hide events from pubkeys
+// muted by the owners.
+func (s *Server) AuthorHidden(pubkey []byte) (hidden bool) {
+	return s.Config().HideMuted && s.IsMuted(pubkey)
+}
+
+// isOwner returns true if the pubkey is one of the relay owners.
+func (s *Server) isOwner(pubkey []byte) bool {
+	return len(pubkey) > 0 && s.IsOwner(pubkey)
+}
+
+// readPolicy rewrites a filter to conform to the read policy for the tier of
+// the client, unauthenticated or authenticated.
+//
+// # Return Values
+//
+//   - keep: false if the filter cannot be permitted and must be dropped.
+//
+//   - modified: true if the filter was narrowed from what the client asked
+//     for, or dropped.
+//
+// # Expected Behaviour:
+//
+// - With RequireSelector, filters without ids, authors or tags are dropped.
+//
+// - With HideMuted, muted pubkeys are removed from the authors of the filter,
+// and the filter is dropped if only muted authors were requested.
+//
+// - For unauthenticated clients with GuestReadKinds, the kinds of the filter
+// are narrowed to the permitted kinds, and the filter is dropped if none of
+// the requested kinds are permitted.
+//
+// - The limit is capped at the maximum for the tier, and set to it if the
+// filter has no limit and no ids.
+//
+// - The time range is capped at the maximum for the tier by moving since
+// forward, and since is set if the filter has none. Filters for gift wraps get
+// GiftWrapBackdate added to the range, as their created_at is randomized into
+// the past.
+func (s *Server) readPolicy(
+	f *filter.F, authedPubkey []byte,
+) (keep bool, modified bool) {
+	cfg := s.Config()
+	guest := len(authedPubkey) == 0
+	if cfg.RequireSelector && f.Ids.Len() == 0 && f.Authors.Len() == 0 &&
+		!hasTagSelector(f) {
+		modified = true
+		return
+	}
+	if cfg.HideMuted && f.Authors.Len() > 0 {
+		authors := tag.NewWithCap(f.Authors.Len())
+		for _, pk := range f.Authors.ToSliceOfBytes() {
+			if s.AuthorHidden(pk) {
+				modified = true
+				continue
+			}
+			authors = authors.Append(pk)
+		}
+		if authors.Len() == 0 {
+			return
+		}
+		f.Authors = authors
+	}
+	if guest && len(cfg.GuestReadKinds) > 0 {
+		permitted := kinds.FromIntSlice(cfg.GuestReadKinds)
+		if f.Kinds.Len() == 0 {
+			f.Kinds = permitted
+		} else {
+			narrowed := kinds.NewWithCap(f.Kinds.Len())
+			for _, k := range f.Kinds.K {
+				if permitted.Contains(k) {
+					narrowed.K = append(narrowed.K, kind.New(k.K))
+					continue
+				}
+				modified = true
+			}
+			if narrowed.Len() == 0 {
+				return
+			}
+			f.Kinds = narrowed
+		}
+	}
+	maxLimit, maxRange := cfg.UserMaxLimit, cfg.UserMaxTimeRange
+	if guest {
+		maxLimit, maxRange = cfg.GuestMaxLimit, cfg.GuestMaxTimeRange
+	}
+	if maxLimit > 0 {
+		// a filter of ids is already bounded by the number of ids.
+		if f.Limit == nil && f.Ids.Len() == 0 {
+			lim := uint(maxLimit)
+			f.Limit = &lim
+		} else if f.Limit != nil && *f.Limit > uint(maxLimit) {
+			*f.Limit = uint(maxLimit)
+			modified = true
+		}
+	}
+	if maxRange > 0 {
+		if f.Kinds.IsGiftWrap() {
+			maxRange += cfg.GiftWrapBackdate
+		}
+		until := time.Now()
+		if f.Until != nil && f.Until.I64() != 0 {
+			until = f.Until.Time()
+		}
+		earliest := until.Add(-maxRange).Unix()
+		if f.Since == nil || f.Since.I64() == 0 {
+			f.Since = timestamp.FromUnix(earliest)
+		} else if f.Since.I64() < earliest {
+			f.Since = timestamp.FromUnix(earliest)
+			modified = true
+		}
+	}
+	keep = true
+	return
+}
+
+// hasTagSelector returns true if a filter has at least one tag with a value.
+func hasTagSelector(f *filter.F) bool {
+	if f.Tags == nil {
+		return false
+	}
+	for _, t := range f.Tags.ToSliceOfTags() {
+		if t.Len() >= 2 {
+			return true
+		}
+	}
+	return false
+}
@@ -1,11 +1,16 @@
 package relay
 
 import (
+	"bytes"
 	"net/http"
 	"testing"
+	"time"
 
 	"orly.dev/pkg/app/config"
+	"orly.dev/pkg/encoders/filter"
 	"orly.dev/pkg/encoders/filters"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/kinds"
 	"orly.dev/pkg/utils/context"
 )
 
@@ -208,3 +213,147 @@ func TestAcceptReqWithRealServer(t *testing.T) {
 		t.Error("AcceptReq() modified = true, want false")
 	}
 }
+
+// TestAcceptReqReadPolicy tests the filter rewriting of the read policy.
+func TestAcceptReqReadPolicy(t *testing.T) {
+	ctx := context.Bg()
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	owner := []byte("owner-pubkey-000000000000000000")
+	muted := []byte("muted-pubkey-000000000000000000")
+	user := []byte("user-pubkey-0000000000000000000")
+	newServer := func() (s *Server) {
+		s = &Server{
+			C: &config.C{
+				PublicReadable:    true,
+				GuestReadKinds:    []int{0, 1},
+				GuestMaxLimit:     100,
+				UserMaxLimit:      1000,
+				GuestMaxTimeRange: 24 * time.Hour,
+				RequireSelector:   true,
+				HideMuted:         true,
+			},
+			Lists: new(Lists),
+		}
+		s.SetOwnersPubkeys([][]byte{owner})
+		s.SetOwnersMuted([][]byte{muted})
+		return
+	}
+	withAuthors := func(pks ...[]byte) (f *filter.F) {
+		f = filter.New()
+		for _, pk := range pks {
+			f.Authors = f.Authors.Append(pk)
+		}
+		return
+	}
+
+	t.Run(
+		"wide open filter is dropped", func(t *testing.T) {
+			s := newServer()
+			_, accept, modified := s.AcceptReq(
+				ctx, req, filters.New(filter.New()), user, "127.0.0.1",
+			)
+			if accept || !modified {
+				t.Errorf("accept = %v modified = %v, want false true", accept, modified)
+			}
+		},
+	)
+	t.Run(
+		"owner is exempt", func(t *testing.T) {
+			s := newServer()
+			ff := filters.New(filter.New())
+			allowed, accept, modified := s.AcceptReq(ctx, req, ff, owner, "127.0.0.1")
+			if !accept || modified || allowed != ff {
+				t.Errorf("accept = %v modified = %v, want true false", accept, modified)
+			}
+			if ff.F[0].Limit != nil {
+				t.Error("owner filter limit should not be set")
+			}
+		},
+	)
+	t.Run(
+		"guest kinds and limit are narrowed", func(t *testing.T) {
+			s := newServer()
+			f := withAuthors(user)
+			f.Kinds = kinds.New(kind.New(1), kind.New(4))
+			lim := uint(5000)
+			f.Limit = &lim
+			allowed, accept, modified := s.AcceptReq(
+				ctx, req, filters.New(f), nil, "127.0.0.1",
+			)
+			if !accept || !modified {
+				t.Fatalf("accept = %v modified = %v, want true true", accept, modified)
+			}
+			got := allowed.F[0]
+			if got.Kinds.Len() != 1 || got.Kinds.K[0].K != 1 {
+				t.Errorf("kinds = %v, want [1]", got.Kinds.ToUint16())
+			}
+			if *got.Limit != 100 {
+				t.Errorf("limit = %d, want 100", *got.Limit)
+			}
+			if got.Since == nil ||
+				time.Since(got.Since.Time()) > 24*time.Hour+time.Minute {
+				t.Error("since should be set to within the guest time range")
+			}
+		},
+	)
+	t.Run(
+		"guest filter without permitted kinds is rejected", func(t *testing.T) {
+			s := newServer()
+			f := withAuthors(user)
+			f.Kinds = kinds.New(kind.New(4))
+			_, accept, _ := s.AcceptReq(ctx, req, filters.New(f), nil, "127.0.0.1")
+			if accept {
+				t.Error("accept = true, want false")
+			}
+		},
+	)
+	t.Run(
+		"authed user limit is set without modification", func(t *testing.T) {
+			s := newServer()
+			f := withAuthors(user)
+			f.Kinds = kinds.New(kind.New(4))
+			allowed, accept, modified := s.AcceptReq(
+				ctx, req, filters.New(f), user, "127.0.0.1",
+			)
+			if !accept || modified {
+				t.Fatalf("accept = %v modified = %v, want true false", accept, modified)
+			}
+			if *allowed.F[0].Limit != 1000 {
+				t.Errorf("limit = %d, want 1000", *allowed.F[0].Limit)
+			}
+		},
+	)
+	t.Run(
+		"ids filter without a limit is left unlimited", func(t *testing.T) {
+			s := newServer()
+			f := filter.New()
+			f.Ids = f.Ids.Append(bytes.Repeat([]byte{1}, 32))
+			allowed, accept, _ := s.AcceptReq(
+				ctx, req, filters.New(f), user, "127.0.0.1",
+			)
+			if !accept {
+				t.Fatal("accept = false, want true")
+			}
+			if allowed.F[0].Limit != nil {
+				t.Errorf("limit = %d, want none", *allowed.F[0].Limit)
+			}
+		},
+	)
+	t.Run(
+		"muted authors are removed", func(t *testing.T) {
+			s := newServer()
+			ff := filters.New(withAuthors(muted, user), withAuthors(muted))
+			allowed, accept, modified := s.AcceptReq(ctx, req, ff, user, "127.0.0.1")
+			if !accept || !modified {
+				t.Fatalf("accept = %v modified = %v, want true true", accept, modified)
+			}
+			if allowed.Len() != 1 || allowed.F[0].Authors.Len() != 1 ||
+				!allowed.F[0].Authors.Contains(user) {
+				t.Errorf("allowed = %s, want only the unmuted author", allowed)
+			}
+			if !s.AuthorHidden(muted) || s.AuthorHidden(user) {
+				t.Error("only the muted author should be hidden")
+			}
+		},
+	)
+}
@@ -2,20 +2,24 @@ package relay
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"go.opentelemetry.io/otel/attribute"
 	"io"
 	"net/http"
 	"net/url"
-	"orly.dev/pkg/crypto/ec/secp256k1"
+	"orly.dev/pkg/crypto/ec/schnorr"
 	"orly.dev/pkg/encoders/hex"
 	"orly.dev/pkg/protocol/httpauth"
 	"orly.dev/pkg/utils"
 	"orly.dev/pkg/utils/chk"
 	"orly.dev/pkg/utils/log"
+	"orly.dev/pkg/utils/tracing"
 	realy_lol "orly.dev/pkg/version"
 	"regexp"
 	"strings"
+	"time"
 
 	"orly.dev/pkg/encoders/event"
 	"orly.dev/pkg/interfaces/relay"
@@ -73,10 +77,12 @@ func NewWriteCloser(w []byte) *WriteCloser {
 //
 // - Saves the event using the Publish method if it is not ephemeral.
 //
-// - Handles duplicate events by returning an appropriate error message.
+// - Accepts duplicate events, as the relay has them, with a duplicate: message.
 //
 // - Delivers the event to subscribers via the listeners' Deliver method.
 //
+// - Pushes the event to the peer relays in the background.
+//
 // - Returns a boolean indicating whether the event was accepted and any
 // relevant message.
 func (s *Server) AddEvent(
@@ -87,13 +93,30 @@ func (s *Server) AddEvent(
 	if ev == nil {
 		return false, normalize.Invalid.F("empty event")
 	}
+	received := time.Now()
+	k := kindLabel(ev)
+	c, span := tracing.Start(
+		c, "relay.AddEvent", attribute.Int("kind", int(ev.Kind.K)),
+	)
+	defer func() {
+		span.SetAttributes(attribute.Bool("accepted", accepted))
+		span.End()
+		// only a duplicate is accepted with a message.
+		if accepted && len(message) == 0 {
+			eventsAccepted.Inc(k)
+		} else {
+			eventsRejected.Inc(rejectReason(string(message), "error"), k)
+		}
+	}()
 	if ev.Kind.IsEphemeral() {
 	} else {
 		if saveErr := s.Publish(c, ev); saveErr != nil {
-			if errors.Is(saveErr, store.ErrDupEvent) {
-				return false, []byte(saveErr.Error())
-			}
 			errmsg := saveErr.Error()
+			// a duplicate is acknowledged, as the relay has the event.
+			if errors.Is(saveErr, store.ErrDupEvent) ||
+				strings.HasPrefix(errmsg, string(normalize.Duplicate)+":") {
+				return true, []byte(errmsg)
+			}
 			if NIP20prefixmatcher.MatchString(errmsg) {
 				if strings.Contains(errmsg, "tombstone") {
 					return false, normalize.Error.F(
@@ -113,84 +136,111 @@ func (s *Server) AddEvent(
 	// notify subscribers
 	s.listeners.Deliver(ev)
 	// push the new event to replicas if replicas are configured, and the relay
-	// has an identity key.
+	// has an identity key, its own or that of its operators. Signing the auth
+	// of each push may need a session with every operator, so it is done
+	// without holding up the client.
+	if addresses, _ := s.Peers.Replicas(); len(addresses) > 0 &&
+		s.Peers.I != nil &&
+		len(s.Peers.Pub()) == schnorr.PubKeyBytesLen {
+		// the event is copied as the buffer of the request it was decoded
+		// from may be reused once it returns.
+		go s.replicate(
+			bytes.Clone(ev.ID), ev.Marshal(nil), pubkeys, received,
+		)
+	}
+	accepted = true
+	return
+}
+
+// replicate pushes the event with the given id and JSON encoding evb to the
+// peer relays, other than those in pubkeys that it came through, with a
+// NIP-98 auth event signed by the relay.
+func (s *Server) replicate(
+	id, evb []byte, pubkeys [][]byte, received time.Time,
+) {
 	var err error
-	if len(s.Peers.Addresses) > 0 &&
-		len(s.Peers.I.Sec()) == secp256k1.SecKeyBytesLen {
-		evb := ev.Marshal(nil)
+	sum := sha256.Sum256(evb)
+	payloadHash := hex.Enc(sum[:])
+	addresses, peerPubkeys := s.Peers.Replicas()
+replica:
+	for i, a := range addresses {
+		// the peer address index is the same as the list of pubkeys
+		// (they're unpacked from a string containing both, appended at the
+		// same time), so if the pubkeys from the http event endpoint sent
+		// us here matches the index of this address, we can skip it.
+		for _, pk := range pubkeys {
+			if utils.FastEqual(peerPubkeys[i], pk) {
+				log.T.C(
+					func() string {
+						return fmt.Sprintf(
+							"not sending back to replica that just sent us this event %0x %s",
+							id, a,
+						)
+					},
+				)
+				continue replica
+			}
+		}
+		var ur *url.URL
+		if ur, err = url.Parse(a + "/api/event"); chk.E(err) {
+			continue
+		}
+		// each replica needs its own reader of the event.
 		var payload io.ReadCloser
 		payload = NewWriteCloser(evb)
-	replica:
-		for i, a := range s.Peers.Addresses {
-			// the peer address index is the same as the list of pubkeys
-			// (they're unpacked from a string containing both, appended at the
-			// same time), so if the pubkeys from the http event endpoint sent
-			// us here matches the index of this address, we can skip it.
-			for _, pk := range pubkeys {
-				if utils.FastEqual(s.Peers.Pubkeys[i], pk) {
-					log.T.C(
-						func() string {
-							return fmt.Sprintf(
-								"not sending back to replica that just sent us this event %0x %s",
-								ev.ID, a,
-							)
-						},
-					)
-					continue replica
-				}
-			}
-			var ur *url.URL
-			if ur, err = url.Parse(a + "/api/event"); chk.E(err) {
-				continue
-			}
-			var r *http.Request
-			r = &http.Request{
-				Method:        "POST",
-				URL:           ur,
-				Proto:         "HTTP/1.1",
-				ProtoMajor:    1,
-				ProtoMinor:    1,
-				Header:        make(http.Header),
-				Body:          payload,
-				ContentLength: int64(len(evb)),
-				Host:          ur.Host,
-			}
-			r.Header.Add("User-Agent", userAgent)
-			if err = httpauth.AddNIP98Header(
-				r, ur, "POST", "", s.Peers.I, 0,
-			); chk.E(err) {
-				continue
-			}
-			// add this replica's pubkey to the list to prevent re-sending to
-			// other replicas more than twice
-			pubkeys = append(pubkeys, s.Peers.Pub())
-			var pubkeysHeader []byte
-			for j, pk := range pubkeys {
-				pubkeysHeader = hex.EncAppend(pubkeysHeader, pk)
-				if j < len(pubkeys)-1 {
-					pubkeysHeader = append(pubkeysHeader, ':')
-				}
-			}
-			r.Header.Add("X-Pubkeys", string(pubkeysHeader))
-			r.GetBody = func() (rc io.ReadCloser, err error) {
-				rc = payload
-				return
-			}
-			client := &http.Client{}
-			if _, err = client.Do(r); chk.E(err) {
-				continue
+		var r *http.Request
+		r = &http.Request{
+			Method:        "POST",
+			URL:           ur,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        make(http.Header),
+			Body:          payload,
+			ContentLength: int64(len(evb)),
+			Host:          ur.Host,
+		}
+		r.Header.Add("User-Agent", userAgent)
+		if err = httpauth.AddNIP98Header(
+			r, ur, "POST", payloadHash, s.Peers.I, 0,
+		); chk.E(err) {
+			continue
+		}
+		// add this replica's pubkey to the list to prevent re-sending to
+		// other replicas more than twice
+		pubkeys = append(pubkeys, s.Peers.Pub())
+		var pubkeysHeader []byte
+		for j, pk := range pubkeys {
+			pubkeysHeader = hex.EncAppend(pubkeysHeader, pk)
+			if j < len(pubkeys)-1 {
+				pubkeysHeader = append(pubkeysHeader, ':')
 			}
-			log.T.C(
-				func() string {
-					return fmt.Sprintf(
-						"event pushed to replica %s\n%s",
-						ur.String(), evb,
-					)
-				},
-			)
-			break
 		}
+		r.Header.Add("X-Pubkeys", string(pubkeysHeader))
+		r.GetBody = func() (rc io.ReadCloser, err error) {
+			rc = payload
+			return
+		}
+		client := &http.Client{}
+		var res *http.Response
+		if res, err = client.Do(r); chk.E(err) {
+			peerFailures.Inc(a)
+			continue
+		}
+		_ = res.Body.Close()
+		if res.StatusCode/100 == 2 {
+			peerReplication.Since(received, a)
+		} else {
+			peerFailures.Inc(a)
+		}
+		log.T.C(
+			func() string {
+				return fmt.Sprintf(
+					"event pushed to replica %s\n%s",
+					ur.String(), evb,
+				)
+			},
+		)
+		break
 	}
-	accepted = true
-	return
 }
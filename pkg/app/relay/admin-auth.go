@@ -3,7 +3,6 @@ package relay
 import (
 	"net/http"
 	"orly.dev/pkg/protocol/httpauth"
-	"orly.dev/pkg/utils"
 	"orly.dev/pkg/utils/chk"
 	"orly.dev/pkg/utils/log"
 	"time"
@@ -19,7 +18,13 @@ func (s *Server) AdminAuth(
 	if len(tolerance) > 0 {
 		tolerate = tolerance[0]
 	}
-	if valid, pubkey, err = httpauth.CheckAuth(r, tolerate); chk.E(err) {
+	if valid, pubkey, err = httpauth.Check(
+		r, httpauth.Options{
+			Tolerance: tolerate,
+			RequireMethod: s.Config().NIP98RequireMethod &&
+				httpauth.IsWrite(r),
+		},
+	); chk.E(err) {
 		return
 	}
 	if !valid {
@@ -29,11 +34,6 @@ func (s *Server) AdminAuth(
 		)
 		return
 	}
-	for _, pk := range s.ownersPubkeys {
-		if utils.FastEqual(pk, pubkey) {
-			authed = true
-			return
-		}
-	}
+	authed = s.IsOwner(pubkey)
 	return
 }
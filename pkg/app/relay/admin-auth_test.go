@@ -0,0 +1,76 @@
+package relay
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"orly.dev/pkg/app/config"
+	"orly.dev/pkg/crypto/p256k"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/protocol/httpauth"
+	"orly.dev/pkg/protocol/openapi"
+	"orly.dev/pkg/protocol/servemux"
+)
+
+func TestAdminConfigExpiringToken(t *testing.T) {
+	owner := &p256k.Signer{}
+	if err := owner.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		C:     &config.C{NIP98RequireMethod: true},
+		Lists: new(Lists),
+	}
+	s.SetOwnersPubkeys([][]byte{owner.Pub()})
+	sm := servemux.NewServeMux()
+	openapi.New(s, "test", "v0", "", "/api", sm)
+	const adminURL = "http://relay.example.com/api/admin/config"
+	expiry := time.Now().Add(time.Hour).Unix()
+	call := func(method, body, tokenMethod, hash string) (code int) {
+		r := httptest.NewRequest(method, adminURL, strings.NewReader(body))
+		if body != "" {
+			r.Header.Set("Content-Type", "application/json")
+		}
+		ur, _ := url.Parse(adminURL)
+		if err := httpauth.AddNIP98Header(
+			r, ur, tokenMethod, hash, owner, expiry,
+		); err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		sm.ServeHTTP(w, r)
+		return w.Code
+	}
+	body := `{"ORLY_LOG_LEVEL":"nope"}`
+	sum := sha256.Sum256([]byte(body))
+	for _, tc := range []struct {
+		name, method, body, tokenMethod, hash string
+		code                                  int
+	}{
+		{"read", http.MethodGet, "", "", "", http.StatusOK},
+		{
+			"write without payload", http.MethodPost, body, "", "",
+			http.StatusUnauthorized,
+		},
+		{
+			"write without method", http.MethodPost, body, "",
+			hex.Enc(sum[:]), http.StatusUnauthorized,
+		},
+		// the token is accepted, and the invalid level is refused.
+		{
+			"write bound to the body", http.MethodPost, body, http.MethodPost,
+			hex.Enc(sum[:]), http.StatusBadRequest,
+		},
+	} {
+		if code := call(
+			tc.method, tc.body, tc.tokenMethod, tc.hash,
+		); code != tc.code {
+			t.Errorf("%s: expected status %d, got %d", tc.name, tc.code, code)
+		}
+	}
+}
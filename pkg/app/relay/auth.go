@@ -4,15 +4,11 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
-
-	"orly.dev/pkg/utils/chk"
-	"orly.dev/pkg/utils/log"
 )
 
 // ServiceURL constructs the service URL based on the incoming HTTP request. It
-// checks for authentication requirements and determines the protocol (ws or
-// wss) based on headers like X-Forwarded-Host, X-Forwarded-Proto, and the host
-// itself.
+// determines the protocol (ws or wss) based on headers like X-Forwarded-Host,
+// X-Forwarded-Proto, and the host itself.
 //
 // # Parameters
 //
@@ -24,8 +20,6 @@ import (
 //
 // # Expected Behaviour:
 //
-// - Checks if authentication is required.
-//
 // - Retrieves the host from X-Forwarded-Host or falls back to req.Host.
 //
 // - Determines the protocol (ws or wss) based on various conditions including
@@ -33,10 +27,6 @@ import (
 //
 // - Returns the constructed URL string.
 func (s *Server) ServiceURL(req *http.Request) (st string) {
-	if !s.AuthRequired() {
-		log.T.F("auth not required")
-		return
-	}
 	host := req.Header.Get("X-Forwarded-Host")
 	if host == "" {
 		host = req.Host
@@ -53,7 +43,7 @@ func (s *Server) ServiceURL(req *http.Request) (st string) {
 				host, ".",
 				"",
 			),
-		); chk.E(err) {
+		); err == nil {
 			// it's a naked IP
 			proto = "ws"
 		} else {
@@ -0,0 +1,48 @@
+package relay
+
+import (
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/protocol/auth"
+)
+
+// CanRead determines whether an event may be served to a client, for both
+// websocket subscriptions and the HTTP API.
+//
+// # Parameters
+//
+//   - ev: the event to be served
+//
+//   - authedPubkey: the pubkey the client authenticated as with NIP-42 or
+//     NIP-98, empty if the client has not authenticated
+//
+//   - super: true if the client is a relay cluster replica
+//
+// # Return Values
+//
+//   - allowed: true if the event may be sent to the client
+//
+// # Expected Behaviour:
+//
+// - Events by authors hidden from readers are never served.
+//
+// - Replicas may read all other events.
+//
+// - Gift wraps are only served to their recipient, whether or not auth is
+// required.
+//
+// - If auth is required, the rules of auth.CanRead apply for privileged kinds
+// and protected events.
+func (s *Server) CanRead(
+	ev *event.E, authedPubkey []byte, super bool,
+) (allowed bool) {
+	if s.AuthorHidden(ev.Pubkey) {
+		return
+	}
+	if super {
+		return true
+	}
+	if !s.AuthRequired() && !ev.Kind.IsGiftWrap() {
+		return true
+	}
+	return auth.CanRead(authedPubkey, ev)
+}
@@ -1,10 +1,35 @@
 package relay
 
 import (
+	"slices"
+
 	"orly.dev/pkg/app/config"
 )
 
+// Config returns the running configuration, which is replaced as a whole when
+// it changes, so it must not be modified, and a reader that needs several
+// fields should read them from one result.
 func (s *Server) Config() (c *config.C) {
-	c = s.C
+	if c = s.live.Load(); c == nil {
+		c = s.C
+	}
+	return
+}
+
+// cloneConfig returns a copy of c that shares none of its slices.
+func cloneConfig(c *config.C) (n *config.C) {
+	cc := *c
+	n = &cc
+	n.Owners = slices.Clone(c.Owners)
+	n.Whitelist = slices.Clone(c.Whitelist)
+	n.Blacklist = slices.Clone(c.Blacklist)
+	n.BlockedIPs = slices.Clone(c.BlockedIPs)
+	n.GuestReadKinds = slices.Clone(c.GuestReadKinds)
+	n.SpiderSeeds = slices.Clone(c.SpiderSeeds)
+	n.PeerRelays = slices.Clone(c.PeerRelays)
+	n.TLSCerts = slices.Clone(c.TLSCerts)
+	n.ACMEDomains = slices.Clone(c.ACMEDomains)
+	n.Operators = slices.Clone(c.Operators)
+	n.CosignKinds = slices.Clone(c.CosignKinds)
 	return
 }
@@ -0,0 +1,237 @@
+package relay
+
+import (
+	"net"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"orly.dev/pkg/app/config"
+	"orly.dev/pkg/app/relay/helpers"
+	"orly.dev/pkg/database"
+	"orly.dev/pkg/utils"
+	"orly.dev/pkg/utils/errorf"
+	"orly.dev/pkg/utils/keys"
+	"orly.dev/pkg/utils/log"
+	"orly.dev/pkg/utils/lol"
+)
+
+// GetConfiguration returns a copy of the running configuration.
+func (s *Server) GetConfiguration() (c config.C, err error) {
+	c = *cloneConfig(s.Config())
+	return
+}
+
+// SetConfiguration applies the fields of c that differ from the running
+// configuration and saves them to the .env file in the configuration
+// directory.
+//
+// # Parameters
+//
+// - c (config.C): The new configuration, usually a modified copy of the
+// result of GetConfiguration.
+//
+// # Return Values
+//
+// - err (error): An error if a field that isn't in config.Live was changed, a
+// new value is invalid, or the .env file could not be written. Nothing is
+// changed when an error is returned.
+//
+// # Expected Behaviour
+//
+// - Writes the changed keys to the .env file before changing the running
+// configuration, so the relay starts up the same way after a restart.
+//
+// - Sets the log levels of the relay and the event store when they change.
+//
+// - Restarts the spider timer when its frequency changes, and replaces the
+// peer relays when they change.
+//
+// - Rebuilds the web of trust from the lists in the event store when the
+// owners or its settings change.
+func (s *Server) SetConfiguration(c config.C) (err error) {
+	return s.applyConfiguration(c, true)
+}
+
+// Reload reads the configuration from the environment and the .env file again
+// and applies the changes that can be made while the relay is running, which
+// leaves existing connections open. Changes to other fields are logged as
+// needing a restart.
+//
+// # Return Values
+//
+// - err (error): An error if the configuration can't be read or a new value is
+// invalid, in which case nothing is changed.
+func (s *Server) Reload() (err error) {
+	var c *config.C
+	if c, err = config.Load(); err != nil {
+		return
+	}
+	var next config.C
+	if next, err = s.GetConfiguration(); err != nil {
+		return
+	}
+	var restart []string
+	for _, k := range config.Changed(&next, c) {
+		if config.IsLive(k) {
+			config.Update(&next, c, k)
+		} else {
+			restart = append(restart, k)
+		}
+	}
+	if len(restart) > 0 {
+		log.W.F(
+			"configuration changes that need a restart: %s",
+			strings.Join(restart, ", "),
+		)
+	}
+	if err = s.applyConfiguration(next, false); err != nil {
+		return
+	}
+	log.I.F("configuration reloaded")
+	return
+}
+
+// applyConfiguration is SetConfiguration, which only writes the changes to
+// the .env file if persist is true.
+func (s *Server) applyConfiguration(c config.C, persist bool) (err error) {
+	s.configMx.Lock()
+	defer s.configMx.Unlock()
+	cur := s.Config()
+	changed := config.Changed(cur, &c)
+	if len(changed) == 0 {
+		return
+	}
+	for _, k := range changed {
+		if !config.IsLive(k) {
+			err = errorf.E("%s can't be changed while the relay is running", k)
+			return
+		}
+	}
+	for _, level := range []string{c.LogLevel, c.DbLogLevel} {
+		if !slices.Contains(lol.LevelNames, level) {
+			err = errorf.E(
+				"invalid log level '%s', must be one of %s", level,
+				strings.Join(lol.LevelNames, ", "),
+			)
+			return
+		}
+	}
+	if slices.Contains(changed, "ORLY_SPIDER_FREQUENCY") && c.SpiderTime <= 0 {
+		err = errorf.E("spider frequency must be more than zero")
+		return
+	}
+	var blacklist [][]byte
+	if blacklist, err = decodePubkeys(c.Blacklist); err != nil {
+		return
+	}
+	if _, err = decodePubkeys(c.Owners); err != nil {
+		return
+	}
+	for _, list := range [][]string{c.Whitelist, c.BlockedIPs} {
+		for _, v := range list {
+			if _, _, e := net.ParseCIDR(v); v != "" && e != nil &&
+				net.ParseIP(v) == nil {
+				err = errorf.E("invalid IP address or CIDR block '%s'", v)
+				return
+			}
+		}
+	}
+	if persist {
+		var kvs config.KVSlice
+		for _, kv := range config.EnvKV(c) {
+			if slices.Contains(changed, kv.Key) {
+				kvs = append(kvs, kv)
+			}
+		}
+		if err = config.WriteEnv(
+			filepath.Join(cur.Config, ".env"), kvs,
+		); err != nil {
+			return
+		}
+	}
+	// the running configuration is replaced rather than modified, so
+	// readers of Config see either the old or the new one.
+	next := *cur
+	config.Update(&next, &c, changed...)
+	nc := cloneConfig(&next)
+	s.live.Store(nc)
+	s.blacklistPubkeys = blacklist
+	log.I.F("configuration changed: %s", strings.Join(changed, ", "))
+	if slices.Contains(changed, "ORLY_LOG_LEVEL") {
+		lol.SetLogLevel(nc.LogLevel)
+	}
+	if slices.Contains(changed, "ORLY_DB_LOG_LEVEL") {
+		if sto := s.Storage(); sto != nil {
+			sto.SetLogLevel(nc.DbLogLevel)
+		}
+	}
+	if slices.Contains(changed, "ORLY_SLOW_QUERY") {
+		if db, ok := s.Storage().(*database.D); ok {
+			db.SetSlowQuery(nc.SlowQuery)
+		}
+	}
+	if slices.Contains(changed, "ORLY_SPIDER_FREQUENCY") && s.spiderTicker != nil {
+		s.spiderTicker.Reset(nc.SpiderTime)
+	}
+	if slices.Contains(changed, "ORLY_PEER_RELAYS") {
+		s.Peers.SetAddresses(nc.PeerRelays)
+	}
+	if slices.Contains(changed, "ORLY_OWNERS") ||
+		slices.Contains(changed, "ORLY_WOT_DEPTH") ||
+		slices.Contains(changed, "ORLY_WOT_MIN_FOLLOWS") ||
+		slices.Contains(changed, "ORLY_WOT_MUTE_THRESHOLD") {
+		if len(nc.Owners) == 0 {
+			s.SetOwnersPubkeys(nil)
+			s.SetOwnersFollowed(nil)
+			s.SetFollowedFollows(nil)
+			s.SetOwnersMuted(nil)
+		} else {
+			// the owners' lists are usually in the event store already, a full
+			// spider run can be requested separately. The configuration has
+			// already changed, so a failure is only logged.
+			if e := s.Spider(true); e != nil {
+				log.E.F("failed to rebuild the web of trust: %v", e)
+			}
+		}
+	}
+	return
+}
+
+// decodePubkeys decodes a list of npub or hex pubkeys, skipping empty ones.
+func decodePubkeys(list []string) (pks [][]byte, err error) {
+	for _, v := range list {
+		if v == "" {
+			continue
+		}
+		var pk []byte
+		if pk, err = keys.DecodeNpubOrHex(v); err != nil {
+			err = errorf.E("invalid pubkey '%s': %v", v, err)
+			return
+		}
+		pks = append(pks, pk)
+	}
+	return
+}
+
+// blacklisted returns true if the pubkey is on the blacklist.
+func (s *Server) blacklisted(pubkey []byte) bool {
+	s.configMx.RLock()
+	defer s.configMx.RUnlock()
+	for _, pk := range s.blacklistPubkeys {
+		if utils.FastEqual(pk, pubkey) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipBlocked returns true if the IP address of the remote address is one of
+// the blocked IP addresses or in one of the blocked CIDR blocks, or has been
+// blocked through NIP-86.
+func (s *Server) ipBlocked(remote string) bool {
+	if s.Management.Has(database.BlockedIPs, remoteIP(remote)) {
+		return true
+	}
+	return helpers.IPListed(remote, s.Config().BlockedIPs)
+}
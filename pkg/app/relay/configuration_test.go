@@ -0,0 +1,119 @@
+package relay
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"orly.dev/pkg/app/config"
+	"orly.dev/pkg/encoders/hex"
+)
+
+func TestSetConfiguration(t *testing.T) {
+	dir := t.TempDir()
+	s := &Server{
+		C:     &config.C{Config: dir, LogLevel: "info", DbLogLevel: "info"},
+		Lists: new(Lists),
+	}
+	pk := make([]byte, 32)
+	pk[0] = 1
+	c, err := s.GetConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Port = 1
+	if err = s.SetConfiguration(c); err == nil {
+		t.Fatal("port was changed while running")
+	}
+	c, _ = s.GetConfiguration()
+	c.Blacklist = []string{"nope"}
+	if err = s.SetConfiguration(c); err == nil {
+		t.Fatal("invalid pubkey was accepted")
+	}
+	if s.blacklisted(pk) || len(s.Config().Blacklist) != 0 {
+		t.Fatal("configuration changed by a failed update")
+	}
+	c.Blacklist = []string{hex.Enc(pk)}
+	c.BlockedIPs = []string{"192.0.2."}
+	if err = s.SetConfiguration(c); err == nil {
+		t.Fatal("address prefix was accepted")
+	}
+	c.BlockedIPs = []string{"192.0.2.0/24", "2001:db8::1"}
+	before := s.Config()
+	if err = s.SetConfiguration(c); err != nil {
+		t.Fatal(err)
+	}
+	// the running configuration is replaced, not modified, and doesn't share
+	// the slices of the one it was made from.
+	if len(before.Blacklist) != 0 || len(s.Config().Blacklist) != 1 {
+		t.Fatal("running configuration was modified in place")
+	}
+	c.Blacklist[0] = "nope"
+	if s.Config().Blacklist[0] != hex.Enc(pk) {
+		t.Fatal("running configuration shares the slices of the update")
+	}
+	if !s.blacklisted(pk) {
+		t.Fatal("pubkey was not blacklisted")
+	}
+	if !s.ipBlocked("192.0.2.7") || !s.ipBlocked("192.0.2.7:4000") ||
+		!s.ipBlocked("[2001:db8::1]:4000") || s.ipBlocked("192.0.20.7") ||
+		s.ipBlocked("198.51.100.1") {
+		t.Fatal("blocked IPs not applied")
+	}
+	b, err := os.ReadFile(filepath.Join(dir, ".env"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "ORLY_BLACKLIST="+hex.Enc(pk)+"\n") ||
+		!strings.Contains(string(b), "ORLY_BLOCKED_IPS=192.0.2.0/24,2001:db8::1\n") ||
+		strings.Contains(string(b), "ORLY_PORT") {
+		t.Fatalf("unexpected .env file:\n%s", b)
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("ORLY_CONFIG_DIR", dir)
+	t.Setenv("ORLY_STATE_DATA_DIR", filepath.Join(dir, "state"))
+	t.Setenv("ORLY_DATA_DIR", filepath.Join(dir, "data"))
+	c, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{C: c, Lists: new(Lists), Peers: new(Peers)}
+	pk := make([]byte, 32)
+	pk[0] = 2
+	env := "ORLY_BLACKLIST=" + hex.Enc(pk) + "\n" +
+		"ORLY_PEER_RELAYS=" + hex.Enc(pk) + "@wss://peer.example.com\n" +
+		"ORLY_PORT=1\n"
+	if err = os.WriteFile(
+		filepath.Join(dir, ".env"), []byte(env), 0600,
+	); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if !s.blacklisted(pk) {
+		t.Fatal("blacklist was not reloaded")
+	}
+	if addresses, _ := s.Peers.Replicas(); len(addresses) != 1 ||
+		addresses[0] != "wss://peer.example.com" {
+		t.Fatalf("peers were not reloaded: %v", addresses)
+	}
+	if s.Config().Port == 1 {
+		t.Fatal("port was changed while running")
+	}
+	if err = os.WriteFile(
+		filepath.Join(dir, ".env"), []byte("ORLY_BLACKLIST=nope\n"), 0600,
+	); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Reload(); err == nil {
+		t.Fatal("invalid configuration was applied")
+	}
+	if !s.blacklisted(pk) {
+		t.Fatal("invalid configuration changed the blacklist")
+	}
+}
@@ -0,0 +1,104 @@
+package relay
+
+import (
+	"path/filepath"
+
+	"go-simpler.org/env"
+
+	"orly.dev/pkg/app"
+	"orly.dev/pkg/app/config"
+	"orly.dev/pkg/database"
+	"orly.dev/pkg/protocol/servemux"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/errorf"
+)
+
+// StartEmbedded starts a relay in this process, for tests, benchmarks and
+// tools that need a relay of their own.
+//
+// # Parameters
+//
+// - c (context.T): The relay stops when it is done.
+//
+// - dir (string): The directory of the configuration, state and event store
+// of the relay.
+//
+// - port (int): The port of the relay on the loopback interface, 0 for any
+// free port.
+//
+// - dbLogLevel (string): The log level of the event store, the default if it
+// is empty.
+//
+// # Return Values
+//
+// - s (*Server): The running relay, whose Addr is the address it listens on,
+// to be stopped with Shutdown.
+//
+// - err (error): An error if the relay could not be started.
+//
+// # Expected Behaviour
+//
+// - Uses the default configuration, without reading the ORLY_ environment or
+// a .env file.
+//
+// - Disables the spider, so the relay doesn't connect anywhere.
+//
+// - Closes the event store and any listeners that were opened if the relay
+// fails to start.
+func StartEmbedded(
+	c context.T, dir string, port int, dbLogLevel string,
+) (s *Server, err error) {
+	cfg := &config.C{}
+	if err = env.Load(
+		cfg, &env.Options{SliceSep: ",", Source: env.Map(nil)},
+	); chk.E(err) {
+		return
+	}
+	cfg.Config = filepath.Join(dir, "config")
+	cfg.State = filepath.Join(dir, "state")
+	cfg.DataDir = filepath.Join(dir, "data")
+	cfg.Listen = "127.0.0.1"
+	cfg.Port = port
+	cfg.SpiderType = "none"
+	cfg.SpiderSeeds = nil
+	if dbLogLevel != "" {
+		cfg.DbLogLevel = dbLogLevel
+	}
+	rc, cancel := context.Cancel(c)
+	var storage *database.D
+	if storage, err = database.New(
+		rc, cancel, cfg.DataDir, cfg.DbLogLevel,
+	); chk.E(err) {
+		cancel()
+		return
+	}
+	var srv *Server
+	if srv, err = NewServer(
+		&ServerParams{
+			Ctx:      rc,
+			Cancel:   cancel,
+			Rl:       &app.Relay{C: cfg, Store: storage},
+			DbPath:   cfg.DataDir,
+			MaxLimit: 512,
+			C:        cfg,
+		}, servemux.NewServeMux(),
+	); chk.E(err) {
+		chk.E(storage.Close())
+		cancel()
+		return
+	}
+	started := make(chan bool)
+	failed := make(chan error, 1)
+	go func() { failed <- srv.Start(cfg.Listen, cfg.Port, started) }()
+	select {
+	case <-started:
+		s = srv
+	case err = <-failed:
+		if err == nil {
+			err = errorf.E("relay stopped before it started")
+		}
+		srv.Shutdown()
+	}
+	return
+}
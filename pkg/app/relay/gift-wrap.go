@@ -0,0 +1,65 @@
+package relay
+
+import (
+	"time"
+
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/encoders/tag"
+)
+
+// acceptCreatedAt checks the created_at of an event against the configured
+// MaxFutureSkew and MaxEventAge. Gift wraps are allowed to be older than
+// MaxEventAge by GiftWrapBackdate, as NIP-59 has their created_at randomized
+// into the past to thwart timing analysis.
+func (s *Server) acceptCreatedAt(ev *event.E) (accept bool, notice string) {
+	cfg := s.Config()
+	if ev.CreatedAt == nil {
+		return true, ""
+	}
+	now := time.Now()
+	created := ev.CreatedAt.Time()
+	if cfg.MaxFutureSkew > 0 && created.After(now.Add(cfg.MaxFutureSkew)) {
+		return false, "created_at is too far in the future"
+	}
+	if cfg.MaxEventAge > 0 {
+		maxAge := cfg.MaxEventAge
+		if ev.Kind.IsGiftWrap() {
+			maxAge += cfg.GiftWrapBackdate
+		}
+		if created.Before(now.Add(-maxAge)) {
+			return false, "created_at is too far in the past"
+		}
+	}
+	return true, ""
+}
+
+// acceptGiftWrap checks that a gift wrap is addressed to a pubkey in the
+// whitelist when GiftWrapWhitelistOnly is set. Other events are always
+// accepted.
+func (s *Server) acceptGiftWrap(ev *event.E) (accept bool, notice string) {
+	if !s.Config().GiftWrapWhitelistOnly || !ev.Kind.IsGiftWrap() {
+		return true, ""
+	}
+	if ev.Tags != nil {
+		for _, t := range ev.Tags.GetAll(tag.New("p")).ToSliceOfTags() {
+			if t.Len() < 2 {
+				continue
+			}
+			pk, err := hex.DecAppend(nil, t.Value())
+			if err != nil {
+				continue
+			}
+			if s.isWhitelisted(pk) {
+				return true, ""
+			}
+		}
+	}
+	return false, "gift wrap recipient is not a user of this relay"
+}
+
+// isWhitelisted returns true if the pubkey is an owner, or is within the second
+// degree of the follow lists of the owners.
+func (s *Server) isWhitelisted(pubkey []byte) bool {
+	return s.isOwner(pubkey) || s.IsTrusted(pubkey)
+}
@@ -0,0 +1,147 @@
+package relay
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"orly.dev/pkg/app/config"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/filters"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/utils/context"
+)
+
+func giftWrapTo(recipient []byte, created time.Time) *event.E {
+	return &event.E{
+		Pubkey:    make([]byte, 32),
+		Kind:      kind.GiftWrap,
+		CreatedAt: timestamp.FromUnix(created.Unix()),
+		Tags:      tags.New(tag.New([]byte("p"), hex.EncAppend(nil, recipient))),
+	}
+}
+
+func TestAcceptEventGiftWrap(t *testing.T) {
+	ctx := context.Bg()
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	user := make([]byte, 32)
+	user[0] = 1
+	stranger := make([]byte, 32)
+	stranger[0] = 2
+	s := &Server{
+		C: &config.C{
+			GiftWrapWhitelistOnly: true,
+			GiftWrapBackdate:      48 * time.Hour,
+			MaxEventAge:           time.Hour,
+			MaxFutureSkew:         15 * time.Minute,
+		},
+		Lists: new(Lists),
+	}
+	s.SetOwnersFollowed([][]byte{user})
+	tests := []struct {
+		name   string
+		ev     *event.E
+		accept bool
+	}{
+		{"gift wrap to user", giftWrapTo(user, time.Now()), true},
+		{"gift wrap to stranger", giftWrapTo(stranger, time.Now()), false},
+		{
+			"gift wrap backdated within tolerance",
+			giftWrapTo(user, time.Now().Add(-40*time.Hour)), true,
+		},
+		{
+			"gift wrap backdated beyond tolerance",
+			giftWrapTo(user, time.Now().Add(-50*time.Hour)), false,
+		},
+		{
+			"note too old",
+			&event.E{
+				Kind:      kind.TextNote,
+				CreatedAt: timestamp.FromUnix(time.Now().Add(-2 * time.Hour).Unix()),
+			},
+			false,
+		},
+		{
+			"note too far in the future",
+			&event.E{
+				Kind:      kind.TextNote,
+				CreatedAt: timestamp.FromUnix(time.Now().Add(time.Hour).Unix()),
+			},
+			false,
+		},
+		{
+			"note",
+			&event.E{Kind: kind.TextNote, CreatedAt: timestamp.Now()},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				accept, notice, _ := s.AcceptEvent(
+					ctx, tt.ev, req, nil, "127.0.0.1",
+				)
+				if accept != tt.accept {
+					t.Errorf(
+						"AcceptEvent() accept = %v, want %v (notice %q)",
+						accept, tt.accept, notice,
+					)
+				}
+			},
+		)
+	}
+}
+
+func TestCanReadGiftWrapWithoutAuth(t *testing.T) {
+	recipient := make([]byte, 32)
+	recipient[0] = 1
+	s := &Server{C: &config.C{}, Lists: new(Lists)}
+	ev := giftWrapTo(recipient, time.Now())
+	if s.CanRead(ev, nil, false) {
+		t.Error("gift wrap served to unauthenticated client")
+	}
+	if s.CanRead(ev, make([]byte, 32), false) {
+		t.Error("gift wrap served to pubkey other than the recipient")
+	}
+	if !s.CanRead(ev, recipient, false) {
+		t.Error("gift wrap not served to the recipient")
+	}
+	if !s.CanRead(ev, nil, true) {
+		t.Error("gift wrap not served to replica")
+	}
+	note := &event.E{Kind: kind.TextNote, CreatedAt: timestamp.Now()}
+	if !s.CanRead(note, nil, false) {
+		t.Error("note not served to unauthenticated client")
+	}
+}
+
+func TestReadPolicyGiftWrapBackdate(t *testing.T) {
+	s := &Server{
+		C: &config.C{
+			UserMaxTimeRange: time.Hour,
+			GiftWrapBackdate: 48 * time.Hour,
+		},
+		Lists: new(Lists),
+	}
+	f := filter.New()
+	f.Kinds = kinds.New(kind.GiftWrap)
+	_, accept, _ := s.AcceptReq(
+		context.Bg(), nil, filters.New(f), []byte("user"), "127.0.0.1",
+	)
+	if !accept {
+		t.Fatal("filter for gift wraps rejected")
+	}
+	earliest := time.Now().Add(-49 * time.Hour).Unix()
+	if f.Since.I64() > earliest+5 || f.Since.I64() < earliest-5 {
+		t.Errorf(
+			"since = %d, want %d, extended by the gift wrap backdate",
+			f.Since.I64(), earliest,
+		)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"sort"
 
+	"orly.dev/pkg/encoders/hex"
 	"orly.dev/pkg/interfaces/relay"
 	"orly.dev/pkg/protocol/relayinfo"
 	"orly.dev/pkg/utils/chk"
@@ -46,6 +47,7 @@ func (s *Server) HandleRelayInfo(w http.ResponseWriter, r *http.Request) {
 			relayinfo.ParameterizedReplaceableEvents,
 			relayinfo.ExpirationTimestamp,
 			relayinfo.ProtectedEvents,
+			relayinfo.RelayManagementAPI,
 			// relayinfo.RelayListMetadata,
 		)
 		sort.Sort(supportedNIPs)
@@ -57,12 +59,16 @@ func (s *Server) HandleRelayInfo(w http.ResponseWriter, r *http.Request) {
 			Software:    version.URL,
 			Version:     version.V,
 			Limitation: relayinfo.Limits{
-				AuthRequired:     s.C.AuthRequired,
-				RestrictedWrites: s.C.AuthRequired,
+				AuthRequired:     s.Config().AuthRequired,
+				RestrictedWrites: s.Config().AuthRequired,
 			},
 			Icon: "https://cdn.satellite.earth/ac9778868fbf23b63c47c769a74e163377e6ea94d3f0f31711931663d035c4f6.png",
 		}
+		if s.Peers != nil && s.Peers.I != nil && len(s.Peers.Pub()) > 0 {
+			info.PubKey = hex.Enc(s.Peers.Pub())
+		}
 	}
+	s.Management.applyInfo(info)
 	if err := json.NewEncoder(w).Encode(info); chk.E(err) {
 	}
 }
@@ -1,6 +1,7 @@
 package helpers
 
 import (
+	"net"
 	"net/http"
 	"strings"
 )
@@ -99,3 +100,27 @@ func GetRemoteFromReq(r *http.Request) (rr string) {
 	}
 	return
 }
+
+// IPListed returns true if the IP address of remote, an address from
+// GetRemoteFromReq with or without a port, is one of the IP addresses or in
+// one of the CIDR blocks in list.
+func IPListed(remote string, list []string) bool {
+	host := remote
+	if h, _, err := net.SplitHostPort(remote); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, v := range list {
+		if _, block, err := net.ParseCIDR(v); err == nil {
+			if block.Contains(ip) {
+				return true
+			}
+		} else if a := net.ParseIP(v); a != nil && a.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
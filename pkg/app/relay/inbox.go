@@ -0,0 +1,107 @@
+package relay
+
+import (
+	"bytes"
+	"net/http"
+
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/normalize"
+)
+
+// acceptInbox is the write policy of the relay when it runs as a NIP-17 DM
+// inbox relay.
+//
+// # Parameters
+//
+//   - c: the context of the request
+//
+//   - ev: the event being published
+//
+//   - hr: the HTTP request of the connection, used to find the ServiceURL of
+//     the relay
+//
+//   - authedPubkey: the pubkey the client authenticated as, if any
+//
+// # Return Values
+//
+//   - accept: true if the event may be stored
+//
+//   - notice: the reason the event was rejected
+//
+// # Expected Behaviour:
+//
+// - Gift wraps are accepted from anyone, as they are signed by a random key,
+// but only if one of their recipients has a DM relay list (kind 10050) that
+// names the ServiceURL of this relay.
+//
+// - Other kinds are only accepted from authenticated members, that is, the
+// owners and the users within the second degree of their follow lists.
+func (s *Server) acceptInbox(
+	c context.T, ev *event.E, hr *http.Request, authedPubkey []byte,
+) (accept bool, notice string) {
+	if ev.Kind.IsGiftWrap() {
+		if hr == nil {
+			return false, "cannot determine the url of this relay"
+		}
+		inbox := s.ServiceURL(hr)
+		if ev.Tags != nil {
+			for _, t := range ev.Tags.GetAll(tag.New("p")).ToSliceOfTags() {
+				if t.Len() < 2 {
+					continue
+				}
+				pk, err := hex.DecAppend(nil, t.Value())
+				if err != nil {
+					continue
+				}
+				if s.usesInbox(c, pk, inbox) {
+					return true, ""
+				}
+			}
+		}
+		return false, "gift wrap recipient does not use this relay as their DM inbox"
+	}
+	if len(authedPubkey) == 0 {
+		return false, "members must authenticate to publish to this inbox relay"
+	}
+	if !s.isWhitelisted(authedPubkey) {
+		return false, "only members may publish to this inbox relay"
+	}
+	return true, ""
+}
+
+// usesInbox returns true if the stored DM relay list of pubkey names the inbox
+// relay URL.
+func (s *Server) usesInbox(c context.T, pubkey []byte, inbox string) bool {
+	if s.relay == nil || s.relay.Storage() == nil {
+		return false
+	}
+	f := filter.New()
+	f.Authors = tag.New(pubkey)
+	f.Kinds = kinds.New(kind.DMRelaysList)
+	evs, err := s.Storage().QueryEvents(c, f)
+	if chk.E(err) {
+		return false
+	}
+	want := bytes.TrimRight(normalize.URL(inbox), "/")
+	for _, ev := range evs {
+		if ev.Tags == nil {
+			continue
+		}
+		for _, t := range ev.Tags.GetAll(tag.New("relay")).ToSliceOfTags() {
+			if t.Len() < 2 {
+				continue
+			}
+			if bytes.Equal(bytes.TrimRight(normalize.URL(t.Value()), "/"), want) {
+				return true
+			}
+		}
+	}
+	return false
+}
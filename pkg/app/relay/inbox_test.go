@@ -0,0 +1,119 @@
+package relay
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"orly.dev/pkg/app/config"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/context"
+)
+
+// listStore is a store.I that only answers queries for stored DM relay lists.
+type listStore struct {
+	store.I
+	lists []*event.E
+}
+
+func (l *listStore) QueryEvents(c context.T, f *filter.F) (evs event.S, err error) {
+	for _, ev := range l.lists {
+		if f.Matches(ev) {
+			evs = append(evs, ev)
+		}
+	}
+	return
+}
+
+func TestAcceptEventInbox(t *testing.T) {
+	ctx := context.Bg()
+	req, _ := http.NewRequest("GET", "http://inbox.example.com", nil)
+	inboxUser := make([]byte, 32)
+	inboxUser[0] = 1
+	otherUser := make([]byte, 32)
+	otherUser[0] = 2
+	member := make([]byte, 32)
+	member[0] = 3
+	blocked := make([]byte, 32)
+	blocked[0] = 4
+	muted := make([]byte, 32)
+	muted[0] = 5
+	dmRelays := func(pk []byte, relays ...string) *event.E {
+		ev := &event.E{
+			Pubkey:    pk,
+			Kind:      kind.DMRelaysList,
+			CreatedAt: timestamp.Now(),
+			Tags:      tags.New(),
+		}
+		for _, r := range relays {
+			ev.Tags = ev.Tags.AppendTags(tag.New("relay", r))
+		}
+		return ev
+	}
+	s := &Server{
+		C: &config.C{Inbox: true},
+		relay: &testRelay{
+			storage: &listStore{
+				lists: []*event.E{
+					dmRelays(inboxUser, "wss://inbox.example.com/"),
+					dmRelays(otherUser, "wss://relay.example.com"),
+				},
+			},
+		},
+		Lists: new(Lists),
+	}
+	s.SetOwnersFollowed([][]byte{member, blocked, muted})
+	s.SetOwnersMuted([][]byte{muted})
+	s.blacklistPubkeys = [][]byte{blocked}
+	note := &event.E{
+		Pubkey: member, Kind: kind.TextNote, CreatedAt: timestamp.Now(),
+	}
+	blockedNote := &event.E{
+		Pubkey: blocked, Kind: kind.TextNote, CreatedAt: timestamp.Now(),
+	}
+	mutedNote := &event.E{
+		Pubkey: muted, Kind: kind.TextNote, CreatedAt: timestamp.Now(),
+	}
+	tests := []struct {
+		name   string
+		ev     *event.E
+		authed []byte
+		accept bool
+	}{
+		{"gift wrap to inbox user", giftWrapTo(inboxUser, time.Now()), nil, true},
+		{
+			"gift wrap to user of another inbox",
+			giftWrapTo(otherUser, time.Now()), nil, false,
+		},
+		{
+			"gift wrap to user without inbox",
+			giftWrapTo(member, time.Now()), nil, false,
+		},
+		{"note from unauthenticated client", note, nil, false},
+		{"note from non-member", note, otherUser, false},
+		{"note from member", note, member, true},
+		{"note from blacklisted member", blockedNote, blocked, false},
+		{"note from muted member", mutedNote, muted, false},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				accept, notice, _ := s.AcceptEvent(
+					ctx, tt.ev, req, tt.authed, "127.0.0.1",
+				)
+				if accept != tt.accept {
+					t.Errorf(
+						"AcceptEvent() accept = %v, want %v (notice %q)",
+						accept, tt.accept, notice,
+					)
+				}
+			},
+		)
+	}
+}
@@ -0,0 +1,179 @@
+package relay
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/cors"
+	"golang.org/x/crypto/acme/autocert"
+
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/tlsconfig"
+)
+
+// listener is a network listener, with the handler of the requests it
+// accepts and, for TLS listeners, the TLS configuration of its connections.
+type listener struct {
+	name string
+	net.Listener
+	http.Handler
+	tls *tls.Config
+}
+
+// adminPaths are the paths that are only served on the admin listener when
+// ORLY_ADMIN_LISTEN is set.
+var adminPaths = []string{"/api/admin", "/debug/pprof", "/metrics", "/health"}
+
+// isAdminPath returns true if path is one of adminPaths or below one of them.
+func isAdminPath(path string) bool {
+	for _, p := range adminPaths {
+		if path == p || strings.HasPrefix(path, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// listen opens the listeners of the relay: the plain TCP listener at addr, and
+// the TLS, ACME http-01, unix socket and admin listeners that are configured.
+//
+// # Parameters
+//
+// - addr (string): The host:port of the plain TCP listener.
+//
+// # Return Values
+//
+// - lns ([]*listener): The listeners, the plain TCP listener first.
+//
+// - err (error): An error if any of the listeners can't be opened, in which
+// case the ones already opened are closed.
+//
+// # Expected Behaviour
+//
+// - The plain, TLS and unix socket listeners serve the relay with CORS
+// headers. When an admin listener is configured, they don't serve the admin
+// paths, which the admin listener serves without CORS along with pprof.
+//
+// - TLS certificates come from ORLY_TLS_CERTS, and for other domains from
+// LetsEncrypt when ORLY_ACME_DOMAINS is set, in the same way as lerproxy.
+//
+// - A socket file left at the unix socket path by a relay that didn't shut
+// down cleanly is removed.
+func (s *Server) listen(addr string) (lns []*listener, err error) {
+	defer func() {
+		if err != nil {
+			for _, l := range lns {
+				chk.E(l.Close())
+			}
+			lns = nil
+		}
+	}()
+	public := s.publicHandler()
+	var ln net.Listener
+	if ln, err = net.Listen("tcp", addr); chk.E(err) {
+		return
+	}
+	lns = append(lns, &listener{name: "relay", Listener: ln, Handler: public})
+	if s.C.TLSListen != "" {
+		var m *autocert.Manager
+		if len(s.C.ACMEDomains) > 0 {
+			cache := filepath.Join(s.C.Config, "autocert")
+			if err = os.MkdirAll(cache, 0700); chk.E(err) {
+				return
+			}
+			m = &autocert.Manager{
+				Prompt:     autocert.AcceptTOS,
+				Cache:      autocert.DirCache(cache),
+				HostPolicy: autocert.HostWhitelist(s.C.ACMEDomains...),
+				Email:      s.C.ACMEEmail,
+			}
+		}
+		if ln, err = net.Listen("tcp", s.C.TLSListen); chk.E(err) {
+			return
+		}
+		lns = append(
+			lns, &listener{
+				name: "tls", Listener: ln, Handler: public,
+				tls: tlsconfig.New(m, s.C.TLSCerts...),
+			},
+		)
+		if m != nil && s.C.ACMEHTTP != "" {
+			if ln, err = net.Listen("tcp", s.C.ACMEHTTP); chk.E(err) {
+				return
+			}
+			lns = append(
+				lns, &listener{
+					name: "acme", Listener: ln, Handler: m.HTTPHandler(nil),
+				},
+			)
+		}
+	}
+	if path := s.C.UnixSocket; path != "" {
+		if fi, e := os.Stat(path); e == nil && fi.Mode()&os.ModeSocket != 0 {
+			if err = os.Remove(path); chk.E(err) {
+				return
+			}
+		}
+		if ln, err = net.Listen("unix", path); chk.E(err) {
+			return
+		}
+		lns = append(
+			lns, &listener{name: "unix socket", Listener: ln, Handler: public},
+		)
+	}
+	if s.C.AdminListen != "" {
+		if ln, err = net.Listen("tcp", s.C.AdminListen); chk.E(err) {
+			return
+		}
+		lns = append(
+			lns, &listener{
+				name: "admin", Listener: ln, Handler: s.adminHandler(),
+			},
+		)
+	}
+	return
+}
+
+// publicHandler returns the handler of the relay listeners, which adds CORS
+// headers and, when there is an admin listener, refuses the admin paths.
+func (s *Server) publicHandler() http.Handler {
+	h := cors.Default().Handler(s)
+	if s.C.AdminListen == "" {
+		return h
+	}
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if isAdminPath(r.URL.Path) {
+				http.NotFound(w, r)
+				return
+			}
+			h.ServeHTTP(w, r)
+		},
+	)
+}
+
+// adminHandler returns the handler of the admin listener, which serves pprof
+// and the admin paths of the relay's mux, and nothing else.
+func (s *Server) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc(
+		"/", func(w http.ResponseWriter, r *http.Request) {
+			if !isAdminPath(r.URL.Path) {
+				http.NotFound(w, r)
+				return
+			}
+			s.mux.ServeHTTP(w, r)
+		},
+	)
+	return mux
+}
@@ -0,0 +1,72 @@
+package relay
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"orly.dev/pkg/app/config"
+	"orly.dev/pkg/protocol/servemux"
+)
+
+func TestListeners(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "orly.sock")
+	// a socket left behind by a relay that didn't shut down cleanly.
+	stale, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close()
+	mux := servemux.NewServeMux()
+	mux.HandleFunc(
+		"/api/admin/test", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("admin"))
+		},
+	)
+	mux.HandleFunc(
+		"/", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("relay"))
+		},
+	)
+	s := &Server{
+		C: &config.C{
+			UnixSocket: sock, AdminListen: "127.0.0.1:0",
+		},
+		mux: mux,
+	}
+	lns, err := s.listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range lns {
+		_ = l.Close()
+	}
+	if len(lns) != 3 || lns[1].name != "unix socket" || lns[2].name != "admin" {
+		t.Fatalf("unexpected listeners %v", lns)
+	}
+	for _, tc := range []struct {
+		handler http.Handler
+		path    string
+		code    int
+		body    string
+	}{
+		{s.publicHandler(), "/api/admin/test", http.StatusNotFound, ""},
+		{s.publicHandler(), "/metrics", http.StatusNotFound, ""},
+		{s.publicHandler(), "/api/events", http.StatusOK, "relay"},
+		{s.adminHandler(), "/api/admin/test", http.StatusOK, "admin"},
+		{s.adminHandler(), "/debug/pprof/", http.StatusOK, ""},
+		{s.adminHandler(), "/api/events", http.StatusNotFound, ""},
+	} {
+		w := httptest.NewRecorder()
+		tc.handler.ServeHTTP(w, httptest.NewRequest("GET", tc.path, nil))
+		if w.Code != tc.code || (tc.body != "" && w.Body.String() != tc.body) {
+			t.Errorf(
+				"%s: got %d %q, expected %d %q", tc.path, w.Code,
+				w.Body.String(), tc.code, tc.body,
+			)
+		}
+	}
+}
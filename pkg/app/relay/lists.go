@@ -15,12 +15,66 @@ import (
 // Currently, there is no explicit purpose for the followedFollows list being
 // separate from the ownersFollowed list, but there could be reasons for this
 // distinction, such as rate limiting applying to the former and not the latter.
+//
+// Each list also has a set of its pubkeys, so checking whether a pubkey is on
+// one is a map lookup rather than a scan.
 type Lists struct {
 	sync.RWMutex
 	ownersPubkeys   [][]byte
 	ownersFollowed  [][]byte
 	followedFollows [][]byte
 	ownersMuted     [][]byte
+	owners          set
+	followed        set
+	follows         set
+	muted           set
+}
+
+// set is the pubkeys of a list, keyed by the pubkey as a string of its bytes.
+type set map[string]struct{}
+
+// newSet returns the set of pks.
+func newSet(pks [][]byte) (s set) {
+	s = make(set, len(pks))
+	for _, pk := range pks {
+		s[string(pk)] = struct{}{}
+	}
+	return
+}
+
+// has returns true if pk is in the set.
+func (s set) has(pk []byte) (ok bool) {
+	_, ok = s[string(pk)]
+	return
+}
+
+// IsOwner returns true if pk is on the owners list.
+func (l *Lists) IsOwner(pk []byte) bool {
+	l.RLock()
+	defer l.RUnlock()
+	return l.owners.has(pk)
+}
+
+// IsFollowed returns true if pk is on the owners followed list.
+func (l *Lists) IsFollowed(pk []byte) bool {
+	l.RLock()
+	defer l.RUnlock()
+	return l.followed.has(pk)
+}
+
+// IsTrusted returns true if pk is on the owners followed list or the followed
+// follows list.
+func (l *Lists) IsTrusted(pk []byte) bool {
+	l.RLock()
+	defer l.RUnlock()
+	return l.followed.has(pk) || l.follows.has(pk)
+}
+
+// IsMuted returns true if pk is on the owners muted list.
+func (l *Lists) IsMuted(pk []byte) bool {
+	l.RLock()
+	defer l.RUnlock()
+	return l.muted.has(pk)
 }
 
 func (l *Lists) LenOwnersPubkeys() (ll int) {
@@ -41,6 +95,7 @@ func (l *Lists) SetOwnersPubkeys(pks [][]byte) {
 	l.Lock()
 	defer l.Unlock()
 	l.ownersPubkeys = pks
+	l.owners = newSet(pks)
 	return
 }
 
@@ -62,6 +117,7 @@ func (l *Lists) SetOwnersFollowed(pks [][]byte) {
 	l.Lock()
 	defer l.Unlock()
 	l.ownersFollowed = pks
+	l.followed = newSet(pks)
 	return
 }
 
@@ -83,6 +139,7 @@ func (l *Lists) SetFollowedFollows(pks [][]byte) {
 	l.Lock()
 	defer l.Unlock()
 	l.followedFollows = pks
+	l.follows = newSet(pks)
 	return
 }
 
@@ -104,5 +161,6 @@ func (l *Lists) SetOwnersMuted(pks [][]byte) {
 	l.Lock()
 	defer l.Unlock()
 	l.ownersMuted = pks
+	l.muted = newSet(pks)
 	return
 }
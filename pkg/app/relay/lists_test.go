@@ -240,3 +240,34 @@ func TestLists_ConcurrentAccess(t *testing.T) {
 
 	// If we got here without deadlocks or panics, the test passes
 }
+
+func TestLists_Membership(t *testing.T) {
+	l := &Lists{}
+	if l.IsOwner([]byte("owner")) || l.IsTrusted([]byte("followed")) {
+		t.Error("Empty lists should have no members")
+	}
+	l.SetOwnersPubkeys([][]byte{[]byte("owner")})
+	l.SetOwnersFollowed([][]byte{[]byte("followed")})
+	l.SetFollowedFollows([][]byte{[]byte("follow")})
+	l.SetOwnersMuted([][]byte{[]byte("muted")})
+
+	if !l.IsOwner([]byte("owner")) || l.IsOwner([]byte("followed")) {
+		t.Error("IsOwner should only match the owners")
+	}
+	if !l.IsFollowed([]byte("followed")) || l.IsFollowed([]byte("follow")) {
+		t.Error("IsFollowed should only match the owners followed list")
+	}
+	if !l.IsTrusted([]byte("followed")) || !l.IsTrusted([]byte("follow")) ||
+		l.IsTrusted([]byte("muted")) {
+		t.Error("IsTrusted should match both follow lists")
+	}
+	if !l.IsMuted([]byte("muted")) || l.IsMuted([]byte("follow")) {
+		t.Error("IsMuted should only match the muted list")
+	}
+
+	// replacing a list replaces its members
+	l.SetFollowedFollows(nil)
+	if l.IsTrusted([]byte("follow")) {
+		t.Error("Replaced list should not match its old members")
+	}
+}
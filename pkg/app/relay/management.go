@@ -0,0 +1,517 @@
+package relay
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"orly.dev/pkg/app/relay/helpers"
+	"orly.dev/pkg/database"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/eventid"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/protocol/httpauth"
+	"orly.dev/pkg/protocol/nip86"
+	"orly.dev/pkg/protocol/relayinfo"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/errorf"
+	"orly.dev/pkg/utils/keys"
+	"orly.dev/pkg/utils/log"
+)
+
+// The keys of the relay information settings changed through NIP-86.
+const (
+	settingName        = "name"
+	settingDescription = "description"
+	settingIcon        = "icon"
+)
+
+// Management holds the relay management lists and settings of NIP-86 in
+// memory, so they can be checked for every event and request, and writes
+// changes through to the event store so they persist across restarts.
+//
+// The read methods can be called on a nil Management, which has empty lists.
+type Management struct {
+	sync.RWMutex
+	db       *database.D
+	lists    map[string]map[string]database.ListEntry
+	settings map[string]string
+}
+
+// NewManagement loads the relay management lists and settings from the
+// database, or creates empty ones that are only held in memory if db is nil.
+func NewManagement(db *database.D) (m *Management, err error) {
+	m = &Management{
+		db:       db,
+		lists:    make(map[string]map[string]database.ListEntry),
+		settings: make(map[string]string),
+	}
+	if db == nil {
+		return
+	}
+	for _, list := range []string{
+		database.BannedPubkeys, database.AllowedPubkeys,
+		database.BannedEvents, database.AllowedEvents,
+		database.AllowedKinds, database.BlockedIPs,
+	} {
+		var entries []database.ListEntry
+		if entries, err = db.GetList(list); chk.E(err) {
+			return
+		}
+		for _, e := range entries {
+			m.set(list, e)
+		}
+	}
+	if m.settings, err = db.GetSettings(); chk.E(err) {
+		return
+	}
+	return
+}
+
+func (m *Management) set(list string, e database.ListEntry) {
+	if m.lists[list] == nil {
+		m.lists[list] = make(map[string]database.ListEntry)
+	}
+	m.lists[list][e.Value] = e
+}
+
+// Add puts a value on a list, replacing the reason if it is already there.
+func (m *Management) Add(list, value, reason string) (err error) {
+	m.Lock()
+	defer m.Unlock()
+	e := database.ListEntry{Value: value, Reason: reason, Added: time.Now()}
+	if m.db != nil {
+		if err = m.db.SetListEntry(list, e); chk.E(err) {
+			return
+		}
+	}
+	m.set(list, e)
+	return
+}
+
+// Remove takes a value off a list.
+func (m *Management) Remove(list, value string) (err error) {
+	m.Lock()
+	defer m.Unlock()
+	if m.db != nil {
+		if err = m.db.DeleteListEntry(list, value); chk.E(err) {
+			return
+		}
+	}
+	delete(m.lists[list], value)
+	return
+}
+
+// Has returns true if the value is on the list.
+func (m *Management) Has(list, value string) (has bool) {
+	if m == nil {
+		return
+	}
+	m.RLock()
+	defer m.RUnlock()
+	_, has = m.lists[list][value]
+	return
+}
+
+// Len returns the number of values on the list.
+func (m *Management) Len(list string) (n int) {
+	if m == nil {
+		return
+	}
+	m.RLock()
+	defer m.RUnlock()
+	return len(m.lists[list])
+}
+
+// List returns the entries of a list in order of value.
+func (m *Management) List(list string) (entries []database.ListEntry) {
+	if m == nil {
+		return
+	}
+	m.RLock()
+	defer m.RUnlock()
+	for _, e := range m.lists[list] {
+		entries = append(entries, e)
+	}
+	slices.SortFunc(
+		entries, func(a, b database.ListEntry) int {
+			if a.Value < b.Value {
+				return -1
+			} else if a.Value > b.Value {
+				return 1
+			}
+			return 0
+		},
+	)
+	return
+}
+
+// Setting returns a relay management setting, empty if it isn't set.
+func (m *Management) Setting(key string) (value string) {
+	if m == nil {
+		return
+	}
+	m.RLock()
+	defer m.RUnlock()
+	return m.settings[key]
+}
+
+// SetSetting changes a relay management setting, an empty value unsets it.
+func (m *Management) SetSetting(key, value string) (err error) {
+	m.Lock()
+	defer m.Unlock()
+	if m.db != nil {
+		if err = m.db.SetSetting(key, value); chk.E(err) {
+			return
+		}
+	}
+	if value == "" {
+		delete(m.settings, key)
+	} else {
+		m.settings[key] = value
+	}
+	return
+}
+
+// applyInfo overrides the fields of a relay information document that have
+// been changed through NIP-86.
+func (m *Management) applyInfo(info *relayinfo.T) {
+	if v := m.Setting(settingName); v != "" {
+		info.Name = v
+	}
+	if v := m.Setting(settingDescription); v != "" {
+		info.Description = v
+	}
+	if v := m.Setting(settingIcon); v != "" {
+		info.Icon = v
+	}
+}
+
+// remoteIP returns the IP address of a remote address, which may have a port.
+func remoteIP(remote string) string {
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		return host
+	}
+	return remote
+}
+
+// HandleManagement serves the NIP-86 relay management API.
+//
+// # Parameters
+//
+//   - w: HTTP response writer for the JSON response.
+//
+//   - r: HTTP request with a NIP-86 request in the body.
+//
+// # Expected Behaviour
+//
+// Requests must have NIP-98 authorization by one of the owners, with a payload
+// tag matching the body, or they are refused with status 401. Otherwise, the
+// method is called and its result or error is returned as a nip86.Response.
+func (s *Server) HandleManagement(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	remote := helpers.GetRemoteFromReq(r)
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		chk.E(json.NewEncoder(w).Encode(nip86.Response{Error: "use POST"}))
+		return
+	}
+	r = httpauth.ForWrite(r)
+	authed, pubkey := s.AdminAuth(r, remote)
+	if !authed {
+		w.WriteHeader(http.StatusUnauthorized)
+		chk.E(
+			json.NewEncoder(w).Encode(
+				nip86.Response{Error: "unauthorized"},
+			),
+		)
+		return
+	}
+	var req nip86.Request
+	var res nip86.Response
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res.Error = "invalid request: " + err.Error()
+	} else {
+		log.I.F(
+			"%s relay management %s by %0x", remote, req.Method, pubkey,
+		)
+		if res.Result, err = s.manage(req); err != nil {
+			res.Error = err.Error()
+		}
+	}
+	chk.E(json.NewEncoder(w).Encode(res))
+}
+
+// manage calls a NIP-86 method.
+func (s *Server) manage(req nip86.Request) (result any, err error) {
+	m := s.Management
+	switch req.Method {
+	case nip86.SupportedMethods:
+		result = []string{
+			nip86.SupportedMethods,
+			nip86.BanPubkey, nip86.ListBannedPubkeys,
+			nip86.AllowPubkey, nip86.ListAllowedPubkeys,
+			nip86.ListEventsNeedingModeration,
+			nip86.AllowEvent, nip86.BanEvent, nip86.ListBannedEvents,
+			nip86.ChangeRelayName, nip86.ChangeRelayDescription,
+			nip86.ChangeRelayIcon,
+			nip86.AllowKind, nip86.DisallowKind, nip86.ListAllowedKinds,
+			nip86.BlockIP, nip86.UnblockIP, nip86.ListBlockedIPs,
+		}
+	case nip86.BanPubkey, nip86.AllowPubkey:
+		var pk []byte
+		var reason string
+		if pk, reason, err = pubkeyParams(req.Params); err != nil {
+			return
+		}
+		list := database.BannedPubkeys
+		if req.Method == nip86.AllowPubkey {
+			list = database.AllowedPubkeys
+		}
+		if err = m.Add(list, hex.Enc(pk), reason); err != nil {
+			return
+		}
+		result = true
+	case nip86.ListBannedPubkeys, nip86.ListAllowedPubkeys:
+		list := database.BannedPubkeys
+		if req.Method == nip86.ListAllowedPubkeys {
+			list = database.AllowedPubkeys
+		}
+		items := []nip86.PubkeyReason{}
+		for _, e := range m.List(list) {
+			items = append(items, nip86.PubkeyReason{Pubkey: e.Value, Reason: e.Reason})
+		}
+		result = items
+	case nip86.BanEvent:
+		var id []byte
+		var reason string
+		if id, reason, err = idParams(req.Params); err != nil {
+			return
+		}
+		if err = m.Remove(database.AllowedEvents, hex.Enc(id)); err != nil {
+			return
+		}
+		if err = m.Add(database.BannedEvents, hex.Enc(id), reason); err != nil {
+			return
+		}
+		if sto := s.Storage(); sto != nil {
+			// the event may not be stored, which is not an error here.
+			_ = sto.DeleteEvent(s.Ctx, eventid.NewWith(id))
+		}
+		result = true
+	case nip86.AllowEvent:
+		var id []byte
+		var reason string
+		if id, reason, err = idParams(req.Params); err != nil {
+			return
+		}
+		if err = m.Remove(database.BannedEvents, hex.Enc(id)); err != nil {
+			return
+		}
+		if err = m.Add(database.AllowedEvents, hex.Enc(id), reason); err != nil {
+			return
+		}
+		result = true
+	case nip86.ListBannedEvents:
+		items := []nip86.IdReason{}
+		for _, e := range m.List(database.BannedEvents) {
+			items = append(items, nip86.IdReason{Id: e.Value, Reason: e.Reason})
+		}
+		result = items
+	case nip86.ListEventsNeedingModeration:
+		// events are accepted or refused when they are published, there is no
+		// moderation queue.
+		result = []nip86.IdReason{}
+	case nip86.ChangeRelayName, nip86.ChangeRelayDescription,
+		nip86.ChangeRelayIcon:
+		var v string
+		if err = stringParam(req.Params, 0, &v); err != nil {
+			return
+		}
+		key := map[string]string{
+			nip86.ChangeRelayName:        settingName,
+			nip86.ChangeRelayDescription: settingDescription,
+			nip86.ChangeRelayIcon:        settingIcon,
+		}[req.Method]
+		if err = m.SetSetting(key, v); err != nil {
+			return
+		}
+		result = true
+	case nip86.AllowKind, nip86.DisallowKind:
+		var k int
+		if len(req.Params) < 1 {
+			err = errorf.E("missing kind parameter")
+			return
+		}
+		if err = json.Unmarshal(req.Params[0], &k); err != nil || k < 0 ||
+			k > 65535 {
+			err = errorf.E("invalid kind parameter")
+			return
+		}
+		if req.Method == nip86.AllowKind {
+			err = m.Add(database.AllowedKinds, strconv.Itoa(k), "")
+		} else {
+			err = m.Remove(database.AllowedKinds, strconv.Itoa(k))
+		}
+		if err != nil {
+			return
+		}
+		result = true
+	case nip86.ListAllowedKinds:
+		items := []int{}
+		for _, e := range m.List(database.AllowedKinds) {
+			if k, e := strconv.Atoi(e.Value); e == nil {
+				items = append(items, k)
+			}
+		}
+		slices.Sort(items)
+		result = items
+	case nip86.BlockIP, nip86.UnblockIP:
+		var ip, reason string
+		if err = stringParam(req.Params, 0, &ip); err != nil {
+			return
+		}
+		if net.ParseIP(ip) == nil {
+			err = errorf.E("invalid IP address '%s'", ip)
+			return
+		}
+		if len(req.Params) > 1 {
+			if err = stringParam(req.Params, 1, &reason); err != nil {
+				return
+			}
+		}
+		if req.Method == nip86.BlockIP {
+			err = m.Add(database.BlockedIPs, ip, reason)
+		} else {
+			err = m.Remove(database.BlockedIPs, ip)
+		}
+		if err != nil {
+			return
+		}
+		result = true
+	case nip86.ListBlockedIPs:
+		items := []nip86.IPReason{}
+		for _, e := range m.List(database.BlockedIPs) {
+			items = append(items, nip86.IPReason{IP: e.Value, Reason: e.Reason})
+		}
+		result = items
+	default:
+		err = errorf.E("unsupported method '%s'", req.Method)
+	}
+	return
+}
+
+// stringParam decodes the string parameter at index i.
+func stringParam(params []json.RawMessage, i int, v *string) (err error) {
+	if len(params) <= i {
+		err = errorf.E("missing parameter %d", i+1)
+		return
+	}
+	if err = json.Unmarshal(params[i], v); err != nil {
+		err = errorf.E("parameter %d must be a string", i+1)
+	}
+	return
+}
+
+// pubkeyParams decodes the pubkey and optional reason parameters.
+func pubkeyParams(params []json.RawMessage) (
+	pk []byte, reason string, err error,
+) {
+	var v string
+	if err = stringParam(params, 0, &v); err != nil {
+		return
+	}
+	if pk, err = keys.DecodeNpubOrHex(v); err != nil {
+		err = errorf.E("invalid pubkey '%s'", v)
+		return
+	}
+	if len(params) > 1 {
+		err = stringParam(params, 1, &reason)
+	}
+	return
+}
+
+// idParams decodes the event id and optional reason parameters.
+func idParams(params []json.RawMessage) (
+	id []byte, reason string, err error,
+) {
+	var v string
+	if err = stringParam(params, 0, &v); err != nil {
+		return
+	}
+	if id, err = hex.Dec(v); err != nil || len(id) != 32 {
+		err = errorf.E("invalid event id '%s'", v)
+		return
+	}
+	if len(params) > 1 {
+		err = stringParam(params, 1, &reason)
+	}
+	return
+}
+
+// acceptManaged applies the relay management lists to an event.
+//
+// # Expected Behaviour
+//
+// - Reject events from blocked IP addresses, by or sent by banned pubkeys,
+// and banned events.
+//
+// - Events that have been allowed, and events by owners, skip the allow lists.
+//
+// - If any kinds are allowed, reject other kinds.
+//
+// - If any pubkeys are allowed and auth is not required, reject events by
+// other pubkeys. When auth is required the allowed pubkeys are added to the
+// users of the relay instead, see AcceptEvent.
+func (s *Server) acceptManaged(
+	ev *event.E, authedPubkey []byte, remote string,
+) (accept bool, notice string) {
+	m := s.Management
+	if s.ipBlocked(remote) {
+		notice = "address is blocked"
+		return
+	}
+	if m.Has(database.BannedPubkeys, hex.Enc(ev.Pubkey)) ||
+		(len(authedPubkey) > 0 &&
+			m.Has(database.BannedPubkeys, hex.Enc(authedPubkey))) {
+		notice = "event author is banned from this relay"
+		return
+	}
+	id := hex.Enc(ev.ID)
+	if m.Has(database.BannedEvents, id) {
+		notice = "event is banned from this relay"
+		return
+	}
+	if m.Has(database.AllowedEvents, id) || s.isOwner(ev.Pubkey) {
+		accept = true
+		return
+	}
+	if m.Len(database.AllowedKinds) > 0 &&
+		!m.Has(database.AllowedKinds, strconv.Itoa(int(ev.Kind.K))) {
+		notice = fmt.Sprintf("kind %d is not allowed on this relay", ev.Kind.K)
+		return
+	}
+	if m.Len(database.AllowedPubkeys) > 0 && !s.AuthRequired() &&
+		!m.Has(database.AllowedPubkeys, hex.Enc(ev.Pubkey)) {
+		notice = "event author is not allowed on this relay"
+		return
+	}
+	accept = true
+	return
+}
+
+// acceptManagedReq applies the relay management lists to a request, refusing
+// blocked IP addresses and banned pubkeys.
+func (s *Server) acceptManagedReq(authedPubkey []byte, remote string) bool {
+	if s.ipBlocked(remote) {
+		return false
+	}
+	return len(authedPubkey) == 0 ||
+		!s.Management.Has(database.BannedPubkeys, hex.Enc(authedPubkey))
+}
@@ -0,0 +1,168 @@
+package relay
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"orly.dev/pkg/app/config"
+	"orly.dev/pkg/crypto/p256k"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filters"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/interfaces/signer"
+	"orly.dev/pkg/protocol/httpauth"
+	"orly.dev/pkg/protocol/nip86"
+	"orly.dev/pkg/utils/context"
+)
+
+const managementURL = "http://relay.example.com/"
+
+// manageRequest calls a NIP-86 method on the server, signing the request
+// with sign if it is not nil.
+func manageRequest(
+	t *testing.T, s *Server, sign signer.I, method string, params ...any,
+) (code int, res nip86.Response) {
+	req := map[string]any{"method": method, "params": params}
+	body, _ := json.Marshal(req)
+	r := httptest.NewRequest(
+		http.MethodPost, managementURL, bytes.NewReader(body),
+	)
+	r.Header.Set("Content-Type", nip86.ContentType)
+	if sign != nil {
+		sum := sha256.Sum256(body)
+		ur, _ := url.Parse(managementURL)
+		if err := httpauth.AddNIP98Header(
+			r, ur, http.MethodPost, hex.Enc(sum[:]), sign, 0,
+		); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("invalid response %q: %v", w.Body.String(), err)
+	}
+	return w.Code, res
+}
+
+func TestManagement(t *testing.T) {
+	owner, user := new(p256k.Signer), new(p256k.Signer)
+	if err := owner.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := user.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	m, _ := NewManagement(nil)
+	s := &Server{C: &config.C{}, Lists: new(Lists), Management: m}
+	s.SetOwnersPubkeys([][]byte{owner.Pub()})
+	s.SetOwnersFollowed([][]byte{owner.Pub()})
+
+	if code, _ := manageRequest(
+		t, s, user, nip86.BanPubkey, hex.Enc(user.Pub()),
+	); code != http.StatusUnauthorized {
+		t.Fatalf("non-owner got status %d", code)
+	}
+	if _, res := manageRequest(
+		t, s, owner, nip86.BanPubkey, hex.Enc(user.Pub()), "spam",
+	); res.Error != "" || res.Result != true {
+		t.Fatalf("banpubkey failed: %+v", res)
+	}
+	_, res := manageRequest(t, s, owner, nip86.ListBannedPubkeys)
+	b, _ := json.Marshal(res.Result)
+	want := `[{"pubkey":"` + hex.Enc(user.Pub()) + `","reason":"spam"}]`
+	if string(b) != want {
+		t.Fatalf("listbannedpubkeys got %s want %s", b, want)
+	}
+	if _, res = manageRequest(t, s, owner, "nope"); res.Error == "" {
+		t.Fatal("unsupported method did not fail")
+	}
+
+	ctx := context.Bg()
+	hr := httptest.NewRequest(http.MethodGet, managementURL, nil)
+	ev := &event.E{
+		ID:        make([]byte, 32),
+		Pubkey:    user.Pub(),
+		Kind:      kind.TextNote,
+		CreatedAt: timestamp.Now(),
+	}
+	accept, notice, _ := s.AcceptEvent(ctx, ev, hr, nil, "192.0.2.1")
+	if accept || notice != "event author is banned from this relay" {
+		t.Fatalf("banned pubkey accept %v notice %q", accept, notice)
+	}
+
+	ev.Pubkey = owner.Pub()
+	if _, res = manageRequest(
+		t, s, owner, nip86.AllowKind, kind.Reaction.K,
+	); res.Error != "" {
+		t.Fatal(res.Error)
+	}
+	if accept, notice, _ = s.AcceptEvent(
+		ctx, ev, hr, owner.Pub(), "192.0.2.1",
+	); !accept {
+		t.Fatalf("owner event was refused: %s", notice)
+	}
+	other := new(p256k.Signer)
+	_ = other.Generate()
+	ev.Pubkey = other.Pub()
+	accept, notice, _ = s.AcceptEvent(ctx, ev, hr, nil, "192.0.2.1")
+	if accept || notice != "kind 1 is not allowed on this relay" {
+		t.Fatalf("disallowed kind accept %v notice %q", accept, notice)
+	}
+
+	if _, res = manageRequest(
+		t, s, owner, nip86.BlockIP, "198.51.100.7", "abuse",
+	); res.Error != "" {
+		t.Fatal(res.Error)
+	}
+	if _, accept, _ = s.AcceptReq(
+		ctx, hr, filters.New(), nil, "198.51.100.7:1234",
+	); accept {
+		t.Fatal("request from blocked IP was accepted")
+	}
+
+	if _, res = manageRequest(
+		t, s, owner, nip86.ChangeRelayName, "managed",
+	); res.Error != "" {
+		t.Fatal(res.Error)
+	}
+	if s.Management.Setting(settingName) != "managed" {
+		t.Fatal("relay name was not changed")
+	}
+}
+
+func TestManagementContentTypeCharset(t *testing.T) {
+	owner := new(p256k.Signer)
+	if err := owner.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	m, _ := NewManagement(nil)
+	s := &Server{C: &config.C{}, Lists: new(Lists), Management: m}
+	s.SetOwnersPubkeys([][]byte{owner.Pub()})
+	body := []byte(`{"method":"supportedmethods","params":[]}`)
+	r := httptest.NewRequest(
+		http.MethodPost, managementURL, bytes.NewReader(body),
+	)
+	r.Header.Set("Content-Type", nip86.ContentType+"; charset=utf-8")
+	sum := sha256.Sum256(body)
+	ur, _ := url.Parse(managementURL)
+	if err := httpauth.AddNIP98Header(
+		r, ur, http.MethodPost, hex.Enc(sum[:]), owner, 0,
+	); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	var res nip86.Response
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil ||
+		res.Error != "" || res.Result == nil {
+		t.Fatalf("unexpected response %q: %v", w.Body.String(), err)
+	}
+}
@@ -1,15 +1,122 @@
 package relay
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"orly.dev/pkg/database"
+	"orly.dev/pkg/encoders/event"
 	"orly.dev/pkg/utils/log"
+	"orly.dev/pkg/utils/metrics"
 )
 
+// The relay-wide metrics, written on /metrics after those of the
+// MetricsCollector.
+var (
+	eventsReceived = metrics.NewCounter(
+		"orly_events_received_total",
+		"events received with a valid id and signature, by kind", "kind",
+	)
+	eventsAccepted = metrics.NewCounter(
+		"orly_events_accepted_total", "events accepted, by kind", "kind",
+	)
+	eventsRejected = metrics.NewCounter(
+		"orly_events_rejected_total",
+		"events rejected, by the prefix of the OK message and kind",
+		"reason", "kind",
+	)
+	subscriptions = metrics.NewGauge(
+		"orly_subscriptions", "open subscriptions, by transport", "transport",
+	)
+	spiderRuns = metrics.NewHistogram(
+		"orly_spider_run_seconds",
+		"duration of spider runs, by phase (lists of the owners, or events of the followed pubkeys) and result",
+		[]float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600},
+		"phase", "result",
+	)
+	peerReplication = metrics.NewHistogram(
+		"orly_peer_replication_seconds",
+		"time from receiving an event to a peer relay accepting it, by peer",
+		metrics.DefBuckets, "peer",
+	)
+	peerFailures = metrics.NewCounter(
+		"orly_peer_replication_failures_total",
+		"events that could not be pushed to a peer relay, by peer", "peer",
+	)
+)
+
+// okPrefixes are the machine readable prefixes of NIP-01 OK messages.
+var okPrefixes = []string{
+	"duplicate", "pow", "blocked", "rate-limited", "invalid", "restricted",
+	"mute", "error", "auth-required",
+}
+
+// rejectReason returns the prefix of an OK message as the reason label of
+// eventsRejected, or def if it has none. Messages about authentication are
+// auth-required, as the websocket API sends them.
+func rejectReason(msg, def string) string {
+	if i := strings.Index(msg, ": "); i > 0 {
+		for _, p := range okPrefixes {
+			if msg[:i] == p {
+				return p
+			}
+		}
+	}
+	if strings.Contains(msg, "authed") || strings.Contains(msg, "authenticate") {
+		return "auth-required"
+	}
+	return def
+}
+
+// kindLabel returns the kind of ev as the kind label of the event metrics,
+// or "other" for the kinds without a name in kind.Map, so that the number of
+// series stays bounded.
+func kindLabel(ev *event.E) string {
+	if ev.Kind == nil {
+		return ""
+	}
+	if ev.Kind.Name() == "" {
+		return "other"
+	}
+	return strconv.Itoa(int(ev.Kind.K))
+}
+
+// spiderState is the progress of the spider runs, which /health reports.
+type spiderState struct {
+	sync.Mutex
+	running  bool
+	lastRun  time.Time
+	duration time.Duration
+	err      error
+}
+
+// start records the start of a spider run.
+func (st *spiderState) start() (start time.Time) {
+	st.Lock()
+	defer st.Unlock()
+	st.running, start = true, time.Now()
+	return
+}
+
+// end records the end of a spider run that started at start, with the
+// error it failed with, if any.
+func (st *spiderState) end(start time.Time, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	spiderRuns.Since(start, "lists", result)
+	st.Lock()
+	defer st.Unlock()
+	st.running, st.lastRun, st.duration, st.err = false, start,
+		time.Since(start), err
+}
+
 // MetricsCollector tracks subscription system metrics
 type MetricsCollector struct {
 	mu sync.RWMutex
@@ -32,9 +139,13 @@ type MetricsCollector struct {
 	maxDurationSamples    int
 
 	// Health status
+	started           time.Time
 	lastHealthCheck   time.Time
 	isHealthy         bool
 	healthCheckErrors []string
+
+	// spider is the state of the spider runs of the relay, if any.
+	spider *spiderState
 }
 
 // NewMetricsCollector creates a new metrics collector
@@ -43,6 +154,7 @@ func NewMetricsCollector(db *database.D) *MetricsCollector {
 		db:                 db,
 		maxDurationSamples: 1000,
 		isHealthy:          true,
+		started:            time.Now(),
 		lastHealthCheck:    time.Now(),
 	}
 }
@@ -254,6 +366,18 @@ func (mc *MetricsCollector) PerformHealthCheck() {
 		mc.healthCheckErrors = append(mc.healthCheckErrors, "database not initialized")
 	}
 
+	if mc.spider != nil {
+		mc.spider.Lock()
+		if mc.spider.err != nil {
+			mc.isHealthy = false
+			mc.healthCheckErrors = append(
+				mc.healthCheckErrors,
+				fmt.Sprintf("spider error: %v", mc.spider.err),
+			)
+		}
+		mc.spider.Unlock()
+	}
+
 	if mc.isHealthy {
 		log.D.Ln("health check passed")
 	} else {
@@ -266,12 +390,28 @@ func (mc *MetricsCollector) GetHealthStatus() map[string]interface{} {
 	mc.mu.RLock()
 	defer mc.mu.RUnlock()
 
-	return map[string]interface{}{
+	status := map[string]interface{}{
 		"healthy":        mc.isHealthy,
 		"last_check":     mc.lastHealthCheck.Format(time.RFC3339),
 		"errors":         mc.healthCheckErrors,
-		"uptime_seconds": time.Since(mc.lastHealthCheck).Seconds(),
+		"uptime_seconds": time.Since(mc.started).Seconds(),
 	}
+	if mc.spider != nil {
+		mc.spider.Lock()
+		spider := map[string]interface{}{
+			"running":               mc.spider.running,
+			"last_duration_seconds": mc.spider.duration.Seconds(),
+		}
+		if !mc.spider.lastRun.IsZero() {
+			spider["last_run"] = mc.spider.lastRun.Format(time.RFC3339)
+		}
+		if mc.spider.err != nil {
+			spider["error"] = mc.spider.err.Error()
+		}
+		mc.spider.Unlock()
+		status["spider"] = spider
+	}
+	return status
 }
 
 // StartPeriodicHealthChecks runs health checks periodically
@@ -293,13 +433,14 @@ func (mc *MetricsCollector) StartPeriodicHealthChecks(interval time.Duration, st
 	}
 }
 
-// MetricsHandler handles HTTP requests for metrics endpoint
+// MetricsHandler handles HTTP requests for metrics endpoint, writing the
+// subscription metrics followed by the relay-wide metrics.
 func (mc *MetricsCollector) MetricsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
 
-	metrics := mc.GetPrometheusMetrics()
-	w.Write([]byte(metrics))
+	w.Write([]byte(mc.GetPrometheusMetrics()))
+	metrics.Write(w)
 }
 
 // HealthHandler handles HTTP requests for health check endpoint
@@ -317,30 +458,7 @@ func (mc *MetricsCollector) HealthHandler(w http.ResponseWriter, r *http.Request
 		w.WriteHeader(http.StatusServiceUnavailable)
 	}
 
-	// Simple JSON formatting without external dependencies
-	healthy := "true"
-	if !status["healthy"].(bool) {
-		healthy = "false"
-	}
-
-	errorsJson := "[]"
-	if errors, ok := status["errors"].([]string); ok && len(errors) > 0 {
-		errorsJson = `["`
-		for i, err := range errors {
-			if i > 0 {
-				errorsJson += `", "`
-			}
-			errorsJson += err
-		}
-		errorsJson += `"]`
-	}
-
-	response := fmt.Sprintf(`{
-  "healthy": %s,
-  "last_check": "%s",
-  "errors": %s,
-  "uptime_seconds": %.2f
-}`, healthy, status["last_check"], errorsJson, status["uptime_seconds"])
-
-	w.Write([]byte(response))
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(status)
 }
@@ -0,0 +1,78 @@
+package relay
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dgraph-io/badger/v4"
+	"orly.dev/pkg/database"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/kind"
+)
+
+func TestRejectReason(t *testing.T) {
+	for msg, reason := range map[string]string{
+		"duplicate: already have this event": "duplicate",
+		"invalid: bad created_at":            "invalid",
+		"client isn't authed":                "auth-required",
+		"event too old":                      "blocked",
+		"something: else":                    "blocked",
+	} {
+		if r := rejectReason(msg, "blocked"); r != reason {
+			t.Errorf("%q: got %s, expected %s", msg, r, reason)
+		}
+	}
+}
+
+func TestKindLabel(t *testing.T) {
+	for k, label := range map[*kind.T]string{
+		kind.TextNote:   "1",
+		kind.FollowList: "3",
+		kind.New(9999):  "other",
+		kind.New(54321): "other",
+	} {
+		if l := kindLabel(&event.E{Kind: k}); l != label {
+			t.Errorf("kind %d: got %s, expected %s", k.K, l, label)
+		}
+	}
+}
+
+func TestHealth(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var spider spiderState
+	mc := NewMetricsCollector(&database.D{DB: db})
+	mc.spider = &spider
+	health := func() (code int, status map[string]any) {
+		w := httptest.NewRecorder()
+		mc.HealthHandler(w, httptest.NewRequest("GET", "/health", nil))
+		if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+			t.Fatal(err)
+		}
+		return w.Code, status
+	}
+	spider.end(spider.start(), nil)
+	if code, status := health(); code != http.StatusOK ||
+		status["spider"].(map[string]any)["last_run"] == nil {
+		t.Fatalf("unexpected health %d %v", code, status)
+	}
+	spider.end(spider.start(), errors.New("no seeds"))
+	if code, status := health(); code != http.StatusServiceUnavailable ||
+		status["spider"].(map[string]any)["error"] != "no seeds" {
+		t.Fatalf("unexpected health %d %v", code, status)
+	}
+	w := httptest.NewRecorder()
+	mc.MetricsHandler(w, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(
+		w.Body.String(), `orly_spider_run_seconds_count{phase="lists",result="error"} 1`,
+	) {
+		t.Fatalf("spider run missing from metrics:\n%s", w.Body.String())
+	}
+}
@@ -1,6 +0,0 @@
-package relay
-
-func (s *Server) OwnersPubkeys() (pks [][]byte) {
-	pks = s.ownersPubkeys
-	return
-}
@@ -22,6 +22,8 @@ type PaymentProcessor struct {
 	ctx       context.T
 	cancel    context.F
 	wg        sync.WaitGroup
+	// metrics counts the outcomes of the payments, if it is set.
+	metrics *MetricsCollector
 }
 
 // NewPaymentProcessor creates a new payment processor
@@ -70,7 +72,20 @@ func (pp *PaymentProcessor) Stop() {
 
 // listenForPayments subscribes to NWC notifications and processes payments
 func (pp *PaymentProcessor) listenForPayments() error {
-	return pp.nwcClient.SubscribeNotifications(pp.ctx, pp.handleNotification)
+	return pp.nwcClient.SubscribeNotifications(
+		pp.ctx, func(typ string, notification map[string]any) (err error) {
+			err = pp.handleNotification(typ, notification)
+			if pp.metrics == nil || typ != "payment_received" {
+				return
+			}
+			if err != nil {
+				pp.metrics.RecordPaymentFailure()
+			} else {
+				pp.metrics.RecordPaymentSuccess()
+			}
+			return
+		},
+	)
 }
 
 // handleNotification processes incoming payment notifications
@@ -1,37 +1,93 @@
 package relay
 
 import (
+	"slices"
+
 	"orly.dev/pkg/crypto/p256k"
 	"orly.dev/pkg/encoders/bech32encoding"
+	"orly.dev/pkg/encoders/event"
 	"orly.dev/pkg/interfaces/signer"
+	"orly.dev/pkg/protocol/cosign"
 	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/errorf"
 	"orly.dev/pkg/utils/keys"
 	"orly.dev/pkg/utils/log"
+	"orly.dev/pkg/utils/passphrase"
 	"strings"
+	"sync"
 )
 
 // Peers is a structure that keeps the information required when peer
 // replication is enabled.
 //
-// - Addresses are the relay addresses that will be pushed new events when
-// accepted. From ORLY_PEER_RELAYS first field after the |.
+// - addresses are the relay addresses that will be pushed new events when
+// accepted. From ORLY_PEER_RELAYS after the @.
+//
+// - pubkeys are the relay peer public keys that we will send any event to
+// including privileged type. From ORLY_PEER_RELAYS before the @.
 //
-// - Pubkeys are the relay peer public keys that we will send any event to
-// including privileged type. From ORLY_PEER_RELAYS before the |.
+// - I - the signer of this relay, generated from the nsec, hex or ncryptsec in
+// ORLY_SECRET_KEY, or the MuSig2 aggregate key of the operators if
+// ORLY_OPERATORS is set.
 //
-// - I - the signer of this relay, generated from the nsec in
-// ORLY_SECRET_KEY.
+// The addresses and pubkeys are replaced together when the configuration
+// changes, and are read with Replicas.
 type Peers struct {
-	Addresses []string
-	Pubkeys   [][]byte
+	mx        sync.RWMutex
+	addresses []string
+	pubkeys   [][]byte
 	signer.I
 }
 
+// Replicas returns the addresses of the peer relays and their pubkeys, at the
+// same index. The slices are never modified, as SetAddresses replaces them.
+func (p *Peers) Replicas() (addresses []string, pubkeys [][]byte) {
+	p.mx.RLock()
+	defer p.mx.RUnlock()
+	return p.addresses, p.pubkeys
+}
+
 // Init accepts the lists which will come from config.C for peer relay settings
-// and populate the Peers with this data after decoding it.
+// and populate the Peers with this data after decoding it. The passphrase of
+// an ncryptsec sec is read from the file descriptor passFD, or prompted for
+// if it is negative.
 func (p *Peers) Init(
-	addresses []string, sec string,
+	addresses []string, sec string, passFD int,
 ) (err error) {
+	p.SetAddresses(addresses)
+	if sec == "" {
+		return
+	}
+	p.I = &p256k.Signer{}
+	var s []byte
+	if s, err = keys.DecodeSecret(
+		sec, func() (string, error) {
+			return passphrase.Get(passFD, "ORLY_SECRET_KEY passphrase: ")
+		},
+	); chk.E(err) {
+		return
+	}
+	if err = p.I.InitSec(s); chk.E(err) {
+		return
+	}
+	var npub []byte
+	if npub, err = bech32encoding.BinToNpub(p.I.Pub()); chk.E(err) {
+		return
+	}
+	log.I.F(
+		"relay peer initialized, relay's npub: %s",
+		npub,
+	)
+	return
+}
+
+// SetAddresses replaces the peer relays with those in addresses, which are in
+// the <pubkey>@<url> format of ORLY_PEER_RELAYS. Invalid addresses are logged
+// and skipped.
+func (p *Peers) SetAddresses(addresses []string) {
+	var urls []string
+	var pubkeys [][]byte
 	for _, address := range addresses {
 		if len(address) == 0 {
 			continue
@@ -41,32 +97,84 @@ func (p *Peers) Init(
 			log.E.F("invalid peer address: %s", address)
 			continue
 		}
-		p.Addresses = append(p.Addresses, split[1])
-		var pk []byte
-		if pk, err = keys.DecodeNpubOrHex(split[0]); chk.D(err) {
+		pk, err := keys.DecodeNpubOrHex(split[0])
+		if chk.D(err) {
+			log.E.F("invalid peer pubkey: %s", address)
 			continue
 		}
-		p.Pubkeys = append(p.Pubkeys, pk)
+		urls = append(urls, split[1])
+		pubkeys = append(pubkeys, pk)
 		log.I.F("peer %s added; pubkey: %0x", split[1], pk)
 	}
-	if sec == "" {
+	p.mx.Lock()
+	p.addresses, p.pubkeys = urls, pubkeys
+	p.mx.Unlock()
+}
+
+// InitOperators replaces the signer of the relay with the MuSig2 aggregate key
+// of the operators, so the relay identity can only sign with every operator
+// co-signing. The key from Init must be the key of one of the operators.
+//
+// # Parameters
+//
+//   - c: the context bounding the signing sessions.
+//
+//   - operators: the npub or hex pubkeys of the operators, including this one.
+//
+//   - relay: the relay the operators exchange signing sessions on.
+//
+//   - kinds: the event kinds this relay co-signs when another operator asks.
+//
+// # Expected Behaviour:
+//
+// Without operators nothing changes. Otherwise the cosign.Signer is started
+// and becomes the signer of the relay, so its pubkey is the one peers and the
+// relay information document see.
+func (p *Peers) InitOperators(
+	c context.T, operators []string, relay string, kinds []int,
+) (err error) {
+	var ops [][]byte
+	for _, o := range operators {
+		if o = strings.TrimSpace(o); o == "" {
+			continue
+		}
+		var pk []byte
+		if pk, err = keys.DecodeNpubOrHex(o); chk.E(err) {
+			return
+		}
+		ops = append(ops, pk)
+	}
+	if len(ops) == 0 {
 		return
 	}
-	p.I = &p256k.Signer{}
-	var s []byte
-	if s, err = keys.DecodeNsecOrHex(sec); chk.E(err) {
+	if p.I == nil || len(p.I.Sec()) == 0 {
+		err = errorf.E("ORLY_OPERATORS requires the operator key in ORLY_SECRET_KEY")
 		return
 	}
-	if err = p.I.InitSec(s); chk.E(err) {
+	if relay == "" {
+		err = errorf.E("ORLY_OPERATORS requires ORLY_COSIGN_RELAY")
 		return
 	}
+	var cs *cosign.Signer
+	if cs, err = cosign.New(p.I, ops, relay); chk.E(err) {
+		return
+	}
+	cs.Approve = func(ev *event.E) (err error) {
+		if !slices.Contains(kinds, ev.Kind.ToInt()) {
+			err = errorf.E("kind %d is not co-signed by this operator", ev.Kind.K)
+		}
+		return
+	}
+	if err = cs.Start(c); chk.E(err) {
+		return
+	}
+	p.I = cs
 	var npub []byte
-	if npub, err = bech32encoding.BinToNpub(p.I.Pub()); chk.E(err) {
+	if npub, err = bech32encoding.BinToNpub(cs.Pub()); chk.E(err) {
 		return
 	}
 	log.I.F(
-		"relay peer initialized, relay's npub: %s",
-		npub,
+		"relay identity is the key of %d operators: %s", len(ops), npub,
 	)
 	return
 }
@@ -16,8 +16,8 @@ func (s *Server) Publisher() *publish.S { return s.listeners }
 
 func (s *Server) Context() context.T { return s.Ctx }
 
-func (s *Server) AuthRequired() bool { return s.C.AuthRequired || s.LenOwnersPubkeys() > 0 }
+func (s *Server) AuthRequired() bool { return s.Config().AuthRequired || s.LenOwnersPubkeys() > 0 }
 
-func (s *Server) PublicReadable() bool { return s.C.PublicReadable }
+func (s *Server) PublicReadable() bool { return s.Config().PublicReadable }
 
 var _ server.I = &Server{}
@@ -3,9 +3,10 @@ package relay
 import (
 	"errors"
 	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"orly.dev/pkg/database"
 	"orly.dev/pkg/encoders/event"
 	"orly.dev/pkg/encoders/filter"
-	"orly.dev/pkg/encoders/kind"
 	"orly.dev/pkg/encoders/kinds"
 	"orly.dev/pkg/encoders/tag"
 	"orly.dev/pkg/encoders/tags"
@@ -16,6 +17,8 @@ import (
 	"orly.dev/pkg/utils/errorf"
 	"orly.dev/pkg/utils/log"
 	"orly.dev/pkg/utils/normalize"
+	"orly.dev/pkg/utils/tracing"
+	"slices"
 )
 
 // Publish processes and stores an event in the server's storage. It handles
@@ -42,7 +45,17 @@ import (
 //
 // - For parameterized replaceable events, it performs a similar process but
 // uses additional tags to identify duplicates.
+//
+// - Follow and mute lists of the owners and the users they trust update the
+// web of trust once they are saved.
 func (s *Server) Publish(c context.T, evt *event.E) (err error) {
+	c, span := tracing.Start(
+		c, "relay.Publish", attribute.Int("kind", int(evt.Kind.K)),
+	)
+	defer func() {
+		tracing.Error(span, err)
+		span.End()
+	}()
 	sto := s.relay.Storage()
 	if evt.Kind.IsEphemeral() {
 		// don't store ephemeral events
@@ -79,7 +92,7 @@ func (s *Server) Publish(c context.T, evt *event.E) (err error) {
 						)
 					},
 				)
-				if ev.CreatedAt.Int() > evt.CreatedAt.Int() {
+				if database.Supersedes(ev, evt) {
 					return errorf.W(
 						string(
 							normalize.Invalid.F(
@@ -94,56 +107,6 @@ func (s *Server) Publish(c context.T, evt *event.E) (err error) {
 				if ev.Kind.IsDirectoryEvent() {
 					del = false
 				}
-				if evt.Kind.Equal(kind.FollowList) {
-					// if the event is from someone on ownersFollowed or
-					// followedFollows, for now add to this list so they're
-					// immediately effective.
-					var isFollowed bool
-					ownersFollowed := s.OwnersFollowed()
-					for _, pk := range ownersFollowed {
-						if utils.FastEqual(evt.Pubkey, pk) {
-							isFollowed = true
-						}
-					}
-					if isFollowed {
-						if _, _, err = sto.SaveEvent(
-							c, evt, false, nil,
-						); err != nil && !errors.Is(
-							err, store.ErrDupEvent,
-						) {
-							return
-						}
-						// we need to trigger the spider with no fetch
-						if err = s.Spider(true); chk.E(err) {
-							err = nil
-						}
-						// event has been saved and lists updated.
-						// return
-					}
-
-				}
-				if evt.Kind.Equal(kind.MuteList) {
-					// check if this is one of the owners, if so, the mute list
-					// should be applied immediately.
-					owners := s.OwnersPubkeys()
-					for _, pk := range owners {
-						if utils.FastEqual(evt.Pubkey, pk) {
-							if _, _, err = sto.SaveEvent(
-								c, evt, false, nil,
-							); err != nil && !errors.Is(
-								err, store.ErrDupEvent,
-							) {
-								return
-							}
-							// we need to trigger the spider with no fetch
-							if err = s.Spider(true); chk.E(err) {
-								err = nil
-							}
-							// event has been saved and lists updated.
-							// return
-						}
-					}
-				}
 				// defer the delete until after the save, further down, has
 				// completed.
 				if del {
@@ -213,7 +176,7 @@ func (s *Server) Publish(c context.T, evt *event.E) (err error) {
 						)
 					},
 				)
-				if ev.CreatedAt.Int() > evt.CreatedAt.Int() {
+				if database.Supersedes(ev, evt) {
 					return errorf.D(string(normalize.Error.F("not replacing newer parameterized replaceable event")))
 				}
 				// not deleting these events because some clients are retarded
@@ -260,8 +223,9 @@ func (s *Server) Publish(c context.T, evt *event.E) (err error) {
 			}
 		}
 	}
+	_, peers := s.Peers.Replicas()
 	if _, _, err = sto.SaveEvent(
-		c, evt, false, append(s.Peers.Pubkeys, s.ownersPubkeys...),
+		c, evt, false, slices.Concat(peers, s.OwnersPubkeys()),
 	); err != nil && !errors.Is(
 		err, store.ErrDupEvent,
 	) {
@@ -272,5 +236,10 @@ func (s *Server) Publish(c context.T, evt *event.E) (err error) {
 			return fmt.Sprintf("saved event:\n%s", evt.Serialize())
 		},
 	)
+	if err == nil {
+		// follow and mute lists of trusted users change who is allowed
+		// within moments.
+		s.updateWoT(evt)
+	}
 	return
 }
@@ -4,14 +4,16 @@ import (
 	_ "embed"
 	"errors"
 	"fmt"
+	"mime"
 	"net"
 	"net/http"
 	"strconv"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"orly.dev/pkg/database"
+	"orly.dev/pkg/protocol/nip86"
 	"orly.dev/pkg/protocol/openapi"
 	"orly.dev/pkg/protocol/socketapi"
 
@@ -19,14 +21,15 @@ import (
 	"orly.dev/pkg/app/relay/helpers"
 	"orly.dev/pkg/app/relay/options"
 	"orly.dev/pkg/app/relay/publish"
+	"orly.dev/pkg/app/relay/spider"
+	"orly.dev/pkg/app/relay/wot"
 	"orly.dev/pkg/interfaces/relay"
 	"orly.dev/pkg/protocol/servemux"
 	"orly.dev/pkg/utils/chk"
 	"orly.dev/pkg/utils/context"
 	"orly.dev/pkg/utils/keys"
 	"orly.dev/pkg/utils/log"
-
-	"github.com/rs/cors"
+	"orly.dev/pkg/utils/metrics"
 )
 
 // Server represents the core structure for running a nostr relay. It
@@ -39,13 +42,33 @@ type Server struct {
 	relay            relay.I
 	Addr             string
 	mux              *servemux.S
-	httpServer       *http.Server
+	httpServers      []*http.Server
 	listeners        *publish.S
 	blacklistPubkeys [][]byte
+	// configMx serialises changes to the configuration and guards the state
+	// derived from it.
+	configMx sync.RWMutex
+	// spiderTicker is the timer of the spider runs.
+	spiderTicker *time.Ticker
+	// spiderState is the progress of the spider runs.
+	spiderState spiderState
+	// spider fetches the events of the spider runs from the seeds.
+	spider *spider.S
+	// wot is the web of trust graph of the last spider run, which the
+	// followed, follows and muted lists are made from.
+	wot atomic.Pointer[wot.G]
+	// wotUpdates are the lists waiting to be applied to wot.
+	wotUpdates wotUpdates
+	// live is the running configuration once it has been changed, see
+	// Config.
+	live atomic.Pointer[config.C]
+	// C is the configuration the server was started with, read the running
+	// configuration with Config.
 	*config.C
 	*Lists
 	*Peers
 	Mux               *servemux.S
+	Management        *Management
 	MetricsCollector  *MetricsCollector
 	subscriptionCache map[string]time.Time // pubkey hex -> cache expiry time
 	subscriptionMutex sync.RWMutex
@@ -115,6 +138,18 @@ func NewServer(
 		Peers:             new(Peers),
 		subscriptionCache: make(map[string]time.Time),
 	}
+	db, _ := sp.Rl.Storage().(*database.D)
+	if db != nil {
+		db.SetSlowQuery(s.C.SlowQuery)
+		s.spider = spider.New(sp.Ctx, db)
+	} else {
+		// without a database there are no cursors, so every run fetches
+		// everything again.
+		s.spider = spider.New(sp.Ctx, nil)
+	}
+	if s.Management, err = NewManagement(db); chk.E(err) {
+		return nil, fmt.Errorf("relay management lists: %w", err)
+	}
 	// Parse blacklist pubkeys
 	for _, v := range s.C.Blacklist {
 		if len(v) == 0 {
@@ -127,9 +162,31 @@ func NewServer(
 		s.blacklistPubkeys = append(s.blacklistPubkeys, pk)
 	}
 	chk.E(
-		s.Peers.Init(sp.C.PeerRelays, sp.C.RelaySecret),
+		s.Peers.Init(
+			sp.C.PeerRelays, sp.C.RelaySecret, sp.C.RelaySecretFD,
+		),
+	)
+	if err = s.Peers.InitOperators(
+		sp.Ctx, sp.C.Operators, sp.C.CosignRelay, sp.C.CosignKinds,
+	); err != nil {
+		return nil, fmt.Errorf("operators: %w", err)
+	}
+	socketPublisher, httpPublisher := socketapi.New(s), openapi.NewPublisher(s)
+	s.listeners = publish.New(socketPublisher, httpPublisher)
+	metrics.Collect(
+		"subscriptions", func() {
+			subscriptions.Set(
+				float64(socketPublisher.Subscriptions()), "websocket",
+			)
+			subscriptions.Set(float64(httpPublisher.Subscriptions()), "http")
+		},
 	)
-	s.listeners = publish.New(socketapi.New(s), openapi.NewPublisher(s))
+	s.MetricsCollector = NewMetricsCollector(db)
+	s.MetricsCollector.spider = &s.spiderState
+	if serveMux != nil {
+		serveMux.HandleFunc("/metrics", s.MetricsCollector.MetricsHandler)
+		serveMux.HandleFunc("/health", s.MetricsCollector.HealthHandler)
+	}
 	go func() {
 		if err := s.relay.Init(); chk.E(err) {
 			s.Shutdown()
@@ -157,23 +214,18 @@ func NewServer(
 // - If "Accept" header is "application/nostr+json", calls HandleRelayInfo
 // method.
 //
+// - If "Content-Type" header is "application/nostr+json+rpc", with or without
+// parameters such as a charset, calls HandleManagement method.
+//
 // - Logs the HTTP request details for non-standard requests.
 //
 // - For all other paths, delegates to the internal mux's ServeHTTP method.
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	c := s.Config()
 	remote := helpers.GetRemoteFromReq(r)
-	var whitelisted bool
-	if len(c.Whitelist) > 0 {
-		for _, addr := range c.Whitelist {
-			if strings.HasPrefix(remote, addr) {
-				whitelisted = true
-			}
-		}
-	} else {
-		whitelisted = true
-	}
-	if !whitelisted {
+	whitelisted := len(c.Whitelist) == 0 ||
+		helpers.IPListed(remote, c.Whitelist)
+	if !whitelisted || s.ipBlocked(remote) {
 		return
 	}
 	// standard nostr protocol only governs the "root" path of the relay and
@@ -187,6 +239,12 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			s.HandleRelayInfo(w, r)
 			return
 		}
+		if mt, _, err := mime.ParseMediaType(
+			r.Header.Get("Content-Type"),
+		); err == nil && mt == nip86.ContentType {
+			s.HandleManagement(w, r)
+			return
+		}
 	}
 	log.T.C(
 		func() string {
@@ -199,36 +257,36 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.mux.ServeHTTP(w, r)
 }
 
-// Start initializes the server by setting up a TCP listener and serving HTTP
-// requests.
+// Start initializes the server by setting up its listeners and serving HTTP
+// requests on them.
 //
 // # Parameters
 //
-// - host (string): The hostname or IP address to listen on.
+// - host (string): The hostname or IP address of the plain TCP listener.
 //
-// - port (int): The port number to bind to.
+// - port (int): The port number of the plain TCP listener, 0 for any free
+// port.
 //
 // - started (...chan bool): Optional channels that are closed after the server
 // starts successfully.
 //
 // # Return Values
 //
-// - err (error): An error if any step fails during the server startup process.
+// - err (error): An error if any step fails during the server startup process,
+// or a listener stops with an error.
 //
 // # Expected Behaviour
 //
-// - Joins the host and port into a full address string.
-//
-// - Logs the intention to start the relay listener at the specified address.
+// - Opens the plain TCP listener at host and port, and the TLS, unix socket
+// and admin listeners that are configured, see listen.
 //
-// - Listens for TCP connections on the specified address.
+// - Sets Addr to the address of the plain TCP listener.
 //
-// - Configures an HTTP server with CORS middleware, sets timeouts, and binds it
-// to the listener.
+// - Configures an HTTP server with timeouts for each listener.
 //
 // - If any started channels are provided, closes them upon successful startup.
 //
-// - Starts serving requests using the configured HTTP server.
+// - Serves requests until all the listeners are shut down.
 func (s *Server) Start(
 	host string, port int, started ...chan bool,
 ) (err error) {
@@ -239,6 +297,7 @@ func (s *Server) Start(
 				log.E.F("failed to create payment processor: %v", err)
 				// Continue without payment processor
 			} else {
+				s.paymentProcessor.metrics = s.MetricsCollector
 				if err := s.paymentProcessor.Start(); err != nil {
 					log.E.F("failed to start payment processor: %v", err)
 				} else {
@@ -250,22 +309,26 @@ func (s *Server) Start(
 		}
 	}
 
-	log.I.F("running spider every %v", s.C.SpiderTime)
-	if len(s.C.Owners) > 0 {
+	go s.MetricsCollector.StartPeriodicHealthChecks(time.Minute, s.Ctx.Done())
+	log.I.F("running spider every %v", s.Config().SpiderTime)
+	if len(s.Config().Owners) > 0 {
 		// start up spider
-		if err = s.Spider(s.C.Private); chk.E(err) {
+		if err = s.Spider(s.Config().Private); chk.E(err) {
 			// there wasn't any owners, or they couldn't be found on the spider
 			// seeds.
 			err = nil
 		}
 	}
 	// start up a spider run to trigger every 30 minutes
-	ticker := time.NewTicker(s.C.SpiderTime)
+	s.configMx.Lock()
+	s.spiderTicker = time.NewTicker(s.Config().SpiderTime)
+	ticker := s.spiderTicker
+	s.configMx.Unlock()
 	go func() {
 		for {
 			select {
 			case <-ticker.C:
-				if err = s.Spider(s.C.Private); chk.E(err) {
+				if err = s.Spider(s.Config().Private); chk.E(err) {
 					// there wasn't any owners, or they couldn't be found on the spider
 					// seeds.
 					err = nil
@@ -276,23 +339,42 @@ func (s *Server) Start(
 			}
 		}
 	}()
-	addr := net.JoinHostPort(host, strconv.Itoa(port))
-	log.I.F("starting relay listener at %s", addr)
-	var ln net.Listener
-	if ln, err = net.Listen("tcp", addr); err != nil {
-		return err
+	var lns []*listener
+	if lns, err = s.listen(
+		net.JoinHostPort(host, strconv.Itoa(port)),
+	); err != nil {
+		return
+	}
+	s.Addr = lns[0].Addr().String()
+	for _, l := range lns {
+		log.I.F("starting %s listener at %s", l.name, l.Addr())
+		s.httpServers = append(
+			s.httpServers, &http.Server{
+				Handler:           l.Handler,
+				TLSConfig:         l.tls,
+				ReadHeaderTimeout: 7 * time.Second,
+				IdleTimeout:       28 * time.Second,
+			},
+		)
 	}
-	s.httpServer = &http.Server{
-		Handler:           cors.Default().Handler(s),
-		Addr:              addr,
-		ReadHeaderTimeout: 7 * time.Second,
-		IdleTimeout:       28 * time.Second,
+	failed := make(chan error, len(lns))
+	for i, l := range lns {
+		srv := s.httpServers[i]
+		go func() {
+			if l.tls != nil {
+				failed <- srv.ServeTLS(l.Listener, "", "")
+				return
+			}
+			failed <- srv.Serve(l.Listener)
+		}()
 	}
 	for _, startedC := range started {
 		close(startedC)
 	}
-	if err = s.httpServer.Serve(ln); errors.Is(err, http.ErrServerClosed) {
-	} else if err != nil {
+	for range lns {
+		if err = <-failed; err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return
+		}
 	}
 	return nil
 }
@@ -308,7 +390,8 @@ func (s *Server) Start(
 //
 // - Closes the event store, logging the action and checking for errors.
 //
-// - Shuts down the HTTP server, logging the action and checking for errors.
+// - Shuts down the HTTP servers of the listeners, logging the action and
+// checking for errors.
 //
 // - If the relay implements ShutdownAware, it calls OnShutdown with the
 // context.
@@ -324,9 +407,9 @@ func (s *Server) Shutdown() {
 	s.Cancel()
 	log.W.Ln("closing event store")
 	chk.E(s.relay.Storage().Close())
-	if s.httpServer != nil {
+	for _, srv := range s.httpServers {
 		log.W.Ln("shutting down relay listener")
-		chk.E(s.httpServer.Shutdown(s.Ctx))
+		chk.E(srv.Shutdown(s.Ctx))
 	}
 	if f, ok := s.relay.(relay.ShutdownAware); ok {
 		f.OnShutdown(s.Ctx)
@@ -0,0 +1,125 @@
+package relay
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"orly.dev/pkg/crypto/p256k"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/protocol/ws"
+	"orly.dev/pkg/utils/context"
+)
+
+// startRelay starts a relay with the default configuration and its event
+// store in a temporary directory, and returns a client connected to it.
+func startRelay(t *testing.T) (cl *ws.Client) {
+	t.Helper()
+	c, cancel := context.Cancel(context.Bg())
+	t.Cleanup(cancel)
+	s, err := StartEmbedded(c, t.TempDir(), 0, "error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Shutdown)
+	if cl, err = ws.RelayConnect(c, "ws://"+s.Addr); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cl.Close() })
+	return
+}
+
+// newSigner returns a signer with a new key.
+func newSigner(t *testing.T) (sign *p256k.Signer) {
+	t.Helper()
+	sign = &p256k.Signer{}
+	if err := sign.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+// signedBy returns an event of kind k created at createdAt signed by sign.
+func signedBy(
+	t *testing.T, sign *p256k.Signer, k *kind.T, createdAt int64,
+	tt ...*tag.T,
+) (ev *event.E) {
+	t.Helper()
+	ev = &event.E{
+		Kind: k, CreatedAt: timestamp.New(createdAt), Tags: tags.New(tt...),
+		Content: []byte("test"),
+	}
+	if err := ev.Sign(sign); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+// signed returns an event of kind k signed by a new key.
+func signed(t *testing.T, k *kind.T, tt ...*tag.T) (ev *event.E) {
+	t.Helper()
+	return signedBy(t, newSigner(t), k, time.Now().Unix(), tt...)
+}
+
+func TestDeleteOtherAuthorsEvent(t *testing.T) {
+	cl := startRelay(t)
+	c, cancel := context.Timeout(context.Bg(), 5*time.Second)
+	defer cancel()
+	note := signed(t, kind.TextNote)
+	if err := cl.Publish(c, note); err != nil {
+		t.Fatal(err)
+	}
+	del := signed(t, kind.Deletion, tag.New("e", note.IdString()))
+	err := cl.Publish(c, del)
+	if err == nil || !strings.Contains(err.Error(), "blocked") {
+		t.Fatalf("expected the delete to be blocked, got %v", err)
+	}
+	evs, err := cl.QuerySync(
+		c, &filter.F{Authors: tag.New(note.Pubkey)},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evs) != 1 {
+		t.Errorf("expected the note to be kept, got %d events", len(evs))
+	}
+}
+
+func TestRejectedEventReason(t *testing.T) {
+	cl := startRelay(t)
+	c, cancel := context.Timeout(context.Bg(), 5*time.Second)
+	defer cancel()
+	sign := newSigner(t)
+	now := time.Now().Unix()
+	if err := cl.Publish(
+		c, signedBy(t, sign, kind.ProfileMetadata, now),
+	); err != nil {
+		t.Fatal(err)
+	}
+	// the relay has a newer profile, so it rejects the older one, and says
+	// why in the OK.
+	err := cl.Publish(c, signedBy(t, sign, kind.ProfileMetadata, now-10))
+	if err == nil || !strings.Contains(
+		err.Error(), "invalid: not replacing newer replaceable event",
+	) {
+		t.Fatalf("expected the reason of the rejection, got %v", err)
+	}
+}
+
+func TestDuplicateEvent(t *testing.T) {
+	cl := startRelay(t)
+	c, cancel := context.Timeout(context.Bg(), 5*time.Second)
+	defer cancel()
+	note := signed(t, kind.TextNote)
+	// the relay has the event after the first, so both are accepted.
+	for range 2 {
+		if err := cl.Publish(c, note); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -1,23 +1,20 @@
 package relay
 
 import (
+	"errors"
 	"runtime/debug"
-	"time"
+	"sync"
 
+	"orly.dev/pkg/app/relay/spider"
 	"orly.dev/pkg/crypto/ec/schnorr"
-	"orly.dev/pkg/database/indexes/types"
 	"orly.dev/pkg/encoders/event"
 	"orly.dev/pkg/encoders/filter"
 	"orly.dev/pkg/encoders/hex"
 	"orly.dev/pkg/encoders/kinds"
 	"orly.dev/pkg/encoders/tag"
-	"orly.dev/pkg/encoders/timestamp"
-	"orly.dev/pkg/protocol/ws"
+	"orly.dev/pkg/interfaces/store"
 	"orly.dev/pkg/utils/chk"
-	"orly.dev/pkg/utils/context"
-	"orly.dev/pkg/utils/errorf"
 	"orly.dev/pkg/utils/log"
-	"orly.dev/pkg/utils/values"
 )
 
 // IdPkTs is a map of event IDs to their id, pubkey, kind, and timestamp
@@ -30,11 +27,46 @@ type IdPkTs struct {
 	Timestamp int64
 }
 
+// latestKey returns the key of a replaceable or addressable event among the
+// events of its author, the pubkey, kind and d tag, of which only the newest
+// counts, or false for a regular event.
+func latestKey(ev *event.E) (key string, ok bool) {
+	switch {
+	case ev.Kind.IsReplaceable():
+		return string(ev.Pubkey) + string(ev.Kind.Marshal(nil)), true
+	case ev.Kind.IsParameterizedReplaceable():
+		var d string
+		if ev.Tags != nil {
+			d = ev.Tags.GetD()
+		}
+		return string(ev.Pubkey) + string(ev.Kind.Marshal(nil)) + ":" + d,
+			true
+	}
+	return
+}
+
+// pTags returns the valid pubkeys of the p tags of ev.
+func pTags(ev *event.E) (pks [][]byte) {
+	for _, t := range ev.Tags.GetAll(tag.New("p")).ToSliceOfTags() {
+		pkh := t.Value()
+		if len(pkh) != 2*schnorr.PubKeyBytesLen {
+			continue
+		}
+		pk := make([]byte, schnorr.PubKeyBytesLen)
+		if _, err := hex.DecBytes(pk, pkh); err != nil {
+			continue
+		}
+		pks = append(pks, pk)
+	}
+	return
+}
+
 func (s *Server) SpiderFetch(
 	k *kinds.T, noFetch, noExtract bool, pubkeys ...[]byte,
 ) (pks [][]byte, err error) {
-	// Map to store id, pubkey, kind, and timestamp for each event
-	// Key is a combination of pubkey and kind for deduplication
+	cfg := s.Config()
+	// Map to store id, pubkey, kind, and timestamp of the newest replaceable
+	// and addressable events, see latestKey
 	pkKindMap := make(map[string]*IdPkTs)
 	// Map to collect pubkeys from p tags
 	pkMap := make(map[string]struct{})
@@ -67,146 +99,112 @@ func (s *Server) SpiderFetch(
 
 	// Process local events
 	for _, ev := range localEvents {
-		// Create a key based on pubkey and kind for deduplication
-		pkKindKey := string(ev.Pubkey) + string(ev.Kind.Marshal(nil))
-
-		// Check if we already have an event with this pubkey and kind
-		existing, exists := pkKindMap[pkKindKey]
-
-		// If it doesn't exist or the new event is newer, store it
-		if !exists || ev.CreatedAtInt64() > existing.Timestamp {
-			pkKindMap[pkKindKey] = &IdPkTs{
+		if key, ok := latestKey(ev); ok {
+			// If it is not newer than the one we have, skip it
+			if existing, exists := pkKindMap[key]; exists &&
+				ev.CreatedAtInt64() <= existing.Timestamp {
+				continue
+			}
+			pkKindMap[key] = &IdPkTs{
 				Id:        ev.ID,
 				Pubkey:    ev.Pubkey,
 				Kind:      ev.Kind.ToU16(),
 				Timestamp: ev.CreatedAtInt64(),
 			}
-
-			// Extract p tags if not in noExtract mode
-			if !noExtract {
-				t := ev.Tags.GetAll(tag.New("p"))
-				for _, tt := range t.ToSliceOfTags() {
-					pkh := tt.Value()
-					if len(pkh) != 2*schnorr.PubKeyBytesLen {
-						continue
-					}
-					pk := make([]byte, schnorr.PubKeyBytesLen)
-					if _, err = hex.DecBytes(pk, pkh); err != nil {
-						err = nil
-						continue
-					}
-					pkMap[string(pk)] = struct{}{}
-				}
+		}
+		// Extract p tags if not in noExtract mode
+		if !noExtract {
+			for _, pk := range pTags(ev) {
+				pkMap[string(pk)] = struct{}{}
 			}
 		}
-		// Nil the event to free memory
-		ev = nil
 	}
-	log.I.F("%d events found of type %s", len(pkKindMap), kindsList)
-	if !noFetch && len(s.C.SpiderSeeds) > 0 {
-		// we need to search the spider seeds.
-		// Break up pubkeys into batches of 128
-		for i := 0; i < len(pubkeys); i += 128 {
-			end := i + 128
-			if end > len(pubkeys) {
-				end = len(pubkeys)
+	log.I.F("%d events found of type %s", len(localEvents), kindsList)
+	if !noFetch && len(cfg.SpiderSeeds) > 0 {
+		// the seeds answer from several goroutines at once, so the maps are
+		// guarded, while events are verified and saved concurrently.
+		var mx sync.Mutex
+		save := func(ev *event.E) (saved bool) {
+			// only the newest of a replaceable or addressable event is kept,
+			// while regular events are told apart by their id.
+			key, latest := latestKey(ev)
+			newer := func() bool {
+				if !latest {
+					return true
+				}
+				existing, exists := pkKindMap[key]
+				return !exists || ev.CreatedAtInt64() > existing.Timestamp
 			}
-			batchPubkeys := pubkeys[i:end]
-			log.I.F(
-				"processing batch %d to %d of %d for kind %s",
-				i, end, len(pubkeys), kindsList,
-			)
-			batchPkList := tag.New(batchPubkeys...)
-			lim := uint(batchPkList.Len())
-			l := &lim
-			var since *timestamp.T
-			if k == nil {
-				since = timestamp.FromTime(time.Now().Add(-1 * s.C.SpiderTime * 3 / 2))
-			} else {
-				l = values.ToUintPointer(512)
+			mx.Lock()
+			ok := newer()
+			mx.Unlock()
+			if !ok {
+				return
 			}
-			batchFilter := &filter.F{
-				Kinds:   k,
-				Authors: batchPkList,
-				Since:   since,
-				Limit:   l,
+			if ser, err := s.Storage().GetSerialById(ev.ID); err == nil &&
+				ser != nil {
+				// we have it, or another seed sent it already
+				return
 			}
-			for _, seed := range s.C.SpiderSeeds {
-				select {
-				case <-s.Ctx.Done():
-					return
-				default:
-				}
-				var evss event.S
-				var cli *ws.Client
-				if cli, err = ws.RelayConnect(
-					context.Bg(), seed,
-				); chk.E(err) {
-					err = nil
-					continue
-				}
-				if evss, err = cli.QuerySync(
-					context.Bg(), batchFilter,
-				); chk.E(err) {
-					err = nil
-					return
+			// verify the signature
+			if valid, err := ev.Verify(); chk.E(err) || !valid {
+				return
+			}
+			// Save the event to the database
+			if _, _, err := s.Storage().SaveEvent(
+				s.Ctx, ev, true, nil,
+			); err != nil {
+				if !errors.Is(err, store.ErrDupEvent) {
+					log.E.F("failed to save %s: %v", ev.IdString(), err)
 				}
-				// Process each event immediately
-				for i, ev := range evss {
-					// log.I.S(ev)
-					// Create a key based on pubkey and kind for deduplication
-					pkKindKey := string(ev.Pubkey) + string(ev.Kind.Marshal(nil))
-					// Check if we already have an event with this pubkey and kind
-					existing, exists := pkKindMap[pkKindKey]
-					// If it doesn't exist or the new event is newer, store it and save to database
-					if !exists || ev.CreatedAtInt64() > existing.Timestamp {
-						var ser *types.Uint40
-						if ser, err = s.Storage().GetSerialById(ev.ID); err == nil && ser != nil {
-							err = errorf.E("event already exists: %0x", ev.ID)
-							return
-						} else {
-							// verify the signature
-							var valid bool
-							if valid, err = ev.Verify(); chk.E(err) || !valid {
-								continue
-							}
-						}
-						// Save the event to the database
-						if _, _, err = s.Storage().SaveEvent(
-							s.Ctx, ev, true, nil,
-						); chk.E(err) {
-							err = nil
-							continue
-						}
-						// Store the essential information
-						pkKindMap[pkKindKey] = &IdPkTs{
-							Id:        ev.ID,
-							Pubkey:    ev.Pubkey,
-							Kind:      ev.Kind.ToU16(),
-							Timestamp: ev.CreatedAtInt64(),
-						}
-						// Extract p tags if not in noExtract mode
-						if !noExtract {
-							t := ev.Tags.GetAll(tag.New("p"))
-							for _, tt := range t.ToSliceOfTags() {
-								pkh := tt.Value()
-								if len(pkh) != 2*schnorr.PubKeyBytesLen {
-									continue
-								}
-								pk := make([]byte, schnorr.PubKeyBytesLen)
-								if _, err = hex.DecBytes(pk, pkh); err != nil {
-									err = nil
-									continue
-								}
-								pkMap[string(pk)] = struct{}{}
-							}
-						}
-					}
-					// Nil the event in the slice to free memory
-					evss[i] = nil
+				return
+			}
+			// Extract p tags if not in noExtract mode
+			var tagged [][]byte
+			if !noExtract {
+				tagged = pTags(ev)
+			}
+			mx.Lock()
+			defer mx.Unlock()
+			// another seed may have sent a newer one in the meantime.
+			if latest && newer() {
+				// Store the essential information
+				pkKindMap[key] = &IdPkTs{
+					Id:        ev.ID,
+					Pubkey:    ev.Pubkey,
+					Kind:      ev.Kind.ToU16(),
+					Timestamp: ev.CreatedAtInt64(),
 				}
 			}
+			for _, pk := range tagged {
+				pkMap[string(pk)] = struct{}{}
+			}
+			return true
+		}
+		var fetched spider.Fetch
+		if fetched, err = s.spider.Fetch(
+			s.Ctx, &spider.Query{
+				Seeds:       cfg.SpiderSeeds,
+				Kinds:       k,
+				Pubkeys:     pubkeys,
+				Window:      cfg.SpiderTime * 3 / 2,
+				Concurrency: cfg.SpiderConcurrency,
+				Timeout:     cfg.SpiderTimeout,
+				Save:        save,
+			},
+		); err != nil {
+			if s.Ctx.Err() != nil {
+				return
+			}
+			// what the seeds did send is still used, and the failures are in
+			// the spider status.
+			log.W.Ln(err)
+			err = nil
 		}
+		log.I.F(
+			"spider fetched %d events of kinds %s from the seeds, %d new",
+			fetched.Events, kindsList, fetched.Saved,
+		)
 	}
 	chk.E(s.Storage().Sync())
 	debug.FreeOSMemory()
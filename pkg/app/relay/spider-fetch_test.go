@@ -0,0 +1,71 @@
+package relay
+
+import (
+	"testing"
+	"time"
+
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/protocol/ws"
+	"orly.dev/pkg/utils/context"
+)
+
+func TestSpiderFetchOlderEvents(t *testing.T) {
+	c, cancel := context.Timeout(context.Bg(), 30*time.Second)
+	defer cancel()
+	seed, err := StartEmbedded(c, t.TempDir(), 0, "error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(seed.Shutdown)
+	cl, err := ws.RelayConnect(c, "ws://"+seed.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	author := newSigner(t)
+	now := time.Now().Unix()
+	var evs event.S
+	for i := range 4 {
+		evs = append(
+			evs, signedBy(t, author, kind.TextNote, now-10*int64(i+1)),
+		)
+	}
+	evs = append(
+		evs, signedBy(t, author, kind.ProfileMetadata, now-100),
+		signedBy(t, author, kind.FollowSets, now-110, tag.New("d", "a")),
+		signedBy(t, author, kind.FollowSets, now-120, tag.New("d", "b")),
+	)
+	for _, ev := range evs {
+		if err = cl.Publish(c, ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := StartEmbedded(c, t.TempDir(), 0, "error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Shutdown)
+	s.C.SpiderSeeds = []string{"ws://" + seed.Addr}
+	// the newest note was fetched before, and the older notes are still
+	// fetched, a page at a time as the limit of a fetch of all kinds is the
+	// number of pubkeys.
+	if _, _, err = s.Storage().SaveEvent(c, evs[0], false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.SpiderFetch(nil, false, true, author.Pub()); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Storage().QueryEvents(
+		c, &filter.F{Authors: tag.New(author.Pub())},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(evs) {
+		t.Fatalf("expected %d events to be fetched, got %d", len(evs), len(got))
+	}
+}
@@ -1,17 +1,20 @@
 package relay
 
 import (
+	"orly.dev/pkg/app/relay/spider"
+	"orly.dev/pkg/app/relay/wot"
 	"orly.dev/pkg/encoders/kind"
 	"orly.dev/pkg/encoders/kinds"
-	"orly.dev/pkg/utils"
 	"orly.dev/pkg/utils/chk"
 	"orly.dev/pkg/utils/keys"
 	"orly.dev/pkg/utils/log"
+	"time"
 )
 
 func (s *Server) Spider(noFetch ...bool) (err error) {
+	cfg := s.Config()
 	var ownersPubkeys [][]byte
-	for _, v := range s.C.Owners {
+	for _, v := range cfg.Owners {
 		var pk []byte
 		if pk, err = keys.DecodeNpubOrHex(v); chk.E(err) {
 			continue
@@ -24,109 +27,104 @@ func (s *Server) Spider(noFetch ...bool) (err error) {
 		return
 	}
 	go func() {
+		var err error
+		start := s.spiderState.start()
+		defer func() { s.spiderState.end(start, err) }()
 		dontFetch := false
 		if len(noFetch) > 0 && noFetch[0] {
 			dontFetch = true
 		}
-		log.I.F("getting ownersFollowed")
-		var ownersFollowed [][]byte
-		if ownersFollowed, err = s.SpiderFetch(
-			kinds.New(kind.FollowList), dontFetch, false, ownersPubkeys...,
-		); chk.E(err) {
-			return
+		// the web of trust is built anew, a level at a time, fetching the
+		// lists of each level from the seeds before reading them from the
+		// event store, and replaces the one in use once it is complete.
+		g := wot.New(s.wotConfig())
+		g.SetOwners(ownersPubkeys)
+		levels := max(cfg.WoTDepth, 1)
+		if cfg.WoTMuteThreshold > 0 {
+			levels = max(levels, 2)
 		}
-		// log.I.S(ownersFollowed)
-		log.I.F("getting followedFollows")
-		var followedFollows [][]byte
-		if followedFollows, err = s.SpiderFetch(
-			kinds.New(kind.FollowList), dontFetch, false, ownersFollowed...,
-		); chk.E(err) {
-			return
-		}
-		log.I.F("getting ownersMuted")
-		var ownersMuted [][]byte
-		if ownersMuted, err = s.SpiderFetch(
-			kinds.New(kind.MuteList), dontFetch, false, ownersPubkeys...,
-		); chk.E(err) {
-			return
-		}
-		// remove the ownersFollowed and ownersMuted items from the followedFollows
-		// list
-		filteredFollows := make([][]byte, 0, len(followedFollows))
-		for _, follow := range followedFollows {
-			for _, owner := range ownersFollowed {
-				if utils.FastEqual(follow, owner) {
-					break
-				}
+		for d := 0; d < levels; d++ {
+			frontier := g.Frontier(d)
+			if len(frontier) == 0 {
+				break
+			}
+			k := kinds.New()
+			if d < cfg.WoTDepth {
+				k.K = append(k.K, kind.FollowList)
+			}
+			if d == 0 || d == 1 && cfg.WoTMuteThreshold > 0 {
+				k.K = append(k.K, kind.MuteList)
+			}
+			if k.Len() == 0 {
+				break
 			}
-			for _, owner := range ownersMuted {
-				if utils.FastEqual(follow, owner) {
-					break
+			log.I.F(
+				"getting the lists of %d pubkeys at depth %d", len(frontier), d,
+			)
+			if !dontFetch {
+				if _, err = s.SpiderFetch(
+					k, false, true, frontier...,
+				); chk.E(err) {
+					return
 				}
 			}
-			filteredFollows = append(filteredFollows, follow)
-		}
-		followedFollows = filteredFollows
-		own := "owner"
-		if len(ownersPubkeys) > 1 {
-			own = "owners"
-		}
-		fol := "pubkey"
-		if len(ownersFollowed) > 1 {
-			fol = "pubkeys"
-		}
-		folfol := "pubkey"
-		if len(followedFollows) > 1 {
-			folfol = "pubkeys"
-		}
-		mut := "pubkey"
-		if len(ownersMuted) > 1 {
-			mut = "pubkeys"
+			if err = g.Load(s.Ctx, s.Storage(), frontier); chk.E(err) {
+				return
+			}
 		}
+		s.wot.Store(g)
+		s.SetOwnersPubkeys(ownersPubkeys)
+		s.publishWoT(g)
+		ownersFollowed, followedFollows := s.OwnersFollowed(), s.FollowedFollows()
 		log.T.F(
-			"found %d %s with a total of %d followed %s and %d followed's follows %s, and excluding %d owner muted %s",
-			len(ownersPubkeys), own,
-			len(ownersFollowed), fol,
-			len(followedFollows), folfol,
-			len(ownersMuted), mut,
+			"found %d owners with a total of %d followed pubkeys and %d followed's follows pubkeys, and excluding %d muted pubkeys",
+			len(ownersPubkeys), len(ownersFollowed), len(followedFollows),
+			s.LenOwnersMuted(),
 		)
-		// add the owners to the ownersFollowed
-		ownersFollowed = append(ownersFollowed, ownersPubkeys...)
-		s.SetOwnersPubkeys(ownersPubkeys)
-		s.SetOwnersFollowed(ownersFollowed)
-		s.SetFollowedFollows(followedFollows)
-		s.SetOwnersMuted(ownersMuted)
 		// lastly, update all followed users new events in the background
-		if !dontFetch && s.C.SpiderType != "none" {
+		if !dontFetch && cfg.SpiderType != "none" {
 			go func() {
+				var err error
+				start := time.Now()
+				defer func() {
+					result := "ok"
+					if err != nil {
+						result = "error"
+					}
+					spiderRuns.Since(start, "events", result)
+				}()
 				var k *kinds.T
-				if s.C.SpiderType == "directory" {
+				if cfg.SpiderType == "directory" {
 					k = kinds.New(
 						kind.ProfileMetadata, kind.RelayListMetadata,
 						kind.DMRelaysList, kind.MuteList,
 					)
 				}
 				everyone := ownersFollowed
-				if s.C.SpiderSecondDegree &&
-					(s.C.SpiderType == "follows" ||
-						s.C.SpiderType == "directory") {
+				if cfg.SpiderSecondDegree &&
+					(cfg.SpiderType == "follows" ||
+						cfg.SpiderType == "directory") {
 					everyone = append(ownersFollowed, followedFollows...)
 				}
-				_, _ = s.SpiderFetch(
+				if _, e := s.SpiderFetch(
 					k, false, true, everyone...,
-				)
+				); e != nil {
+					err = e
+				}
 				// get the directory events also for second degree if spider
 				// type is directory but second degree is disabled, so all
 				// directory data is available for all whitelisted users.
-				if !s.C.SpiderSecondDegree && s.C.SpiderType == "directory" {
+				if !cfg.SpiderSecondDegree && cfg.SpiderType == "directory" {
 					k = kinds.New(
 						kind.ProfileMetadata, kind.RelayListMetadata,
 						kind.DMRelaysList, kind.MuteList,
 					)
 					everyone = append(ownersFollowed, followedFollows...)
-					_, _ = s.SpiderFetch(
+					if _, e := s.SpiderFetch(
 						k, false, true, everyone...,
-					)
+					); e != nil {
+						err = e
+					}
 
 				}
 			}()
@@ -134,3 +132,22 @@ func (s *Server) Spider(noFetch ...bool) (err error) {
 	}()
 	return
 }
+
+// SpiderStatus returns the state of the last spider run, the health of the
+// seeds and the outcome of the most recent fetches from them.
+func (s *Server) SpiderStatus() (st *spider.Status) {
+	s.spiderState.Lock()
+	st = &spider.Status{
+		Running:    s.spiderState.running,
+		LastRun:    s.spiderState.lastRun,
+		DurationMs: s.spiderState.duration.Milliseconds(),
+	}
+	if s.spiderState.err != nil {
+		st.Error = s.spiderState.err.Error()
+	}
+	s.spiderState.Unlock()
+	if s.spider != nil {
+		st.Seeds, st.Fetches = s.spider.Status()
+	}
+	return
+}
@@ -0,0 +1,136 @@
+package spider
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// backoff is how long a seed is left alone after its first failure to
+	// answer a query, doubling with each further failure in a row.
+	backoff = 30 * time.Second
+	// maxBackoff is the longest a seed is left alone.
+	maxBackoff = 30 * time.Minute
+	// weight is how much the latest query counts in the moving averages of
+	// the score and latency of a seed.
+	weight = 0.2
+)
+
+// Seed is the health of a seed relay.
+type Seed struct {
+	URL       string `json:"url"`
+	Successes int    `json:"successes"`
+	Failures  int    `json:"failures"`
+	// Consecutive is the number of failures since the last success.
+	Consecutive int `json:"consecutive_failures"`
+	// LatencyMs is the moving average of the time the seed takes to answer a
+	// query.
+	LatencyMs int64 `json:"latency_ms"`
+	// Score is the moving average of the success of the queries to the seed,
+	// from 0 when they all fail to 1 when they all succeed.
+	Score     float64 `json:"score"`
+	LastError string  `json:"last_error,omitempty"`
+	// LastSuccess and BackoffUntil are unix seconds, 0 for never.
+	LastSuccess  int64 `json:"last_success,omitempty"`
+	BackoffUntil int64 `json:"backoff_until,omitempty"`
+}
+
+// Fetch is the outcome of a Fetch.
+type Fetch struct {
+	Kinds   string `json:"kinds"`
+	Pubkeys int    `json:"pubkeys"`
+	Batches int    `json:"batches"`
+	// Failed is the number of batches that no seed answered.
+	Failed int `json:"failed_batches"`
+	// Events is the number of events received from all seeds, and Saved the
+	// number of them that were new.
+	Events     int       `json:"events"`
+	Saved      int       `json:"saved"`
+	Started    time.Time `json:"started"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+// Status is the state of the spider runs of a relay, with the health of the
+// seeds and the most recent fetches, the latest first.
+type Status struct {
+	Running bool `json:"running"`
+	// LastRun is when the last run of the lists of the owners started, and
+	// DurationMs how long it took.
+	LastRun    time.Time `json:"last_run"`
+	DurationMs int64     `json:"duration_ms"`
+	Error      string    `json:"error,omitempty"`
+	Seeds      []Seed    `json:"seeds"`
+	Fetches    []Fetch   `json:"fetches"`
+}
+
+// seed returns the health of the seed at url, adding it if it is new.
+func (sp *S) seed(url string) (sd *Seed) {
+	var ok bool
+	if sd, ok = sp.seeds[url]; !ok {
+		sd = &Seed{URL: url, Score: 1}
+		sp.seeds[url] = sd
+	}
+	return
+}
+
+// ready returns the seeds that are not backed off at now, the best scored
+// first.
+func (sp *S) ready(seeds []string, now time.Time) (urls []string) {
+	sp.Lock()
+	defer sp.Unlock()
+	score := make(map[string]float64)
+	for _, url := range seeds {
+		if url == "" {
+			continue
+		}
+		if _, ok := score[url]; ok {
+			continue
+		}
+		sd := sp.seed(url)
+		if sd.BackoffUntil > now.Unix() {
+			continue
+		}
+		score[url] = sd.Score
+		urls = append(urls, url)
+	}
+	sort.SliceStable(
+		urls, func(i, j int) bool { return score[urls[i]] > score[urls[j]] },
+	)
+	return
+}
+
+// result records the outcome of a query to the seed at url that took
+// latency, and backs it off if it failed.
+func (sp *S) result(url string, latency time.Duration, err error) {
+	sp.Lock()
+	defer sp.Unlock()
+	sd := sp.seed(url)
+	if err != nil {
+		sd.Failures++
+		sd.Consecutive++
+		sd.Score *= 1 - weight
+		sd.LastError = err.Error()
+		wait := time.Duration(
+			min(
+				float64(backoff)*math.Pow(2, float64(sd.Consecutive-1)),
+				float64(maxBackoff),
+			),
+		)
+		sd.BackoffUntil = time.Now().Add(wait).Unix()
+		return
+	}
+	ms := latency.Milliseconds()
+	if sd.Successes == 0 {
+		sd.LatencyMs = ms
+	} else {
+		sd.LatencyMs = int64(
+			(1-weight)*float64(sd.LatencyMs) + weight*float64(ms),
+		)
+	}
+	sd.Successes++
+	sd.Consecutive = 0
+	sd.Score = (1-weight)*sd.Score + weight
+	sd.LastSuccess = time.Now().Unix()
+	sd.BackoffUntil = 0
+}
@@ -0,0 +1,402 @@
+// Package spider fetches the events of lists of pubkeys from a set of seed
+// relays, in parallel, keeping a health score for each seed and a cursor for
+// each pubkey and kind so that each run only asks for what is newer than the
+// last.
+package spider
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/filters"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/protocol/ws"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/errorf"
+	"orly.dev/pkg/utils/log"
+	"orly.dev/pkg/utils/values"
+)
+
+// BatchSize is the number of pubkeys in the filter of each query to a seed.
+const BatchSize = 128
+
+// maxFetches is the number of the most recent fetches kept for Status.
+const maxFetches = 16
+
+// Cursors stores when the events of each kind of each pubkey were last
+// fetched, which database.D implements. The kind is a kind number, or "*" for
+// a fetch of all kinds.
+type Cursors interface {
+	SpiderCursors(pubkeys [][]byte, k string) (cursors map[string]int64, err error)
+	SetSpiderCursors(pubkeys [][]byte, k string, t int64) (err error)
+}
+
+// Query is what a Fetch asks the seeds for.
+type Query struct {
+	// Seeds are the urls of the relays to ask.
+	Seeds []string
+	// Kinds are the kinds of the events, or nil for all kinds.
+	Kinds *kinds.T
+	// Pubkeys are the authors of the events.
+	Pubkeys [][]byte
+	// Window is how far back the events of all kinds of pubkeys that were
+	// never fetched are asked for. With Kinds, all of their events are.
+	Window time.Duration
+	// Concurrency is the number of queries to seeds that run at once, 1 if it
+	// is less.
+	Concurrency int
+	// Timeout is how long a query to a seed may take to reach its EOSE before
+	// it counts as a failure, 30s if it is 0.
+	Timeout time.Duration
+	// Save is called with each event that is fetched, from any number of
+	// goroutines at once, and returns whether it was new and was saved.
+	Save func(ev *event.E) (saved bool)
+}
+
+// S is a spider, which keeps its connections to the seeds open between
+// fetches.
+type S struct {
+	pool    *ws.SimplePool
+	cursors Cursors
+	sync.Mutex
+	seeds   map[string]*Seed
+	fetches []Fetch
+}
+
+// New creates a spider that lives as long as c, storing its cursors in
+// cursors, which may be nil to fetch everything on every run.
+//
+// Seeds that fail to connect are left alone for a while by the penalty box of
+// the pool, and seeds that fail to answer a query are backed off by the
+// spider.
+func New(c context.T, cursors Cursors) (sp *S) {
+	sp = &S{
+		pool:    ws.NewSimplePool(c, ws.WithPenaltyBox()),
+		cursors: cursors,
+		seeds:   make(map[string]*Seed),
+	}
+	return
+}
+
+// batch is the pubkeys of one filter of a fetch.
+type batch struct {
+	pubkeys [][]byte
+	since   *timestamp.T
+	ok      atomic.Bool
+}
+
+// kindKeys returns the cursor kinds of k.
+func kindKeys(k *kinds.T) (keys []string) {
+	if k == nil || k.Len() == 0 {
+		return []string{"*"}
+	}
+	for _, kk := range k.K {
+		keys = append(keys, strconv.Itoa(int(kk.K)))
+	}
+	return
+}
+
+// kindNames returns the names of the kinds of k for logs and the status.
+func kindNames(k *kinds.T) string {
+	if k == nil || k.Len() == 0 {
+		return "*"
+	}
+	names := make([]string, 0, k.Len())
+	for _, kk := range k.K {
+		names = append(names, kk.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+// batches splits the pubkeys of q into the batches of a fetch at now.
+//
+// Pubkeys that have a cursor for every kind are asked for the events since
+// the oldest cursor of their batch, and the others for all of their events,
+// or those within the window when no kinds are given.
+func (sp *S) batches(q *Query, keys []string, now time.Time) (bb []*batch) {
+	oldest := make(map[string]int64)
+	if sp.cursors != nil {
+		for i, k := range keys {
+			cursors, err := sp.cursors.SpiderCursors(q.Pubkeys, k)
+			if chk.E(err) {
+				oldest = make(map[string]int64)
+				break
+			}
+			for _, pk := range q.Pubkeys {
+				t, ok := cursors[string(pk)]
+				if !ok {
+					delete(oldest, string(pk))
+					continue
+				}
+				if prev, ok := oldest[string(pk)]; i == 0 || ok && t < prev {
+					oldest[string(pk)] = t
+				}
+			}
+		}
+	}
+	var known, unknown [][]byte
+	for _, pk := range q.Pubkeys {
+		if _, ok := oldest[string(pk)]; ok {
+			known = append(known, pk)
+		} else {
+			unknown = append(unknown, pk)
+		}
+	}
+	// the pubkeys fetched longest ago go together, so the since of a batch is
+	// as late as it can be.
+	sort.SliceStable(
+		known, func(i, j int) bool {
+			return oldest[string(known[i])] < oldest[string(known[j])]
+		},
+	)
+	for i := 0; i < len(known); i += BatchSize {
+		pks := known[i:min(i+BatchSize, len(known))]
+		bb = append(
+			bb, &batch{
+				pubkeys: pks, since: timestamp.New(oldest[string(pks[0])]),
+			},
+		)
+	}
+	var since *timestamp.T
+	if q.Kinds == nil && q.Window > 0 {
+		since = timestamp.FromTime(now.Add(-q.Window))
+	}
+	for i := 0; i < len(unknown); i += BatchSize {
+		bb = append(
+			bb, &batch{
+				pubkeys: unknown[i:min(i+BatchSize, len(unknown))],
+				since:   since,
+			},
+		)
+	}
+	return
+}
+
+// Fetch asks the seeds that aren't backed off for the events of q, in
+// batches of BatchSize pubkeys, with up to q.Concurrency queries at once.
+//
+// The cursors of the pubkeys of a batch are moved to the start of the fetch
+// once at least one seed has answered the whole query of the batch, paging
+// back until it sends fewer events than the limit, as in query.
+//
+// # Parameters
+//
+// - c (context.T): ends the fetch early when it is done.
+//
+// - q (*Query): the seeds, kinds and pubkeys to fetch, and what to do with
+// the events.
+//
+// # Return Values
+//
+// - f (Fetch): the outcome of the fetch, which Status also reports.
+//
+// - err (error): an error if no seed could be asked, or if there were batches
+// that no seed answered.
+func (sp *S) Fetch(c context.T, q *Query) (f Fetch, err error) {
+	now := time.Now()
+	keys := kindKeys(q.Kinds)
+	f = Fetch{Kinds: kindNames(q.Kinds), Pubkeys: len(q.Pubkeys), Started: now}
+	defer func() {
+		f.DurationMs = time.Since(now).Milliseconds()
+		sp.record(f)
+	}()
+	seeds := sp.ready(q.Seeds, now)
+	if len(seeds) == 0 {
+		err = errorf.E("spider: all %d seeds are backed off", len(q.Seeds))
+		return
+	}
+	bb := sp.batches(q, keys, now)
+	f.Batches = len(bb)
+	timeout := q.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	var events, saved atomic.Int64
+	sem := make(chan struct{}, max(q.Concurrency, 1))
+	var wg sync.WaitGroup
+jobs:
+	for i, b := range bb {
+		lim := uint(len(b.pubkeys))
+		l := &lim
+		if q.Kinds != nil {
+			l = values.ToUintPointer(512)
+		}
+		fl := &filter.F{
+			Kinds:   q.Kinds,
+			Authors: tag.New(b.pubkeys...),
+			Since:   b.since,
+			Limit:   l,
+		}
+		log.I.F(
+			"spider: batch %d of %d with %d pubkeys for kinds %s",
+			i+1, len(bb), len(b.pubkeys), f.Kinds,
+		)
+		for _, seed := range seeds {
+			select {
+			case <-c.Done():
+				break jobs
+			case sem <- struct{}{}:
+			}
+			wg.Add(1)
+			go func(b *batch, seed string) {
+				defer func() { <-sem; wg.Done() }()
+				start := time.Now()
+				n, s, err := sp.query(c, seed, fl, timeout, q.Save)
+				events.Add(int64(n))
+				saved.Add(int64(s))
+				if c.Err() != nil {
+					// the fetch was cancelled, which says nothing about the
+					// seed.
+					return
+				}
+				sp.result(seed, time.Since(start), err)
+				if err != nil {
+					log.D.F("spider: %s: %v", seed, err)
+					return
+				}
+				b.ok.Store(true)
+			}(b, seed)
+		}
+	}
+	wg.Wait()
+	f.Events, f.Saved = int(events.Load()), int(saved.Load())
+	for _, b := range bb {
+		if !b.ok.Load() {
+			f.Failed++
+			continue
+		}
+		if sp.cursors == nil {
+			continue
+		}
+		for _, k := range keys {
+			chk.E(sp.cursors.SetSpiderCursors(b.pubkeys, k, now.Unix()))
+		}
+	}
+	if err = c.Err(); err != nil {
+		return
+	}
+	if f.Failed > 0 {
+		err = errorf.E(
+			"spider: %d of %d batches for kinds %s were not answered by any seed",
+			f.Failed, f.Batches, f.Kinds,
+		)
+	}
+	return
+}
+
+// query asks one seed for the events of fl, returning the number of events
+// that were received and saved, and an error if the seed failed to answer
+// with an EOSE within the timeout.
+//
+// While the seed sends as many events as the limit of fl, the events older
+// than the oldest it sent are asked for with until, so a fetch that reaches
+// the limit doesn't skip the events before it.
+func (sp *S) query(
+	c context.T, seed string, fl *filter.F, timeout time.Duration,
+	save func(ev *event.E) bool,
+) (events, saved int, err error) {
+	var cl *ws.Client
+	if cl, err = sp.pool.EnsureRelay(seed); err != nil {
+		return
+	}
+	// a shallow copy, as only the until is replaced.
+	pf := *fl
+	for {
+		var n, s int
+		var oldest int64
+		n, s, oldest, err = sp.page(c, cl, seed, &pf, timeout, save)
+		events += n
+		saved += s
+		if err != nil || pf.Limit == nil || n < int(*pf.Limit) {
+			return
+		}
+		// the events of the second of the oldest may not all have been sent,
+		// so it is asked for again, unless the whole page was of that second,
+		// when until can't tell its other events apart and the paging moves
+		// past it.
+		until := oldest
+		if pf.Until != nil && until >= pf.Until.I64() {
+			until = pf.Until.I64() - 1
+		}
+		if pf.Since != nil && until < pf.Since.I64() {
+			return
+		}
+		pf.Until = timestamp.New(until)
+	}
+}
+
+// page asks a seed for one page of the events of fl, returning the number of
+// events that were received and saved, and the created_at of the oldest.
+func (sp *S) page(
+	c context.T, cl *ws.Client, seed string, fl *filter.F,
+	timeout time.Duration, save func(ev *event.E) bool,
+) (events, saved int, oldest int64, err error) {
+	ctx, cancel := context.TimeoutCause(
+		c, timeout, errors.New("spider query took too long"),
+	)
+	defer cancel()
+	var sub *ws.Subscription
+	if sub, err = cl.Subscribe(ctx, filters.New(fl)); err != nil {
+		return
+	}
+	defer sub.Unsub()
+	for {
+		select {
+		case <-ctx.Done():
+			err = context.GetCause(ctx)
+			return
+		case <-sub.EndOfStoredEvents:
+			return
+		case reason := <-sub.ClosedReason:
+			err = errorf.E("closed: %s", reason)
+			return
+		case ev, more := <-sub.Events:
+			if !more {
+				err = errorf.E("connection to %s closed", seed)
+				return
+			}
+			if events == 0 || ev.CreatedAtInt64() < oldest {
+				oldest = ev.CreatedAtInt64()
+			}
+			events++
+			if save != nil && save(ev) {
+				saved++
+			}
+		}
+	}
+}
+
+// Status returns the health of the seeds, sorted by url, and the most recent
+// fetches, the latest first.
+func (sp *S) Status() (seeds []Seed, fetches []Fetch) {
+	sp.Lock()
+	defer sp.Unlock()
+	for _, sd := range sp.seeds {
+		seeds = append(seeds, *sd)
+	}
+	sort.Slice(seeds, func(i, j int) bool { return seeds[i].URL < seeds[j].URL })
+	for i := len(sp.fetches) - 1; i >= 0; i-- {
+		fetches = append(fetches, sp.fetches[i])
+	}
+	return
+}
+
+// record adds f to the most recent fetches.
+func (sp *S) record(f Fetch) {
+	sp.Lock()
+	defer sp.Unlock()
+	sp.fetches = append(sp.fetches, f)
+	if len(sp.fetches) > maxFetches {
+		sp.fetches = sp.fetches[len(sp.fetches)-maxFetches:]
+	}
+}
@@ -0,0 +1,251 @@
+package spider
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"orly.dev/pkg/crypto/p256k"
+	"orly.dev/pkg/encoders/envelopes"
+	"orly.dev/pkg/encoders/envelopes/eoseenvelope"
+	"orly.dev/pkg/encoders/envelopes/eventenvelope"
+	"orly.dev/pkg/encoders/envelopes/reqenvelope"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/utils/context"
+)
+
+// cursors is a Cursors in memory, keyed by kind and then pubkey.
+type cursors map[string]map[string]int64
+
+func (cc cursors) SpiderCursors(pubkeys [][]byte, k string) (
+	found map[string]int64, err error,
+) {
+	found = make(map[string]int64)
+	for _, pk := range pubkeys {
+		if t, ok := cc[k][string(pk)]; ok {
+			found[string(pk)] = t
+		}
+	}
+	return
+}
+
+func (cc cursors) SetSpiderCursors(pubkeys [][]byte, k string, t int64) (err error) {
+	if cc[k] == nil {
+		cc[k] = make(map[string]int64)
+	}
+	for _, pk := range pubkeys {
+		cc[k][string(pk)] = t
+	}
+	return
+}
+
+func TestBatches(t *testing.T) {
+	c, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	cc := cursors{
+		"0":     {"a": 100, "b": 300, "c": 50},
+		"10002": {"a": 200, "b": 400},
+	}
+	sp := New(c, cc)
+	now := time.Unix(10000, 0)
+
+	q := &Query{
+		Kinds:   kinds.New(kind.ProfileMetadata, kind.RelayListMetadata),
+		Pubkeys: [][]byte{[]byte("c"), []byte("b"), []byte("a")},
+	}
+	bb := sp.batches(q, kindKeys(q.Kinds), now)
+	if len(bb) != 2 {
+		t.Fatalf("expected 2 batches, got %d", len(bb))
+	}
+	// a and b have cursors for both kinds, and the oldest of them is the
+	// since, and c has no relay list so it is fetched in full.
+	if len(bb[0].pubkeys) != 2 || string(bb[0].pubkeys[0]) != "a" ||
+		bb[0].since.I64() != 100 {
+		t.Fatalf("unexpected known batch %q since %v", bb[0].pubkeys, bb[0].since)
+	}
+	if len(bb[1].pubkeys) != 1 || string(bb[1].pubkeys[0]) != "c" ||
+		bb[1].since != nil {
+		t.Fatalf("unexpected new batch %q since %v", bb[1].pubkeys, bb[1].since)
+	}
+
+	// without kinds, pubkeys that were never fetched only get the window.
+	q = &Query{Pubkeys: [][]byte{[]byte("a")}, Window: time.Hour}
+	bb = sp.batches(q, kindKeys(q.Kinds), now)
+	if len(bb) != 1 || bb[0].since.I64() != now.Unix()-3600 {
+		t.Fatalf("unexpected batches of all kinds %v", bb)
+	}
+}
+
+func TestSeedHealth(t *testing.T) {
+	c, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	sp := New(c, nil)
+	now := time.Now()
+	seeds := []string{"wss://a", "wss://b", "wss://c"}
+	if got := sp.ready(seeds, now); len(got) != 3 {
+		t.Fatalf("expected all seeds to be ready, got %v", got)
+	}
+	sp.result("wss://a", time.Second, errors.New("timeout"))
+	sp.result("wss://b", 100*time.Millisecond, errors.New("closed"))
+	sp.result("wss://b", 0, nil)
+	sp.result("wss://c", 200*time.Millisecond, nil)
+
+	// a is backed off, and b has a worse score than c.
+	got := sp.ready(seeds, now)
+	if len(got) != 2 || got[0] != "wss://c" || got[1] != "wss://b" {
+		t.Fatalf("unexpected ready seeds %v", got)
+	}
+	st, _ := sp.Status()
+	if st[0].URL != "wss://a" || st[0].Consecutive != 1 ||
+		st[0].LastError != "timeout" ||
+		st[0].BackoffUntil < now.Add(backoff).Unix() {
+		t.Fatalf("unexpected health of a %+v", st[0])
+	}
+	if st[2].LatencyMs != 200 || st[2].Successes != 1 {
+		t.Fatalf("unexpected health of c %+v", st[2])
+	}
+
+	sp.result("wss://a", time.Second, errors.New("timeout"))
+	if st, _ = sp.Status(); st[0].BackoffUntil < now.Add(2*backoff).Unix() {
+		t.Fatalf("backoff did not double %+v", st[0])
+	}
+	if got = sp.ready(seeds, now.Add(maxBackoff+time.Second)); len(got) != 3 {
+		t.Fatalf("expected the backoff to end, got %v", got)
+	}
+}
+
+// seed is a relay that answers each REQ with the newest of its events that
+// match, up to the limit, and an EOSE, and keeps the filters of the REQs.
+type seed struct {
+	mx     sync.Mutex
+	events event.S
+	reqs   []*filter.F
+}
+
+// newSeed starts a seed with the events evs that is closed when the test
+// ends, and returns it with its websocket URL.
+func newSeed(t *testing.T, evs ...*event.E) (sd *seed, url string) {
+	sd = &seed{events: evs}
+	sort.Slice(
+		sd.events, func(i, j int) bool {
+			return sd.events[i].CreatedAtInt64() > sd.events[j].CreatedAtInt64()
+		},
+	)
+	srv := httptest.NewServer(
+		&websocket.Server{
+			Handshake: func(*websocket.Config, *http.Request) error { return nil },
+			Handler:   sd.serve,
+		},
+	)
+	t.Cleanup(srv.Close)
+	url = "ws" + strings.TrimPrefix(srv.URL, "http")
+	return
+}
+
+func (sd *seed) serve(conn *websocket.Conn) {
+	for {
+		var msg []byte
+		if err := websocket.Message.Receive(conn, &msg); err != nil {
+			return
+		}
+		label, rem, err := envelopes.Identify(msg)
+		if err != nil || label != reqenvelope.L {
+			continue
+		}
+		env := reqenvelope.New()
+		if _, err = env.Unmarshal(rem); err != nil {
+			continue
+		}
+		sd.mx.Lock()
+		for _, f := range env.Filters.F {
+			sd.reqs = append(sd.reqs, f)
+			var n uint
+			for _, ev := range sd.events {
+				if f.Limit != nil && n >= *f.Limit {
+					break
+				}
+				if !f.Matches(ev) {
+					continue
+				}
+				n++
+				res, _ := eventenvelope.NewResultWith(env.Subscription.T, ev)
+				_ = websocket.Message.Send(conn, string(res.Marshal(nil)))
+			}
+		}
+		sd.mx.Unlock()
+		_ = websocket.Message.Send(
+			conn, string(eoseenvelope.NewFrom(env.Subscription).Marshal(nil)),
+		)
+	}
+}
+
+func TestFetchPages(t *testing.T) {
+	c, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	sign := &p256k.Signer{}
+	if err := sign.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Unix()
+	var evs event.S
+	for i := range 5 {
+		ev := &event.E{
+			Kind: kind.TextNote, CreatedAt: timestamp.New(now - 10*int64(i+1)),
+			Tags: tags.New(), Content: []byte("test"),
+		}
+		if err := ev.Sign(sign); err != nil {
+			t.Fatal(err)
+		}
+		evs = append(evs, ev)
+	}
+	sd, url := newSeed(t, evs...)
+	cc := cursors{}
+	sp := New(c, cc)
+	var mx sync.Mutex
+	got := make(map[string]struct{})
+	// without kinds the limit is the number of pubkeys, one, so the seed
+	// sends one event at a time.
+	f, err := sp.Fetch(
+		c, &Query{
+			Seeds: []string{url}, Pubkeys: [][]byte{sign.Pub()},
+			Window: time.Hour, Timeout: 5 * time.Second,
+			Save: func(ev *event.E) bool {
+				mx.Lock()
+				defer mx.Unlock()
+				if _, ok := got[ev.IdString()]; ok {
+					return false
+				}
+				got[ev.IdString()] = struct{}{}
+				return true
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 5 || f.Saved != 5 {
+		t.Fatalf("expected all 5 events, got %d saved %d", len(got), f.Saved)
+	}
+	sd.mx.Lock()
+	reqs := sd.reqs
+	sd.mx.Unlock()
+	// each page but the first asks for what is older than the one before.
+	if len(reqs) < 6 || reqs[0].Until != nil || reqs[1].Until == nil {
+		t.Fatalf("unexpected pages %d", len(reqs))
+	}
+	if cc["*"][string(sign.Pub())] < now {
+		t.Fatalf("the cursor was not moved to the start of the fetch")
+	}
+}
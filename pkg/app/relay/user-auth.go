@@ -18,7 +18,13 @@ func (s *Server) UserAuth(
 	if len(tolerance) > 0 {
 		tolerate = tolerance[0]
 	}
-	if valid, pubkey, err = httpauth.CheckAuth(r, tolerate); chk.E(err) {
+	if valid, pubkey, err = httpauth.Check(
+		r, httpauth.Options{
+			Tolerance: tolerate,
+			RequireMethod: s.Config().NIP98RequireMethod &&
+				httpauth.IsWrite(r),
+		},
+	); chk.E(err) {
 		return
 	}
 	if !valid {
@@ -28,16 +34,14 @@ func (s *Server) UserAuth(
 		)
 		return
 	}
-	for _, pk := range append(s.ownersFollowed, s.followedFollows...) {
-		if utils.FastEqual(pk, pubkey) {
-			authed = true
-			return
-		}
+	if s.IsTrusted(pubkey) {
+		authed = true
+		return
 	}
 	// if the client is one of the relay cluster replicas, also set the super
 	// flag to indicate that privilege checks can be bypassed.
-	if len(s.Peers.Pubkeys) > 0 {
-		for _, pk := range s.Peers.Pubkeys {
+	if _, peers := s.Peers.Replicas(); len(peers) > 0 {
+		for _, pk := range peers {
 			if utils.FastEqual(pk, pubkey) {
 				authed = true
 				super = true
@@ -0,0 +1,130 @@
+package relay
+
+import (
+	"sync"
+	"time"
+
+	"orly.dev/pkg/app/relay/wot"
+	"orly.dev/pkg/database"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/utils/chk"
+)
+
+// wotConfig returns the configuration of the web of trust graph.
+func (s *Server) wotConfig() wot.Config {
+	return wot.Config{
+		Depth:         s.Config().WoTDepth,
+		MinFollows:    s.Config().WoTMinFollows,
+		MuteThreshold: s.Config().WoTMuteThreshold,
+	}
+}
+
+// publishWoT replaces the followed, follows and muted lists with those of the
+// graph g, so that they are used for access control.
+func (s *Server) publishWoT(g *wot.G) {
+	followed, follows, muted := g.Levels()
+	s.SetOwnersFollowed(followed)
+	s.SetFollowedFollows(follows)
+	s.SetOwnersMuted(muted)
+}
+
+// wotDebounce is how long the web of trust waits for more lists after one is
+// saved before it applies them, so a burst of lists is applied at once.
+const wotDebounce = time.Second
+
+// wotUpdates are the follow and mute lists that were saved and are waiting to
+// be applied to the web of trust.
+type wotUpdates struct {
+	sync.Mutex
+	once    sync.Once
+	pending event.S
+	// kick wakes up applyWoT when lists are added.
+	kick chan struct{}
+}
+
+// updateWoT queues a follow or mute list that was just saved to be applied to
+// the web of trust in the background, see applyWoT.
+func (s *Server) updateWoT(ev *event.E) {
+	if !ev.Kind.Equal(kind.FollowList) && !ev.Kind.Equal(kind.MuteList) {
+		return
+	}
+	// the event may be reused once the request is done.
+	cp := event.New()
+	if _, err := cp.Unmarshal(ev.Marshal(nil)); chk.E(err) {
+		return
+	}
+	u := &s.wotUpdates
+	u.once.Do(
+		func() {
+			u.kick = make(chan struct{}, 1)
+			go s.applyWoT()
+		},
+	)
+	u.Lock()
+	u.pending = append(u.pending, cp)
+	u.Unlock()
+	select {
+	case u.kick <- struct{}{}:
+	default:
+	}
+}
+
+// applyWoT applies the lists queued by updateWoT to the web of trust until the
+// server shuts down, waiting wotDebounce after the first of them for more,
+// loading the lists of any users they newly trust from the event store, and
+// updates the lists used for access control if they changed anything.
+func (s *Server) applyWoT() {
+	u := &s.wotUpdates
+	for {
+		select {
+		case <-s.Ctx.Done():
+			return
+		case <-u.kick:
+		}
+		select {
+		case <-s.Ctx.Done():
+			return
+		case <-time.After(wotDebounce):
+		}
+		u.Lock()
+		evs := u.pending
+		u.pending = nil
+		u.Unlock()
+		g := s.wot.Load()
+		if g == nil || !g.Update(evs...) {
+			continue
+		}
+		// each load can trust users a step further out whose lists count
+		// too.
+		for missing := g.Missing(); len(missing) > 0; missing = g.Missing() {
+			if err := g.Load(s.Ctx, s.Storage(), missing); chk.E(err) {
+				break
+			}
+		}
+		s.publishWoT(g)
+	}
+}
+
+// ExplainAccess returns why pk is or isn't allowed to publish to the relay
+// when auth is required: the blacklist, the allowed pubkeys of the management
+// API, and the position of pk in the web of trust of the owners.
+func (s *Server) ExplainAccess(pk []byte) (x *wot.Explanation) {
+	g := s.wot.Load()
+	if g == nil {
+		// there was no spider run yet, so only the owners are known.
+		g = wot.New(s.wotConfig())
+		g.SetOwners(s.OwnersPubkeys())
+	}
+	x = g.Explain(pk)
+	switch {
+	case s.blacklisted(pk):
+		x.Allowed, x.Reason = false, "on the blacklist"
+	case x.Muted:
+		// as in AcceptEvent, a mute overrides the allowed pubkeys list.
+	case s.Management.Has(database.AllowedPubkeys, hex.Enc(pk)):
+		x.Allowed, x.Reason = true, "on the allowed pubkeys list"
+	}
+	return
+}
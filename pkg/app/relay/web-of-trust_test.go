@@ -0,0 +1,69 @@
+package relay
+
+import (
+	"testing"
+	"time"
+
+	"orly.dev/pkg/app/config"
+	"orly.dev/pkg/app/relay/wot"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/utils/context"
+)
+
+// noLists is a store.Querent without any lists.
+type noLists struct{}
+
+func (noLists) QueryEvents(context.T, *filter.F) (evs event.S, err error) {
+	return
+}
+
+func TestUpdateWoT(t *testing.T) {
+	c, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	s := &Server{
+		Ctx: c, C: &config.C{WoTDepth: 1}, Lists: new(Lists),
+	}
+	owner, followed := newSigner(t), newSigner(t)
+	g := wot.New(s.wotConfig())
+	g.SetOwners([][]byte{owner.Pub()})
+	// the owner has no lists yet.
+	if err := g.Load(c, noLists{}, g.Frontier(0)); err != nil {
+		t.Fatal(err)
+	}
+	s.wot.Store(g)
+	s.publishWoT(g)
+
+	// the list is applied in the background, so a client publishing it isn't
+	// held up.
+	start := time.Now()
+	s.updateWoT(
+		signedBy(
+			t, owner, kind.FollowList, time.Now().Unix(),
+			tag.New("p", hex.Enc(followed.Pub())),
+		),
+	)
+	if time.Since(start) >= wotDebounce {
+		t.Fatal("updateWoT waited for the web of trust to be updated")
+	}
+	if len(s.OwnersFollowed()) != 1 {
+		t.Fatal("the list was applied before the debounce")
+	}
+	deadline := time.Now().Add(5 * wotDebounce)
+	for !g.Trusted(followed.Pub()) {
+		if time.Now().After(deadline) {
+			t.Fatal("the follow list of the owner was not applied")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// the lists used for access control follow shortly after the graph.
+	for len(s.OwnersFollowed()) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("the followed list was not updated")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
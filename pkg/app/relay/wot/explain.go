@@ -0,0 +1,128 @@
+package wot
+
+import (
+	"fmt"
+	"sort"
+
+	"orly.dev/pkg/encoders/hex"
+)
+
+// maxListed is the most pubkeys listed in each field of an Explanation.
+const maxListed = 100
+
+// Explanation is why a pubkey is or isn't trusted.
+type Explanation struct {
+	Pubkey  string `json:"pubkey"`
+	Allowed bool   `json:"allowed"`
+	Owner   bool   `json:"owner,omitempty"`
+	// Depth is the number of steps of follows from the owners, or -1 if the
+	// pubkey isn't trusted.
+	Depth int  `json:"depth"`
+	Muted bool `json:"muted,omitempty"`
+	// Followers is the number of trusted pubkeys whose follows count that
+	// follow the pubkey, and FollowedBy the first of them, the closest to
+	// the owners first.
+	Followers  int      `json:"followers"`
+	FollowedBy []string `json:"followed_by,omitempty"`
+	// MutedBy are the trusted pubkeys whose mutes count that mute the
+	// pubkey.
+	MutedBy []string `json:"muted_by,omitempty"`
+	Reason  string   `json:"reason"`
+	Config  Config   `json:"config"`
+}
+
+// Explain returns why pk is or isn't trusted.
+func (g *G) Explain(pk []byte) (x *Explanation) {
+	g.RLock()
+	defer g.RUnlock()
+	key := string(pk)
+	x = &Explanation{Pubkey: hex.Enc(pk), Depth: -1, Config: g.cfg}
+	if d, ok := g.depth[key]; ok {
+		x.Allowed, x.Depth = true, d
+	}
+	_, x.Owner = g.owners[key]
+	_, x.Muted = g.muted[key]
+	// closest is the depth of the closest follower, whose depth decides the
+	// depth of pk.
+	closest, closestFollowers := -1, 0
+	var followedBy []string
+	for f, l := range g.follows {
+		d, trusted := g.depth[f]
+		if !trusted || d >= g.cfg.Depth {
+			continue
+		}
+		if _, ok := l.pubkeys[key]; !ok {
+			continue
+		}
+		x.Followers++
+		followedBy = append(followedBy, f)
+		switch {
+		case closest == -1 || d < closest:
+			closest, closestFollowers = d, 1
+		case d == closest:
+			closestFollowers++
+		}
+	}
+	sort.Slice(
+		followedBy, func(i, j int) bool {
+			di, dj := g.depth[followedBy[i]], g.depth[followedBy[j]]
+			if di != dj {
+				return di < dj
+			}
+			return followedBy[i] < followedBy[j]
+		},
+	)
+	for _, f := range followedBy[:min(len(followedBy), maxListed)] {
+		x.FollowedBy = append(x.FollowedBy, hex.Enc([]byte(f)))
+	}
+	var muters int
+	var ownerMuted bool
+	for m, l := range g.mutes {
+		d, trusted := g.depth[m]
+		if !trusted || d > 1 || d == 1 && g.cfg.MuteThreshold == 0 {
+			continue
+		}
+		if _, ok := l.pubkeys[key]; !ok {
+			continue
+		}
+		if d == 0 {
+			ownerMuted = true
+		} else {
+			muters++
+		}
+		if len(x.MutedBy) < maxListed {
+			x.MutedBy = append(x.MutedBy, hex.Enc([]byte(m)))
+		}
+	}
+	sort.Strings(x.MutedBy)
+	need := max(g.cfg.MinFollows, 1)
+	switch {
+	case x.Owner:
+		x.Reason = "owner of the relay"
+	case x.Allowed && x.Depth == 1:
+		x.Reason = "followed by an owner"
+	case x.Allowed:
+		x.Reason = fmt.Sprintf(
+			"followed by %d trusted users at depth %d, %d are required",
+			closestFollowers, x.Depth-1, need,
+		)
+	case x.Muted && ownerMuted:
+		x.Reason = "muted by an owner"
+	case x.Muted:
+		x.Reason = fmt.Sprintf(
+			"muted by %d of the owners' follows, the threshold is %d",
+			muters, g.cfg.MuteThreshold,
+		)
+	case closest == -1:
+		x.Reason = fmt.Sprintf(
+			"not followed by any trusted user within depth %d of the owners",
+			g.cfg.Depth,
+		)
+	default:
+		x.Reason = fmt.Sprintf(
+			"followed by %d trusted users at depth %d, %d are required",
+			closestFollowers, closest, need,
+		)
+	}
+	return
+}
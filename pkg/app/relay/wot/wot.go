@@ -0,0 +1,323 @@
+// Package wot is the web of trust of a relay: the follow and mute lists of
+// the owners and of the users they trust, and which pubkeys that makes
+// allowed, at what depth, and why.
+package wot
+
+import (
+	"sort"
+	"sync"
+
+	"orly.dev/pkg/crypto/ec/schnorr"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/context"
+)
+
+// batchSize is the number of authors in each query of Load.
+const batchSize = 512
+
+// Config is how the graph decides who is trusted.
+type Config struct {
+	// Depth is how many steps of follows from the owners are trusted, 0 for
+	// only the owners, 1 for the users they follow, 2 for the users those
+	// follow, and so on.
+	Depth int
+	// MinFollows is how many trusted users one step closer to the owners must
+	// follow a pubkey beyond the owners' follows for it to be trusted. Users
+	// followed by an owner need only one follow.
+	MinFollows int
+	// MuteThreshold is how many of the owners' follows must mute a pubkey
+	// beyond the owners' follows for it to be muted, or 0 to only mute the
+	// pubkeys that an owner mutes.
+	MuteThreshold int
+}
+
+// list is the pubkeys of the p tags of a follow or mute list.
+type list struct {
+	createdAt int64
+	pubkeys   map[string]struct{}
+}
+
+// G is a web of trust graph. Membership is a map lookup, and the graph is
+// recomputed whenever the owners, the configuration or a list that counts
+// changes.
+type G struct {
+	sync.RWMutex
+	cfg     Config
+	owners  map[string]struct{}
+	follows map[string]*list
+	mutes   map[string]*list
+	// loaded are the pubkeys whose lists were looked for in the event store,
+	// whether or not they had any.
+	loaded map[string]struct{}
+	// depth is the number of steps of follows from the owners of each
+	// trusted pubkey.
+	depth map[string]int
+	// muted are the pubkeys that the owners, or enough of their follows,
+	// mute.
+	muted map[string]struct{}
+}
+
+// New creates an empty graph with the configuration cfg.
+func New(cfg Config) (g *G) {
+	g = &G{
+		cfg:     cfg,
+		owners:  make(map[string]struct{}),
+		follows: make(map[string]*list),
+		mutes:   make(map[string]*list),
+		loaded:  make(map[string]struct{}),
+	}
+	g.compute()
+	return
+}
+
+// SetOwners replaces the owners, the roots of the graph.
+func (g *G) SetOwners(owners [][]byte) {
+	g.Lock()
+	defer g.Unlock()
+	g.owners = make(map[string]struct{})
+	for _, pk := range owners {
+		g.owners[string(pk)] = struct{}{}
+	}
+	g.compute()
+}
+
+// Configure replaces the configuration of the graph.
+func (g *G) Configure(cfg Config) {
+	g.Lock()
+	defer g.Unlock()
+	g.cfg = cfg
+	g.compute()
+}
+
+// Frontier returns the trusted pubkeys at depth d, whose follow lists make
+// the pubkeys at depth d+1, and whose mute lists count if d is 0, or 1 with a
+// mute threshold.
+func (g *G) Frontier(d int) (pks [][]byte) {
+	g.RLock()
+	defer g.RUnlock()
+	for pk, dd := range g.depth {
+		if dd == d {
+			pks = append(pks, []byte(pk))
+		}
+	}
+	return
+}
+
+// Missing returns the trusted pubkeys whose lists count but were not looked
+// for in the event store yet, such as users that were just followed.
+func (g *G) Missing() (pks [][]byte) {
+	g.RLock()
+	defer g.RUnlock()
+	for pk := range g.depth {
+		if _, ok := g.loaded[pk]; !ok && (g.counts(pk, kind.FollowList) ||
+			g.counts(pk, kind.MuteList)) {
+			pks = append(pks, []byte(pk))
+		}
+	}
+	return
+}
+
+// Load reads the follow and mute lists of the pubkeys from the event store,
+// and recomputes the graph.
+func (g *G) Load(c context.T, q store.Querent, pubkeys [][]byte) (err error) {
+	for i := 0; i < len(pubkeys); i += batchSize {
+		batch := pubkeys[i:min(i+batchSize, len(pubkeys))]
+		var evs event.S
+		if evs, err = q.QueryEvents(
+			c, &filter.F{
+				Kinds:   kinds.New(kind.FollowList, kind.MuteList),
+				Authors: tag.New(batch...),
+			},
+		); err != nil {
+			return
+		}
+		g.Lock()
+		for _, pk := range batch {
+			g.loaded[string(pk)] = struct{}{}
+		}
+		for _, ev := range evs {
+			g.set(ev)
+		}
+		g.Unlock()
+	}
+	g.Lock()
+	g.compute()
+	g.Unlock()
+	return
+}
+
+// Update applies follow or mute lists that were just published, and returns
+// true if they changed the graph, which is then recomputed once. Lists of
+// pubkeys whose lists don't count, and lists that are older than the ones the
+// graph has, are ignored.
+func (g *G) Update(evs ...*event.E) (changed bool) {
+	g.Lock()
+	defer g.Unlock()
+	for _, ev := range evs {
+		if !ev.Kind.Equal(kind.FollowList) && !ev.Kind.Equal(kind.MuteList) {
+			continue
+		}
+		if g.counts(string(ev.Pubkey), ev.Kind) && g.set(ev) {
+			changed = true
+		}
+	}
+	if changed {
+		g.compute()
+	}
+	return
+}
+
+// counts returns true if the list of kind k of pk counts in the graph.
+func (g *G) counts(pk string, k *kind.T) bool {
+	d, ok := g.depth[pk]
+	if !ok {
+		return false
+	}
+	if k.Equal(kind.MuteList) {
+		return d == 0 || d == 1 && g.cfg.MuteThreshold > 0
+	}
+	return d < g.cfg.Depth
+}
+
+// set stores the list of ev if it is newer than the one of its author and
+// kind, and returns true if it was.
+func (g *G) set(ev *event.E) (ok bool) {
+	lists := g.follows
+	if ev.Kind.Equal(kind.MuteList) {
+		lists = g.mutes
+	}
+	if l, found := lists[string(ev.Pubkey)]; found &&
+		l.createdAt >= ev.CreatedAtInt64() {
+		return
+	}
+	l := &list{
+		createdAt: ev.CreatedAtInt64(),
+		pubkeys:   make(map[string]struct{}),
+	}
+	for _, t := range ev.Tags.GetAll(tag.New("p")).ToSliceOfTags() {
+		v := t.Value()
+		if len(v) != 2*schnorr.PubKeyBytesLen {
+			continue
+		}
+		pk := make([]byte, schnorr.PubKeyBytesLen)
+		if _, err := hex.DecBytes(pk, v); err != nil {
+			continue
+		}
+		l.pubkeys[string(pk)] = struct{}{}
+	}
+	lists[string(ev.Pubkey)] = l
+	return true
+}
+
+// compute works out the trusted and muted pubkeys from the lists.
+//
+// The owners are trusted at depth 0 and can't be muted. The pubkeys the
+// owners mute are never trusted. The pubkeys the owners follow are trusted at
+// depth 1, and then the pubkeys that enough of the owners' follows mute are
+// muted. Each further depth is the pubkeys that at least MinFollows of the
+// trusted pubkeys of the depth before follow, that aren't muted.
+func (g *G) compute() {
+	g.depth = make(map[string]int)
+	g.muted = make(map[string]struct{})
+	for pk := range g.owners {
+		g.depth[pk] = 0
+		if l, ok := g.mutes[pk]; ok {
+			for m := range l.pubkeys {
+				g.muted[m] = struct{}{}
+			}
+		}
+	}
+	for pk := range g.owners {
+		delete(g.muted, pk)
+	}
+	for d := 1; d <= g.cfg.Depth; d++ {
+		followers := make(map[string]int)
+		for pk, dd := range g.depth {
+			if dd != d-1 {
+				continue
+			}
+			if l, ok := g.follows[pk]; ok {
+				for f := range l.pubkeys {
+					followers[f]++
+				}
+			}
+		}
+		need := 1
+		if d > 1 {
+			need = max(g.cfg.MinFollows, 1)
+		}
+		for pk, n := range followers {
+			if _, ok := g.depth[pk]; ok {
+				continue
+			}
+			if _, ok := g.muted[pk]; ok || n < need {
+				continue
+			}
+			g.depth[pk] = d
+		}
+		if d == 1 && g.cfg.MuteThreshold > 0 {
+			muters := make(map[string]int)
+			for pk, dd := range g.depth {
+				if dd != 1 {
+					continue
+				}
+				if l, ok := g.mutes[pk]; ok {
+					for m := range l.pubkeys {
+						muters[m]++
+					}
+				}
+			}
+			for pk, n := range muters {
+				if _, ok := g.depth[pk]; !ok && n >= g.cfg.MuteThreshold {
+					g.muted[pk] = struct{}{}
+				}
+			}
+		}
+	}
+}
+
+// Trusted returns true if pk is within the configured depth of the owners.
+func (g *G) Trusted(pk []byte) (ok bool) {
+	g.RLock()
+	defer g.RUnlock()
+	_, ok = g.depth[string(pk)]
+	return
+}
+
+// Muted returns true if pk is muted by the owners, or enough of their
+// follows.
+func (g *G) Muted(pk []byte) (ok bool) {
+	g.RLock()
+	defer g.RUnlock()
+	_, ok = g.muted[string(pk)]
+	return
+}
+
+// Levels returns the trusted pubkeys up to depth 1, including the owners,
+// those beyond, and the muted pubkeys, sorted.
+func (g *G) Levels() (followed, follows, muted [][]byte) {
+	g.RLock()
+	defer g.RUnlock()
+	for pk, d := range g.depth {
+		if d <= 1 {
+			followed = append(followed, []byte(pk))
+		} else {
+			follows = append(follows, []byte(pk))
+		}
+	}
+	for pk := range g.muted {
+		muted = append(muted, []byte(pk))
+	}
+	for _, pks := range [][][]byte{followed, follows, muted} {
+		sort.Slice(
+			pks, func(i, j int) bool { return string(pks[i]) < string(pks[j]) },
+		)
+	}
+	return
+}
@@ -0,0 +1,139 @@
+package wot
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+)
+
+// lists is a store.Querent of follow and mute lists.
+type lists []*event.E
+
+func (l lists) QueryEvents(
+	_ context.Context, f *filter.F,
+) (evs event.S, err error) {
+	for _, ev := range l {
+		if f.Matches(ev) {
+			evs = append(evs, ev)
+		}
+	}
+	return
+}
+
+// pubkey returns a pubkey made of the byte b.
+func pubkey(b byte) []byte {
+	pk := make([]byte, 32)
+	for i := range pk {
+		pk[i] = b
+	}
+	return pk
+}
+
+// listEvent returns a list of kind k by author with a p tag for each of pks.
+func listEvent(
+	k *kind.T, author []byte, createdAt int64, pks ...[]byte,
+) *event.E {
+	ev := &event.E{
+		Pubkey: author, Kind: k, CreatedAt: timestamp.New(createdAt),
+		Tags: tags.New(),
+	}
+	for _, pk := range pks {
+		ev.Tags.AppendTags(tag.New("p", hex.Enc(pk)))
+	}
+	return ev
+}
+
+func TestGraph(t *testing.T) {
+	owner, a, b, c, d, e, m := pubkey(1), pubkey(2), pubkey(3), pubkey(4),
+		pubkey(5), pubkey(6), pubkey(7)
+	store := lists{
+		listEvent(kind.FollowList, owner, 1, a, b, m),
+		listEvent(kind.MuteList, owner, 1, m),
+		// c is followed by both a and b, d only by a, and e is muted by both.
+		listEvent(kind.FollowList, a, 1, c, d, e, owner),
+		listEvent(kind.FollowList, b, 1, c, e),
+		listEvent(kind.MuteList, a, 1, e),
+		listEvent(kind.MuteList, b, 1, e),
+		listEvent(kind.FollowList, m, 1, d),
+	}
+	g := New(Config{Depth: 2, MinFollows: 2})
+	g.SetOwners([][]byte{owner})
+	for depth := 0; depth < 2; depth++ {
+		if err := g.Load(
+			context.Background(), store, g.Frontier(depth),
+		); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tc := range []struct {
+		pk      []byte
+		trusted bool
+		depth   int
+	}{
+		{owner, true, 0}, {a, true, 1}, {b, true, 1}, {c, true, 2},
+		{d, false, -1}, {e, true, 2}, {m, false, -1},
+	} {
+		x := g.Explain(tc.pk)
+		if g.Trusted(tc.pk) != tc.trusted || x.Allowed != tc.trusted ||
+			x.Depth != tc.depth {
+			t.Errorf(
+				"%x: expected trusted %v at depth %d, got %+v", tc.pk[0],
+				tc.trusted, tc.depth, x,
+			)
+		}
+	}
+	if x := g.Explain(d); x.Followers != 1 ||
+		!strings.Contains(x.Reason, "2 are required") {
+		t.Errorf("unexpected explanation of d %+v", x)
+	}
+	if x := g.Explain(m); !x.Muted || x.Reason != "muted by an owner" {
+		t.Errorf("unexpected explanation of m %+v", x)
+	}
+
+	// with a mute threshold, the mutes of a and b exclude e.
+	g.Configure(Config{Depth: 2, MinFollows: 2, MuteThreshold: 2})
+	if x := g.Explain(e); x.Allowed || !x.Muted ||
+		!strings.Contains(x.Reason, "muted by 2 of the owners' follows") {
+		t.Errorf("unexpected explanation of e %+v", x)
+	}
+
+	// a new follow list of b that adds d makes it trusted, and an older one
+	// is ignored.
+	if !g.Update(listEvent(kind.FollowList, b, 2, c, d, e)) ||
+		!g.Trusted(d) {
+		t.Error("the new follow list of b was not applied")
+	}
+	if g.Update(listEvent(kind.FollowList, b, 1)) || !g.Trusted(d) {
+		t.Error("an older follow list of b was applied")
+	}
+	// lists of pubkeys beyond the depth whose lists count don't change it.
+	if g.Update(listEvent(kind.FollowList, c, 2, pubkey(8), pubkey(9))) {
+		t.Error("the follow list of c was applied")
+	}
+
+	// the owner following a new user makes it trusted, and its follow list
+	// is missing until it is loaded.
+	f := pubkey(10)
+	if !g.Update(listEvent(kind.FollowList, owner, 2, a, b, f)) {
+		t.Fatal("the new follow list of the owner was not applied")
+	}
+	if missing := g.Missing(); len(missing) != 1 ||
+		string(missing[0]) != string(f) {
+		t.Errorf("expected f to be missing, got %d pubkeys", len(missing))
+	}
+	followed, follows, muted := g.Levels()
+	if len(followed) != 4 || len(follows) != 2 || len(muted) != 2 {
+		t.Errorf(
+			"unexpected levels %d followed, %d follows and %d muted",
+			len(followed), len(follows), len(muted),
+		)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"orly.dev/pkg/utils/units"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"time"
 )
 
@@ -22,6 +23,9 @@ type D struct {
 	Logger  *logger
 	*badger.DB
 	seq *badger.Sequence
+	// slowQuery is the duration in nanoseconds above which queries are
+	// logged with their plan, 0 to not log them.
+	slowQuery atomic.Int64
 }
 
 func New(ctx context.T, cancel context.F, dataDir, logLevel string) (
@@ -64,6 +68,7 @@ func New(ctx context.T, cancel context.F, dataDir, logLevel string) (
 	// run code that updates indexes when new indexes have been added and bumps
 	// the version so they aren't run again.
 	d.RunMigrations()
+	d.collectSizes()
 	// start up the expiration tag processing and shut down and clean up the
 	// database after the context is canceled.
 	go func() {
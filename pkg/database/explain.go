@@ -0,0 +1,103 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/log"
+)
+
+// RangeStats is an index range that GetIndexesFromFilter chose for a filter,
+// and the number of keys that were scanned in it.
+type RangeStats struct {
+	Prefix string `json:"prefix" doc:"three character prefix of the index"`
+	Start  string `json:"start" doc:"hex encoded first key of the range"`
+	End    string `json:"end" doc:"hex encoded last key of the range"`
+	Keys   int    `json:"keys" doc:"number of keys scanned in the range"`
+}
+
+// QueryStats is the plan of a query and the statistics of its execution.
+type QueryStats struct {
+	Index    string        `json:"index" doc:"index path chosen for the filter"`
+	Ranges   []RangeStats  `json:"ranges" doc:"index ranges scanned, including those of the deletion events of the authors"`
+	Scanned  int           `json:"keys_scanned" doc:"number of index keys scanned"`
+	Fetched  int           `json:"events_fetched" doc:"number of events fetched, some of them more than once"`
+	Results  int           `json:"results" doc:"number of events in the result"`
+	Duration time.Duration `json:"duration" doc:"duration of the query in nanoseconds"`
+}
+
+// scanned records that keys index keys were scanned in the range r.
+func (st *QueryStats) scanned(r Range, keys int) {
+	var prefix string
+	if len(r.Start) >= 3 {
+		prefix = string(r.Start[:3])
+	}
+	st.Ranges = append(
+		st.Ranges, RangeStats{
+			Prefix: prefix, Start: hex.Enc(r.Start), End: hex.Enc(r.End),
+			Keys: keys,
+		},
+	)
+	st.Scanned += keys
+}
+
+// String formats the statistics on one line for the log.
+func (st *QueryStats) String() string {
+	prefixes := make([]string, len(st.Ranges))
+	for i, r := range st.Ranges {
+		prefixes[i] = fmt.Sprintf("%s:%d", r.Prefix, r.Keys)
+	}
+	return fmt.Sprintf(
+		"index %s ranges %d [%s] keys scanned %d events fetched %d "+
+			"results %d in %v", st.Index, len(st.Ranges),
+		strings.Join(prefixes, " "), st.Scanned, st.Fetched, st.Results,
+		st.Duration,
+	)
+}
+
+// Explain runs the query of a filter and returns its plan and statistics
+// instead of the events.
+//
+// # Parameters
+//
+//   - c: The context of the query.
+//
+//   - f: The filter to explain.
+//
+// # Return Values
+//
+//   - st: The index the filter was mapped to, the ranges that were scanned
+//     with the number of keys in each, and the number of events fetched and
+//     matched.
+//
+//   - err: An error if the query failed.
+//
+// # Expected Behaviour
+//
+// The query is executed in the same way as QueryEvents, so the statistics are
+// those of a real query, including the time it took.
+func (d *D) Explain(c context.T, f *filter.F) (st *QueryStats, err error) {
+	st = new(QueryStats)
+	_, err = d.queryEvents(c, f, st)
+	return
+}
+
+// SetSlowQuery sets the duration above which queries are logged with their
+// plan and statistics, 0 to not log them.
+func (d *D) SetSlowQuery(threshold time.Duration) {
+	d.slowQuery.Store(int64(threshold))
+}
+
+// logSlow logs the filter and statistics of a query that took longer than the
+// slow query threshold.
+func (d *D) logSlow(f *filter.F, st *QueryStats) {
+	threshold := time.Duration(d.slowQuery.Load())
+	if threshold <= 0 || st.Duration < threshold {
+		return
+	}
+	log.W.F("slow query %s %s", f.Serialize(), st)
+}
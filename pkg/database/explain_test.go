@@ -0,0 +1,80 @@
+package database
+
+import (
+	"os"
+	"testing"
+
+	"orly.dev/pkg/crypto/p256k"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/utils/context"
+)
+
+// testNotes opens a database in a temporary directory with n text notes of
+// one author saved in it, which is closed at the end of the test.
+func testNotes(t *testing.T, n int) (c context.T, db *D, pub []byte) {
+	tempDir, err := os.MkdirTemp("", "test-db-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tempDir) })
+	c, cancel := context.Cancel(context.Bg())
+	if db, err = New(c, cancel, tempDir, "info"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(
+		func() {
+			db.Close()
+			cancel()
+		},
+	)
+	sign := new(p256k.Signer)
+	if err = sign.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	pub = sign.Pub()
+	for i := 0; i < n; i++ {
+		ev := event.New()
+		ev.Kind = kind.TextNote
+		ev.Pubkey = pub
+		ev.CreatedAt = timestamp.FromUnix(timestamp.Now().I64() - int64(i))
+		ev.Content = []byte("note")
+		ev.Tags = tags.New()
+		if err = ev.Sign(sign); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err = db.SaveEvent(c, ev, false, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return
+}
+
+func TestExplain(t *testing.T) {
+	c, db, pub := testNotes(t, 3)
+	st, err := db.Explain(c, &filter.F{Kinds: kinds.New(kind.TextNote)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Index != "kind" || len(st.Ranges) != 1 ||
+		st.Ranges[0].Prefix != "kc-" || st.Scanned != 3 || st.Results != 3 {
+		t.Fatalf("unexpected plan %+v", st)
+	}
+	// the events of a query with authors are fetched along with the
+	// deletions of the authors, in two passes.
+	if st, err = db.Explain(
+		c, &filter.F{Kinds: kinds.New(kind.TextNote), Authors: tag.New(pub)},
+	); err != nil {
+		t.Fatal(err)
+	}
+	if st.Index != "kind-pubkey" || len(st.Ranges) != 2 ||
+		st.Ranges[0].Prefix != "kpc" || st.Ranges[1].Prefix != "kpc" ||
+		st.Scanned != 3 || st.Fetched != 6 || st.Results != 3 {
+		t.Fatalf("unexpected plan %+v", st)
+	}
+}
@@ -19,7 +19,14 @@ func (d *D) GetSerialsByRange(idx Range) (
 				},
 			)
 			defer it.Close()
-			for it.Seek(idx.End); it.Valid(); it.Next() {
+			// the keys end with the serial, so the end of the range is padded
+			// to the largest serial for the keys of the last timestamp to be
+			// included.
+			end := append(
+				append(make([]byte, 0, len(idx.End)+5), idx.End...),
+				0xff, 0xff, 0xff, 0xff, 0xff,
+			)
+			for it.Seek(end); it.Valid(); it.Next() {
 				item := it.Item()
 				var key []byte
 				key = item.Key()
@@ -3,6 +3,7 @@ package database
 import (
 	"bufio"
 	"bytes"
+	"orly.dev/pkg/crypto/p256k"
 	"orly.dev/pkg/database/indexes/types"
 	"orly.dev/pkg/encoders/event"
 	"orly.dev/pkg/encoders/event/examples"
@@ -10,6 +11,7 @@ import (
 	"orly.dev/pkg/encoders/kind"
 	"orly.dev/pkg/encoders/kinds"
 	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
 	"orly.dev/pkg/encoders/timestamp"
 	"orly.dev/pkg/utils"
 	"orly.dev/pkg/utils/chk"
@@ -230,3 +232,48 @@ func TestGetSerialsByRange(t *testing.T) {
 		}
 	}
 }
+
+func TestGetSerialsByRangeUntil(t *testing.T) {
+	tempDir := t.TempDir()
+	ctx, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	db, err := New(ctx, cancel, tempDir, "info")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	sign := new(p256k.Signer)
+	if err = sign.Generate(); chk.E(err) {
+		t.Fatal(err)
+	}
+	ev := &event.E{
+		Kind: kind.TextNote, CreatedAt: timestamp.Now(), Tags: tags.New(),
+		Content: []byte("test"),
+	}
+	if err = ev.Sign(sign); chk.E(err) {
+		t.Fatal(err)
+	}
+	if _, _, err = db.SaveEvent(ctx, ev, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	serial, err := db.GetSerialById(ev.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an event created in the second of until is within the range.
+	ranges, err := GetIndexesFromFilter(
+		&filter.F{Since: ev.CreatedAt, Until: ev.CreatedAt},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serials, err := db.GetSerialsByRange(ranges[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(serials) != 1 || serials[0].Get() != serial.Get() {
+		t.Fatalf("expected the serial of the event, got %d serials", len(serials))
+	}
+}
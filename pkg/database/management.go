@@ -0,0 +1,111 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// The names of the relay management lists, which hold pubkeys and event ids
+// in hex, kinds as decimal numbers, and IP addresses.
+const (
+	BannedPubkeys  = "bannedpubkeys"
+	AllowedPubkeys = "allowedpubkeys"
+	BannedEvents   = "bannedevents"
+	AllowedEvents  = "allowedevents"
+	AllowedKinds   = "allowedkinds"
+	BlockedIPs     = "blockedips"
+)
+
+// ListEntry is an item on one of the relay management lists.
+type ListEntry struct {
+	Value  string    `msgpack:"value"`
+	Reason string    `msgpack:"reason"`
+	Added  time.Time `msgpack:"added"`
+}
+
+func listKey(list, value string) []byte {
+	return []byte(fmt.Sprintf("mgmt:list:%s:%s", list, value))
+}
+
+func settingKey(key string) []byte {
+	return []byte(fmt.Sprintf("mgmt:setting:%s", key))
+}
+
+// SetListEntry adds an entry to a relay management list, replacing any entry
+// with the same value.
+func (d *D) SetListEntry(list string, entry ListEntry) error {
+	data, err := msgpack.Marshal(&entry)
+	if err != nil {
+		return err
+	}
+	return d.DB.Update(func(txn *badger.Txn) error {
+		return txn.Set(listKey(list, entry.Value), data)
+	})
+}
+
+// DeleteListEntry removes the entry with a value from a relay management list.
+func (d *D) DeleteListEntry(list, value string) error {
+	return d.DB.Update(func(txn *badger.Txn) error {
+		return txn.Delete(listKey(list, value))
+	})
+}
+
+// GetList returns the entries of a relay management list, in order of value.
+func (d *D) GetList(list string) ([]ListEntry, error) {
+	prefix := listKey(list, "")
+	var entries []ListEntry
+	err := d.DB.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			err := it.Item().Value(func(val []byte) error {
+				var entry ListEntry
+				if err := msgpack.Unmarshal(val, &entry); err != nil {
+					return err
+				}
+				entries = append(entries, entry)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// SetSetting stores a relay management setting, such as the relay name, or
+// deletes it if the value is empty.
+func (d *D) SetSetting(key, value string) error {
+	return d.DB.Update(func(txn *badger.Txn) error {
+		if value == "" {
+			return txn.Delete(settingKey(key))
+		}
+		return txn.Set(settingKey(key), []byte(value))
+	})
+}
+
+// GetSettings returns all the relay management settings.
+func (d *D) GetSettings() (map[string]string, error) {
+	prefix := settingKey("")
+	settings := make(map[string]string)
+	err := d.DB.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			key := strings.TrimPrefix(string(it.Item().Key()), string(prefix))
+			val, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			settings[key] = string(val)
+		}
+		return nil
+	})
+	return settings, err
+}
@@ -0,0 +1,59 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+func TestManagementLists(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	d := &D{DB: db}
+
+	for _, v := range []string{"bb", "aa"} {
+		if err = d.SetListEntry(
+			BannedPubkeys, ListEntry{Value: v, Reason: "spam", Added: time.Now()},
+		); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = d.SetListEntry(BannedEvents, ListEntry{Value: "cc"}); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := d.GetList(BannedPubkeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Value != "aa" ||
+		entries[1].Reason != "spam" {
+		t.Fatalf("unexpected list %+v", entries)
+	}
+	if err = d.DeleteListEntry(BannedPubkeys, "aa"); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ = d.GetList(BannedPubkeys); len(entries) != 1 {
+		t.Fatalf("entry was not deleted %+v", entries)
+	}
+
+	if err = d.SetSetting("name", "relay"); err != nil {
+		t.Fatal(err)
+	}
+	settings, err := d.GetSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings["name"] != "relay" {
+		t.Fatalf("unexpected settings %v", settings)
+	}
+	if err = d.SetSetting("name", ""); err != nil {
+		t.Fatal(err)
+	}
+	if settings, _ = d.GetSettings(); len(settings) != 0 {
+		t.Fatalf("setting was not deleted %v", settings)
+	}
+}
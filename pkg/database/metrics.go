@@ -0,0 +1,63 @@
+package database
+
+import (
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/utils/metrics"
+)
+
+var (
+	queryDuration = metrics.NewHistogram(
+		"orly_query_seconds",
+		"duration of event queries, by the index they are answered from",
+		metrics.DefBuckets, "index",
+	)
+	lsmSize = metrics.NewGauge(
+		"orly_badger_lsm_bytes", "size of the badger LSM tree",
+	)
+	vlogSize = metrics.NewGauge(
+		"orly_badger_vlog_bytes", "size of the badger value log",
+	)
+)
+
+// collectSizes reports the sizes of the event store on /metrics, which
+// badger updates every minute.
+func (d *D) collectSizes() {
+	metrics.Collect(
+		"badger", func() {
+			if d.DB == nil || d.DB.IsClosed() {
+				return
+			}
+			lsm, vlog := d.DB.Size()
+			lsmSize.Set(float64(lsm))
+			vlogSize.Set(float64(vlog))
+		},
+	)
+}
+
+// IndexPath returns the name of the index GetIndexesFromFilter uses for a
+// filter, which is the index label of the query metrics.
+func IndexPath(f *filter.F) string {
+	ids := f.Ids != nil && f.Ids.Len() > 0
+	kinds := f.Kinds != nil && f.Kinds.Len() > 0
+	authors := f.Authors != nil && f.Authors.Len() > 0
+	tags := f.Tags != nil && f.Tags.Len() > 0
+	switch {
+	case ids:
+		return "id"
+	case kinds && authors && tags:
+		return "tag-kind-pubkey"
+	case kinds && tags:
+		return "tag-kind"
+	case authors && tags:
+		return "tag-pubkey"
+	case tags:
+		return "tag"
+	case kinds && authors:
+		return "kind-pubkey"
+	case kinds:
+		return "kind"
+	case authors:
+		return "pubkey"
+	}
+	return "created-at"
+}
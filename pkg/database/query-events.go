@@ -2,6 +2,7 @@ package database
 
 import (
 	"bytes"
+	"go.opentelemetry.io/otel/attribute"
 	"orly.dev/pkg/crypto/sha256"
 	"orly.dev/pkg/database/indexes/types"
 	"orly.dev/pkg/encoders/event"
@@ -16,6 +17,7 @@ import (
 	"orly.dev/pkg/utils/chk"
 	"orly.dev/pkg/utils/context"
 	"orly.dev/pkg/utils/log"
+	"orly.dev/pkg/utils/tracing"
 	"sort"
 	"strconv"
 	"time"
@@ -35,20 +37,62 @@ func CheckExpiration(ev *event.E) (expired bool) {
 	return
 }
 
+// Supersedes returns true if the replaceable event a replaces b, which it does
+// if it is newer, or was created in the same second and has the lower id.
+func Supersedes(a, b *event.E) bool {
+	if a.CreatedAt.I64() != b.CreatedAt.I64() {
+		return a.CreatedAt.I64() > b.CreatedAt.I64()
+	}
+	return bytes.Compare(a.ID, b.ID) < 0
+}
+
+// QueryEvents returns the events that match the filter, newest first, and
+// logs the plan and statistics of the query if it took longer than the slow
+// query threshold.
 func (d *D) QueryEvents(c context.T, f *filter.F) (evs event.S, err error) {
+	st := new(QueryStats)
+	evs, err = d.queryEvents(c, f, st)
+	d.logSlow(f, st)
+	return
+}
+
+// queryEvents is QueryEvents, which records the ranges it scanned and the
+// events it fetched in st.
+func (d *D) queryEvents(c context.T, f *filter.F, st *QueryStats) (
+	evs event.S, err error,
+) {
+	start := time.Now()
+	st.Index = IndexPath(f)
+	defer func() {
+		st.Results = len(evs)
+		st.Duration = time.Since(start)
+		queryDuration.Observe(st.Duration.Seconds(), st.Index)
+	}()
+	c, span := tracing.Start(
+		c, "database.QueryEvents", append(
+			tracing.Filter(f), attribute.String("index", st.Index),
+		)...,
+	)
+	defer func() {
+		span.SetAttributes(attribute.Int("results", len(evs)))
+		tracing.Error(span, err)
+		span.End()
+	}()
 	// if there is Ids in the query, this overrides anything else
 	var expDeletes types.Uint40s
 	var expEvs event.S
 	if f.Ids != nil && f.Ids.Len() > 0 {
+		fetch := d.fetchSpan(c, "ids", st)
 		for _, idx := range f.Ids.ToSliceOfBytes() {
 			// we know there is only Ids in this, so run the ID query and fetch.
 			var ser *types.Uint40
+			st.Scanned++
 			if ser, err = d.GetSerialById(idx); chk.E(err) {
 				continue
 			}
 			// fetch the events
 			var ev *event.E
-			if ev, err = d.FetchEventBySerial(ser); err != nil {
+			if ev, err = fetch.event(ser); err != nil {
 				continue
 			}
 			// check for an expiration tag and delete after returning the result
@@ -59,6 +103,7 @@ func (d *D) QueryEvents(c context.T, f *filter.F) (evs event.S, err error) {
 			}
 			evs = append(evs, ev)
 		}
+		fetch.end()
 		// sort the events by timestamp
 		sort.Slice(
 			evs, func(i, j int) bool {
@@ -67,7 +112,7 @@ func (d *D) QueryEvents(c context.T, f *filter.F) (evs event.S, err error) {
 		)
 	} else {
 		var idPkTs []*store.IdPkTs
-		if idPkTs, err = d.QueryForIds(c, f); chk.E(err) {
+		if idPkTs, err = d.queryForIds(c, f, st); chk.E(err) {
 			return
 		}
 		// Create a map to store the latest version of replaceable events
@@ -94,8 +139,8 @@ func (d *D) QueryEvents(c context.T, f *filter.F) (evs event.S, err error) {
 			}
 
 			var deletionIdPkTs []*store.IdPkTs
-			if deletionIdPkTs, err = d.QueryForIds(
-				c, deletionFilter,
+			if deletionIdPkTs, err = d.queryForIds(
+				c, deletionFilter, st,
 			); chk.E(err) {
 				return
 			}
@@ -104,13 +149,14 @@ func (d *D) QueryEvents(c context.T, f *filter.F) (evs event.S, err error) {
 			idPkTs = append(idPkTs, deletionIdPkTs...)
 		}
 		// First pass: collect all deletion events
+		fetch := d.fetchSpan(c, "deletions", st)
 		for _, idpk := range idPkTs {
 			var ev *event.E
 			ser := new(types.Uint40)
 			if err = ser.Set(idpk.Ser); chk.E(err) {
 				continue
 			}
-			if ev, err = d.FetchEventBySerial(ser); err != nil {
+			if ev, err = fetch.event(ser); err != nil {
 				continue
 			}
 			// check for an expiration tag and delete after returning the result
@@ -231,19 +277,21 @@ func (d *D) QueryEvents(c context.T, f *filter.F) (evs event.S, err error) {
 				}
 			}
 		}
-
+		fetch.end()
 		// Second pass: process all events, filtering out deleted ones
+		fetch = d.fetchSpan(c, "events", st)
 		for _, idpk := range idPkTs {
 			var ev *event.E
 			ser := new(types.Uint40)
 			if err = ser.Set(idpk.Ser); chk.E(err) {
 				continue
 			}
-			if ev, err = d.FetchEventBySerial(ser); err != nil {
+			if ev, err = fetch.event(ser); err != nil {
 				continue
 			}
-			// Skip events with kind 5 (Deletion)
-			if ev.Kind.Equal(kind.Deletion) {
+			// Skip events with kind 5 (Deletion), and expired events, which
+			// the first pass has queued for deletion.
+			if ev.Kind.Equal(kind.Deletion) || CheckExpiration(ev) {
 				continue
 			}
 			// Check if this event's ID is in the filter
@@ -275,7 +323,7 @@ func (d *D) QueryEvents(c context.T, f *filter.F) (evs event.S, err error) {
 					// Check if there's a newer event with the same kind/pubkey
 					// that hasn't been specifically deleted
 					existing, exists := replaceableEvents[key]
-					if !exists || ev.CreatedAt.I64() > existing.CreatedAt.I64() {
+					if !exists || Supersedes(ev, existing) {
 						// This is the newest event so far, keep it
 						replaceableEvents[key] = ev
 					} else {
@@ -285,7 +333,7 @@ func (d *D) QueryEvents(c context.T, f *filter.F) (evs event.S, err error) {
 				} else {
 					// Normal replaceable event handling
 					existing, exists := replaceableEvents[key]
-					if !exists || ev.CreatedAt.I64() > existing.CreatedAt.I64() {
+					if !exists || Supersedes(ev, existing) {
 						replaceableEvents[key] = ev
 					}
 				}
@@ -325,7 +373,7 @@ func (d *D) QueryEvents(c context.T, f *filter.F) (evs event.S, err error) {
 				if !exists {
 					// No existing event, add this one
 					paramReplaceableEvents[key][dValue] = ev
-				} else if ev.CreatedAt.I64() > existing.CreatedAt.I64() {
+				} else if Supersedes(ev, existing) {
 					// This event is newer than the existing one, replace it
 					paramReplaceableEvents[key][dValue] = ev
 				}
@@ -335,6 +383,7 @@ func (d *D) QueryEvents(c context.T, f *filter.F) (evs event.S, err error) {
 				regularEvents = append(regularEvents, ev)
 			}
 		}
+		fetch.end()
 		// Add all the latest replaceable events to the result
 		for _, ev := range replaceableEvents {
 			evs = append(evs, ev)
@@ -354,10 +403,11 @@ func (d *D) QueryEvents(c context.T, f *filter.F) (evs event.S, err error) {
 				return evs[i].CreatedAt.I64() > evs[j].CreatedAt.I64()
 			},
 		)
-		// delete the expired events in a background thread
+		// delete the expired events in a background thread, which must not
+		// touch err, as the span reads it when the query returns.
 		go func() {
 			for i, ser := range expDeletes {
-				if err = d.DeleteEventBySerial(c, ser, expEvs[i]); chk.E(err) {
+				if e := d.DeleteEventBySerial(c, ser, expEvs[i]); chk.E(e) {
 					continue
 				}
 			}
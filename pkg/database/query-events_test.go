@@ -631,3 +631,86 @@ func TestQueryEventsByTag(t *testing.T) {
 		}
 	}
 }
+
+func TestQueryEventsExpired(t *testing.T) {
+	db, _, ctx, cancel, tempDir := setupTestDB(t)
+	defer os.RemoveAll(tempDir) // Clean up after the test
+	defer cancel()
+	defer db.Close()
+
+	sign := new(p256k.Signer)
+	if err := sign.Generate(); chk.E(err) {
+		t.Fatal(err)
+	}
+	// Create a note that expired an hour ago, and one that hasn't expired
+	var evs []*event.E
+	for _, expiry := range []int64{-3600, 3600} {
+		ev := event.New()
+		ev.Kind = kind.TextNote
+		ev.CreatedAt = new(timestamp.T)
+		ev.CreatedAt.V = timestamp.Now().V - 7200
+		ev.Content = []byte("expiring note")
+		ev.Tags = tags.New(
+			tag.New("expiration", fmt.Sprint(timestamp.Now().V+expiry)),
+		)
+		ev.Sign(sign)
+		if _, _, err := db.SaveEvent(ctx, ev, false, nil); err != nil {
+			t.Fatalf("Failed to save event: %v", err)
+		}
+		evs = append(evs, ev)
+	}
+
+	// Query for the notes of the author, which only finds the live one
+	found, err := db.QueryEvents(
+		ctx, &filter.F{Authors: tag.New(sign.Pub())},
+	)
+	if err != nil {
+		t.Fatalf("Failed to query events: %v", err)
+	}
+	if len(found) != 1 || !utils.FastEqual(found[0].ID, evs[1].ID) {
+		t.Fatalf("Expected only the unexpired event, got %d events", len(found))
+	}
+}
+
+func TestQueryEventsReplaceableSameSecond(t *testing.T) {
+	db, _, ctx, cancel, tempDir := setupTestDB(t)
+	defer os.RemoveAll(tempDir) // Clean up after the test
+	defer cancel()
+	defer db.Close()
+
+	sign := new(p256k.Signer)
+	if err := sign.Generate(); chk.E(err) {
+		t.Fatal(err)
+	}
+	// Create two profiles in the same second, of which the one with the
+	// lower id replaces the other, whichever order they are saved in
+	createdAt := timestamp.Now().V
+	var lowest *event.E
+	for _, content := range []string{"one profile", "another profile"} {
+		ev := event.New()
+		ev.Kind = kind.ProfileMetadata
+		ev.CreatedAt = new(timestamp.T)
+		ev.CreatedAt.V = createdAt
+		ev.Content = []byte(content)
+		ev.Tags = tags.New()
+		ev.Sign(sign)
+		if _, _, err := db.SaveEvent(ctx, ev, false, nil); err != nil {
+			t.Fatalf("Failed to save event: %v", err)
+		}
+		if lowest == nil || bytes.Compare(ev.ID, lowest.ID) < 0 {
+			lowest = ev
+		}
+	}
+	evs, err := db.QueryEvents(
+		ctx, &filter.F{
+			Kinds:   kinds.New(kind.ProfileMetadata),
+			Authors: tag.New(sign.Pub()),
+		},
+	)
+	if err != nil {
+		t.Fatalf("Failed to query events: %v", err)
+	}
+	if len(evs) != 1 || !utils.FastEqual(evs[0].ID, lowest.ID) {
+		t.Fatalf("Expected only the profile with the lowest id, got %d", len(evs))
+	}
+}
@@ -1,12 +1,14 @@
 package database
 
 import (
+	"go.opentelemetry.io/otel/attribute"
 	"orly.dev/pkg/database/indexes/types"
 	"orly.dev/pkg/encoders/filter"
 	"orly.dev/pkg/interfaces/store"
 	"orly.dev/pkg/utils/chk"
 	"orly.dev/pkg/utils/context"
 	"orly.dev/pkg/utils/errorf"
+	"orly.dev/pkg/utils/tracing"
 	"sort"
 )
 
@@ -16,6 +18,13 @@ import (
 // Returns an error if the filter contains Ids or if any operation fails.
 func (d *D) QueryForIds(c context.T, f *filter.F) (
 	idPkTs []*store.IdPkTs, err error,
+) {
+	return d.queryForIds(c, f, new(QueryStats))
+}
+
+// queryForIds is QueryForIds, which records the ranges it scanned in st.
+func (d *D) queryForIds(c context.T, f *filter.F, st *QueryStats) (
+	idPkTs []*store.IdPkTs, err error,
 ) {
 	if f.Ids != nil && f.Ids.Len() > 0 {
 		// if there is Ids in the query, this is an error for this query
@@ -23,15 +32,26 @@ func (d *D) QueryForIds(c context.T, f *filter.F) (
 		return
 	}
 	var idxs []Range
-	if idxs, err = GetIndexesFromFilter(f); chk.E(err) {
+	_, span := tracing.Start(c, "database.GetIndexesFromFilter")
+	idxs, err = GetIndexesFromFilter(f)
+	span.SetAttributes(attribute.Int("ranges", len(idxs)))
+	tracing.Error(span, err)
+	span.End()
+	if chk.E(err) {
 		return
 	}
 	var results []*store.IdPkTs
 	var founds []*types.Uint40
 	for _, idx := range idxs {
-		if founds, err = d.GetSerialsByRange(idx); chk.E(err) {
+		_, span = tracing.Start(c, "database.GetSerialsByRange")
+		founds, err = d.GetSerialsByRange(idx)
+		span.SetAttributes(attribute.Int("rows", len(founds)))
+		tracing.Error(span, err)
+		span.End()
+		if chk.E(err) {
 			return
 		}
+		st.scanned(idx, len(founds))
 		var tmp []*store.IdPkTs
 		if tmp, err = d.GetFullIdPubkeyBySerials(founds); chk.E(err) {
 			return
@@ -3,6 +3,7 @@ package database
 import (
 	"bytes"
 	"github.com/dgraph-io/badger/v4"
+	"go.opentelemetry.io/otel/attribute"
 	"orly.dev/pkg/database/indexes"
 	"orly.dev/pkg/database/indexes/types"
 	"orly.dev/pkg/encoders/event"
@@ -16,6 +17,7 @@ import (
 	"orly.dev/pkg/utils/chk"
 	"orly.dev/pkg/utils/context"
 	"orly.dev/pkg/utils/errorf"
+	"orly.dev/pkg/utils/tracing"
 	"sort"
 )
 
@@ -23,11 +25,18 @@ import (
 func (d *D) SaveEvent(
 	c context.T, ev *event.E, noVerify bool, owners [][]byte,
 ) (kc, vc int, err error) {
+	c, span := tracing.Start(
+		c, "database.SaveEvent", attribute.Int("kind", int(ev.Kind.K)),
+	)
+	defer func() {
+		tracing.Error(span, err)
+		span.End()
+	}()
 	if !noVerify {
 		// check if the event already exists
 		var ser *types.Uint40
 		if ser, err = d.GetSerialById(ev.ID); err == nil && ser != nil {
-			err = errorf.E("event already exists: %0x", ev.ID)
+			err = errorf.E("%w: %0x", store.ErrDupEvent, ev.ID)
 			return
 		}
 	}
@@ -3,6 +3,7 @@ package database
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"orly.dev/pkg/crypto/p256k"
 	"orly.dev/pkg/encoders/event"
 	"orly.dev/pkg/encoders/event/examples"
@@ -11,10 +12,12 @@ import (
 	"orly.dev/pkg/encoders/tag"
 	"orly.dev/pkg/encoders/tags"
 	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/interfaces/store"
 	"orly.dev/pkg/utils/chk"
 	"orly.dev/pkg/utils/context"
 	"orly.dev/pkg/utils/errorf"
 	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -216,12 +219,9 @@ func TestSaveExistingEvent(t *testing.T) {
 		t.Fatal("Expected error when saving an existing event, but got nil")
 	}
 
-	// Verify the error message
-	expectedErrorPrefix := "event already exists: "
-	if !bytes.HasPrefix([]byte(err.Error()), []byte(expectedErrorPrefix)) {
-		t.Fatalf(
-			"Expected error message to start with '%s', got '%s'",
-			expectedErrorPrefix, err.Error(),
-		)
+	// Verify the error is a duplicate, whose message has the NIP-01 prefix
+	if !errors.Is(err, store.ErrDupEvent) ||
+		!strings.HasPrefix(err.Error(), "duplicate: ") {
+		t.Fatalf("Expected a duplicate event error, got '%s'", err.Error())
 	}
 }
@@ -0,0 +1,64 @@
+package database
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// spiderCursorKey is the key of the time the spider last fetched the events of
+// a kind of a pubkey.
+func spiderCursorKey(pubkey []byte, k string) []byte {
+	return []byte(fmt.Sprintf("spider:%x:%s", pubkey, k))
+}
+
+// SpiderCursors returns the times, as unix seconds, that the spider last
+// fetched the events of kind k of the pubkeys, keyed by the pubkey as a
+// string of its bytes. The kind is a kind number, or any other name for a
+// fetch that isn't of a single kind. Pubkeys that were never fetched are
+// left out.
+func (d *D) SpiderCursors(pubkeys [][]byte, k string) (
+	cursors map[string]int64, err error,
+) {
+	cursors = make(map[string]int64)
+	err = d.DB.View(
+		func(txn *badger.Txn) error {
+			for _, pk := range pubkeys {
+				item, err := txn.Get(spiderCursorKey(pk, k))
+				if err == badger.ErrKeyNotFound {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				if err = item.Value(
+					func(val []byte) error {
+						if len(val) == 8 {
+							cursors[string(pk)] = int64(binary.BigEndian.Uint64(val))
+						}
+						return nil
+					},
+				); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	)
+	return
+}
+
+// SetSpiderCursors records that the spider fetched the events of kind k of
+// the pubkeys up to t, as unix seconds.
+func (d *D) SetSpiderCursors(pubkeys [][]byte, k string, t int64) (err error) {
+	val := binary.BigEndian.AppendUint64(nil, uint64(t))
+	wb := d.DB.NewWriteBatch()
+	defer wb.Cancel()
+	for _, pk := range pubkeys {
+		if err = wb.Set(spiderCursorKey(pk, k), val); err != nil {
+			return
+		}
+	}
+	return wb.Flush()
+}
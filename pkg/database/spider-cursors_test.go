@@ -0,0 +1,39 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+func TestSpiderCursors(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	d := &D{DB: db}
+
+	a, b := []byte{1, 2}, []byte{3, 4}
+	if err = d.SetSpiderCursors([][]byte{a, b}, "3", 1000); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.SetSpiderCursors([][]byte{a}, "3", 2000); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.SetSpiderCursors([][]byte{b}, "*", 3000); err != nil {
+		t.Fatal(err)
+	}
+	cursors, err := d.SpiderCursors([][]byte{a, b, {5}}, "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cursors) != 2 || cursors[string(a)] != 2000 ||
+		cursors[string(b)] != 1000 {
+		t.Fatalf("unexpected cursors %v", cursors)
+	}
+	if cursors, _ = d.SpiderCursors([][]byte{a, b}, "*"); len(cursors) != 1 ||
+		cursors[string(b)] != 3000 {
+		t.Fatalf("unexpected cursors of all kinds %v", cursors)
+	}
+}
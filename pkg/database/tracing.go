@@ -0,0 +1,52 @@
+package database
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"orly.dev/pkg/database/indexes/types"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/tracing"
+)
+
+// fetcher fetches events with FetchEventBySerial inside one span that covers a
+// loop over serials, rather than one span for each event, and counts the
+// events it fetched and the serials it didn't find.
+type fetcher struct {
+	d                *D
+	st               *QueryStats
+	span             trace.Span
+	fetched, missing int
+}
+
+// fetchSpan starts the span of a loop over serials, named by pass, whose
+// events are fetched with event, and which is ended with end, which adds the
+// number of events fetched to st.
+func (d *D) fetchSpan(c context.T, pass string, st *QueryStats) (f *fetcher) {
+	f = &fetcher{d: d, st: st}
+	_, f.span = tracing.Start(
+		c, "database.FetchEventBySerial", attribute.String("pass", pass),
+	)
+	return
+}
+
+// event fetches the event of a serial, and counts whether it was found.
+func (f *fetcher) event(ser *types.Uint40) (ev *event.E, err error) {
+	if ev, err = f.d.FetchEventBySerial(ser); err != nil {
+		f.missing++
+		return
+	}
+	f.fetched++
+	return
+}
+
+// end sets the counts of the span and ends it.
+func (f *fetcher) end() {
+	f.st.Fetched += f.fetched
+	f.span.SetAttributes(
+		attribute.Int("fetched", f.fetched),
+		attribute.Int("missing", f.missing),
+	)
+	f.span.End()
+}
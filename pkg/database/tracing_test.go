@@ -0,0 +1,74 @@
+package database
+
+import (
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/tracing"
+)
+
+func TestQueryEventsTracing(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	tp := tracing.Init(exp, "test", 100)
+	defer func() {
+		_ = tp.Shutdown(context.Bg())
+		otel.SetTracerProvider(noop.NewTracerProvider())
+	}()
+	c, db, _ := testNotes(t, 3)
+	if err := tp.ForceFlush(c); err != nil {
+		t.Fatal(err)
+	}
+	exp.Reset()
+	evs, err := db.QueryEvents(c, &filter.F{Kinds: kinds.New(kind.TextNote)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evs) != 3 {
+		t.Fatalf("got %d events, expected 3", len(evs))
+	}
+	if err = tp.ForceFlush(c); err != nil {
+		t.Fatal(err)
+	}
+	spans := make(map[string]tracetest.SpanStub)
+	for _, s := range exp.GetSpans() {
+		if _, ok := spans[s.Name]; !ok {
+			spans[s.Name] = s
+		}
+	}
+	query, ok := spans["database.QueryEvents"]
+	if !ok {
+		t.Fatalf("no query span in %v", exp.GetSpans())
+	}
+	attrs := make(map[string]int64)
+	for _, a := range query.Attributes {
+		attrs[string(a.Key)] = a.Value.AsInt64()
+	}
+	if attrs["filter.kinds"] != 1 || attrs["results"] != 3 {
+		t.Errorf("unexpected query span attributes %v", query.Attributes)
+	}
+	for _, name := range []string{
+		"database.GetIndexesFromFilter", "database.GetSerialsByRange",
+		"database.FetchEventBySerial",
+	} {
+		s, ok := spans[name]
+		if !ok {
+			t.Errorf("no %s span", name)
+			continue
+		}
+		if s.Parent.SpanID() != query.SpanContext.SpanID() {
+			t.Errorf("%s span is not a child of the query span", name)
+		}
+	}
+	for _, a := range spans["database.GetSerialsByRange"].Attributes {
+		if a.Key == "rows" && a.Value.AsInt64() != 3 {
+			t.Errorf("got %d rows, expected 3", a.Value.AsInt64())
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package bech32encoding
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/text/unicode/norm"
+
+	"orly.dev/pkg/crypto/ec/bech32"
+	"orly.dev/pkg/utils"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/errorf"
+)
+
+// NcryptsecHRP is the Human Readable Prefix (HRP) for a NIP-49 password
+// encrypted nostr secret key.
+var NcryptsecHRP = []byte("ncryptsec")
+
+const (
+	// DefaultLogN is the scrypt cost used by EncryptSecretKey when none is
+	// given, 2^16 rounds, which takes about 100ms and 64MiB of memory.
+	DefaultLogN = 16
+
+	// KeySecurityInsecure marks a key known to have been handled insecurely,
+	// such as stored unencrypted or pasted into a web page.
+	KeySecurityInsecure byte = 0x00
+	// KeySecuritySecure marks a key not known to have been handled
+	// insecurely.
+	KeySecuritySecure byte = 0x01
+	// KeySecurityUnknown marks a key whose history is not tracked.
+	KeySecurityUnknown byte = 0x02
+
+	ncryptsecVersion = 0x02
+	ncryptsecLen     = 1 + 1 + 16 + 24 + 1 + 32 + 16
+)
+
+// ncryptsecKey derives the symmetric key from the password with scrypt, after
+// normalizing the password to unicode NFKC so it can be typed anywhere.
+func ncryptsecKey(password string, salt []byte, logN uint8) (
+	key []byte, err error,
+) {
+	if logN > 22 {
+		// 2^22 rounds need 4GiB of memory.
+		err = errorf.E("ncryptsec: log_n %d is too large", logN)
+		return
+	}
+	pw := norm.NFKC.Bytes([]byte(password))
+	if key, err = scrypt.Key(pw, salt, 1<<logN, 8, 1, 32); chk.E(err) {
+		return
+	}
+	return
+}
+
+// EncryptSecretKey encrypts a 32 byte secret key with a password into a
+// NIP-49 ncryptsec bech32 string.
+//
+// logN is the scrypt cost as a power of two, DefaultLogN if zero, and
+// keySecurity is one of KeySecurityInsecure, KeySecuritySecure or
+// KeySecurityUnknown.
+func EncryptSecretKey(
+	sk []byte, password string, logN uint8, keySecurity byte,
+) (ncryptsec []byte, err error) {
+	if len(sk) != 32 {
+		err = errorf.E("ncryptsec: secret key must be 32 bytes")
+		return
+	}
+	if keySecurity > KeySecurityUnknown {
+		err = errorf.E("ncryptsec: invalid key security byte %d", keySecurity)
+		return
+	}
+	if logN == 0 {
+		logN = DefaultLogN
+	}
+	b := make([]byte, 2, ncryptsecLen)
+	b[0], b[1] = ncryptsecVersion, logN
+	salt := make([]byte, 16)
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	if _, err = rand.Read(salt); chk.E(err) {
+		return
+	}
+	if _, err = rand.Read(nonce); chk.E(err) {
+		return
+	}
+	var key []byte
+	if key, err = ncryptsecKey(password, salt, logN); err != nil {
+		return
+	}
+	var aead cipher.AEAD
+	if aead, err = chacha20poly1305.NewX(key); chk.E(err) {
+		return
+	}
+	ad := []byte{keySecurity}
+	b = append(b, salt...)
+	b = append(b, nonce...)
+	b = append(b, ad...)
+	b = aead.Seal(b, nonce, sk, ad)
+	var b5 []byte
+	if b5, err = ConvertForBech32(b); chk.E(err) {
+		return
+	}
+	return bech32.Encode(NcryptsecHRP, b5)
+}
+
+// DecryptSecretKey decrypts a NIP-49 ncryptsec with its password, returning
+// the secret key and the key security byte it was stored with.
+func DecryptSecretKey(ncryptsec []byte, password string) (
+	sk []byte, keySecurity byte, err error,
+) {
+	var hrp, b5, b []byte
+	if hrp, b5, err = bech32.DecodeNoLimit(ncryptsec); chk.D(err) {
+		return
+	}
+	if !utils.FastEqual(hrp, NcryptsecHRP) {
+		err = errorf.E("ncryptsec: invalid prefix '%s'", hrp)
+		return
+	}
+	if b, err = ConvertFromBech32(b5); chk.E(err) {
+		return
+	}
+	// the padding of the bit conversion can leave a trailing zero byte.
+	if len(b) == ncryptsecLen+1 && b[ncryptsecLen] == 0 {
+		b = b[:ncryptsecLen]
+	}
+	if len(b) != ncryptsecLen {
+		err = errorf.E("ncryptsec: invalid length %d", len(b))
+		return
+	}
+	if b[0] != ncryptsecVersion {
+		err = errorf.E("ncryptsec: unknown version %d", b[0])
+		return
+	}
+	logN, salt, nonce := b[1], b[2:18], b[18:42]
+	keySecurity = b[42]
+	var key []byte
+	if key, err = ncryptsecKey(password, salt, logN); err != nil {
+		return
+	}
+	var aead cipher.AEAD
+	if aead, err = chacha20poly1305.NewX(key); chk.E(err) {
+		return
+	}
+	if sk, err = aead.Open(nil, nonce, b[43:], b[42:43]); err != nil {
+		err = errorf.E("ncryptsec: wrong password or corrupted key")
+		return
+	}
+	return
+}
+
+// IsNcryptsec returns true if s has the ncryptsec prefix.
+func IsNcryptsec[V string | []byte](s V) bool {
+	return len(s) > len(NcryptsecHRP) &&
+		string(s[:len(NcryptsecHRP)]) == string(NcryptsecHRP)
+}
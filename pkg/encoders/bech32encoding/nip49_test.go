@@ -0,0 +1,49 @@
+package bech32encoding
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/utils"
+)
+
+// TestDecryptSecretKeyVector checks the test vector of NIP-49.
+func TestDecryptSecretKeyVector(t *testing.T) {
+	sk, _, err := DecryptSecretKey(
+		[]byte("ncryptsec1qgg9947rlpvqu76pj5ecreduf9jxhselq2nae2kghhvd5g7dgjtcxfqtd67p9m0w57lspw8gsq6yphnm8623nsl8xn9j4jdzz84zm3frztj3z7s35vpzmqf6ksu8r89qk5z2zxfmu5gv8th8wclt0h4p"),
+		"nostr",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.Enc(sk) != "3501454135014541350145413501453fefb02227e449e57cf4d3a3ce05378683" {
+		t.Fatalf("unexpected secret key %0x", sk)
+	}
+}
+
+func TestEncryptSecretKey(t *testing.T) {
+	sk := make([]byte, 32)
+	if _, err := rand.Read(sk); err != nil {
+		t.Fatal(err)
+	}
+	// "ÅΩẛ̣" in two different unicode forms must normalize to the same
+	// password.
+	enc, err := EncryptSecretKey(sk, "ÅΩẛ̣", 4, KeySecuritySecure)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsNcryptsec(enc) {
+		t.Fatalf("missing prefix: %s", enc)
+	}
+	dec, ks, err := DecryptSecretKey(enc, "ÅΩṩ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !utils.FastEqual(sk, dec) || ks != KeySecuritySecure {
+		t.Fatal("round trip mismatch")
+	}
+	if _, _, err = DecryptSecretKey(enc, "wrong"); err == nil {
+		t.Fatal("expected wrong password to fail")
+	}
+}
@@ -9,12 +9,22 @@ import (
 	"orly.dev/pkg/utils/log"
 )
 
+// Signer is implemented by a signer.I that has to see the whole event rather
+// than only its ID hash, such as a NIP-46 remote signer. It must populate the
+// Pubkey, ID and Sig of the event.
+type Signer interface {
+	SignEvent(ev *E) (err error)
+}
+
 // Sign the event using the signer.I. Uses github.com/bitcoin-core/secp256k1 if
 // available for much faster signatures.
 //
 // Note that this only populates the Pubkey, ID and Sig. The caller must
 // set the CreatedAt timestamp as intended.
 func (ev *E) Sign(keys signer.I) (err error) {
+	if s, ok := keys.(Signer); ok {
+		return s.SignEvent(ev)
+	}
 	ev.Pubkey = keys.Pub()
 	ev.ID = ev.GetIDBytes()
 	if ev.Sig, err = keys.Sign(ev.ID); chk.E(err) {
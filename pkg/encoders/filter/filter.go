@@ -454,6 +454,11 @@ func (f *F) MatchesIgnoringTimestampConstraints(ev *event.E) bool {
 				continue
 			}
 			key := v.Key()
+			// filter tag keys arrive from the wire with the # prefix, as with
+			// the database indexes, match on the letter after it.
+			if len(key) == 2 && key[0] == '#' {
+				key = key[1:]
+			}
 			values := v.ToSliceOfBytes()[1:]
 			if !ev.Tags.ContainsAny(key, tag.New(values...)) {
 				return false
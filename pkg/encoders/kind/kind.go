@@ -87,6 +87,13 @@ func (k *T) IsPrivileged() (is bool) {
 	return
 }
 
+// IsGiftWrap returns true if the type is a NIP-59 gift wrap, which is signed
+// by a random single-use key and is only of interest to the recipient in its p
+// tag.
+func (k *T) IsGiftWrap() (is bool) {
+	return k.Equal(GiftWrap) || k.Equal(GiftWrapWithKind4)
+}
+
 // Marshal renders the kind.T into bytes containing the ASCII string form of the kind number.
 func (k *T) Marshal(dst []byte) (b []byte) { return ints.New(k.ToU64()).Marshal(dst) }
 
@@ -284,7 +291,10 @@ var (
 	WalletNotification     = &T{23197}
 	// NostrConnect is an event type that...
 	NostrConnect = &T{24133}
-	HTTPAuth     = &T{27235}
+	// CosignSession is an event type that carries the messages of a MuSig2
+	// signing session between the operators of a relay.
+	CosignSession = &T{24140}
+	HTTPAuth      = &T{27235}
 	// EphemeralEnd is an event type that...
 	EphemeralEnd = &T{29999}
 	// ParameterizedReplaceableStart is an event type that...
@@ -392,6 +402,7 @@ var Map = map[uint16]string{
 	WalletNotificationNip4.K:      "WalletNotificationNip4",
 	WalletNotification.K:          "WalletNotification",
 	NostrConnect.K:                "NostrConnect",
+	CosignSession.K:               "CosignSession",
 	HTTPAuth.K:                    "HTTPAuth",
 	FollowSets.K:                  "FollowSets",
 	GenericLists.K:                "GenericLists",
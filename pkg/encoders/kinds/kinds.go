@@ -149,3 +149,18 @@ func (k *T) IsPrivileged() (priv bool) {
 	}
 	return
 }
+
+// IsGiftWrap returns true if any of the elements of a kinds.T are NIP-59 gift
+// wraps, which are only served to their recipient. A nil kinds.T, which
+// matches any kind, doesn't explicitly ask for gift wraps.
+func (k *T) IsGiftWrap() (gw bool) {
+	if k == nil {
+		return
+	}
+	for i := range k.K {
+		if k.K[i].IsGiftWrap() {
+			return true
+		}
+	}
+	return
+}
@@ -35,3 +35,18 @@ func TestUnmarshalKindsArray(t *testing.T) {
 		}
 	}
 }
+
+func TestIsGiftWrap(t *testing.T) {
+	for _, tc := range []struct {
+		k  *T
+		gw bool
+	}{
+		{nil, false},
+		{New(kind.TextNote), false},
+		{New(kind.TextNote, kind.GiftWrap), true},
+	} {
+		if gw := tc.k.IsGiftWrap(); gw != tc.gw {
+			t.Errorf("%v: got %v, expected %v", tc.k, gw, tc.gw)
+		}
+	}
+}
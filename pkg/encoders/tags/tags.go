@@ -94,6 +94,9 @@ func (t *T) AddCap(i, c int) (tt *T) {
 
 // ToStringsSlice converts a tags.T to a slice of slice of strings.
 func (t *T) ToStringsSlice() (b [][]string) {
+	if t == nil {
+		return [][]string{}
+	}
 	b = make([][]string, 0, len(t.element))
 	for i := range t.element {
 		b = append(b, t.element[i].ToStringSlice())
@@ -4,6 +4,8 @@ import (
 	"net/http"
 	"orly.dev/pkg/app/config"
 	"orly.dev/pkg/app/relay/publish"
+	"orly.dev/pkg/app/relay/spider"
+	"orly.dev/pkg/app/relay/wot"
 	"orly.dev/pkg/encoders/event"
 	"orly.dev/pkg/encoders/filters"
 	"orly.dev/pkg/interfaces/relay"
@@ -41,5 +43,10 @@ type I interface {
 	PublicReadable() bool
 	ServiceURL(req *http.Request) (s string)
 	OwnersPubkeys() (pks [][]byte)
+	CanRead(ev *event.E, authedPubkey []byte, super bool) (allowed bool)
 	Config() *config.C
+	store.Configurationer
+	Spider(noFetch ...bool) (err error)
+	SpiderStatus() (st *spider.Status)
+	ExplainAccess(pk []byte) (x *wot.Explanation)
 }
@@ -26,18 +26,26 @@ func CheckPrivilege(authedPubkey []byte, ev *event.E) (privileged bool) {
 			// access the event. this is the case for nip-4, nip-44
 			// DMs, and gift-wraps. The query would usually have
 			// been for precisely a p tag with their pubkey.
-			eTags := ev.Tags.GetAll(tag.New("p"))
-			var hexAuthedKey []byte
-			hex.EncAppend(hexAuthedKey, authedPubkey)
-			for _, e := range eTags.ToSliceOfTags() {
-				if utils.FastEqual(e.Value(), hexAuthedKey) {
-					privileged = true
-					break
-				}
-			}
+			privileged = IsRecipient(authedPubkey, ev)
 		}
 	} else {
 		privileged = true
 	}
 	return
 }
+
+// IsRecipient returns true if the pubkey is named in one of the p tags of the
+// event.
+func IsRecipient(pubkey []byte, ev *event.E) (is bool) {
+	if len(pubkey) == 0 || ev.Tags == nil {
+		return
+	}
+	hexKey := hex.EncAppend(nil, pubkey)
+	pTags := ev.Tags.GetAll(tag.New("p"))
+	for _, p := range pTags.ToSliceOfTags() {
+		if utils.FastEqual(p.Value(), hexKey) {
+			return true
+		}
+	}
+	return
+}
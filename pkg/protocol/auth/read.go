@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"orly.dev/pkg/encoders/event"
+)
+
+// CanRead returns true if an event may be served to a client. It is the single
+// read authorization check used for both websocket subscriptions, where the
+// client authenticates with NIP-42, and the HTTP API, where it authenticates
+// with NIP-98. authedPubkey is empty for clients that have not authenticated.
+//
+// # Expected Behaviour
+//
+// - Gift wraps may only be read by the recipient named in their p tag, as the
+// author is a random single-use key.
+//
+// - Other privileged kinds may only be read by their author or a pubkey named
+// in one of their p tags, see CheckPrivilege.
+//
+// - Protected events, marked with a NIP-70 "-" tag, may only be read by
+// authenticated clients, as the author has restricted them to the users of
+// this relay.
+//
+// - All other events may be read by anyone.
+func CanRead(authedPubkey []byte, ev *event.E) (allowed bool) {
+	if ev.Kind.IsGiftWrap() {
+		return IsRecipient(authedPubkey, ev)
+	}
+	if !CheckPrivilege(authedPubkey, ev) {
+		return
+	}
+	if len(authedPubkey) == 0 && ev.Tags != nil &&
+		ev.Tags.ContainsProtectedMarker() {
+		return
+	}
+	return true
+}
@@ -0,0 +1,19 @@
+package auth
+
+import (
+	"testing"
+
+	"orly.dev/pkg/protocol/auth/readtester"
+)
+
+func TestCanRead(t *testing.T) {
+	cases, err := readtester.Matrix()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range cases {
+		if got := CanRead(c.Reader, c.Event); got != c.Allowed {
+			t.Errorf("%s: CanRead = %v, want %v", c.Name, got, c.Allowed)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+// Package readtester provides a matrix of events and readers with the
+// expected outcome of read authorization, shared by the tests of every
+// transport that serves events to clients so that they are all held to the
+// same rules.
+package readtester
+
+import (
+	"orly.dev/pkg/crypto/p256k"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/interfaces/signer"
+	"orly.dev/pkg/utils/chk"
+)
+
+// Case is a single entry of the read authorization matrix.
+type Case struct {
+	// Name describes the event and the reader.
+	Name string
+	// Event is the event being served.
+	Event *event.E
+	// Reader is the authenticated pubkey of the client, nil if the client has
+	// not authenticated.
+	Reader []byte
+	// Allowed is whether the event may be served to the reader.
+	Allowed bool
+	// AuthRequired is whether the relay requires auth, which only the cases
+	// of RelayMatrix vary.
+	AuthRequired bool
+}
+
+// Matrix generates a fresh set of keys and events and returns every
+// combination of event and reader with the expected outcome when auth is
+// required.
+func Matrix() (cases []Case, err error) {
+	var author, recipient, stranger, wrapper signer.I
+	for _, s := range []*signer.I{&author, &recipient, &stranger, &wrapper} {
+		sign := new(p256k.Signer)
+		if err = sign.Generate(); chk.E(err) {
+			return
+		}
+		*s = sign
+	}
+	pTag := tag.New([]byte("p"), hex.EncAppend(nil, recipient.Pub()))
+	type spec struct {
+		name string
+		sign signer.I
+		kind *kind.T
+		tags *tags.T
+		// who may read it out of the author, recipient, stranger and
+		// unauthenticated.
+		author, recipient, stranger, guest bool
+	}
+	specs := []spec{
+		{
+			"text note", author, kind.TextNote, tags.New(pTag),
+			true, true, true, true,
+		},
+		{
+			"protected note", author, kind.TextNote,
+			tags.New(tag.New("-"), pTag),
+			true, true, true, false,
+		},
+		{
+			"encrypted dm", author, kind.EncryptedDirectMessage,
+			tags.New(pTag),
+			true, true, false, false,
+		},
+		{
+			"seal", author, kind.Seal, tags.New(pTag),
+			true, true, false, false,
+		},
+		{
+			"gift wrap", wrapper, kind.GiftWrap, tags.New(pTag),
+			false, true, false, false,
+		},
+		{
+			"gift wrap with kind 4", wrapper, kind.GiftWrapWithKind4,
+			tags.New(pTag),
+			false, true, false, false,
+		},
+	}
+	for _, sp := range specs {
+		ev := &event.E{
+			CreatedAt: timestamp.Now(),
+			Kind:      sp.kind,
+			Tags:      sp.tags,
+			Content:   []byte(sp.name),
+		}
+		if err = ev.Sign(sp.sign); chk.E(err) {
+			return
+		}
+		cases = append(
+			cases,
+			Case{
+				Name: sp.name + " read by author", Event: ev,
+				Reader: author.Pub(), Allowed: sp.author, AuthRequired: true,
+			},
+			Case{
+				Name: sp.name + " read by recipient", Event: ev,
+				Reader: recipient.Pub(), Allowed: sp.recipient,
+				AuthRequired: true,
+			},
+			Case{
+				Name: sp.name + " read by stranger", Event: ev,
+				Reader: stranger.Pub(), Allowed: sp.stranger,
+				AuthRequired: true,
+			},
+			Case{
+				Name: sp.name + " read by guest", Event: ev,
+				Allowed: sp.guest, AuthRequired: true,
+			},
+		)
+	}
+	return
+}
+
+// RelayMatrix extends Matrix with the rules a relay applies on top of it, for
+// tests that serve events through a relay rather than calling auth.CanRead
+// directly.
+//
+// Every case of Matrix is returned both with auth required and without, when
+// only gift wraps are withheld from anyone but their recipient. Notes by the
+// returned hidden author, which the relay is expected to hide from readers,
+// are never allowed.
+func RelayMatrix() (cases []Case, hidden []byte, err error) {
+	var matrix []Case
+	if matrix, err = Matrix(); chk.E(err) {
+		return
+	}
+	for _, c := range matrix {
+		open := c
+		open.Name += " without auth"
+		open.AuthRequired = false
+		open.Allowed = c.Allowed || !c.Event.Kind.IsGiftWrap()
+		c.Name += " with auth required"
+		cases = append(cases, c, open)
+	}
+	sign := new(p256k.Signer)
+	if err = sign.Generate(); chk.E(err) {
+		return
+	}
+	hidden = sign.Pub()
+	ev := &event.E{
+		CreatedAt: timestamp.Now(),
+		Kind:      kind.TextNote,
+		Tags:      tags.New(),
+		Content:   []byte("hidden note"),
+	}
+	if err = ev.Sign(sign); chk.E(err) {
+		return
+	}
+	for _, authRequired := range []bool{true, false} {
+		mode := " without auth"
+		if authRequired {
+			mode = " with auth required"
+		}
+		cases = append(
+			cases,
+			Case{
+				Name: "hidden note read by author" + mode, Event: ev,
+				Reader: hidden, AuthRequired: authRequired,
+			},
+			Case{
+				Name: "hidden note read by guest" + mode, Event: ev,
+				AuthRequired: authRequired,
+			},
+		)
+	}
+	return
+}
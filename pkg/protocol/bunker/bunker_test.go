@@ -0,0 +1,197 @@
+package bunker
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"orly.dev/pkg/crypto/encryption"
+	"orly.dev/pkg/crypto/p256k"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/tests/fanout"
+	"orly.dev/pkg/utils"
+	"orly.dev/pkg/utils/context"
+)
+
+func startService(
+	t *testing.T, url, secret string,
+) (svc *Service, key *p256k.Signer) {
+	key = &p256k.Signer{}
+	if err := key.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	svc = NewService(url, key, secret)
+	if err := svc.Start(context.Bg()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(svc.Stop)
+	return
+}
+
+func TestParseURI(t *testing.T) {
+	pub := strings.Repeat("ab", 32)
+	u, err := ParseURI(
+		"bunker://" + pub + "?relay=wss://a.example&relay=wss://b.example" +
+			"&secret=s3cret",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != SchemeBunker || len(u.Relays) != 2 || u.Secret != "s3cret" {
+		t.Fatalf("unexpected parse result %+v", u)
+	}
+	var v *URI
+	if v, err = ParseURI(u.String()); err != nil {
+		t.Fatal(err)
+	}
+	if !utils.FastEqual(u.Pubkey, v.Pubkey) || v.Relays[1] != "wss://b.example" {
+		t.Fatalf("round trip mismatch %+v", v)
+	}
+	for _, bad := range []string{
+		"nostr+walletconnect://" + pub + "?relay=wss://a.example",
+		"bunker://" + pub,
+		"bunker://abcd?relay=wss://a.example",
+		"nostrconnect://" + pub + "?relay=wss://a.example",
+	} {
+		if _, err = ParseURI(bad); err == nil {
+			t.Fatalf("expected error for %s", bad)
+		}
+	}
+}
+
+func TestSignerBunker(t *testing.T) {
+	_, url := fanout.New(t)
+	svc, key := startService(t, url, "s3cret")
+	c, cancel := context.Timeout(context.Bg(), 10*time.Second)
+	defer cancel()
+	s, err := New(c, svc.ConnectURI(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if !utils.FastEqual(s.Pub(), key.Pub()) {
+		t.Fatal("signer pubkey does not match bunker key")
+	}
+	if err = s.Ping(c); err != nil {
+		t.Fatal(err)
+	}
+	ev := &event.E{
+		CreatedAt: timestamp.Now(),
+		Kind:      kind.TextNote,
+		Tags:      tags.New(tag.New("t", "bunker")),
+		Content:   []byte("signed remotely"),
+	}
+	if err = ev.Sign(s); err != nil {
+		t.Fatal(err)
+	}
+	var valid bool
+	if valid, err = ev.Verify(); err != nil || !valid {
+		t.Fatalf("remote signature invalid: %v", err)
+	}
+	if !utils.FastEqual(ev.Pubkey, key.Pub()) {
+		t.Fatal("event not signed by the bunker key")
+	}
+	if _, err = s.Sign(ev.ID); err != ErrRawSign {
+		t.Fatalf("expected ErrRawSign, got %v", err)
+	}
+	// nip44 round trip with a third party holding its key locally.
+	other := &p256k.Signer{}
+	if err = other.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	var ct, pt, ck []byte
+	if ct, err = s.Nip44Encrypt(c, other.Pub(), []byte("hi there")); err != nil {
+		t.Fatal(err)
+	}
+	if ck, err = encryption.GenerateConversationKeyWithSigner(
+		other, key.Pub(),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if pt, err = encryption.Decrypt(ct, ck); err != nil {
+		t.Fatal(err)
+	}
+	if string(pt) != "hi there" {
+		t.Fatalf("unexpected plaintext %q", pt)
+	}
+	if ct, err = encryption.Encrypt([]byte("and back"), ck); err != nil {
+		t.Fatal(err)
+	}
+	if pt, err = s.Nip44Decrypt(c, other.Pub(), ct); err != nil {
+		t.Fatal(err)
+	}
+	if string(pt) != "and back" {
+		t.Fatalf("unexpected plaintext %q", pt)
+	}
+}
+
+func TestSignerBunkerWrongSecret(t *testing.T) {
+	_, url := fanout.New(t)
+	svc, _ := startService(t, url, "s3cret")
+	u, err := ParseURI(svc.ConnectURI())
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Secret = "wrong"
+	c, cancel := context.Timeout(context.Bg(), 10*time.Second)
+	defer cancel()
+	if _, err = New(c, u.String(), nil); err == nil {
+		t.Fatal("expected connect with the wrong secret to fail")
+	}
+}
+
+func TestSignerNostrConnect(t *testing.T) {
+	relay, url := fanout.New(t)
+	svc, key := startService(t, url, "")
+	<-relay.Reqs
+	client := &p256k.Signer{}
+	if err := client.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	uri := (&URI{
+		Scheme: SchemeNostrConnect,
+		Pubkey: client.Pub(),
+		Relays: []string{url},
+		Secret: "0123456789abcdef",
+		Name:   "test",
+	}).String()
+	c, cancel := context.Timeout(context.Bg(), 10*time.Second)
+	defer cancel()
+	type result struct {
+		s   *Signer
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		s, err := New(c, uri, client)
+		done <- result{s, err}
+	}()
+	// wait until the client is subscribed before the bunker answers.
+	<-relay.Reqs
+	if err := svc.NostrConnect(uri); err != nil {
+		t.Fatal(err)
+	}
+	r := <-done
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	defer r.s.Close()
+	if !utils.FastEqual(r.s.Pub(), key.Pub()) {
+		t.Fatal("signer pubkey does not match bunker key")
+	}
+	ev := &event.E{
+		CreatedAt: timestamp.Now(),
+		Kind:      kind.TextNote,
+		Content:   []byte("signed via nostrconnect"),
+	}
+	if err := ev.Sign(r.s); err != nil {
+		t.Fatal(err)
+	}
+	if valid, err := ev.Verify(); err != nil || !valid {
+		t.Fatalf("remote signature invalid: %v", err)
+	}
+}
@@ -0,0 +1,339 @@
+package bunker
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/utils/apputil"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/errorf"
+)
+
+// Policy decides which clients a Service serves.
+type Policy interface {
+	// Connect is called when a client presents a valid secret, with the
+	// permissions it asks for, eg. "sign_event:1,nip44_encrypt", and returns an
+	// error to refuse the connection.
+	Connect(client []byte, perms string) (err error)
+	// Allow is called for every other request, and returns an error to deny
+	// it.
+	Allow(client []byte, method string, params []string) (err error)
+}
+
+// Grant is what one client may ask of the bunker.
+type Grant struct {
+	// Client is the hex pubkey of the client.
+	Client string `json:"client"`
+	// Methods the client may call, all if null. ping and get_public_key are
+	// always allowed.
+	Methods []string `json:"methods"`
+	// Kinds the client may sign with sign_event, any if null.
+	Kinds []int `json:"kinds"`
+	// Rate is the maximum number of requests per minute, 0 for unlimited.
+	Rate int `json:"rate,omitempty"`
+	// Expiry is the unix time the grant expires, 0 for never.
+	Expiry int64 `json:"expiry,omitempty"`
+	// Created is the unix time the grant was made.
+	Created int64 `json:"created"`
+}
+
+// Expired returns true if the grant has an expiry before now.
+func (g *Grant) Expired(now time.Time) bool {
+	return g.Expiry != 0 && now.Unix() >= g.Expiry
+}
+
+// Approval is a line of the approval log.
+type Approval struct {
+	Time    int64  `json:"time"`
+	Client  string `json:"client"`
+	Method  string `json:"method"`
+	Kind    *int   `json:"kind,omitempty"`
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// window counts the requests of a client in the current minute.
+type window struct {
+	start time.Time
+	count int
+}
+
+// Grants is a Policy of a Grant per client, saved to a JSON file so clients
+// stay connected across restarts. Every decision is written to Log.
+type Grants struct {
+	// Methods bounds the methods a newly connecting client is granted, all if
+	// nil.
+	Methods []string
+	// Kinds bounds the kinds a newly connecting client may sign, any if nil.
+	Kinds []int
+	// Rate is the request rate per minute given to new grants.
+	Rate int
+	// TTL is how long a new grant lasts, forever if zero.
+	TTL time.Duration
+	// Log receives an Approval JSON line for every decision, if not nil.
+	Log     io.Writer
+	path    string
+	mx      sync.Mutex
+	grants  map[string]*Grant
+	windows map[string]*window
+}
+
+// NewGrants loads the grants saved at path, which is created when the first
+// grant is made. An empty path keeps the grants in memory only.
+func NewGrants(path string) (g *Grants, err error) {
+	g = &Grants{
+		path:    path,
+		grants:  make(map[string]*Grant),
+		windows: make(map[string]*window),
+	}
+	if path == "" || !apputil.FileExists(path) {
+		return
+	}
+	var b []byte
+	if b, err = os.ReadFile(path); chk.E(err) {
+		return
+	}
+	var list []*Grant
+	if err = json.Unmarshal(b, &list); chk.E(err) {
+		return
+	}
+	for _, gr := range list {
+		g.grants[gr.Client] = gr
+	}
+	return
+}
+
+// save writes the grants to the file, via a temporary file so a crash never
+// leaves it truncated. It must be called with the lock held.
+func (g *Grants) save() (err error) {
+	if g.path == "" {
+		return
+	}
+	list := make([]*Grant, 0, len(g.grants))
+	for _, gr := range g.grants {
+		list = append(list, gr)
+	}
+	sort.Slice(
+		list, func(i, j int) bool { return list[i].Created < list[j].Created },
+	)
+	var b []byte
+	if b, err = json.MarshalIndent(list, "", "  "); chk.E(err) {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(g.path), 0700); chk.E(err) {
+		return
+	}
+	tmp := g.path + ".tmp"
+	if err = os.WriteFile(tmp, b, 0600); chk.E(err) {
+		return
+	}
+	if err = os.Rename(tmp, g.path); chk.E(err) {
+		return
+	}
+	return
+}
+
+// log writes a decision to the approval log.
+func (g *Grants) log(client []byte, method string, kind *int, reason error) {
+	if g.Log == nil {
+		return
+	}
+	a := &Approval{
+		Time:    time.Now().Unix(),
+		Client:  hex.Enc(client),
+		Method:  method,
+		Kind:    kind,
+		Allowed: reason == nil,
+	}
+	if reason != nil {
+		a.Reason = reason.Error()
+	}
+	b, err := json.Marshal(a)
+	if chk.E(err) {
+		return
+	}
+	_, _ = g.Log.Write(append(b, '\n'))
+}
+
+// intersect returns the elements of a that are in b, where a nil slice stands
+// for everything.
+func intersect[V comparable](a, b []V) (c []V) {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	c = []V{}
+	for _, v := range a {
+		if slices.Contains(b, v) {
+			c = append(c, v)
+		}
+	}
+	return
+}
+
+// parsePerms splits a NIP-46 permission list such as
+// "sign_event:1,sign_event:7,nip44_encrypt" into methods and sign_event kinds.
+// kinds is nil if sign_event is asked for without a kind.
+func parsePerms(perms string) (methods []string, kinds []int, err error) {
+	anyKind := false
+	for _, p := range strings.Split(perms, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		method, param, found := strings.Cut(p, ":")
+		if !slices.Contains(methods, method) {
+			methods = append(methods, method)
+		}
+		if method != "sign_event" {
+			continue
+		}
+		if !found {
+			anyKind = true
+			continue
+		}
+		var k int
+		if k, err = strconv.Atoi(param); err != nil {
+			err = errorf.E("invalid permission '%s'", p)
+			return
+		}
+		kinds = append(kinds, k)
+	}
+	if anyKind {
+		kinds = nil
+	}
+	return
+}
+
+// Connect grants a client the permissions it asks for, bounded by the
+// Methods and Kinds of g. A client asking for nothing in particular gets the
+// bounds themselves.
+func (g *Grants) Connect(client []byte, perms string) (err error) {
+	gr := &Grant{
+		Client:  hex.Enc(client),
+		Methods: g.Methods,
+		Kinds:   g.Kinds,
+		Rate:    g.Rate,
+		Created: time.Now().Unix(),
+	}
+	if g.TTL > 0 {
+		gr.Expiry = time.Now().Add(g.TTL).Unix()
+	}
+	if perms != "" {
+		var methods []string
+		var kinds []int
+		if methods, kinds, err = parsePerms(perms); err != nil {
+			g.log(client, "connect", nil, err)
+			return
+		}
+		gr.Methods = intersect(methods, g.Methods)
+		gr.Kinds = intersect(kinds, g.Kinds)
+	}
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	g.grants[gr.Client] = gr
+	if err = g.save(); err != nil {
+		return
+	}
+	g.log(client, "connect", nil, nil)
+	return
+}
+
+// Add makes or replaces a grant directly, without the client connecting.
+func (g *Grants) Add(gr *Grant) (err error) {
+	if gr.Created == 0 {
+		gr.Created = time.Now().Unix()
+	}
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	g.grants[gr.Client] = gr
+	return g.save()
+}
+
+// Revoke removes the grant of a client.
+func (g *Grants) Revoke(client []byte) (err error) {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	if _, ok := g.grants[hex.Enc(client)]; !ok {
+		err = errorf.E("no grant for client %0x", client)
+		return
+	}
+	delete(g.grants, hex.Enc(client))
+	delete(g.windows, hex.Enc(client))
+	return g.save()
+}
+
+// List returns the grants, oldest first.
+func (g *Grants) List() (list []*Grant) {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	for _, gr := range g.grants {
+		list = append(list, gr)
+	}
+	sort.Slice(
+		list, func(i, j int) bool { return list[i].Created < list[j].Created },
+	)
+	return
+}
+
+// Allow checks a request against the grant of the client: it must exist, not
+// be expired, cover the method and for sign_event the kind, and the client
+// must be within its rate.
+func (g *Grants) Allow(
+	client []byte, method string, params []string,
+) (err error) {
+	var kind *int
+	if method == "sign_event" && len(params) > 0 {
+		u := &unsigned{}
+		if err = json.Unmarshal([]byte(params[0]), u); err != nil {
+			err = errorf.E("invalid event")
+			g.log(client, method, nil, err)
+			return
+		}
+		kind = &u.Kind
+	}
+	defer func() { g.log(client, method, kind, err) }()
+	now := time.Now()
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	key := hex.Enc(client)
+	gr, ok := g.grants[key]
+	switch {
+	case !ok:
+		err = errorf.E("not connected")
+		return
+	case gr.Expired(now):
+		err = errorf.E("permission expired")
+		return
+	case method != "ping" && method != "get_public_key" &&
+		gr.Methods != nil && !slices.Contains(gr.Methods, method):
+		err = errorf.E("permission denied for %s", method)
+		return
+	case kind != nil && gr.Kinds != nil && !slices.Contains(gr.Kinds, *kind):
+		err = errorf.E("permission denied to sign kind %d", *kind)
+		return
+	}
+	if gr.Rate > 0 {
+		w, ok := g.windows[key]
+		if !ok || now.Sub(w.start) >= time.Minute {
+			w = &window{start: now}
+			g.windows[key] = w
+		}
+		if w.count >= gr.Rate {
+			err = errorf.E("rate limited")
+			return
+		}
+		w.count++
+	}
+	return
+}
@@ -0,0 +1,130 @@
+package bunker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/tests/fanout"
+	"orly.dev/pkg/utils/context"
+)
+
+func signParams(k int) []string {
+	return []string{
+		fmt.Sprintf(`{"kind":%d,"content":"","tags":[],"created_at":0}`, k),
+	}
+}
+
+func TestGrantsAllow(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "grants.json")
+	g, err := NewGrants(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log bytes.Buffer
+	g.Log = &log
+	g.Methods = []string{"sign_event", "nip44_encrypt"}
+	g.Kinds = []int{1, 7}
+	g.Rate = 3
+	client := bytes.Repeat([]byte{1}, 32)
+	if err = g.Allow(client, "ping", nil); err == nil {
+		t.Fatal("expected a client without a grant to be denied")
+	}
+	// asks for more than the bounds allow.
+	if err = g.Connect(
+		client, "sign_event:1,sign_event:4,nip44_encrypt,nip44_decrypt",
+	); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		method string
+		params []string
+		ok     bool
+	}{
+		{"get_public_key", nil, true},
+		{"sign_event", signParams(1), true},
+		{"sign_event", signParams(4), false},
+		{"sign_event", signParams(7), false},
+		{"nip44_decrypt", nil, false},
+		{"nip44_encrypt", nil, true},
+		// the rate of 3 per minute is used up by the three allowed requests.
+		{"ping", nil, false},
+	}
+	for i, c := range cases {
+		if err = g.Allow(client, c.method, c.params); (err == nil) != c.ok {
+			t.Fatalf("case %d %s: expected allowed %v, got %v", i, c.method, c.ok, err)
+		}
+	}
+	// the grant survives a restart.
+	var h *Grants
+	if h, err = NewGrants(path); err != nil {
+		t.Fatal(err)
+	}
+	list := h.List()
+	if len(list) != 1 || len(list[0].Kinds) != 1 || list[0].Kinds[0] != 1 {
+		t.Fatalf("unexpected reloaded grants %+v", list)
+	}
+	list[0].Expiry = time.Now().Add(-time.Second).Unix()
+	if err = h.Allow(client, "ping", nil); err == nil {
+		t.Fatal("expected an expired grant to be denied")
+	}
+	if err = h.Revoke(client); err != nil {
+		t.Fatal(err)
+	}
+	if len(h.List()) != 0 {
+		t.Fatal("expected revoke to remove the grant")
+	}
+	// the first denial, connect and one line per case.
+	lines := strings.Split(strings.TrimSpace(log.String()), "\n")
+	if len(lines) != len(cases)+2 {
+		t.Fatalf("expected %d approval log lines, got %d", len(cases)+2, len(lines))
+	}
+	a := &Approval{}
+	if err = json.Unmarshal([]byte(lines[4]), a); err != nil {
+		t.Fatal(err)
+	}
+	if a.Allowed || a.Kind == nil || *a.Kind != 4 || a.Method != "sign_event" {
+		t.Fatalf("unexpected approval %+v", a)
+	}
+}
+
+func TestServicePolicy(t *testing.T) {
+	_, url := fanout.New(t)
+	svc, _ := startService(t, url, "")
+	g, err := NewGrants("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.Kinds = []int{1}
+	svc.Policy = g
+	c, cancel := context.Timeout(context.Bg(), 10*time.Second)
+	defer cancel()
+	s, err := New(c, svc.ConnectURI(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ev := &event.E{
+		CreatedAt: timestamp.Now(),
+		Kind:      kind.TextNote,
+		Content:   []byte("allowed"),
+	}
+	if err = ev.Sign(s); err != nil {
+		t.Fatal(err)
+	}
+	ev = &event.E{
+		CreatedAt: timestamp.Now(),
+		Kind:      kind.Reaction,
+		Content:   []byte("+"),
+	}
+	if err = ev.Sign(s); err == nil {
+		t.Fatal("expected signing a kind outside the grant to fail")
+	}
+}
@@ -0,0 +1,305 @@
+package bunker
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"orly.dev/pkg/crypto/encryption"
+	"orly.dev/pkg/crypto/p256k"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/filters"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/interfaces/signer"
+	"orly.dev/pkg/protocol/ws"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/errorf"
+	"orly.dev/pkg/utils/log"
+)
+
+// Service is the signing host side of NIP-46. It holds the user key and
+// answers the requests of connected clients over a relay.
+//
+// The user key also serves as the remote signer key, so the pubkey of a
+// bunker:// URI from ConnectURI is the user pubkey.
+type Service struct {
+	// Policy, if set, decides which clients may connect and which requests
+	// they may make. Without one any client presenting the secret may make
+	// any request.
+	Policy  Policy
+	relay   string
+	key     signer.I
+	secret  string
+	client  *ws.Client
+	ctx     context.T
+	cancel  context.F
+	mx      sync.Mutex
+	clients map[string][]byte
+}
+
+// NewService creates a Service for the user key on relay. If secret is not
+// empty clients must present it to connect.
+func NewService(relay string, key signer.I, secret string) (s *Service) {
+	return &Service{
+		relay:   relay,
+		key:     key,
+		secret:  secret,
+		clients: make(map[string][]byte),
+	}
+}
+
+// ConnectURI returns the bunker:// URI clients use to connect.
+func (s *Service) ConnectURI() string {
+	return (&URI{
+		Scheme: SchemeBunker,
+		Pubkey: s.key.Pub(),
+		Relays: []string{s.relay},
+		Secret: s.secret,
+	}).String()
+}
+
+// Start connects to the relay and begins answering requests until Stop is
+// called or c is cancelled.
+func (s *Service) Start(c context.T) (err error) {
+	s.ctx, s.cancel = context.Cancel(c)
+	if s.client, err = ws.RelayConnect(s.ctx, s.relay); chk.E(err) {
+		err = errorf.E("bunker: failed to connect to relay: %v", err)
+		return
+	}
+	var sub *ws.Subscription
+	if sub, err = s.client.Subscribe(
+		s.ctx, filters.New(
+			&filter.F{
+				Kinds: kinds.New(kind.NostrConnect),
+				Tags: tags.New(
+					tag.New("p", hex.Enc(s.key.Pub())),
+				),
+				Since: &timestamp.T{V: time.Now().Unix()},
+			},
+		),
+	); chk.E(err) {
+		return
+	}
+	go s.serve(sub)
+	return
+}
+
+// Stop disconnects the Service from the relay.
+func (s *Service) Stop() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	if s.client != nil {
+		chk.E(s.client.Close())
+	}
+}
+
+// NostrConnect accepts a nostrconnect:// URI shown by a client, by sending it
+// a connect response carrying the secret of the URI.
+func (s *Service) NostrConnect(uri string) (err error) {
+	var u *URI
+	if u, err = ParseURI(uri); chk.E(err) {
+		return
+	}
+	if u.Scheme != SchemeNostrConnect {
+		err = errorf.E("bunker: not a nostrconnect URI")
+		return
+	}
+	if s.Policy != nil {
+		if err = s.Policy.Connect(u.Pubkey, u.Perms); err != nil {
+			return
+		}
+	}
+	var ck []byte
+	if ck, err = s.connect(u.Pubkey); chk.E(err) {
+		return
+	}
+	return s.respond(u.Pubkey, ck, &response{ID: "", Result: u.Secret})
+}
+
+// connect registers a client and returns its conversation key.
+func (s *Service) connect(pub []byte) (ck []byte, err error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	var ok bool
+	if ck, ok = s.clients[string(pub)]; ok {
+		return
+	}
+	if ck, err = encryption.GenerateConversationKeyWithSigner(
+		s.key, pub,
+	); chk.E(err) {
+		return
+	}
+	s.clients[string(pub)] = ck
+	return
+}
+
+// connected returns the conversation key of a client that has connected.
+func (s *Service) connected(pub []byte) (ck []byte, ok bool) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	ck, ok = s.clients[string(pub)]
+	return
+}
+
+func (s *Service) serve(sub *ws.Subscription) {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case ev := <-sub.Events:
+			if ev == nil {
+				return
+			}
+			if err := s.handle(ev); err != nil {
+				log.D.F("bunker: request from %0x: %v", ev.Pubkey, err)
+			}
+		}
+	}
+}
+
+// handle decrypts a request and sends back the response.
+func (s *Service) handle(ev *event.E) (err error) {
+	ck, ok := s.connected(ev.Pubkey)
+	if !ok {
+		if ck, err = encryption.GenerateConversationKeyWithSigner(
+			s.key, ev.Pubkey,
+		); chk.E(err) {
+			return
+		}
+	}
+	var raw []byte
+	if raw, err = encryption.Decrypt(ev.Content, ck); err != nil {
+		return
+	}
+	req := &request{}
+	if err = json.Unmarshal(raw, req); err != nil {
+		return
+	}
+	res := &response{ID: req.ID}
+	if req.Method == "connect" {
+		if s.secret != "" &&
+			(len(req.Params) < 2 || req.Params[1] != s.secret) {
+			res.Error = "invalid secret"
+			return s.respond(ev.Pubkey, ck, res)
+		}
+		if s.Policy != nil {
+			var perms string
+			if len(req.Params) > 2 {
+				perms = req.Params[2]
+			}
+			if err = s.Policy.Connect(ev.Pubkey, perms); err != nil {
+				res.Error = err.Error()
+				return s.respond(ev.Pubkey, ck, res)
+			}
+		}
+		if _, err = s.connect(ev.Pubkey); chk.E(err) {
+			return
+		}
+		res.Result = "ack"
+		return s.respond(ev.Pubkey, ck, res)
+	}
+	if s.Policy != nil {
+		// the policy remembers its clients across restarts of the service.
+		if err = s.Policy.Allow(ev.Pubkey, req.Method, req.Params); err != nil {
+			res.Error = err.Error()
+			return s.respond(ev.Pubkey, ck, res)
+		}
+	} else if !ok {
+		res.Error = "not connected"
+		return s.respond(ev.Pubkey, ck, res)
+	}
+	if res.Result, err = s.call(req.Method, req.Params); err != nil {
+		res.Error = err.Error()
+	}
+	return s.respond(ev.Pubkey, ck, res)
+}
+
+// call executes a request method of a connected client.
+func (s *Service) call(method string, params []string) (result string, err error) {
+	switch method {
+	case "ping":
+		result = "pong"
+	case "get_public_key":
+		result = hex.Enc(s.key.Pub())
+	case "sign_event":
+		if len(params) < 1 {
+			err = errorf.E("missing event")
+			return
+		}
+		u := &unsigned{}
+		if err = json.Unmarshal([]byte(params[0]), u); err != nil {
+			return
+		}
+		ev := &event.E{
+			CreatedAt: timestamp.FromUnix(u.CreatedAt),
+			Kind:      kind.New(u.Kind),
+			Content:   []byte(u.Content),
+		}
+		if len(u.Tags) > 0 {
+			ev.TagsFromStrings(u.Tags...)
+		}
+		if err = ev.Sign(s.key); chk.E(err) {
+			return
+		}
+		var b []byte
+		if b, err = json.Marshal(ev.ToEventJ()); chk.E(err) {
+			return
+		}
+		result = string(b)
+	case "nip44_encrypt", "nip44_decrypt":
+		if len(params) < 2 {
+			err = errorf.E("missing parameters")
+			return
+		}
+		var pub, ck, b []byte
+		if pub, err = p256k.HexToBin(params[0]); err != nil {
+			return
+		}
+		if ck, err = encryption.GenerateConversationKeyWithSigner(
+			s.key, pub,
+		); err != nil {
+			return
+		}
+		if method == "nip44_encrypt" {
+			b, err = encryption.Encrypt([]byte(params[1]), ck)
+		} else {
+			b, err = encryption.Decrypt([]byte(params[1]), ck)
+		}
+		if err != nil {
+			return
+		}
+		result = string(b)
+	default:
+		err = errorf.E("unsupported method: %s", method)
+	}
+	return
+}
+
+// respond encrypts and publishes a response to a client.
+func (s *Service) respond(pub, ck []byte, res *response) (err error) {
+	var b, content []byte
+	if b, err = json.Marshal(res); chk.E(err) {
+		return
+	}
+	if content, err = encryption.Encrypt(b, ck); chk.E(err) {
+		return
+	}
+	ev := &event.E{
+		Content:   content,
+		CreatedAt: timestamp.Now(),
+		Kind:      kind.NostrConnect,
+		Tags:      tags.New(tag.New("p", hex.Enc(pub))),
+	}
+	if err = ev.Sign(s.key); chk.E(err) {
+		return
+	}
+	return s.client.Publish(s.ctx, ev)
+}
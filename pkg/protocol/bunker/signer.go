@@ -0,0 +1,518 @@
+package bunker
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"orly.dev/pkg/crypto/encryption"
+	"orly.dev/pkg/crypto/p256k"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/filters"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/interfaces/signer"
+	"orly.dev/pkg/protocol/ws"
+	"orly.dev/pkg/utils"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/errorf"
+	"orly.dev/pkg/utils/log"
+)
+
+// DefaultTimeout is how long a Signer waits for the bunker to answer a
+// request. It is generous because a bunker may ask its operator to approve.
+const DefaultTimeout = 30 * time.Second
+
+var (
+	// ErrRawSign is returned by Signer.Sign because NIP-46 has no method for
+	// signing a bare hash; sign events with event.E.Sign instead.
+	ErrRawSign = errors.New("bunker: remote signer can only sign whole events")
+	// ErrNoSecret is returned by the signer.I methods that need the secret
+	// key, which never leaves the signing host.
+	ErrNoSecret = errors.New("bunker: secret key is held by the remote signer")
+)
+
+// request is the plaintext of a kind 24133 event sent to the remote signer.
+type request struct {
+	ID     string   `json:"id"`
+	Method string   `json:"method"`
+	Params []string `json:"params"`
+}
+
+// response is the plaintext of a kind 24133 event sent to the client.
+type response struct {
+	ID     string `json:"id"`
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// unsigned is the sign_event parameter, an event without id, pubkey and sig.
+type unsigned struct {
+	Kind      int        `json:"kind"`
+	Content   string     `json:"content"`
+	Tags      [][]string `json:"tags"`
+	CreatedAt int64      `json:"created_at"`
+}
+
+// Signer is a signer.I backed by a NIP-46 remote signer. Events passed to
+// event.E.Sign are sent whole to the bunker with sign_event, so the secret key
+// stays on the signing host.
+//
+// The Sec, InitSec, Generate, Sign and ECDH methods of signer.I cannot be
+// provided remotely and return an error or nil.
+type Signer struct {
+	// Timeout bounds each request to the bunker, DefaultTimeout if zero.
+	Timeout time.Duration
+	uri     *URI
+	client  signer.I
+	remote  []byte
+	user    []byte
+	rc      *ws.Client
+	sub     *ws.Subscription
+	ctx     context.T
+	cancel  context.F
+	mx      sync.Mutex
+	keys    map[string][]byte
+	pending map[string]chan *response
+	joined  chan struct{}
+}
+
+// New connects to the remote signer described by a bunker:// or
+// nostrconnect:// URI.
+//
+// # Parameters
+//
+//   - c: the context bounding the lifetime of the session.
+//
+//   - uri: the connection token.
+//
+//   - client: the local key identifying this client to the bunker. For
+//     bunker:// a fresh one is generated if it is nil; for nostrconnect:// it
+//     is required and must match the pubkey of the URI.
+//
+// # Return Values
+//
+//   - s: the connected Signer, whose Pub is the user pubkey.
+//
+//   - err: an error if the URI is invalid, no relay could be reached or the
+//     bunker did not accept the connection.
+//
+// # Expected Behaviour:
+//
+// - bunker:// sends a connect request with the secret and perms of the URI.
+//
+// - nostrconnect:// waits until the remote signer answers with the secret of
+// the URI, which identifies the remote signer pubkey.
+//
+// - Either way, the user pubkey is then requested with get_public_key.
+func New(c context.T, uri string, client signer.I) (s *Signer, err error) {
+	var u *URI
+	if u, err = ParseURI(uri); chk.E(err) {
+		return
+	}
+	if client == nil {
+		if u.Scheme == SchemeNostrConnect {
+			err = errorf.E("bunker: nostrconnect requires the client key")
+			return
+		}
+		client = &p256k.Signer{}
+		if err = client.Generate(); chk.E(err) {
+			return
+		}
+	}
+	if u.Scheme == SchemeNostrConnect &&
+		!utils.FastEqual(client.Pub(), u.Pubkey) {
+		err = errorf.E("bunker: client key does not match nostrconnect URI")
+		return
+	}
+	s = &Signer{
+		uri:     u,
+		client:  client,
+		keys:    make(map[string][]byte),
+		pending: make(map[string]chan *response),
+		joined:  make(chan struct{}),
+	}
+	if u.Scheme == SchemeBunker {
+		s.remote = u.Pubkey
+	}
+	s.ctx, s.cancel = context.Cancel(c)
+	if err = s.dial(); err != nil {
+		s.Close()
+		s = nil
+		return
+	}
+	go s.receive()
+	if err = s.join(); err != nil {
+		s.Close()
+		s = nil
+		return
+	}
+	return
+}
+
+// dial connects to the first reachable relay of the URI and subscribes to
+// messages addressed to the client key.
+func (s *Signer) dial() (err error) {
+	for _, r := range s.uri.Relays {
+		if s.rc, err = ws.RelayConnect(s.ctx, r); err == nil {
+			break
+		}
+		log.W.F("bunker: failed to connect to %s: %v", r, err)
+	}
+	if s.rc == nil {
+		err = errorf.E("bunker: no relay of %v could be reached", s.uri.Relays)
+		return
+	}
+	if s.sub, err = s.rc.Subscribe(
+		s.ctx, filters.New(
+			&filter.F{
+				Kinds: kinds.New(kind.NostrConnect),
+				Tags: tags.New(
+					tag.New("p", hex.Enc(s.client.Pub())),
+				),
+				Since: &timestamp.T{V: time.Now().Unix()},
+			},
+		),
+	); chk.E(err) {
+		return
+	}
+	return
+}
+
+// join performs the connection handshake and fetches the user pubkey.
+func (s *Signer) join() (err error) {
+	if s.uri.Scheme == SchemeBunker {
+		params := []string{hex.Enc(s.remote), s.uri.Secret, s.uri.Perms}
+		for len(params) > 1 && params[len(params)-1] == "" {
+			params = params[:len(params)-1]
+		}
+		var res string
+		if res, err = s.Request(s.ctx, "connect", params...); err != nil {
+			return
+		}
+		if res != "ack" && res != s.uri.Secret {
+			err = errorf.E("bunker: unexpected connect result '%s'", res)
+			return
+		}
+	} else {
+		ctx, cancel := context.Timeout(s.ctx, s.timeout())
+		defer cancel()
+		select {
+		case <-s.joined:
+		case <-ctx.Done():
+			err = errorf.E("bunker: remote signer did not connect")
+			return
+		}
+	}
+	if s.user, err = s.GetPublicKey(s.ctx); err != nil {
+		return
+	}
+	return
+}
+
+func (s *Signer) timeout() time.Duration {
+	if s.Timeout > 0 {
+		return s.Timeout
+	}
+	return DefaultTimeout
+}
+
+// conversationKey returns the cached NIP-44 conversation key for pub.
+func (s *Signer) conversationKey(pub []byte) (ck []byte, err error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	var ok bool
+	if ck, ok = s.keys[string(pub)]; ok {
+		return
+	}
+	if ck, err = encryption.GenerateConversationKeyWithSigner(
+		s.client, pub,
+	); chk.E(err) {
+		return
+	}
+	s.keys[string(pub)] = ck
+	return
+}
+
+// receive decrypts the responses from the remote signer and hands them to
+// the waiting requests.
+func (s *Signer) receive() {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case ev := <-s.sub.Events:
+			if ev == nil {
+				return
+			}
+			s.mx.Lock()
+			remote := s.remote
+			s.mx.Unlock()
+			if remote != nil && !utils.FastEqual(ev.Pubkey, remote) {
+				continue
+			}
+			var err error
+			var ck, raw []byte
+			if ck, err = s.conversationKey(ev.Pubkey); chk.E(err) {
+				continue
+			}
+			if raw, err = encryption.Decrypt(ev.Content, ck); err != nil {
+				log.D.F("bunker: undecryptable message from %0x", ev.Pubkey)
+				continue
+			}
+			res := &response{}
+			if err = json.Unmarshal(raw, res); chk.E(err) {
+				continue
+			}
+			if remote == nil {
+				// nostrconnect: the remote signer identifies itself by
+				// answering with the secret of the URI.
+				if res.Result == s.uri.Secret {
+					s.mx.Lock()
+					s.remote = ev.Pubkey
+					s.mx.Unlock()
+					close(s.joined)
+				}
+				continue
+			}
+			if res.Result == "auth_url" {
+				log.I.F(
+					"bunker: request %s must be authorized at %s", res.ID,
+					res.Error,
+				)
+				continue
+			}
+			s.mx.Lock()
+			ch, ok := s.pending[res.ID]
+			delete(s.pending, res.ID)
+			s.mx.Unlock()
+			if ok {
+				ch <- res
+			}
+		}
+	}
+}
+
+// Request sends a method call to the remote signer and waits for its result.
+func (s *Signer) Request(
+	c context.T, method string, params ...string,
+) (result string, err error) {
+	ctx, cancel := context.Timeout(c, s.timeout())
+	defer cancel()
+	id := make([]byte, 16)
+	if _, err = rand.Read(id); chk.E(err) {
+		return
+	}
+	req := &request{ID: hex.Enc(id), Method: method, Params: params}
+	if req.Params == nil {
+		req.Params = []string{}
+	}
+	var b, content, ck []byte
+	if b, err = json.Marshal(req); chk.E(err) {
+		return
+	}
+	if ck, err = s.conversationKey(s.remote); err != nil {
+		return
+	}
+	if content, err = encryption.Encrypt(b, ck); chk.E(err) {
+		return
+	}
+	ev := &event.E{
+		Content:   content,
+		CreatedAt: timestamp.Now(),
+		Kind:      kind.NostrConnect,
+		Tags:      tags.New(tag.New("p", hex.Enc(s.remote))),
+	}
+	if err = ev.Sign(s.client); chk.E(err) {
+		return
+	}
+	ch := make(chan *response, 1)
+	s.mx.Lock()
+	s.pending[req.ID] = ch
+	s.mx.Unlock()
+	defer func() {
+		s.mx.Lock()
+		delete(s.pending, req.ID)
+		s.mx.Unlock()
+	}()
+	if err = s.rc.Publish(ctx, ev); err != nil {
+		err = errorf.E("bunker: %s: publish failed: %v", method, err)
+		return
+	}
+	select {
+	case <-ctx.Done():
+		err = errorf.E("bunker: %s: no response from remote signer", method)
+		return
+	case res := <-ch:
+		if res.Error != "" {
+			err = errorf.E("bunker: %s: %s", method, res.Error)
+			return
+		}
+		result = res.Result
+	}
+	return
+}
+
+// Ping checks the remote signer is responding.
+func (s *Signer) Ping(c context.T) (err error) {
+	var res string
+	if res, err = s.Request(c, "ping"); err != nil {
+		return
+	}
+	if res != "pong" {
+		err = errorf.E("bunker: unexpected ping result '%s'", res)
+	}
+	return
+}
+
+// GetPublicKey asks the remote signer for the user pubkey.
+func (s *Signer) GetPublicKey(c context.T) (pub []byte, err error) {
+	var res string
+	if res, err = s.Request(c, "get_public_key"); err != nil {
+		return
+	}
+	if pub, err = p256k.HexToBin(res); err != nil || len(pub) != 32 {
+		err = errorf.E("bunker: invalid public key '%s'", res)
+		return
+	}
+	return
+}
+
+// SignEvent has the remote signer sign ev, implementing event.Signer. The
+// returned signature is only accepted if it is valid for the user pubkey over
+// exactly the event that was sent.
+func (s *Signer) SignEvent(ev *event.E) (err error) {
+	u := &unsigned{
+		Kind:      ev.Kind.ToInt(),
+		Content:   string(ev.Content),
+		Tags:      [][]string{},
+		CreatedAt: ev.CreatedAt.I64(),
+	}
+	if ev.Tags != nil && ev.Tags.Len() > 0 {
+		u.Tags = ev.Tags.ToStringsSlice()
+	} else {
+		// an empty tags.T does not have the canonical encoding of no tags, so
+		// the ID would not match what the remote signer computes.
+		ev.Tags = nil
+	}
+	var b []byte
+	if b, err = json.Marshal(u); chk.E(err) {
+		return
+	}
+	var res string
+	if res, err = s.Request(s.ctx, "sign_event", string(b)); err != nil {
+		return
+	}
+	j := &event.J{}
+	if err = json.Unmarshal([]byte(res), j); chk.E(err) {
+		return
+	}
+	var signed *event.E
+	if signed, err = j.ToEvent(); chk.E(err) {
+		return
+	}
+	ev.Pubkey = s.user
+	ev.ID = ev.GetIDBytes()
+	if !utils.FastEqual(signed.ID, ev.ID) {
+		err = errorf.E("bunker: remote signer returned a different event")
+		return
+	}
+	ev.Sig = signed.Sig
+	var valid bool
+	if valid, err = ev.Verify(); err != nil || !valid {
+		err = errorf.E("bunker: remote signer returned an invalid signature")
+		return
+	}
+	return
+}
+
+// Nip44Encrypt has the remote signer encrypt plaintext to pub.
+func (s *Signer) Nip44Encrypt(
+	c context.T, pub, plaintext []byte,
+) (ciphertext []byte, err error) {
+	var res string
+	if res, err = s.Request(
+		c, "nip44_encrypt", hex.Enc(pub), string(plaintext),
+	); err != nil {
+		return
+	}
+	ciphertext = []byte(res)
+	return
+}
+
+// Nip44Decrypt has the remote signer decrypt ciphertext from pub.
+func (s *Signer) Nip44Decrypt(
+	c context.T, pub, ciphertext []byte,
+) (plaintext []byte, err error) {
+	var res string
+	if res, err = s.Request(
+		c, "nip44_decrypt", hex.Enc(pub), string(ciphertext),
+	); err != nil {
+		return
+	}
+	plaintext = []byte(res)
+	return
+}
+
+// Close ends the session with the remote signer.
+func (s *Signer) Close() {
+	s.cancel()
+	if s.sub != nil {
+		s.sub.Unsub()
+	}
+	if s.rc != nil {
+		chk.E(s.rc.Close())
+	}
+}
+
+// Generate is not possible for a remote signer.
+func (s *Signer) Generate() (err error) { return ErrNoSecret }
+
+// InitSec is not possible for a remote signer.
+func (s *Signer) InitSec(sec []byte) (err error) { return ErrNoSecret }
+
+// InitPub is not possible for a remote signer, the pubkey is provided by
+// get_public_key.
+func (s *Signer) InitPub(pub []byte) (err error) { return ErrNoSecret }
+
+// Sec returns nil, the secret key never leaves the remote signer.
+func (s *Signer) Sec() []byte { return nil }
+
+// Pub returns the user pubkey reported by the remote signer.
+func (s *Signer) Pub() []byte { return s.user }
+
+// Sign returns ErrRawSign, NIP-46 can only sign whole events.
+func (s *Signer) Sign(msg []byte) (sig []byte, err error) {
+	err = ErrRawSign
+	return
+}
+
+// Verify checks a signature on msg against the user pubkey.
+func (s *Signer) Verify(msg, sig []byte) (valid bool, err error) {
+	v := &p256k.Signer{}
+	if err = v.InitPub(s.user); chk.E(err) {
+		return
+	}
+	return v.Verify(msg, sig)
+}
+
+// Zero wipes the client key and closes the session.
+func (s *Signer) Zero() {
+	s.Close()
+	s.client.Zero()
+}
+
+// ECDH is not possible for a remote signer, use Nip44Encrypt and
+// Nip44Decrypt instead.
+func (s *Signer) ECDH(pub []byte) (secret []byte, err error) {
+	err = ErrNoSecret
+	return
+}
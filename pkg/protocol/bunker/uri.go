@@ -0,0 +1,104 @@
+// Package bunker implements NIP-46 remote signing: a signer.I that forwards
+// signing and encryption requests to a bunker over a relay, and a Service that
+// answers them on the signing host.
+package bunker
+
+import (
+	"net/url"
+	"strings"
+
+	"orly.dev/pkg/crypto/p256k"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/errorf"
+)
+
+const (
+	// SchemeBunker is the URI scheme of a connection token handed out by the
+	// remote signer, where the host is the remote signer pubkey.
+	SchemeBunker = "bunker"
+	// SchemeNostrConnect is the URI scheme of a connection token handed out
+	// by the client, where the host is the client pubkey.
+	SchemeNostrConnect = "nostrconnect"
+)
+
+// URI is a parsed bunker:// or nostrconnect:// connection token.
+type URI struct {
+	// Scheme is either SchemeBunker or SchemeNostrConnect.
+	Scheme string
+	// Pubkey is the remote signer pubkey for bunker:// and the client pubkey
+	// for nostrconnect://.
+	Pubkey []byte
+	// Relays the two parties communicate over.
+	Relays []string
+	// Secret is the optional single-use connection secret.
+	Secret string
+	// Perms is the comma separated list of permissions requested by the
+	// client, eg. "sign_event:1,nip44_encrypt".
+	Perms string
+	// Name is the client application name.
+	Name string
+}
+
+// ParseURI decodes a bunker:// or nostrconnect:// URI.
+func ParseURI(s string) (u *URI, err error) {
+	var p *url.URL
+	if p, err = url.Parse(s); chk.E(err) {
+		return
+	}
+	u = &URI{Scheme: p.Scheme}
+	if u.Scheme != SchemeBunker && u.Scheme != SchemeNostrConnect {
+		err = errorf.E("bunker: incorrect scheme '%s'", p.Scheme)
+		return
+	}
+	if u.Pubkey, err = p256k.HexToBin(p.Host); chk.E(err) {
+		err = errorf.E("bunker: invalid public key '%s'", p.Host)
+		return
+	}
+	if len(u.Pubkey) != 32 {
+		err = errorf.E("bunker: invalid public key '%s'", p.Host)
+		return
+	}
+	query := p.Query()
+	for _, r := range query["relay"] {
+		if r = strings.TrimSpace(r); r != "" {
+			u.Relays = append(u.Relays, r)
+		}
+	}
+	if len(u.Relays) == 0 {
+		err = errorf.E("bunker: missing relay parameter")
+		return
+	}
+	u.Secret = query.Get("secret")
+	if u.Scheme == SchemeNostrConnect && u.Secret == "" {
+		// the secret is how the client recognises the signer's connect
+		// response, so it is mandatory for nostrconnect.
+		err = errorf.E("bunker: nostrconnect URI requires a secret")
+		return
+	}
+	u.Perms = query.Get("perms")
+	u.Name = query.Get("name")
+	return
+}
+
+// String encodes the URI back into its connection token form.
+func (u *URI) String() string {
+	query := url.Values{}
+	for _, r := range u.Relays {
+		query.Add("relay", r)
+	}
+	if u.Secret != "" {
+		query.Set("secret", u.Secret)
+	}
+	if u.Perms != "" {
+		query.Set("perms", u.Perms)
+	}
+	if u.Name != "" {
+		query.Set("name", u.Name)
+	}
+	return (&url.URL{
+		Scheme:   u.Scheme,
+		Host:     hex.Enc(u.Pubkey),
+		RawQuery: query.Encode(),
+	}).String()
+}
@@ -0,0 +1,136 @@
+package cosign
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"orly.dev/pkg/crypto/p256k"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/tests/fanout"
+	"orly.dev/pkg/utils"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/errorf"
+)
+
+// startOperators starts a Signer for each of n new operator keys.
+func startOperators(t *testing.T, url string, n int) (signers []*Signer) {
+	var operators [][]byte
+	var keys []*p256k.Signer
+	for range n {
+		k := &p256k.Signer{}
+		if err := k.Generate(); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, k)
+		operators = append(operators, k.Pub())
+	}
+	for _, k := range keys {
+		s, err := New(k, operators, url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Timeout = 5 * time.Second
+		s.Approve = func(ev *event.E) error { return nil }
+		if err = s.Start(context.Bg()); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(s.Stop)
+		signers = append(signers, s)
+	}
+	return
+}
+
+func TestAggregateKey(t *testing.T) {
+	var operators [][]byte
+	for range 3 {
+		k := &p256k.Signer{}
+		if err := k.Generate(); err != nil {
+			t.Fatal(err)
+		}
+		operators = append(operators, k.Pub())
+	}
+	a, err := AggregateKey(operators)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := AggregateKey(
+		[][]byte{operators[2], operators[0], operators[1]},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !utils.FastEqual(a, b) {
+		t.Fatal("aggregate key depends on the order of the operators")
+	}
+	if _, err = AggregateKey(operators[:1]); err == nil {
+		t.Fatal("expected a single operator to be rejected")
+	}
+	if _, err = AggregateKey(
+		[][]byte{operators[0], operators[0]},
+	); err == nil {
+		t.Fatal("expected a repeated operator to be rejected")
+	}
+}
+
+func TestSignEvent(t *testing.T) {
+	r, url := fanout.New(t)
+	signers := startOperators(t, url, 3)
+	for range signers {
+		<-r.Reqs
+	}
+	agg, err := AggregateKey(signers[0].Operators())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// any operator can start a session, with keys of either Y parity.
+	for i, s := range signers {
+		if !utils.FastEqual(s.Pub(), agg) {
+			t.Fatalf("operator %d has a different aggregate key", i)
+		}
+		ev := &event.E{
+			CreatedAt: timestamp.Now(),
+			Kind:      kind.RelayListMetadata,
+			Content:   []byte("co-signed"),
+		}
+		if err = ev.Sign(s); err != nil {
+			t.Fatal(err)
+		}
+		if !utils.FastEqual(ev.Pubkey, agg) {
+			t.Fatal("event is not signed by the aggregate key")
+		}
+		var valid bool
+		if valid, err = ev.Verify(); err != nil || !valid {
+			t.Fatalf("co-signed event does not verify: %v", err)
+		}
+	}
+}
+
+func TestSignEventRefused(t *testing.T) {
+	r, url := fanout.New(t)
+	signers := startOperators(t, url, 3)
+	for range signers {
+		<-r.Reqs
+	}
+	signers[2].Approve = func(ev *event.E) error {
+		if ev.Kind.Equal(kind.TextNote) {
+			return errorf.E("not signing notes")
+		}
+		return nil
+	}
+	ev := &event.E{
+		CreatedAt: timestamp.Now(),
+		Kind:      kind.TextNote,
+		Content:   []byte("refused"),
+	}
+	start := time.Now()
+	if err := ev.Sign(signers[0]); err == nil ||
+		!strings.Contains(err.Error(), "not signing notes") {
+		t.Fatalf("expected the refusal to be returned, got %v", err)
+	}
+	if time.Since(start) >= signers[0].Timeout {
+		t.Fatal("a refusal should not wait for the timeout")
+	}
+}
@@ -0,0 +1,90 @@
+// Package cosign provides a relay operator key that is the MuSig2 aggregate
+// of the keys of several operators, so that nothing is signed with it unless
+// every operator co-signs. The operators run each signing session by
+// exchanging ephemeral events over a relay.
+package cosign
+
+import (
+	"bytes"
+	"sort"
+
+	"orly.dev/pkg/crypto/ec"
+	"orly.dev/pkg/crypto/ec/musig2"
+	"orly.dev/pkg/crypto/ec/schnorr"
+	"orly.dev/pkg/crypto/ec/secp256k1"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/errorf"
+)
+
+// sortOperators returns a sorted copy of the x-only operator pubkeys, so the
+// aggregate key does not depend on the order the operators were listed in.
+func sortOperators(operators [][]byte) (sorted [][]byte, err error) {
+	if len(operators) < 2 {
+		err = errorf.E("cosign: at least two operators are needed")
+		return
+	}
+	sorted = make([][]byte, len(operators))
+	copy(sorted, operators)
+	sort.Slice(
+		sorted, func(i, j int) bool {
+			return bytes.Compare(sorted[i], sorted[j]) < 0
+		},
+	)
+	for i := range sorted {
+		if len(sorted[i]) != schnorr.PubKeyBytesLen {
+			err = errorf.E("cosign: operator pubkey must be 32 bytes")
+			return
+		}
+		if i > 0 && bytes.Equal(sorted[i], sorted[i-1]) {
+			err = errorf.E("cosign: operator %0x is listed twice", sorted[i])
+			return
+		}
+	}
+	return
+}
+
+// parseOperators lifts the sorted x-only pubkeys to the points with an even
+// Y coordinate, which is what a nostr pubkey stands for.
+func parseOperators(sorted [][]byte) (keys []*btcec.PublicKey, err error) {
+	for _, op := range sorted {
+		var pk *btcec.PublicKey
+		if pk, err = schnorr.ParsePubKey(op); chk.E(err) {
+			err = errorf.E("cosign: invalid operator pubkey %0x: %v", op, err)
+			return
+		}
+		keys = append(keys, pk)
+	}
+	return
+}
+
+// AggregateKey returns the x-only MuSig2 aggregate of the x-only pubkeys of
+// the operators, which is the pubkey of the events they co-sign. It is the
+// same whatever order the operators are given in.
+func AggregateKey(operators [][]byte) (pub []byte, err error) {
+	var sorted [][]byte
+	if sorted, err = sortOperators(operators); err != nil {
+		return
+	}
+	var keys []*btcec.PublicKey
+	if keys, err = parseOperators(sorted); err != nil {
+		return
+	}
+	var agg *musig2.AggregateKey
+	if agg, _, _, err = musig2.AggregateKeys(keys, false); chk.E(err) {
+		return
+	}
+	pub = schnorr.SerializePubKey(agg.FinalKey)
+	return
+}
+
+// evenKey returns the secret key for sec whose public key has an even Y
+// coordinate, negating it if needed, so it matches the x-only pubkey of the
+// operator in the key set.
+func evenKey(sec []byte) (sk *btcec.SecretKey) {
+	var pk *btcec.PublicKey
+	sk, pk = btcec.SecKeyFromBytes(sec)
+	if pk.SerializeCompressed()[0] == secp256k1.PubKeyFormatCompressedOdd {
+		sk.Key.Negate()
+	}
+	return
+}
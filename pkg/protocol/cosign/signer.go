@@ -0,0 +1,637 @@
+package cosign
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"orly.dev/pkg/crypto/ec"
+	"orly.dev/pkg/crypto/ec/musig2"
+	"orly.dev/pkg/crypto/ec/schnorr"
+	"orly.dev/pkg/crypto/p256k"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/filters"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/interfaces/signer"
+	"orly.dev/pkg/protocol/ws"
+	"orly.dev/pkg/utils"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/errorf"
+	"orly.dev/pkg/utils/log"
+)
+
+// DefaultTimeout is how long a signing session waits for the other operators.
+const DefaultTimeout = 30 * time.Second
+
+var (
+	// ErrRawSign is returned by Signer.Sign because the other operators must
+	// see the whole event to decide whether to co-sign it; sign events with
+	// event.E.Sign instead.
+	ErrRawSign = errors.New("cosign: the operator key can only sign whole events")
+	// ErrNoSecret is returned by the signer.I methods that need the secret
+	// key, which no single operator has.
+	ErrNoSecret = errors.New("cosign: the operator key has no single secret key")
+)
+
+// the steps of a signing session.
+const (
+	stepRequest = "request"
+	stepNonce   = "nonce"
+	stepPartial = "partial"
+	stepRefuse  = "refuse"
+)
+
+// message is the content of a kind 24140 event between operators.
+//
+// The operator starting a session sends a request, carrying the canonical
+// form of the event to sign and its public nonce, to all the others. Each
+// operator that approves the event sends its public nonce to all the others,
+// and once it has every nonce, its partial signature to the one that started
+// the session, which combines them. An operator that does not approve sends a
+// refusal instead.
+type message struct {
+	Session string          `json:"session"`
+	Step    string          `json:"step"`
+	Event   json.RawMessage `json:"event,omitempty"`
+	Nonce   string          `json:"nonce,omitempty"`
+	Partial string          `json:"partial,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// session is the state of one signing session.
+type session struct {
+	initiator []byte
+	msg       []byte
+	ms        *musig2.Session
+	// nonces and partials are the ones received, which are registered with
+	// ms once it exists and, for partials, has signed.
+	nonces     map[string][musig2.PubNonceSize]byte
+	registered map[string]bool
+	partials   map[string]*musig2.PartialSignature
+	combined   map[string]bool
+	signed     bool
+	done       chan error
+	created    time.Time
+}
+
+// finish hands the outcome of a session to the waiting initiator. Only the
+// first outcome counts.
+func (sess *session) finish(err error) {
+	if sess.done == nil {
+		return
+	}
+	select {
+	case sess.done <- err:
+	default:
+	}
+}
+
+// Signer is a signer.I whose pubkey is the MuSig2 aggregate of the keys of
+// the operators. Signing an event runs a session with the other operators
+// over a relay, and only succeeds if all of them co-sign.
+//
+// Every operator runs a Signer with its own key, which also answers the
+// sessions the others start, co-signing the events Approve accepts.
+//
+// The Sec, InitSec, InitPub, Generate, Sign and ECDH methods of signer.I
+// cannot be provided for an aggregate key and return an error or nil.
+type Signer struct {
+	// Timeout bounds each signing session, DefaultTimeout if zero.
+	Timeout time.Duration
+	// Approve decides whether to co-sign an event another operator asks for.
+	// If it is nil every request is refused.
+	Approve   func(ev *event.E) (err error)
+	relay     string
+	key       signer.I
+	operators [][]byte
+	mctx      *musig2.Context
+	pub       []byte
+	rc        *ws.Client
+	ctx       context.T
+	cancel    context.F
+	mx        sync.Mutex
+	sessions  map[string]*session
+}
+
+// New creates a Signer for an operator.
+//
+// # Parameters
+//
+//   - key: the key of this operator, which must be one of operators and hold
+//     its secret key.
+//
+//   - operators: the x-only pubkeys of all the operators, including this one.
+//
+//   - relay: the relay the operators exchange session messages on.
+//
+// # Return Values
+//
+//   - s: the Signer, which must be started with Start before it is used.
+//
+//   - err: an error if the key is not one of at least two distinct operators.
+func New(key signer.I, operators [][]byte, relay string) (
+	s *Signer, err error,
+) {
+	if len(key.Sec()) != btcec.SecKeyBytesLen {
+		err = errorf.E("cosign: the operator key has no secret key")
+		return
+	}
+	var sorted [][]byte
+	if sorted, err = sortOperators(operators); err != nil {
+		return
+	}
+	var found bool
+	for _, op := range sorted {
+		if utils.FastEqual(op, key.Pub()) {
+			found = true
+		}
+	}
+	if !found {
+		err = errorf.E("cosign: %0x is not one of the operators", key.Pub())
+		return
+	}
+	var keys []*btcec.PublicKey
+	if keys, err = parseOperators(sorted); err != nil {
+		return
+	}
+	s = &Signer{
+		relay:     relay,
+		key:       key,
+		operators: sorted,
+		sessions:  make(map[string]*session),
+	}
+	if s.mctx, err = musig2.NewContext(
+		evenKey(key.Sec()), false, musig2.WithKnownSigners(keys),
+	); chk.E(err) {
+		return
+	}
+	var agg *btcec.PublicKey
+	if agg, err = s.mctx.CombinedKey(); chk.E(err) {
+		return
+	}
+	s.pub = schnorr.SerializePubKey(agg)
+	return
+}
+
+// Start connects to the relay and begins answering the sessions of the other
+// operators until Stop is called or c is cancelled.
+func (s *Signer) Start(c context.T) (err error) {
+	s.ctx, s.cancel = context.Cancel(c)
+	if s.rc, err = ws.RelayConnect(s.ctx, s.relay); chk.E(err) {
+		err = errorf.E("cosign: failed to connect to relay: %v", err)
+		return
+	}
+	var sub *ws.Subscription
+	if sub, err = s.rc.Subscribe(
+		s.ctx, filters.New(
+			&filter.F{
+				Kinds: kinds.New(kind.CosignSession),
+				Tags: tags.New(
+					tag.New("p", hex.Enc(s.key.Pub())),
+				),
+				Since: &timestamp.T{V: time.Now().Unix()},
+			},
+		),
+	); chk.E(err) {
+		return
+	}
+	go s.receive(sub)
+	return
+}
+
+// Stop disconnects the Signer from the relay.
+func (s *Signer) Stop() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	if s.rc != nil {
+		chk.E(s.rc.Close())
+	}
+}
+
+// Operators returns the x-only pubkeys of the operators, sorted.
+func (s *Signer) Operators() [][]byte { return s.operators }
+
+func (s *Signer) timeout() time.Duration {
+	if s.Timeout > 0 {
+		return s.Timeout
+	}
+	return DefaultTimeout
+}
+
+// send publishes a session message to the given operators.
+func (s *Signer) send(to [][]byte, m *message) (err error) {
+	var b []byte
+	if b, err = json.Marshal(m); chk.E(err) {
+		return
+	}
+	t := tags.New()
+	for _, op := range to {
+		t.AppendTags(tag.New("p", hex.Enc(op)))
+	}
+	ev := &event.E{
+		Content:   b,
+		CreatedAt: timestamp.Now(),
+		Kind:      kind.CosignSession,
+		Tags:      t,
+	}
+	if err = ev.Sign(s.key); chk.E(err) {
+		return
+	}
+	ctx, cancel := context.Timeout(s.ctx, s.timeout())
+	defer cancel()
+	if err = s.rc.Publish(ctx, ev); err != nil {
+		err = errorf.E("cosign: %s: publish failed: %v", m.Step, err)
+		return
+	}
+	return
+}
+
+// others returns the operators other than this one.
+func (s *Signer) others() (to [][]byte) {
+	for _, op := range s.operators {
+		if !utils.FastEqual(op, s.key.Pub()) {
+			to = append(to, op)
+		}
+	}
+	return
+}
+
+// SignEvent signs ev with the aggregate key, implementing event.Signer. It
+// returns once every operator has co-signed, or with an error if one refused
+// or the session timed out.
+func (s *Signer) SignEvent(ev *event.E) (err error) {
+	if s.rc == nil {
+		err = errorf.E("cosign: signer is not started")
+		return
+	}
+	if ev.Tags != nil && ev.Tags.Len() == 0 {
+		// an empty tags.T does not have the canonical encoding of no tags.
+		ev.Tags = nil
+	}
+	ev.Pubkey = s.pub
+	ev.ID = ev.GetIDBytes()
+	id := make([]byte, 16)
+	if _, err = rand.Read(id); chk.E(err) {
+		return
+	}
+	sess := &session{
+		initiator:  s.key.Pub(),
+		msg:        ev.ID,
+		nonces:     make(map[string][musig2.PubNonceSize]byte),
+		registered: make(map[string]bool),
+		partials:   make(map[string]*musig2.PartialSignature),
+		combined:   make(map[string]bool),
+		done:       make(chan error, 1),
+		created:    time.Now(),
+	}
+	if sess.ms, err = s.mctx.NewSession(); chk.E(err) {
+		return
+	}
+	nonce := sess.ms.PublicNonce()
+	s.mx.Lock()
+	s.sessions[hex.Enc(id)] = sess
+	s.mx.Unlock()
+	defer func() {
+		s.mx.Lock()
+		delete(s.sessions, hex.Enc(id))
+		s.mx.Unlock()
+	}()
+	if err = s.send(
+		s.others(), &message{
+			Session: hex.Enc(id),
+			Step:    stepRequest,
+			Event:   ev.ToCanonical(nil),
+			Nonce:   hex.Enc(nonce[:]),
+		},
+	); err != nil {
+		return
+	}
+	ctx, cancel := context.Timeout(s.ctx, s.timeout())
+	defer cancel()
+	select {
+	case <-ctx.Done():
+		err = errorf.E("cosign: not every operator co-signed in time")
+		return
+	case err = <-sess.done:
+		if err != nil {
+			return
+		}
+	}
+	ev.Sig = sess.ms.FinalSig().Serialize()
+	return
+}
+
+func (s *Signer) receive(sub *ws.Subscription) {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case ev := <-sub.Events:
+			if ev == nil {
+				return
+			}
+			if err := s.handle(ev); err != nil {
+				log.D.F("cosign: message from %0x: %v", ev.Pubkey, err)
+			}
+		}
+	}
+}
+
+// operator returns true if pub is one of the other operators.
+func (s *Signer) operator(pub []byte) bool {
+	for _, op := range s.others() {
+		if utils.FastEqual(op, pub) {
+			return true
+		}
+	}
+	return false
+}
+
+// prune forgets the sessions that outlived their timeout, such as the ones
+// buffering nonces of a request that never arrived. It must be called with
+// the lock held.
+func (s *Signer) prune() {
+	for id, sess := range s.sessions {
+		if sess.done == nil && time.Since(sess.created) > 2*s.timeout() {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+// outgoing is a session message waiting to be sent once the lock is
+// released.
+type outgoing struct {
+	to [][]byte
+	m  *message
+}
+
+// handle processes a session message from another operator. The messages
+// it leads to are sent after the lock is released, so a slow relay does not
+// hold up the other sessions.
+func (s *Signer) handle(ev *event.E) (err error) {
+	if !s.operator(ev.Pubkey) {
+		err = errorf.E("not an operator")
+		return
+	}
+	var valid bool
+	if valid, err = ev.Verify(); err != nil || !valid {
+		err = errorf.E("invalid signature")
+		return
+	}
+	m := &message{}
+	if err = json.Unmarshal(ev.Content, m); err != nil {
+		return
+	}
+	s.mx.Lock()
+	out, err := s.process(ev.Pubkey, m)
+	s.mx.Unlock()
+	for _, o := range out {
+		if e := s.send(o.to, o.m); e != nil {
+			err = e
+		}
+	}
+	return
+}
+
+// process applies a session message from the operator from, and returns the
+// messages to send in reply. It must be called with the lock held.
+func (s *Signer) process(from []byte, m *message) (
+	out []outgoing, err error,
+) {
+	s.prune()
+	sess, ok := s.sessions[m.Session]
+	if !ok {
+		if m.Step != stepRequest && m.Step != stepNonce {
+			return
+		}
+		sess = &session{
+			nonces:     make(map[string][musig2.PubNonceSize]byte),
+			registered: make(map[string]bool),
+			partials:   make(map[string]*musig2.PartialSignature),
+			combined:   make(map[string]bool),
+			created:    time.Now(),
+		}
+		s.sessions[m.Session] = sess
+	}
+	switch m.Step {
+	case stepRequest:
+		if sess.initiator != nil {
+			err = errorf.E("duplicate request for session %s", m.Session)
+			return
+		}
+		sess.initiator = from
+		if err = s.accept(sess, m); err != nil {
+			delete(s.sessions, m.Session)
+			out = append(
+				out, outgoing{
+					[][]byte{from}, &message{
+						Session: m.Session, Step: stepRefuse,
+						Error: err.Error(),
+					},
+				},
+			)
+			err = nil
+			return
+		}
+		if err = s.addNonce(sess, from, m.Nonce); err != nil {
+			return
+		}
+		nonce := sess.ms.PublicNonce()
+		out = append(
+			out, outgoing{
+				s.others(), &message{
+					Session: m.Session, Step: stepNonce,
+					Nonce: hex.Enc(nonce[:]),
+				},
+			},
+		)
+	case stepNonce:
+		if err = s.addNonce(sess, from, m.Nonce); err != nil {
+			return
+		}
+	case stepPartial:
+		if sess.done == nil {
+			return
+		}
+		var b []byte
+		if b, err = hex.Dec(m.Partial); err != nil {
+			return
+		}
+		ps := &musig2.PartialSignature{}
+		if err = ps.Decode(bytes.NewReader(b)); err != nil || ps.S == nil {
+			err = errorf.E("invalid partial signature")
+			return
+		}
+		sess.partials[string(from)] = ps
+	case stepRefuse:
+		sess.finish(
+			errorf.E("cosign: operator %0x refused: %s", from, m.Error),
+		)
+		return
+	default:
+		return
+	}
+	var partial *outgoing
+	if partial, err = s.advance(m.Session, sess); partial != nil {
+		out = append(out, *partial)
+	}
+	return
+}
+
+// accept checks the event of a request and starts the local half of the
+// session.
+func (s *Signer) accept(sess *session, m *message) (err error) {
+	ev := &event.E{}
+	if _, err = ev.FromCanonical(m.Event); err != nil {
+		err = errorf.E("invalid event")
+		return
+	}
+	if !utils.FastEqual(ev.Pubkey, s.pub) {
+		err = errorf.E("event is not for the operator key")
+		return
+	}
+	ev.ID = event.Hash(m.Event)
+	if s.Approve == nil {
+		err = errorf.E("no approval policy")
+		return
+	}
+	if err = s.Approve(ev); err != nil {
+		return
+	}
+	sess.msg = ev.ID
+	if sess.ms, err = s.mctx.NewSession(); chk.E(err) {
+		return
+	}
+	return
+}
+
+// addNonce buffers the public nonce of an operator.
+func (s *Signer) addNonce(sess *session, from []byte, nonce string) (
+	err error,
+) {
+	var b []byte
+	if b, err = hex.Dec(nonce); err != nil || len(b) != musig2.PubNonceSize {
+		err = errorf.E("invalid nonce")
+		return
+	}
+	if _, ok := sess.nonces[string(from)]; ok {
+		return
+	}
+	var n [musig2.PubNonceSize]byte
+	copy(n[:], b)
+	sess.nonces[string(from)] = n
+	return
+}
+
+// advance registers what has arrived for a session, signs once every nonce
+// is in, and either returns the partial signature to send to the initiator
+// or, for the initiator, combines the partial signatures. It must be called
+// with the lock held.
+func (s *Signer) advance(id string, sess *session) (
+	partial *outgoing, err error,
+) {
+	if sess.ms == nil {
+		return
+	}
+	for from, n := range sess.nonces {
+		if sess.registered[from] {
+			continue
+		}
+		sess.registered[from] = true
+		if _, err = sess.ms.RegisterPubNonce(n); chk.E(err) {
+			return
+		}
+	}
+	if !sess.signed {
+		if sess.ms.NumRegisteredNonces() < len(s.operators) {
+			return
+		}
+		var msg [32]byte
+		copy(msg[:], sess.msg)
+		var ps *musig2.PartialSignature
+		if ps, err = sess.ms.Sign(msg); chk.E(err) {
+			return
+		}
+		sess.signed = true
+		if sess.done == nil {
+			// only the initiator needs the partial signatures.
+			delete(s.sessions, id)
+			buf := new(bytes.Buffer)
+			if err = ps.Encode(buf); chk.E(err) {
+				return
+			}
+			partial = &outgoing{
+				[][]byte{sess.initiator}, &message{
+					Session: id, Step: stepPartial,
+					Partial: hex.Enc(buf.Bytes()),
+				},
+			}
+			return
+		}
+	}
+	for from, ps := range sess.partials {
+		if sess.combined[from] {
+			continue
+		}
+		sess.combined[from] = true
+		var all bool
+		if all, err = sess.ms.CombineSig(ps); err != nil {
+			sess.finish(errorf.E("cosign: invalid partial signature: %v", err))
+			return
+		}
+		if all {
+			sess.finish(nil)
+		}
+	}
+	return
+}
+
+// Pub returns the aggregate pubkey of the operators.
+func (s *Signer) Pub() []byte { return s.pub }
+
+// Sec returns nil, no operator has the secret key of the aggregate key.
+func (s *Signer) Sec() []byte { return nil }
+
+// Sign returns ErrRawSign.
+func (s *Signer) Sign(msg []byte) (sig []byte, err error) {
+	err = ErrRawSign
+	return
+}
+
+// Verify checks a signature against the aggregate pubkey.
+func (s *Signer) Verify(msg, sig []byte) (valid bool, err error) {
+	v := &p256k.Signer{}
+	if err = v.InitPub(s.pub); chk.E(err) {
+		return
+	}
+	return v.Verify(msg, sig)
+}
+
+// Generate returns ErrNoSecret.
+func (s *Signer) Generate() (err error) { return ErrNoSecret }
+
+// InitSec returns ErrNoSecret.
+func (s *Signer) InitSec(sec []byte) (err error) { return ErrNoSecret }
+
+// InitPub returns ErrNoSecret.
+func (s *Signer) InitPub(pub []byte) (err error) { return ErrNoSecret }
+
+// ECDH returns ErrNoSecret.
+func (s *Signer) ECDH(pub []byte) (secret []byte, err error) {
+	err = ErrNoSecret
+	return
+}
+
+// Zero stops the Signer.
+func (s *Signer) Zero() { s.Stop() }
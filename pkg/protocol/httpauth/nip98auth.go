@@ -19,8 +19,10 @@ const (
 	NIP98Prefix = "Nostr"
 )
 
-// MakeNIP98Event creates a new NIP-98 event. If expiry is given, method is
-// ignored; otherwise either option is the same.
+// MakeNIP98Event creates a new NIP-98 event. If expiry is given, the event is
+// an expiring token and method may be empty, to allow its use with any method;
+// it should be given for tokens used on write routes of servers that require
+// it.
 func MakeNIP98Event(u, method, hash string, expiry int64) (ev *event.E) {
 	var t []*tag.T
 	t = append(t, tag.New("u", u))
@@ -29,7 +31,8 @@ func MakeNIP98Event(u, method, hash string, expiry int64) (ev *event.E) {
 			t,
 			tag.New("expiration", timestamp.FromUnix(expiry).String()),
 		)
-	} else {
+	}
+	if expiry <= 0 || method != "" {
 		t = append(
 			t,
 			tag.New("method", strings.ToUpper(method)),
@@ -1,9 +1,263 @@
 package httpauth
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
 	"testing"
+	"time"
+
+	"orly.dev/pkg/crypto/p256k"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/interfaces/signer"
 )
 
+const testURL = "http://example.com/api/event"
+
+func newSigner(t *testing.T) (sign signer.I) {
+	s := new(p256k.Signer)
+	if err := s.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func bodyHash(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.Enc(sum[:])
+}
+
+// newRequest makes a request with a body and a NIP-98 header made with the
+// given method, payload hash and expiry.
+func newRequest(
+	t *testing.T, sign signer.I, method, body, hash string, expiry int64,
+) (r *http.Request) {
+	var rb io.Reader
+	if body != "" {
+		rb = strings.NewReader(body)
+	}
+	r = httptest.NewRequest(http.MethodPost, testURL, rb)
+	ur, _ := url.Parse(testURL)
+	if err := AddNIP98Header(r, ur, method, hash, sign, expiry); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
 func TestMakeNIP98Request_ValidateNIP98Request(t *testing.T) {
+	sign := newSigner(t)
+	body := `{"kind":1}`
+	r := newRequest(t, sign, "POST", body, bodyHash([]byte(body)), 0)
+	valid, pubkey, err := CheckAuth(r)
+	if err != nil || !valid {
+		t.Fatalf("valid %v err %v", valid, err)
+	}
+	if !bytes.Equal(pubkey, sign.Pub()) {
+		t.Fatalf("got pubkey %0x, want %0x", pubkey, sign.Pub())
+	}
+	// the body must still be readable by the handler.
+	var b []byte
+	if b, err = io.ReadAll(r.Body); err != nil || string(b) != body {
+		t.Fatalf("body after check %q err %v", b, err)
+	}
+}
+
+func TestCheckAuthPayload(t *testing.T) {
+	sign := newSigner(t)
+	body := `{"kind":1}`
+	tests := []struct {
+		name   string
+		body   string
+		hash   string
+		expiry int64
+		valid  bool
+		write  bool
+	}{
+		{"matching payload", body, bodyHash([]byte(body)), 0, true, false},
+		{
+			"payload of other body", body, bodyHash([]byte("other")), 0, false,
+			false,
+		},
+		{"body without payload", body, "", 0, false, false},
+		{"no body without payload", "", "", 0, true, false},
+		{
+			"expiring token without payload", body, "",
+			time.Now().Add(time.Hour).Unix(), true, false,
+		},
+		{
+			"expiring token with wrong payload", body,
+			bodyHash([]byte("other")), time.Now().Add(time.Hour).Unix(), false,
+			false,
+		},
+		{
+			"expiring token without payload on a write route", body, "",
+			time.Now().Add(time.Hour).Unix(), false, true,
+		},
+		{
+			"expiring token with payload on a write route", body,
+			bodyHash([]byte(body)), time.Now().Add(time.Hour).Unix(), true,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				r := newRequest(t, sign, "POST", tt.body, tt.hash, tt.expiry)
+				if tt.write {
+					r = ForWrite(r)
+				}
+				valid, _, err := CheckAuth(r)
+				if valid != tt.valid {
+					t.Fatalf("valid %v, want %v, err %v", valid, tt.valid, err)
+				}
+			},
+		)
+	}
+}
+
+func TestCheckAuthHashBody(t *testing.T) {
+	sign := newSigner(t)
+	body := strings.Repeat("x", MaxMemoryBody+10)
+	r := newRequest(t, sign, "POST", body, bodyHash([]byte(body)), 0)
+	if err := HashBody(r); err != nil {
+		t.Fatal(err)
+	}
+	b, ok := r.Body.(*Body)
+	if !ok || b.file == nil {
+		t.Fatal("expected large body to be spooled to a file")
+	}
+	defer b.Close()
+	// consume the body as a framework decoding it would before the check.
+	if _, err := io.Copy(io.Discard, r.Body); err != nil {
+		t.Fatal(err)
+	}
+	if valid, _, err := CheckAuth(r); !valid {
+		t.Fatalf("payload of consumed body not verified: %v", err)
+	}
+}
+
+func TestCheckAuthBodyLimit(t *testing.T) {
+	sign := newSigner(t)
+	body := `{"kind":1}`
+	r := newRequest(t, sign, "POST", body, bodyHash([]byte(body)), 0)
+	r.ContentLength = MaxBody + 1
+	if err := HashBody(r); !errors.Is(err, ErrBodyTooLarge) {
+		t.Fatalf("expected the body to be too large, got %v", err)
+	}
+}
+
+func TestHashBodyOnlyWithPayload(t *testing.T) {
+	sign := newSigner(t)
+	body := strings.Repeat("x", MaxMemoryBody+10)
+	r := newRequest(t, sign, "POST", body, "", 0)
+	if err := HashBody(r); err != nil {
+		t.Fatal(err)
+	}
+	if b, ok := r.Body.(*Body); !ok || b.Hash != nil {
+		t.Fatal("body hashed for an auth event without a payload tag")
+	}
+	if valid, _, _ := CheckAuth(r); valid {
+		t.Fatal("body without payload accepted")
+	}
+	b, err := io.ReadAll(r.Body)
+	if err != nil || string(b) != body {
+		t.Fatalf("body after check has %d bytes, err %v", len(b), err)
+	}
+}
+
+func TestSpooledBodyReleased(t *testing.T) {
+	sign := newSigner(t)
+	body := strings.Repeat("x", MaxMemoryBody+10)
+	r := newRequest(t, sign, "POST", body, bodyHash([]byte(body)), 0)
+	c, cancel := context.WithCancel(r.Context())
+	r = r.WithContext(c)
+	if valid, _, err := CheckAuth(r); !valid {
+		t.Fatal(err)
+	}
+	b, ok := r.Body.(*Body)
+	if !ok || b.file == nil {
+		t.Fatal("expected large body to be spooled to a file")
+	}
+	name := b.file.Name()
+	// the request is done.
+	cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("spooled body was not removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCheckAuthReplay(t *testing.T) {
+	sign := newSigner(t)
+	r := newRequest(t, sign, "POST", "", "", 0)
+	if valid, _, err := CheckAuth(r); !valid {
+		t.Fatalf("first use rejected: %v", err)
+	}
+	replay := httptest.NewRequest(http.MethodPost, testURL, nil)
+	replay.Header.Set(HeaderKey, r.Header.Get(HeaderKey))
+	if valid, _, _ := CheckAuth(replay); valid {
+		t.Fatal("replayed auth event accepted")
+	}
+	// expiring tokens are meant to be used more than once.
+	exp := newRequest(t, sign, "", "", "", time.Now().Add(time.Hour).Unix())
+	for range 2 {
+		again := httptest.NewRequest(http.MethodPost, testURL, nil)
+		again.Header.Set(HeaderKey, exp.Header.Get(HeaderKey))
+		if valid, _, err := CheckAuth(again); !valid {
+			t.Fatalf("expiring token rejected: %v", err)
+		}
+	}
+}
+
+func TestCheckAuthRequireMethod(t *testing.T) {
+	sign := newSigner(t)
+	expiry := time.Now().Add(time.Hour).Unix()
+	o := Options{RequireMethod: true}
+	r := newRequest(t, sign, "", "", "", expiry)
+	if valid, _, _ := Check(r, o); valid {
+		t.Fatal("expiring token without method accepted")
+	}
+	r = newRequest(t, sign, "GET", "", "", expiry)
+	if valid, _, _ := Check(r, o); valid {
+		t.Fatal("expiring token with other method accepted")
+	}
+	r = newRequest(t, sign, "POST", "", "", expiry)
+	if valid, _, err := Check(r, o); !valid {
+		t.Fatalf("expiring token with method rejected: %v", err)
+	}
+}
 
+func TestReplayCacheBounded(t *testing.T) {
+	c := NewReplayCache(4)
+	until := time.Now().Add(time.Minute)
+	for i := range 10 {
+		if c.Seen([]byte{byte(i)}, until) {
+			t.Fatalf("fresh id %d reported as replayed", i)
+		}
+	}
+	if c.Len() != 4 {
+		t.Fatalf("cache holds %d ids, want 4", c.Len())
+	}
+	if !c.Seen([]byte{9}, until) {
+		t.Fatal("recent id not reported as replayed")
+	}
+	if c.Seen([]byte{10}, time.Now().Add(-time.Second)) {
+		t.Fatal("fresh id reported as replayed")
+	}
+	if c.Seen([]byte{10}, until) {
+		t.Fatal("expired id reported as replayed")
+	}
 }
@@ -0,0 +1,215 @@
+package httpauth
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"orly.dev/pkg/encoders/tag"
+)
+
+// MaxMemoryBody is the largest request body that HashBody keeps in memory,
+// larger bodies are spooled to a temporary file.
+const MaxMemoryBody = 4 << 20
+
+// MaxBody is the largest request body that is read ahead to check the payload
+// tag of an auth event.
+const MaxBody = 256 << 20
+
+var ErrBodyTooLarge = fmt.Errorf(
+	"request body is larger than the limit of %d bytes", MaxBody,
+)
+
+// Body is a request body that has been read ahead to compute its sha256 hash
+// for the NIP-98 payload tag, and can be read again by the request handler.
+type Body struct {
+	io.Reader
+	// Hash is the sha256 hash of the body, or nil if only the first byte of
+	// the body was read to find whether it has one.
+	Hash []byte
+	// Size is the length of a hashed body.
+	Size int64
+	// nonEmpty is whether a body that was not hashed has any content.
+	nonEmpty bool
+	rc       io.ReadCloser
+	file     *os.File
+	once     sync.Once
+}
+
+// Close closes the original body, and releases the temporary file of a
+// spooled body. It may be called more than once.
+func (b *Body) Close() (err error) {
+	b.once.Do(
+		func() {
+			if b.rc != nil {
+				err = b.rc.Close()
+			}
+			if b.file != nil {
+				err = b.file.Close()
+				_ = os.Remove(b.file.Name())
+			}
+		},
+	)
+	return
+}
+
+// HashBody replaces the body of a request carrying a NIP-98 authorization
+// header with a Body, so the payload tag can be checked after the body has
+// been consumed, as happens when an API framework decodes it before the
+// handler runs. It does nothing if the request has no body or no NIP-98
+// header, or the body has already been hashed.
+//
+// The body is only hashed if the auth event has a payload tag and a valid
+// signature, otherwise only its first byte is read, to find whether the
+// request has a body that the payload tag is missing for. A spooled body is
+// released when the request is done.
+func HashBody(r *http.Request) (err error) {
+	val := r.Header.Get(HeaderKey)
+	if !strings.HasPrefix(val, NIP98Prefix) {
+		return
+	}
+	ev, err := authEvent(val)
+	if err != nil {
+		// Check reports the invalid header.
+		return peekBody(r)
+	}
+	if ev.Tags.GetFirst(tag.New("payload")) == nil {
+		return peekBody(r)
+	}
+	if ok, _ := ev.Verify(); !ok {
+		return peekBody(r)
+	}
+	return hashBody(r)
+}
+
+// readBody reads a body into a Body, computing its hash, keeping it in memory
+// up to MaxMemoryBody and spooling it to a temporary file beyond that, up to
+// MaxBody.
+func readBody(rc io.ReadCloser) (b *Body, err error) {
+	defer rc.Close()
+	h := sha256.New()
+	var buf bytes.Buffer
+	var n int64
+	if n, err = io.Copy(
+		io.MultiWriter(h, &buf), io.LimitReader(rc, MaxMemoryBody+1),
+	); err != nil {
+		return
+	}
+	if n <= MaxMemoryBody {
+		b = &Body{Reader: &buf, Hash: h.Sum(nil), Size: n}
+		return
+	}
+	var f *os.File
+	if f, err = os.CreateTemp("", "nip98-body-*"); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+	// the buffered head of the body is already hashed.
+	if _, err = buf.WriteTo(f); err != nil {
+		return
+	}
+	if n, err = io.Copy(
+		io.MultiWriter(h, f), io.LimitReader(rc, MaxBody-n+1),
+	); err != nil {
+		return
+	}
+	var fi os.FileInfo
+	if fi, err = f.Stat(); err != nil {
+		return
+	}
+	if fi.Size() > MaxBody {
+		err = ErrBodyTooLarge
+		return
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	b = &Body{Reader: f, Hash: h.Sum(nil), Size: fi.Size(), file: f}
+	return
+}
+
+// payloadHash returns the sha256 hash of the body of a request. If HashBody
+// has not already hashed it, the body is read and replaced so the handler can
+// still read it.
+func payloadHash(r *http.Request) (sum []byte, err error) {
+	if err = hashBody(r); err != nil {
+		return
+	}
+	if b, ok := r.Body.(*Body); ok {
+		return b.Hash, nil
+	}
+	empty := sha256.Sum256(nil)
+	return empty[:], nil
+}
+
+// hasBody returns true if the request has a non-empty body, reading no more
+// than its first byte.
+func hasBody(r *http.Request) (has bool, err error) {
+	if err = peekBody(r); err != nil {
+		return
+	}
+	if b, ok := r.Body.(*Body); ok {
+		if b.Hash != nil {
+			return b.Size > 0, nil
+		}
+		return b.nonEmpty, nil
+	}
+	return
+}
+
+// hashBody replaces the body of a request with a hashed Body, unless it has
+// already been hashed.
+func hashBody(r *http.Request) (err error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return
+	}
+	if b, ok := r.Body.(*Body); ok && b.Hash != nil {
+		return
+	}
+	if r.ContentLength > MaxBody {
+		return ErrBodyTooLarge
+	}
+	var b *Body
+	if b, err = readBody(r.Body); err != nil {
+		return
+	}
+	r.Body = b
+	if b.file != nil {
+		context.AfterFunc(r.Context(), func() { b.Close() })
+	}
+	return
+}
+
+// peekBody replaces the body of a request with a Body that has read its first
+// byte, unless it has already been replaced.
+func peekBody(r *http.Request) (err error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return
+	}
+	if _, ok := r.Body.(*Body); ok {
+		return
+	}
+	var p [1]byte
+	var n int
+	if n, err = io.ReadFull(r.Body, p[:]); err == io.EOF {
+		err = nil
+	} else if err != nil {
+		return
+	}
+	r.Body = &Body{
+		Reader: io.MultiReader(bytes.NewReader(p[:n]), r.Body), rc: r.Body,
+		nonEmpty: n > 0,
+	}
+	return
+}
@@ -0,0 +1,64 @@
+package httpauth
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultReplayCacheSize is the number of auth event IDs remembered by the
+// Replays cache.
+const DefaultReplayCacheSize = 1 << 16
+
+// Replays is the cache of auth events already used, consulted by Check.
+var Replays = NewReplayCache(DefaultReplayCacheSize)
+
+// ReplayCache is a bounded set of the IDs of NIP-98 auth events that have been
+// used, each remembered until the event would fall outside of the tolerance
+// window and be rejected anyway.
+//
+// When the cache is full the oldest entry is evicted, so a flood of fresh auth
+// events can make an older one replayable again before it expires; the size
+// should comfortably exceed the number of requests expected within the
+// tolerance window.
+type ReplayCache struct {
+	mx    sync.Mutex
+	max   int
+	seen  map[string]time.Time
+	order []string
+}
+
+// NewReplayCache creates a ReplayCache holding at most max event IDs.
+func NewReplayCache(max int) (c *ReplayCache) {
+	return &ReplayCache{max: max, seen: make(map[string]time.Time)}
+}
+
+// Seen records an event ID as used until the given time, and returns true if
+// it was already recorded and has not yet expired.
+func (c *ReplayCache) Seen(id []byte, until time.Time) (replayed bool) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	now := time.Now()
+	if exp, ok := c.seen[string(id)]; ok && now.Before(exp) {
+		return true
+	}
+	// drop expired entries from the front, and the oldest if still full.
+	for len(c.order) > 0 {
+		front := c.order[0]
+		if exp, ok := c.seen[front]; ok && now.Before(exp) &&
+			len(c.seen) < c.max {
+			break
+		}
+		delete(c.seen, front)
+		c.order = c.order[1:]
+	}
+	c.seen[string(id)] = until
+	c.order = append(c.order, string(id))
+	return
+}
+
+// Len returns the number of event IDs in the cache.
+func (c *ReplayCache) Len() int {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return len(c.seen)
+}
@@ -1,10 +1,12 @@
 package httpauth
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
 	"net/http"
 	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/hex"
 	"orly.dev/pkg/encoders/ints"
 	"orly.dev/pkg/encoders/kind"
 	"orly.dev/pkg/encoders/tag"
@@ -19,6 +21,17 @@ var ErrMissingKey = fmt.Errorf(
 	"'%s' key missing from request header", HeaderKey,
 )
 
+// Options are the settings for Check.
+type Options struct {
+	// Tolerance is the allowed divergence of the created_at of an auth event
+	// from the current time, or the grace period after the expiration of an
+	// expiring auth event. One minute if zero.
+	Tolerance time.Duration
+	// RequireMethod requires the method tag to match the request even on
+	// expiring auth events, which otherwise may be used with any method.
+	RequireMethod bool
+}
+
 // CheckAuth verifies a received http.Request has got a valid authentication
 // event in it, with an optional specification for tolerance of before and
 // after, and provides the public key that should be verified to be authorized
@@ -26,6 +39,27 @@ var ErrMissingKey = fmt.Errorf(
 func CheckAuth(r *http.Request, tolerance ...time.Duration) (
 	valid bool,
 	pubkey []byte, err error,
+) {
+	var o Options
+	if len(tolerance) > 0 {
+		o.Tolerance = tolerance[0]
+	}
+	return Check(r, o)
+}
+
+// Check verifies a received http.Request has got a valid authentication event
+// in it, and provides the public key that should be verified to be authorized
+// to access the resource associated with the request.
+//
+// Besides the checks of NIP-98, the payload tag is verified against the sha256
+// hash of the request body, and is required for requests with a body unless
+// the auth event is expiring and the request is not marked with ForWrite. The
+// body is only read after the auth event is otherwise valid, and no more than
+// MaxBody of it. Auth events that are not expiring may only be used once, see
+// Replays.
+func Check(r *http.Request, o Options) (
+	valid bool,
+	pubkey []byte, err error,
 ) {
 	val := r.Header.Get(HeaderKey)
 	if val == "" {
@@ -33,52 +67,15 @@ func CheckAuth(r *http.Request, tolerance ...time.Duration) (
 		valid = true
 		return
 	}
-	if len(tolerance) == 0 {
-		tolerance = append(tolerance, time.Minute)
+	if o.Tolerance == 0 {
+		o.Tolerance = time.Minute
 	}
-	// log.I.S(tolerance)
-	if tolerance[0] == 0 {
-		tolerance[0] = time.Minute
-	}
-	tolerate := int64(tolerance[0] / time.Second)
+	tolerate := int64(o.Tolerance / time.Second)
 	log.T.C(func() string { return fmt.Sprintf("validating auth '%s'", val) })
 	switch {
 	case strings.HasPrefix(val, NIP98Prefix):
-		split := strings.Split(val, " ")
-		if len(split) == 1 {
-			err = errorf.E(
-				"missing nip-98 auth event from '%s' http header key: '%s'",
-				HeaderKey, val,
-			)
-		}
-		if len(split) > 2 {
-			err = errorf.E(
-				"extraneous content after second field space separated: %s",
-				val,
-			)
-			return
-		}
-		var evb []byte
-		if evb, err = base64.URLEncoding.DecodeString(split[1]); chk.E(err) {
-			return
-		}
-		ev := event.New()
-		var rem []byte
-		if rem, err = ev.Unmarshal(evb); chk.E(err) {
-			return
-		}
-		if len(rem) > 0 {
-			err = errorf.E("rem", rem)
-			return
-		}
-		// log.T.F("received http auth event:\n%s\n", ev.SerializeIndented())
-		// The kind MUST be 27235.
-		if !ev.Kind.Equal(kind.HTTPAuth) {
-			err = errorf.E(
-				"invalid kind %d %s in nip-98 http auth event, require %d %s",
-				ev.Kind.K, ev.Kind.Name(), kind.HTTPAuth.K,
-				kind.HTTPAuth.Name(),
-			)
+		var ev *event.E
+		if ev, err = authEvent(val); chk.E(err) {
 			return
 		}
 		// if there is an expiration timestamp, check it supersedes the
@@ -95,7 +92,7 @@ func CheckAuth(r *http.Request, tolerance ...time.Duration) (
 		if exp.Len() == 1 {
 			ex := ints.New(0)
 			exp1 := exp.ToSliceOfTags()[0]
-			if rem, err = ex.Unmarshal(exp1.Value()); chk.E(err) {
+			if _, err = ex.Unmarshal(exp1.Value()); chk.E(err) {
 				return
 			}
 			tn := time.Now().Unix()
@@ -159,13 +156,13 @@ func CheckAuth(r *http.Request, tolerance ...time.Duration) (
 			)
 			return
 		}
-		if !expiring {
+		if !expiring || o.RequireMethod {
 			// The method tag MUST be the same HTTP method used for the
 			// requested resource.
 			mt := ev.Tags.GetAll(tag.New("method"))
 			if mt.Len() != 1 {
 				err = errorf.E(
-					"more than one \"method\" tag found: '%s'",
+					"require one \"method\" tag, found: '%s'",
 					mt.MarshalTo(nil),
 				)
 				return
@@ -179,12 +176,57 @@ func CheckAuth(r *http.Request, tolerance ...time.Duration) (
 				return
 			}
 		}
-		if valid, err = ev.Verify(); chk.E(err) {
+		var ok bool
+		if ok, err = ev.Verify(); chk.E(err) {
 			return
 		}
-		if !valid {
+		if !ok {
 			return
 		}
+		// The payload tag, if present, MUST be the sha256 hash of the body.
+		pt := ev.Tags.GetAll(tag.New("payload"))
+		if pt.Len() > 1 {
+			err = errorf.E(
+				"more than one \"payload\" tag found: '%s'",
+				pt.MarshalTo(nil),
+			)
+			return
+		}
+		if pt.Len() == 1 {
+			var sum []byte
+			if sum, err = payloadHash(r); chk.E(err) {
+				return
+			}
+			pts := pt.ToSliceOfTags()
+			if !strings.EqualFold(string(pts[0].Value()), hex.Enc(sum)) {
+				err = errorf.E(
+					"request body has hash %0x but event has payload %s",
+					sum, pts[0].Value(),
+				)
+				return
+			}
+		} else if !expiring || IsWrite(r) {
+			// expiring auth events may be used again, so on routes that write
+			// they are bound to the body by its payload tag.
+			var has bool
+			if has, err = hasBody(r); chk.E(err) {
+				return
+			}
+			if has {
+				err = errorf.E(
+					"payload tag is required for a request with a body",
+				)
+				return
+			}
+		}
+		// an auth event for a single request may not be used again.
+		if !expiring && Replays.Seen(
+			ev.ID, time.Unix(ev.CreatedAt.I64()+tolerate, 0),
+		) {
+			err = errorf.E("auth event %0x has already been used", ev.ID)
+			return
+		}
+		valid = true
 		pubkey = ev.Pubkey
 	default:
 		err = errorf.E("invalid '%s' value: '%s'", HeaderKey, val)
@@ -193,3 +235,60 @@ func CheckAuth(r *http.Request, tolerance ...time.Duration) (
 
 	return
 }
+
+// authEvent decodes the NIP-98 auth event of an Authorization header value,
+// and checks that it has the right kind.
+func authEvent(val string) (ev *event.E, err error) {
+	split := strings.Split(val, " ")
+	if len(split) == 1 {
+		err = errorf.E(
+			"missing nip-98 auth event from '%s' http header key: '%s'",
+			HeaderKey, val,
+		)
+		return
+	}
+	if len(split) > 2 {
+		err = errorf.E(
+			"extraneous content after second field space separated: %s",
+			val,
+		)
+		return
+	}
+	var evb []byte
+	if evb, err = base64.URLEncoding.DecodeString(split[1]); err != nil {
+		return
+	}
+	ev = event.New()
+	var rem []byte
+	if rem, err = ev.Unmarshal(evb); err != nil {
+		return
+	}
+	if len(rem) > 0 {
+		err = errorf.E("rem", rem)
+		return
+	}
+	// The kind MUST be 27235.
+	if !ev.Kind.Equal(kind.HTTPAuth) {
+		err = errorf.E(
+			"invalid kind %d %s in nip-98 http auth event, require %d %s",
+			ev.Kind.K, ev.Kind.Name(), kind.HTTPAuth.K,
+			kind.HTTPAuth.Name(),
+		)
+		return
+	}
+	return
+}
+
+type writeKey struct{}
+
+// ForWrite marks a request as being for a route that writes to the server, so
+// that IsWrite reports it when the auth event is checked.
+func ForWrite(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), writeKey{}, true))
+}
+
+// IsWrite returns true if the request has been marked with ForWrite.
+func IsWrite(r *http.Request) bool {
+	w, _ := r.Context().Value(writeKey{}).(bool)
+	return w
+}
@@ -0,0 +1,66 @@
+// Package nip86 provides the message types and method names of the NIP-86
+// relay management API, a JSON-RPC-like protocol carried in HTTP POST requests
+// to the relay URL with NIP-98 authorization.
+package nip86
+
+import (
+	"encoding/json"
+)
+
+// ContentType is the content type of NIP-86 requests and responses.
+const ContentType = "application/nostr+json+rpc"
+
+// The methods of NIP-86.
+const (
+	SupportedMethods            = "supportedmethods"
+	BanPubkey                   = "banpubkey"
+	ListBannedPubkeys           = "listbannedpubkeys"
+	AllowPubkey                 = "allowpubkey"
+	ListAllowedPubkeys          = "listallowedpubkeys"
+	ListEventsNeedingModeration = "listeventsneedingmoderation"
+	AllowEvent                  = "allowevent"
+	BanEvent                    = "banevent"
+	ListBannedEvents            = "listbannedevents"
+	ChangeRelayName             = "changerelayname"
+	ChangeRelayDescription      = "changerelaydescription"
+	ChangeRelayIcon             = "changerelayicon"
+	AllowKind                   = "allowkind"
+	DisallowKind                = "disallowkind"
+	ListAllowedKinds            = "listallowedkinds"
+	BlockIP                     = "blockip"
+	UnblockIP                   = "unblockip"
+	ListBlockedIPs              = "listblockedips"
+)
+
+// Request is a NIP-86 method call.
+type Request struct {
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+// Response is the result of a NIP-86 method call, with Error set if it
+// failed.
+type Response struct {
+	Result any    `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// PubkeyReason is an item of the result of listbannedpubkeys and
+// listallowedpubkeys.
+type PubkeyReason struct {
+	Pubkey string `json:"pubkey"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// IdReason is an item of the result of listbannedevents and
+// listeventsneedingmoderation.
+type IdReason struct {
+	Id     string `json:"id"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// IPReason is an item of the result of listblockedips.
+type IPReason struct {
+	IP     string `json:"ip"`
+	Reason string `json:"reason,omitempty"`
+}
@@ -0,0 +1,499 @@
+package openapi
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/danielgtaylor/huma/v2"
+
+	"orly.dev/pkg/app/config"
+	"orly.dev/pkg/app/relay/helpers"
+	"orly.dev/pkg/app/relay/spider"
+	"orly.dev/pkg/app/relay/wot"
+	"orly.dev/pkg/database"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/protocol/httpauth"
+	"orly.dev/pkg/utils"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/keys"
+	"orly.dev/pkg/utils/log"
+)
+
+// AdminInput is the parameters for the admin control methods without a body.
+type AdminInput struct {
+	Auth string `header:"Authorization" doc:"nostr nip-98 (and expiring variant)" required:"true"`
+}
+
+// ConfigInput is the parameters for the HTTP API SetConfig method.
+type ConfigInput struct {
+	Auth string            `header:"Authorization" doc:"nostr nip-98 (and expiring variant)" required:"true"`
+	Body map[string]string `doc:"env keys and their new values, slices comma separated" example:"{\"ORLY_LOG_LEVEL\":\"debug\"}"`
+}
+
+// ConfigOutput is the live configuration of the relay, as env keys and values.
+type ConfigOutput struct {
+	Body map[string]string
+}
+
+// BanInput is the parameters for the HTTP API Ban and Unban methods.
+type BanInput struct {
+	Auth string   `header:"Authorization" doc:"nostr nip-98 (and expiring variant)" required:"true"`
+	Body *BanBody `doc:"pubkeys and IP addresses to ban or unban"`
+}
+
+type BanBody struct {
+	Pubkeys []string `json:"pubkeys,omitempty" doc:"pubkeys in hex or npub format" example:"[\"npub1...\"]"`
+	IPs     []string `json:"ips,omitempty" doc:"IP addresses or address prefixes" example:"[\"192.0.2.1\"]"`
+}
+
+// LogLevelInput is the parameters for the HTTP API LogLevel method.
+type LogLevelInput struct {
+	Auth string        `header:"Authorization" doc:"nostr nip-98 (and expiring variant)" required:"true"`
+	Body *LogLevelBody `doc:"new log levels, empty to leave unchanged"`
+}
+
+type LogLevelBody struct {
+	Level   string `json:"level,omitempty" doc:"relay log level" enum:"off,fatal,error,warn,info,debug,trace"`
+	DbLevel string `json:"db_level,omitempty" doc:"event store log level" enum:"off,fatal,error,warn,info,debug,trace"`
+}
+
+// ExplainInput is the parameters for the HTTP API Explain method.
+type ExplainInput struct {
+	Auth string  `header:"Authorization" doc:"nostr nip-98 (and expiring variant)" required:"true"`
+	Body *Filter `doc:"filter JSON (standard NIP-01 filter syntax)"`
+}
+
+// ExplainOutput is the plan and execution statistics of a query.
+type ExplainOutput struct {
+	Body *database.QueryStats
+}
+
+// WoTInput is the parameters for the HTTP API WoT method.
+type WoTInput struct {
+	Auth   string `header:"Authorization" doc:"nostr nip-98 (and expiring variant)" required:"true"`
+	Pubkey string `path:"pubkey" doc:"public key in hex or npub format" maxLength:"64" minLength:"52"`
+}
+
+// WoTOutput is the result of the HTTP API WoT method.
+type WoTOutput struct {
+	Body *wot.Explanation
+}
+
+// SpiderStatusOutput is the result of the HTTP API SpiderStatus method.
+type SpiderStatusOutput struct {
+	Body *spider.Status
+}
+
+// adminAuth checks that the request of an admin method is from an owner. The
+// requests of methods that change the relay are marked with
+// httpauth.ForWrite, so an expiring token must be bound to their method and
+// body.
+func (x *Operations) adminAuth(
+	ctx context.T, name string, write bool,
+) (err error) {
+	r := ctx.Value("http-request").(*http.Request)
+	if write {
+		r = httpauth.ForWrite(r)
+	}
+	remote := helpers.GetRemoteFromReq(r)
+	authed, pubkey := x.AdminAuth(r, remote)
+	if !authed {
+		err = huma.Error401Unauthorized("Not Authorized")
+		return
+	}
+	log.I.F("%s admin %s by %0x", remote, name, pubkey)
+	return
+}
+
+// liveConfig returns the fields of the configuration that can be changed while
+// the relay is running.
+func liveConfig(c config.C) (m map[string]string) {
+	m = make(map[string]string)
+	for _, kv := range config.EnvKV(c) {
+		if config.IsLive(kv.Key) {
+			m[kv.Key] = kv.Value
+		}
+	}
+	return
+}
+
+// setConfiguration applies a change to the configuration, reporting invalid
+// changes as bad requests.
+func (x *Operations) setConfiguration(c config.C) (
+	out *ConfigOutput, err error,
+) {
+	if err = x.SetConfiguration(c); err != nil {
+		err = huma.Error400BadRequest(err.Error())
+		return
+	}
+	if c, err = x.GetConfiguration(); chk.E(err) {
+		return
+	}
+	out = &ConfigOutput{Body: liveConfig(c)}
+	return
+}
+
+// RegisterGetConfig implements the GetConfig HTTP API method.
+func (x *Operations) RegisterGetConfig(api huma.API) {
+	name := "GetConfig"
+	description := `Get the relay configuration that can be changed while it is running
+
+Returns the env keys and values of the live configuration, other settings are not included.`
+	scopes := []string{"admin"}
+	huma.Register(
+		api, huma.Operation{
+			OperationID: name,
+			Summary:     name,
+			Path:        x.path + "/admin/config",
+			Method:      http.MethodGet,
+			Tags:        []string{"admin"},
+			Description: helpers.GenerateDescription(description, scopes),
+			Security:    []map[string][]string{{"auth": scopes}},
+		}, func(ctx context.T, input *AdminInput) (
+			out *ConfigOutput, err error,
+		) {
+			if err = x.adminAuth(ctx, "get config", false); err != nil {
+				return
+			}
+			var c config.C
+			if c, err = x.GetConfiguration(); chk.E(err) {
+				return
+			}
+			out = &ConfigOutput{Body: liveConfig(c)}
+			return
+		},
+	)
+}
+
+// RegisterSetConfig implements the SetConfig HTTP API method.
+func (x *Operations) RegisterSetConfig(api huma.API) {
+	name := "SetConfig"
+	description := `Change the relay configuration while it is running
+
+Takes env keys and their new values in the same format as the .env file, and saves the changes to it. Only the keys returned by GetConfig can be changed.`
+	scopes := []string{"admin", "write"}
+	huma.Register(
+		api, huma.Operation{
+			OperationID: name,
+			Summary:     name,
+			Path:        x.path + "/admin/config",
+			Method:      http.MethodPost,
+			Tags:        []string{"admin"},
+			Description: helpers.GenerateDescription(description, scopes),
+			Security:    []map[string][]string{{"auth": scopes}},
+		}, func(ctx context.T, input *ConfigInput) (
+			out *ConfigOutput, err error,
+		) {
+			if err = x.adminAuth(ctx, "set config", true); err != nil {
+				return
+			}
+			var c config.C
+			if c, err = x.GetConfiguration(); chk.E(err) {
+				return
+			}
+			for k, v := range input.Body {
+				if !config.IsLive(k) {
+					err = huma.Error400BadRequest(
+						k + " can't be changed while the relay is running",
+					)
+					return
+				}
+				if err = config.Set(&c, k, v); err != nil {
+					err = huma.Error400BadRequest(err.Error())
+					return
+				}
+			}
+			return x.setConfiguration(c)
+		},
+	)
+}
+
+// RegisterBan implements the Ban HTTP API method.
+func (x *Operations) RegisterBan(api huma.API) {
+	name := "Ban"
+	description := `Ban pubkeys and IP addresses
+
+Adds the pubkeys to the blacklist, whose events are refused, and the IP addresses to the blocked IPs, whose connections are refused. Returns the new configuration.`
+	scopes := []string{"admin", "write"}
+	huma.Register(
+		api, huma.Operation{
+			OperationID: name,
+			Summary:     name,
+			Path:        x.path + "/admin/ban",
+			Method:      http.MethodPost,
+			Tags:        []string{"admin"},
+			Description: helpers.GenerateDescription(description, scopes),
+			Security:    []map[string][]string{{"auth": scopes}},
+		}, func(ctx context.T, input *BanInput) (
+			out *ConfigOutput, err error,
+		) {
+			if err = x.adminAuth(ctx, "ban", true); err != nil {
+				return
+			}
+			var c config.C
+			if c, err = x.GetConfiguration(); chk.E(err) {
+				return
+			}
+			if input.Body != nil {
+				for _, v := range input.Body.Pubkeys {
+					var pk []byte
+					if pk, err = keys.DecodeNpubOrHex(v); err != nil {
+						err = huma.Error400BadRequest("invalid pubkey " + v)
+						return
+					}
+					if indexPubkey(c.Blacklist, pk) < 0 {
+						c.Blacklist = append(c.Blacklist, hex.Enc(pk))
+					}
+				}
+				for _, ip := range input.Body.IPs {
+					if !slices.Contains(c.BlockedIPs, ip) {
+						c.BlockedIPs = append(c.BlockedIPs, ip)
+					}
+				}
+			}
+			return x.setConfiguration(c)
+		},
+	)
+}
+
+// RegisterUnban implements the Unban HTTP API method.
+func (x *Operations) RegisterUnban(api huma.API) {
+	name := "Unban"
+	description := `Unban pubkeys and IP addresses
+
+Removes the pubkeys from the blacklist and the IP addresses from the blocked IPs. Returns the new configuration.`
+	scopes := []string{"admin", "write"}
+	huma.Register(
+		api, huma.Operation{
+			OperationID: name,
+			Summary:     name,
+			Path:        x.path + "/admin/unban",
+			Method:      http.MethodPost,
+			Tags:        []string{"admin"},
+			Description: helpers.GenerateDescription(description, scopes),
+			Security:    []map[string][]string{{"auth": scopes}},
+		}, func(ctx context.T, input *BanInput) (
+			out *ConfigOutput, err error,
+		) {
+			if err = x.adminAuth(ctx, "unban", true); err != nil {
+				return
+			}
+			var c config.C
+			if c, err = x.GetConfiguration(); chk.E(err) {
+				return
+			}
+			if input.Body != nil {
+				for _, v := range input.Body.Pubkeys {
+					var pk []byte
+					if pk, err = keys.DecodeNpubOrHex(v); err != nil {
+						err = huma.Error400BadRequest("invalid pubkey " + v)
+						return
+					}
+					for i := indexPubkey(c.Blacklist, pk); i >= 0; i = indexPubkey(
+						c.Blacklist, pk,
+					) {
+						c.Blacklist = slices.Delete(c.Blacklist, i, i+1)
+					}
+				}
+				for _, ip := range input.Body.IPs {
+					c.BlockedIPs = slices.DeleteFunc(
+						c.BlockedIPs, func(s string) bool { return s == ip },
+					)
+				}
+			}
+			return x.setConfiguration(c)
+		},
+	)
+}
+
+// indexPubkey returns the index of the npub or hex pubkey in list that is pk,
+// or -1 if there is none.
+func indexPubkey(list []string, pk []byte) int {
+	for i, v := range list {
+		if p, err := keys.DecodeNpubOrHex(v); err == nil &&
+			utils.FastEqual(p, pk) {
+			return i
+		}
+	}
+	return -1
+}
+
+// RegisterLogLevel implements the LogLevel HTTP API method.
+func (x *Operations) RegisterLogLevel(api huma.API) {
+	name := "LogLevel"
+	description := `Change the log levels of the relay and the event store
+
+Returns the new configuration.`
+	scopes := []string{"admin", "write"}
+	huma.Register(
+		api, huma.Operation{
+			OperationID: name,
+			Summary:     name,
+			Path:        x.path + "/admin/loglevel",
+			Method:      http.MethodPost,
+			Tags:        []string{"admin"},
+			Description: helpers.GenerateDescription(description, scopes),
+			Security:    []map[string][]string{{"auth": scopes}},
+		}, func(ctx context.T, input *LogLevelInput) (
+			out *ConfigOutput, err error,
+		) {
+			if err = x.adminAuth(ctx, "log level", true); err != nil {
+				return
+			}
+			var c config.C
+			if c, err = x.GetConfiguration(); chk.E(err) {
+				return
+			}
+			if input.Body != nil {
+				if input.Body.Level != "" {
+					c.LogLevel = input.Body.Level
+				}
+				if input.Body.DbLevel != "" {
+					c.DbLogLevel = input.Body.DbLevel
+				}
+			}
+			return x.setConfiguration(c)
+		},
+	)
+}
+
+// RegisterSpider implements the Spider HTTP API method.
+func (x *Operations) RegisterSpider(api huma.API) {
+	name := "Spider"
+	description := `Start a spider run
+
+Refreshes the owners' follow and mute lists, and fetches the events of the followed users unless the relay is private or the spider is disabled. The run continues in the background after the response.`
+	scopes := []string{"admin"}
+	huma.Register(
+		api, huma.Operation{
+			OperationID: name,
+			Summary:     name,
+			Path:        x.path + "/admin/spider",
+			Method:      http.MethodPost,
+			Tags:        []string{"admin"},
+			Description: helpers.GenerateDescription(description, scopes),
+			Security:    []map[string][]string{{"auth": scopes}},
+		}, func(ctx context.T, input *AdminInput) (
+			out *struct{}, err error,
+		) {
+			if err = x.adminAuth(ctx, "spider", true); err != nil {
+				return
+			}
+			if len(x.Config().Owners) == 0 {
+				err = huma.Error400BadRequest("there are no owners to spider")
+				return
+			}
+			if err = x.Spider(x.Config().Private); chk.E(err) {
+				err = huma.Error500InternalServerError(err.Error())
+			}
+			return
+		},
+	)
+}
+
+// RegisterSpiderStatus implements the SpiderStatus HTTP API method.
+func (x *Operations) RegisterSpiderStatus(api huma.API) {
+	name := "SpiderStatus"
+	description := `Get the results of the spider runs
+
+Returns whether a spider run is going on, when the last one started, how long it took and the error it failed with, the health of each seed relay, with its successes, failures, latency, score and how long it is backed off for, and the outcome of the most recent fetches from the seeds.`
+	scopes := []string{"admin"}
+	huma.Register(
+		api, huma.Operation{
+			OperationID: name,
+			Summary:     name,
+			Path:        x.path + "/admin/spider",
+			Method:      http.MethodGet,
+			Tags:        []string{"admin"},
+			Description: helpers.GenerateDescription(description, scopes),
+			Security:    []map[string][]string{{"auth": scopes}},
+		}, func(ctx context.T, input *AdminInput) (
+			out *SpiderStatusOutput, err error,
+		) {
+			if err = x.adminAuth(ctx, "spider status", false); err != nil {
+				return
+			}
+			out = &SpiderStatusOutput{Body: x.SpiderStatus()}
+			return
+		},
+	)
+}
+
+// RegisterWoT implements the WoT HTTP API method.
+func (x *Operations) RegisterWoT(api huma.API) {
+	name := "WoT"
+	description := `Explain why a pubkey is or isn't allowed to publish
+
+Returns whether the pubkey is allowed to publish to the relay when auth is required, its depth in the web of trust of the owners, the trusted users who follow or mute it, the settings of the web of trust, and the reason, which is also the blacklist or the allowed pubkeys list when the pubkey is on one of them.`
+	scopes := []string{"admin"}
+	huma.Register(
+		api, huma.Operation{
+			OperationID: name,
+			Summary:     name,
+			Path:        x.path + "/admin/wot/{pubkey}",
+			Method:      http.MethodGet,
+			Tags:        []string{"admin"},
+			Description: helpers.GenerateDescription(description, scopes),
+			Security:    []map[string][]string{{"auth": scopes}},
+		}, func(ctx context.T, input *WoTInput) (
+			out *WoTOutput, err error,
+		) {
+			if err = x.adminAuth(ctx, "wot", false); err != nil {
+				return
+			}
+			var pk []byte
+			if pk, err = parsePubkey(input.Pubkey); err != nil {
+				err = huma.Error400BadRequest("invalid pubkey", err)
+				return
+			}
+			out = &WoTOutput{Body: x.ExplainAccess(pk)}
+			return
+		},
+	)
+}
+
+// RegisterExplain implements the Explain HTTP API method.
+func (x *Operations) RegisterExplain(api huma.API) {
+	name := "Explain"
+	description := `Explain how the event store runs the query of a filter
+
+Runs the query and returns the index it was mapped to, the index ranges that were scanned with the number of keys in each, the number of events fetched and matched, and the time it took, without the events. The read policy of the relay is not applied to the filter.`
+	scopes := []string{"admin"}
+	huma.Register(
+		api, huma.Operation{
+			OperationID: name,
+			Summary:     name,
+			Path:        x.path + "/admin/explain",
+			Method:      http.MethodPost,
+			Tags:        []string{"admin"},
+			RequestBody: EventsBody,
+			Description: helpers.GenerateDescription(description, scopes),
+			Security:    []map[string][]string{{"auth": scopes}},
+		}, func(ctx context.T, input *ExplainInput) (
+			out *ExplainOutput, err error,
+		) {
+			if err = x.adminAuth(ctx, "explain", false); err != nil {
+				return
+			}
+			if input.Body == nil {
+				err = huma.Error400BadRequest("a filter is required")
+				return
+			}
+			db, ok := x.Storage().(*database.D)
+			if !ok {
+				err = huma.Error501NotImplemented(
+					"the event store can't explain queries",
+				)
+				return
+			}
+			var st *database.QueryStats
+			if st, err = db.Explain(ctx, input.Body.ToFilter()); chk.E(err) {
+				err = huma.Error500InternalServerError(err.Error())
+				return
+			}
+			out = &ExplainOutput{Body: st}
+			return
+		},
+	)
+}
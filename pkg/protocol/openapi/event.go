@@ -14,6 +14,7 @@ import (
 	"orly.dev/pkg/encoders/ints"
 	"orly.dev/pkg/encoders/kind"
 	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/protocol/httpauth"
 	"orly.dev/pkg/utils"
 	"orly.dev/pkg/utils/chk"
 	"orly.dev/pkg/utils/context"
@@ -100,7 +101,9 @@ func (x *Operations) RegisterEvent(api huma.API) {
 		}, func(ctx context.T, input *EventInput) (
 			output *EventOutput, err error,
 		) {
-			r := ctx.Value("http-request").(*http.Request)
+			r := httpauth.ForWrite(
+				ctx.Value("http-request").(*http.Request),
+			)
 			remote := helpers.GetRemoteFromReq(r)
 
 			var authed, super bool
@@ -14,7 +14,6 @@ import (
 	"orly.dev/pkg/encoders/kind"
 	"orly.dev/pkg/encoders/tag"
 	"orly.dev/pkg/encoders/timestamp"
-	"orly.dev/pkg/protocol/auth"
 	"orly.dev/pkg/utils/context"
 	"orly.dev/pkg/utils/log"
 	"orly.dev/pkg/utils/pointers"
@@ -652,28 +651,26 @@ Returns events as a JSON array of event objects.`
 					}
 					continue
 				}
-				// filter events the authed pubkey is not privileged to fetch.
+				// filter events the authed pubkey is not permitted to read.
 				// relay replicas don't have this limitation.
-				if x.AuthRequired() && len(pubkey) > 0 && !super {
-					var tmp event.S
-					for _, ev := range events {
-						if !auth.CheckPrivilege(pubkey, ev) {
-							log.W.F(
-								"not privileged: client pubkey '%0x' event pubkey '%0x' kind %s privileged: %v",
-								pubkey, ev.Pubkey,
-								ev.Kind.Name(),
-								ev.Kind.IsPrivileged(),
-							)
-							continue
-						}
-						tmp = append(tmp, ev)
-					}
-					// cap the number of events to 512 to stop excessively large
-					// response.
-					if len(events) > 512 {
-						break
+				var tmp event.S
+				for _, ev := range events {
+					if !x.CanRead(ev, pubkey, super) {
+						log.T.F(
+							"not permitted: client pubkey '%0x' event pubkey '%0x' kind %s privileged: %v",
+							pubkey, ev.Pubkey,
+							ev.Kind.Name(),
+							ev.Kind.IsPrivileged(),
+						)
+						continue
 					}
-					events = tmp
+					tmp = append(tmp, ev)
+				}
+				events = tmp
+				// cap the number of events to 512 to stop excessively large
+				// response.
+				if len(events) > 512 {
+					break
 				}
 			}
 			output = &EventsOutput{}
@@ -1,12 +1,15 @@
 package openapi
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/danielgtaylor/huma/v2"
 	"github.com/danielgtaylor/huma/v2/adapters/humago"
 
+	"orly.dev/pkg/protocol/httpauth"
 	"orly.dev/pkg/protocol/servemux"
+	"orly.dev/pkg/utils/chk"
 )
 
 // ExposeMiddleware adds the http.Request and http.ResponseWriter to the context
@@ -14,6 +17,18 @@ import (
 func ExposeMiddleware(ctx huma.Context, next func(huma.Context)) {
 	// Unwrap the request and response objects.
 	r, w := humago.Unwrap(ctx)
+	// hash the body before it is decoded, for the NIP-98 payload tag.
+	if err := httpauth.HashBody(r); chk.E(err) {
+		if errors.Is(err, httpauth.ErrBodyTooLarge) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+	if b, ok := r.Body.(*httpauth.Body); ok {
+		defer b.Close()
+	}
 	ctx = huma.WithValue(ctx, "http-request", r)
 	ctx = huma.WithValue(ctx, "http-response", w)
 	next(ctx)
@@ -6,6 +6,7 @@ import (
 	"io"
 	"net/http"
 	"orly.dev/pkg/app/relay/helpers"
+	"orly.dev/pkg/protocol/httpauth"
 	"orly.dev/pkg/utils/context"
 	"orly.dev/pkg/utils/log"
 	"orly.dev/pkg/utils/lol"
@@ -44,7 +45,9 @@ func (x *Operations) RegisterImport(api huma.API) {
 		) {
 			lol.Tracer("Import", input)
 			defer func() { lol.Tracer("end Import", output, err) }()
-			r := ctx.Value("http-request").(*http.Request)
+			r := httpauth.ForWrite(
+				ctx.Value("http-request").(*http.Request),
+			)
 			remote := helpers.GetRemoteFromReq(r)
 			authed, pubkey := x.AdminAuth(r, remote, 10*time.Minute)
 			if !authed {
@@ -7,24 +7,12 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/danielgtaylor/huma/v2"
 	"github.com/danielgtaylor/huma/v2/adapters/humachi"
 	"github.com/go-chi/chi/v5"
 	"orly.dev/pkg/app/config"
 )
 
-// mockServerInterface implements the server.I interface for testing
-type mockServerInterface struct {
-	cfg *config.C
-}
-
-func (m *mockServerInterface) Config() *config.C {
-	return m.cfg
-}
-
-func (m *mockServerInterface) Storage() interface{} {
-	return nil
-}
-
 func TestInvoiceEndpoint(t *testing.T) {
 	// Create a test configuration
 	cfg := &config.C{
@@ -33,13 +21,11 @@ func TestInvoiceEndpoint(t *testing.T) {
 	}
 
 	// Create mock server interface
-	mockServer := &mockServerInterface{cfg: cfg}
+	mockServer := &mockServer{cfg: cfg}
 
 	// Create a router and API
 	router := chi.NewRouter()
-	api := humachi.New(router, &humachi.HumaConfig{
-		OpenAPI: humachi.DefaultOpenAPIConfig(),
-	})
+	api := humachi.New(router, huma.DefaultConfig("test", "0.0.0"))
 
 	// Create operations and register invoice endpoint
 	ops := &Operations{
@@ -66,19 +52,19 @@ func TestInvoiceEndpoint(t *testing.T) {
 		{
 			name:           "missing pubkey",
 			body:           map[string]interface{}{"months": 1},
-			expectedStatus: http.StatusBadRequest,
+			expectedStatus: http.StatusUnprocessableEntity,
 			expectError:    true,
 		},
 		{
 			name:           "invalid months - too low",
 			body:           map[string]interface{}{"pubkey": "npub1test", "months": 0},
-			expectedStatus: http.StatusBadRequest,
+			expectedStatus: http.StatusUnprocessableEntity,
 			expectError:    true,
 		},
 		{
 			name:           "invalid months - too high",
 			body:           map[string]interface{}{"pubkey": "npub1test", "months": 13},
-			expectedStatus: http.StatusBadRequest,
+			expectedStatus: http.StatusUnprocessableEntity,
 			expectError:    true,
 		},
 		{
@@ -52,11 +52,16 @@ Many browsers have a limited number of SSE channels that can be open at once, so
 			r := ctx.Value("http-request").(*http.Request)
 			remote := helpers.GetRemoteFromReq(r)
 			var err error
-			var authed bool
+			var authed, super bool
 			var pubkey []byte
-			if x.I.AuthRequired() && !x.I.PublicReadable() {
-				authed, pubkey, _ = x.UserAuth(r, remote)
-				if !authed {
+			// a NIP-98 authorization header authenticates the channel so that
+			// privileged events addressed to the pubkey can be delivered on
+			// it; it is only mandatory if auth is required and the relay is
+			// not public readable.
+			mustAuth := x.I.AuthRequired() && !x.I.PublicReadable()
+			if mustAuth || input.Auth != "" {
+				authed, pubkey, super = x.UserAuth(r, remote)
+				if mustAuth && !authed {
 					err = huma.Error401Unauthorized("Not Authorized")
 					return
 				}
@@ -78,6 +83,7 @@ Many browsers have a limited number of SSE channels that can be open at once, so
 				New:       true,
 				Receiver:  receiver,
 				Pubkey:    pubkey,
+				Super:     super,
 				FilterMap: make(map[string]*filter.F),
 			}
 
@@ -7,7 +7,7 @@ import (
 	"orly.dev/pkg/interfaces/publisher"
 	"orly.dev/pkg/interfaces/server"
 	"orly.dev/pkg/interfaces/typer"
-	"orly.dev/pkg/protocol/auth"
+	"orly.dev/pkg/protocol/subindex"
 	"orly.dev/pkg/utils/log"
 	"reflect"
 	"sync"
@@ -43,16 +43,30 @@ type H struct {
 
 	// Pubkey is the authenticated public key for this listener
 	Pubkey []byte
+
+	// Super is set if the listener authenticated as a relay cluster replica.
+	Super bool
 }
 
 func (h *H) Type() (typeName string) { return Type }
 
+// Sub identifies a single subscription of an HTTP listener in the
+// subscription index.
+type Sub struct {
+	ListenerId string
+	SubId      string
+}
+
 type Publisher struct {
 	sync.Mutex
 
 	// ListenMap maps listener IDs to listener objects
 	ListenMap map[string]*H
 
+	// Index is the inverted index of the filters in ListenMap used to find
+	// the subscriptions an event should be delivered to.
+	Index *subindex.Index[Sub]
+
 	// Server is an interface to the server
 	Server server.I
 }
@@ -64,10 +78,21 @@ func (p *Publisher) Type() (typeName string) { return Type }
 func NewPublisher(s server.I) (p *Publisher) {
 	return &Publisher{
 		ListenMap: make(map[string]*H),
+		Index:     subindex.New[Sub](),
 		Server:    s,
 	}
 }
 
+// Subscriptions returns the number of open subscriptions.
+func (p *Publisher) Subscriptions() (n int) {
+	p.Lock()
+	defer p.Unlock()
+	for _, h := range p.ListenMap {
+		n += len(h.FilterMap)
+	}
+	return
+}
+
 // Receive handles incoming messages to manage HTTP listener subscriptions and
 // associated filters.
 //
@@ -118,12 +143,14 @@ func (p *Publisher) Receive(msg typer.T) {
 					FilterMap: make(map[string]*filter.F),
 					Receiver:  m.Receiver,
 					Pubkey:    m.Pubkey,
+					Super:     m.Super,
 				}
 
 				// Add the filters if provided
 				if m.FilterMap != nil {
 					for id, f := range m.FilterMap {
 						listener.FilterMap[id] = f
+						p.Index.Add(Sub{m.Id, id}, f)
 						log.T.F(
 							"added subscription %s for new listener %s", id,
 							m.Id,
@@ -144,6 +171,7 @@ func (p *Publisher) Receive(msg typer.T) {
 			if m.FilterMap != nil {
 				for id, f := range m.FilterMap {
 					listener.FilterMap[id] = f
+					p.Index.Add(Sub{m.Id, id}, f)
 					log.T.F("added subscription %s for %s", id, m.Id)
 				}
 			}
@@ -160,62 +188,56 @@ func (p *Publisher) Receive(msg typer.T) {
 //
 // # Expected behaviour
 //
-// Delivers the event to all subscribers whose filters match the event. It
-// applies authentication checks if required by the server, and skips delivery
-// for unauthenticated users when events are privileged.
+// Delivers the event to all subscribers whose filters match the event. Each
+// delivery is checked with the server CanRead read authorization, the same as
+// is used for websocket subscriptions.
 func (p *Publisher) Deliver(ev *event.E) {
 	log.T.F("delivering event %0x to HTTP subscribers", ev.ID)
 	p.Lock()
 	defer p.Unlock()
-	for listenerId, listener := range p.ListenMap {
-		for subId, filter := range listener.FilterMap {
-			if !filter.Matches(ev) {
+	p.Index.Matches(
+		ev, func(sub Sub) {
+			listener, ok := p.ListenMap[sub.ListenerId]
+			if !ok {
+				return
+			}
+			if !p.Server.CanRead(ev, listener.Pubkey, listener.Super) {
 				log.T.C(
 					func() string {
 						return fmt.Sprintf(
-							"listener %s, subscription id %s event\n%s\ndoes not match filter\n%s",
-							listenerId, subId, ev.Marshal(nil),
-							filter.Marshal(nil),
+							"not permitted: listener pubkey %0x ev pubkey %0x kind %s privileged: %v",
+							listener.Pubkey, ev.Pubkey, ev.Kind.Name(),
+							ev.Kind.IsPrivileged(),
 						)
 					},
 				)
-				continue
-			}
-			if p.Server.AuthRequired() {
-				if !auth.CheckPrivilege(listener.Pubkey, ev) {
-					log.T.C(
-						func() string {
-							return fmt.Sprintf(
-								"not privileged %0x ev pubkey %0x listener pubkey %0x kind %s privileged: %v",
-								listener.Pubkey, ev.Pubkey,
-								listener.Pubkey, ev.Kind.Name(),
-								ev.Kind.IsPrivileged(),
-							)
-						},
-					)
-					continue
-				}
+				return
 			}
 			// Send the event to the listener's receiver channel
 			select {
-			case listener.Receiver <- &Delivery{SubId: subId, Event: ev}:
+			case listener.Receiver <- &Delivery{SubId: sub.SubId, Event: ev}:
 				log.T.F(
 					"dispatched event %0x to subscription %s for listener %s",
-					ev.ID, subId, listenerId,
+					ev.ID, sub.SubId, sub.ListenerId,
 				)
 			default:
 				log.W.F(
 					"failed to dispatch event %0x to subscription %s for listener %s: channel full",
-					ev.ID, subId, listenerId,
+					ev.ID, sub.SubId, sub.ListenerId,
 				)
 			}
-		}
-	}
+		},
+	)
 }
 
 // removeListener removes a listener from the Publisher collection.
 func (p *Publisher) removeListener(id string) {
 	p.Lock()
+	if listener, ok := p.ListenMap[id]; ok {
+		for subId := range listener.FilterMap {
+			p.Index.Remove(Sub{id, subId})
+		}
+	}
 	delete(p.ListenMap, id)
 	p.Unlock()
 }
@@ -229,6 +251,7 @@ func (p *Publisher) removeSubscription(
 	if listener, ok := p.ListenMap[listenerId]; ok {
 		for id := range filterMap {
 			delete(listener.FilterMap, id)
+			p.Index.Remove(Sub{listenerId, id})
 		}
 		// We no longer delete the listener when all subscriptions are removed
 		// This allows the listener to remain active for future subscriptions
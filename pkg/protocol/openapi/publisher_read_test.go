@@ -0,0 +1,67 @@
+package openapi_test
+
+import (
+	"testing"
+
+	"orly.dev/pkg/app/config"
+	"orly.dev/pkg/app/relay"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/protocol/auth/readtester"
+	"orly.dev/pkg/protocol/openapi"
+	"orly.dev/pkg/utils/context"
+)
+
+// TestPublisherReadMatrix checks that the HTTP SSE publisher applies the read
+// authorization of the relay, the same as the websocket API.
+func TestPublisherReadMatrix(t *testing.T) {
+	cases, hidden, err := readtester.RelayMatrix()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	publishers := make(map[bool]*openapi.Publisher)
+	for _, authRequired := range []bool{true, false} {
+		s := &relay.Server{
+			Ctx: c,
+			C: &config.C{
+				AuthRequired: authRequired, HideMuted: true,
+			},
+			Lists: new(relay.Lists),
+		}
+		s.SetOwnersMuted([][]byte{hidden})
+		publishers[authRequired] = openapi.NewPublisher(s)
+	}
+	for _, tc := range cases {
+		t.Run(
+			tc.Name, func(t *testing.T) {
+				publisher := publishers[tc.AuthRequired]
+				receiver := make(openapi.DeliverChan, 1)
+				publisher.Receive(
+					&openapi.H{
+						Id:       tc.Name,
+						New:      true,
+						Receiver: receiver,
+						Pubkey:   tc.Reader,
+						FilterMap: map[string]*filter.F{
+							"all": filter.New(),
+						},
+					},
+				)
+				defer publisher.Receive(&openapi.H{Id: tc.Name, Cancel: true})
+				publisher.Deliver(tc.Event)
+				var delivered bool
+				select {
+				case <-receiver:
+					delivered = true
+				default:
+				}
+				if delivered != tc.Allowed {
+					t.Fatalf(
+						"expected delivered %v, got %v", tc.Allowed, delivered,
+					)
+				}
+			},
+		)
+	}
+}
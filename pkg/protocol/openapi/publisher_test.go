@@ -8,6 +8,8 @@ import (
 	"orly.dev/pkg/app/config"
 
 	"orly.dev/pkg/app/relay/publish"
+	"orly.dev/pkg/app/relay/spider"
+	"orly.dev/pkg/app/relay/wot"
 	"orly.dev/pkg/encoders/event"
 	"orly.dev/pkg/encoders/filter"
 	"orly.dev/pkg/encoders/filters"
@@ -17,6 +19,7 @@ import (
 	"orly.dev/pkg/encoders/timestamp"
 	"orly.dev/pkg/interfaces/relay"
 	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/protocol/auth"
 	ctx "orly.dev/pkg/utils/context"
 )
 
@@ -24,6 +27,7 @@ import (
 type mockServer struct {
 	authRequired bool
 	context      ctx.T
+	cfg          *config.C
 }
 
 // Implement the methods needed for our tests
@@ -99,10 +103,39 @@ func (m *mockServer) OwnersPubkeys() (pks [][]byte) {
 	return nil
 }
 
-func (m *mockServer) Config() (c *config.C) {
+func (m *mockServer) GetConfiguration() (c config.C, err error) {
 	return
 }
 
+func (m *mockServer) SetConfiguration(c config.C) (err error) {
+	return nil
+}
+
+func (m *mockServer) Spider(noFetch ...bool) (err error) {
+	return nil
+}
+
+func (m *mockServer) SpiderStatus() (st *spider.Status) {
+	return &spider.Status{}
+}
+
+func (m *mockServer) ExplainAccess(pk []byte) (x *wot.Explanation) {
+	return wot.New(wot.Config{}).Explain(pk)
+}
+
+func (m *mockServer) CanRead(
+	ev *event.E, authedPubkey []byte, super bool,
+) (allowed bool) {
+	if super || !m.authRequired {
+		return true
+	}
+	return auth.CanRead(authedPubkey, ev)
+}
+
+func (m *mockServer) Config() (c *config.C) {
+	return m.cfg
+}
+
 // TestPublisherFunctionality tests the listen/subscribe/unsubscribe and publisher functionality
 func TestPublisherFunctionality(t *testing.T) {
 	// Create a context with cancel function
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"orly.dev/pkg/app/relay/helpers"
 	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/filters"
 	"orly.dev/pkg/utils/context"
 	"orly.dev/pkg/utils/log"
 )
@@ -56,8 +57,29 @@ func (x *Operations) RegisterSubscribe(api huma.API) {
 				return nil, huma.Error404NotFound("client_id does not exist, create a listener first with the /listen endpoint")
 			}
 
-			// Convert the Filter to a filter.F
+			r := ctx.Value("http-request").(*http.Request)
+			remote := helpers.GetRemoteFromReq(r)
+			var authed bool
+			var pubkey []byte
+			mustAuth := x.I.AuthRequired() && !x.I.PublicReadable()
+			if mustAuth || input.Auth != "" {
+				authed, pubkey, _ = x.UserAuth(r, remote)
+				if mustAuth && !authed {
+					return nil, huma.Error401Unauthorized("Not Authorized")
+				}
+			}
+
+			// Convert the Filter to a filter.F and apply the read policy
 			f := input.Body.ToFilter()
+			allowed, accept, _ := x.AcceptReq(
+				x.Context(), r, filters.New(f), pubkey, remote,
+			)
+			if !accept || len(allowed.F) == 0 {
+				return nil, huma.Error403Forbidden(
+					"filter not permitted by relay read policy",
+				)
+			}
+			f = allowed.F[0]
 
 			// Create a subscription message
 			subscription := &H{
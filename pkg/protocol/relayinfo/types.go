@@ -145,6 +145,8 @@ var (
 	NIP78                          = ApplicationSpecificData
 	Highlights                     = NIP{"Highlights", 84}
 	NIP84                          = Highlights
+	RelayManagementAPI             = NIP{"Relay Management API", 86}
+	NIP86                          = RelayManagementAPI
 	RecommendedApplicationHandlers = NIP{"Recommended Application Handlers", 89}
 	NIP89                          = RecommendedApplicationHandlers
 	DataVendingMachines            = NIP{"Data Vending Machines", 90}
@@ -171,7 +173,7 @@ var NIPMap = map[int]NIP{
 	52: NIP52,
 	53: NIP53, 56: NIP56, 57: NIP57, 58: NIP58, 65: NIP65, 72: NIP72, 75: NIP75,
 	78: NIP78,
-	84: NIP84, 89: NIP89, 90: NIP90, 94: NIP94, 96: NIP96, 98: NIP98, 99: NIP99,
+	84: NIP84, 86: NIP86, 89: NIP89, 90: NIP90, 94: NIP94, 96: NIP96, 98: NIP98, 99: NIP99,
 }
 
 // Limits are rules about what is acceptable for events and filters on a relay.
@@ -31,12 +31,12 @@ import (
 //
 // # Expected behaviour
 //
-// Handles the authentication process by checking if authentication is required,
-// unmarshalling and validating the response against a challenge, logging
+// Handles the authentication process by checking if authentication is required
+// or has been requested from the client, unmarshalling and validating the response against a challenge, logging
 // relevant information, and setting up the authenticated state on successful
 // validation.
 func (a *A) HandleAuth(b []byte, srv server.I) (msg []byte) {
-	if a.I.AuthRequired() {
+	if a.I.AuthRequired() || a.Listener.AuthRequested() {
 		log.T.C(func() string { return fmt.Sprintf("AUTH:\n%s", b) })
 		var err error
 		var rem []byte
@@ -3,6 +3,7 @@ package socketapi
 import (
 	"bytes"
 	"fmt"
+	"go.opentelemetry.io/otel/attribute"
 	"orly.dev/pkg/crypto/sha256"
 	"orly.dev/pkg/encoders/bech32encoding"
 	"orly.dev/pkg/encoders/envelopes/authenvelope"
@@ -17,11 +18,13 @@ import (
 	"orly.dev/pkg/encoders/kind"
 	"orly.dev/pkg/encoders/tag"
 	"orly.dev/pkg/interfaces/server"
+	"orly.dev/pkg/protocol/auth"
 	"orly.dev/pkg/utils"
 	"orly.dev/pkg/utils/chk"
 	"orly.dev/pkg/utils/context"
 	"orly.dev/pkg/utils/iptracker"
 	"orly.dev/pkg/utils/log"
+	"orly.dev/pkg/utils/tracing"
 	"strings"
 	"time"
 )
@@ -55,6 +58,8 @@ func (a *A) HandleEvent(
 	c context.T, req []byte, srv server.I,
 ) (msg []byte) {
 	var err error
+	c, span := tracing.Start(c, "socketapi.HandleEvent")
+	defer span.End()
 	log.T.C(
 		func() string {
 			return fmt.Sprintf(
@@ -71,12 +76,17 @@ func (a *A) HandleEvent(
 	rl := srv.Relay()
 	env := eventenvelope.NewSubmission()
 	if rem, err = env.Unmarshal(req); chk.E(err) {
+		tracing.Error(span, err)
 		return
 	}
+	span.SetAttributes(attribute.Int("kind", int(env.E.Kind.K)))
 	if len(rem) > 0 {
 		log.I.F("extra '%s'", rem)
 	}
-	if a.I.AuthRequired() && !a.Listener.IsAuthed() {
+	// an inbox relay accepts gift wraps from anyone, as they are signed by a
+	// random key, so they don't need auth.
+	inboxDelivery := srv.Config().Inbox && env.E.Kind.IsGiftWrap()
+	if a.I.AuthRequired() && !a.Listener.IsAuthed() && !inboxDelivery {
 		remoteIP := a.Listener.RealRemote()
 		log.I.F("requesting auth from client from %s", remoteIP)
 
@@ -190,13 +200,27 @@ func (a *A) HandleEvent(
 		a.Listener.RealRemote(),
 	)
 	if !accept {
-		if strings.Contains(notice, "auth") {
+		if strings.Contains(notice, "authed") ||
+			strings.Contains(notice, "authenticate") {
 			if err = Ok.AuthRequired(
 				a, env, notice,
 			); chk.E(err) {
 				return
 			}
-
+			if !a.Listener.IsAuthed() {
+				if len(a.Listener.Challenge()) == 0 {
+					a.Listener.SetChallenge(auth.GenerateChallenge())
+				}
+				a.Listener.RequestAuth()
+				if err = authenvelope.NewChallengeWith(a.Listener.Challenge()).
+					Write(a.Listener); chk.E(err) {
+					return
+				}
+			}
+			return
+		}
+		if err = Ok.Blocked(a, env, "%s", notice); chk.E(err) {
+			return
 		}
 		return
 	}
@@ -268,11 +292,11 @@ func (a *A) HandleEvent(
 							referencedEvent.Pubkey, env.Pubkey,
 						) && !ownerDelete {
 							if err = Ok.Blocked(
-								a, env,
-								"blocked: can't delete events from other authors",
+								a, env, "can't delete events from other authors",
 							); chk.E(err) {
 								return
 							}
+							return
 						}
 
 						// Create eventid.T from the event ID bytes
@@ -485,7 +509,7 @@ func (a *A) HandleEvent(
 		},
 	)
 	if err = okenvelope.NewFrom(
-		env.E.ID, ok,
+		env.E.ID, ok, reason,
 	).Write(a.Listener); chk.E(err) {
 		return
 	}
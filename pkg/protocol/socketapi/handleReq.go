@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/dgraph-io/badger/v4"
+	"go.opentelemetry.io/otel/attribute"
 	"orly.dev/pkg/encoders/bech32encoding"
 	"orly.dev/pkg/encoders/envelopes/authenvelope"
 	"orly.dev/pkg/encoders/envelopes/closedenvelope"
@@ -12,6 +13,7 @@ import (
 	"orly.dev/pkg/encoders/envelopes/noticeenvelope"
 	"orly.dev/pkg/encoders/envelopes/reqenvelope"
 	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
 	"orly.dev/pkg/encoders/reason"
 	"orly.dev/pkg/interfaces/server"
 	"orly.dev/pkg/protocol/auth"
@@ -20,6 +22,8 @@ import (
 	"orly.dev/pkg/utils/log"
 	"orly.dev/pkg/utils/normalize"
 	"orly.dev/pkg/utils/pointers"
+	"orly.dev/pkg/utils/tracing"
+	"slices"
 )
 
 // HandleReq processes a raw request, parses its envelope, validates filters,
@@ -50,6 +54,8 @@ import (
 // generates and sends a closure envelope.
 func (a *A) HandleReq(c context.T, req []byte, srv server.I) (r []byte) {
 	var err error
+	c, span := tracing.Start(c, "socketapi.HandleReq")
+	defer span.End()
 	log.T.C(
 		func() string {
 			return fmt.Sprintf(
@@ -63,8 +69,13 @@ func (a *A) HandleReq(c context.T, req []byte, srv server.I) (r []byte) {
 	var rem []byte
 	env := reqenvelope.New()
 	if rem, err = env.Unmarshal(req); chk.E(err) {
+		tracing.Error(span, err)
 		return normalize.Error.F(err.Error())
 	}
+	span.SetAttributes(
+		attribute.String("subscription", env.Subscription.String()),
+		attribute.Int("filters", env.Filters.Len()),
+	)
 	if len(rem) > 0 {
 		log.I.F("extra '%s'", rem)
 	}
@@ -107,20 +118,60 @@ func (a *A) HandleReq(c context.T, req []byte, srv server.I) (r []byte) {
 			return
 		}
 	}
-	var accept bool
-	allowed, accept, _ := srv.AcceptReq(
+	if !a.I.AuthRequired() && !a.Listener.IsAuthed() &&
+		slices.ContainsFunc(
+			env.Filters.F, func(f *filter.F) bool { return f.Kinds.IsGiftWrap() },
+		) {
+		// gift wraps are only served to their recipient, so even when auth is
+		// not required the client has to authenticate to read them.
+		log.I.F(
+			"requesting auth for gift wraps from client from %s",
+			a.Listener.RealRemote(),
+		)
+		if len(a.Listener.Challenge()) == 0 {
+			a.Listener.SetChallenge(auth.GenerateChallenge())
+		}
+		a.Listener.RequestAuth()
+		if err = authenvelope.NewChallengeWith(a.Listener.Challenge()).
+			Write(a.Listener); chk.E(err) {
+			return
+		}
+		if err = closedenvelope.NewFrom(
+			env.Subscription,
+			reason.AuthRequired.F("gift wraps are only served to their recipient"),
+		).Write(a.Listener); chk.E(err) {
+			return
+		}
+		return
+	}
+	var accept, modified bool
+	allowed, accept, modified := srv.AcceptReq(
 		c, a.Request, env.Filters, a.Listener.AuthedPubkey(),
 		a.Listener.RealRemote(),
 	)
 	if !accept {
 		if err = closedenvelope.NewFrom(
-			env.Subscription, []byte("filters aren't permitted for client"),
+			env.Subscription,
+			reason.Restricted.F("filters aren't permitted for client"),
 		).Write(a.Listener); chk.E(err) {
 			return
 		}
 		return
 	}
+	if modified {
+		// tell the client the results are narrower than what it asked for.
+		if err = noticeenvelope.NewFrom(
+			reason.Restricted.F(
+				"subscription %s filters were narrowed by the relay read "+
+					"policy", env.Subscription.String(),
+			),
+		).Write(a.Listener); chk.E(err) {
+			err = nil
+		}
+	}
 	var events event.S
+	var sent int
+	defer func() { span.SetAttributes(attribute.Int("events", sent)) }()
 	for _, f := range allowed.F {
 		// var i uint
 		if pointers.Present(f.Limit) {
@@ -134,23 +185,21 @@ func (a *A) HandleReq(c context.T, req []byte, srv server.I) (r []byte) {
 			}
 			continue
 		}
-		// filter events the authed pubkey is not privileged to fetch.
-		if srv.AuthRequired() {
-			var tmp event.S
-			for _, ev := range events {
-				if !auth.CheckPrivilege(a.Listener.AuthedPubkey(), ev) {
-					log.W.F(
-						"not privileged: client pubkey '%0x' event "+
-							"pubkey '%0x' kind %s privileged: %v",
-						a.Listener.AuthedPubkey(), ev.Pubkey, ev.Kind.Name(),
-						ev.Kind.IsPrivileged(),
-					)
-					continue
-				}
-				tmp = append(tmp, ev)
+		// filter events the authed pubkey is not permitted to read.
+		var tmp event.S
+		for _, ev := range events {
+			if !srv.CanRead(ev, a.Listener.AuthedPubkey(), false) {
+				log.T.F(
+					"not permitted: client pubkey '%0x' event "+
+						"pubkey '%0x' kind %s privileged: %v",
+					a.Listener.AuthedPubkey(), ev.Pubkey, ev.Kind.Name(),
+					ev.Kind.IsPrivileged(),
+				)
+				continue
 			}
-			events = tmp
+			tmp = append(tmp, ev)
 		}
+		events = tmp
 		// write out the events to the socket
 		for _, ev := range events {
 			var res *eventenvelope.Result
@@ -163,6 +212,7 @@ func (a *A) HandleReq(c context.T, req []byte, srv server.I) (r []byte) {
 			if err = res.Write(a.Listener); chk.E(err) {
 				return
 			}
+			sent++
 		}
 	}
 	if err = eoseenvelope.NewFrom(env.Subscription).
@@ -194,7 +244,7 @@ func (a *A) HandleReq(c context.T, req []byte, srv server.I) (r []byte) {
 				Listener: a.Listener,
 				Id:       env.Subscription.String(),
 				Receiver: receiver,
-				Filters:  env.Filters,
+				Filters:  allowed,
 			},
 		)
 	} else {
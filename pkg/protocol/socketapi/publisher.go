@@ -8,7 +8,7 @@ import (
 	"orly.dev/pkg/interfaces/publisher"
 	"orly.dev/pkg/interfaces/server"
 	"orly.dev/pkg/interfaces/typer"
-	"orly.dev/pkg/protocol/auth"
+	"orly.dev/pkg/protocol/subindex"
 	"orly.dev/pkg/protocol/ws"
 	"orly.dev/pkg/utils/chk"
 	"orly.dev/pkg/utils/log"
@@ -48,21 +48,33 @@ type Close struct {
 	Id string
 }
 
+// Sub identifies a single subscription of a websocket listener in the
+// subscription index.
+type Sub struct {
+	*ws.Listener
+	Id string
+}
+
 // S is a structure that manages subscriptions and associated filters for
 // websocket listeners. It uses a mutex to synchronize access to a map storing
 // subscriber connections and their filter configurations.
 type S struct {
-	// Mx is the mutex for the Map.
+	// Mx is the mutex for the Map and Index.
 	Mx sync.Mutex
 	// Map is the map of subscribers and subscriptions from the websocket api.
 	Map
+	// Index is the inverted index of the filters in Map used to find the
+	// subscriptions an event should be delivered to.
+	Index *subindex.Index[Sub]
 	// Server is an interface to the server.
 	Server server.I
 }
 
 var _ publisher.I = &S{}
 
-func New(s server.I) (publisher *S) { return &S{Map: make(Map), Server: s} }
+func New(s server.I) (publisher *S) {
+	return &S{Map: make(Map), Index: subindex.New[Sub](), Server: s}
+}
 
 func (p *S) Type() (typeName string) { return Type }
 
@@ -104,6 +116,9 @@ func (p *S) Receive(msg typer.T) {
 		}
 		p.Mx.Lock()
 		defer p.Mx.Unlock()
+		if m.Filters != nil {
+			p.Index.Add(Sub{m.Listener, m.Id}, m.Filters.F...)
+		}
 		if subs, ok := p.Map[m.Listener]; !ok {
 			subs = make(map[string]*filters.T)
 			subs[m.Id] = m.Filters
@@ -139,9 +154,10 @@ func (p *S) Receive(msg typer.T) {
 //
 // # Expected behaviour
 //
-// Delivers the event to all subscribers whose filters match the event. It
-// applies authentication checks if required by the server and skips delivery
-// for unauthenticated users when events are privileged.
+// Delivers the event to all subscribers whose filters match the event, using
+// the subscription Index so only filters that could match are evaluated. Each
+// delivery is checked with the server CanRead read authorization, the same as
+// is used for the HTTP API.
 func (p *S) Deliver(ev *event.E) {
 	var err error
 	p.Mx.Lock()
@@ -154,40 +170,38 @@ func (p *S) Deliver(ev *event.E) {
 			)
 		},
 	)
-	for w, subs := range p.Map {
-		log.T.C(
-			func() string {
-				return fmt.Sprintf(
-					"%v %s", subs, w.RealRemote(),
-				)
-			},
-		)
-		for id, subscriber := range subs {
-			if !subscriber.Match(ev) {
-				continue
+	p.Index.Matches(
+		ev, func(sub Sub) {
+			if !p.Server.CanRead(ev, sub.AuthedPubkey(), false) {
+				return
 			}
-			if p.Server.AuthRequired() {
-				if !auth.CheckPrivilege(w.AuthedPubkey(), ev) {
-					continue
-				}
-				var res *eventenvelope.Result
-				if res, err = eventenvelope.NewResultWith(id, ev); chk.E(err) {
-					continue
-				}
-				if err = res.Write(w); chk.E(err) {
-					continue
-				}
-				log.T.C(
-					func() string {
-						return fmt.Sprintf(
-							"dispatched event %0x to subscription %s", ev.ID,
-							id,
-						)
-					},
-				)
+			var res *eventenvelope.Result
+			if res, err = eventenvelope.NewResultWith(sub.Id, ev); chk.E(err) {
+				return
 			}
-		}
+			if err = res.Write(sub.Listener); chk.E(err) {
+				return
+			}
+			log.T.C(
+				func() string {
+					return fmt.Sprintf(
+						"dispatched event %0x to subscription %s", ev.ID,
+						sub.Id,
+					)
+				},
+			)
+		},
+	)
+}
+
+// Subscriptions returns the number of open subscriptions.
+func (p *S) Subscriptions() (n int) {
+	p.Mx.Lock()
+	defer p.Mx.Unlock()
+	for _, subs := range p.Map {
+		n += len(subs)
 	}
+	return
 }
 
 // removeSubscriberId removes a specific subscription from a subscriber
@@ -196,6 +210,7 @@ func (p *S) removeSubscriberId(ws *ws.Listener, id string) {
 	p.Mx.Lock()
 	var subs map[string]*filters.T
 	var ok bool
+	p.Index.Remove(Sub{ws, id})
 	if subs, ok = p.Map[ws]; ok {
 		delete(p.Map[ws], id)
 		_ = subs
@@ -209,6 +224,9 @@ func (p *S) removeSubscriberId(ws *ws.Listener, id string) {
 // removeSubscriber removes a websocket from the S collection.
 func (p *S) removeSubscriber(ws *ws.Listener) {
 	p.Mx.Lock()
+	for id := range p.Map[ws] {
+		p.Index.Remove(Sub{ws, id})
+	}
 	clear(p.Map[ws])
 	delete(p.Map, ws)
 	p.Mx.Unlock()
@@ -0,0 +1,121 @@
+package socketapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+
+	"orly.dev/pkg/app/config"
+	"orly.dev/pkg/app/relay"
+	"orly.dev/pkg/encoders/envelopes"
+	"orly.dev/pkg/encoders/envelopes/eventenvelope"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/filters"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/protocol/auth/readtester"
+	"orly.dev/pkg/protocol/socketapi"
+	"orly.dev/pkg/protocol/ws"
+	"orly.dev/pkg/utils"
+	"orly.dev/pkg/utils/context"
+)
+
+// TestDeliverReadMatrix checks that the websocket publisher applies the read
+// authorization of the relay, the same as the HTTP API.
+func TestDeliverReadMatrix(t *testing.T) {
+	cases, hidden, err := readtester.RelayMatrix()
+	if err != nil {
+		t.Fatal(err)
+	}
+	servers := make(map[bool]*relay.Server)
+	for _, authRequired := range []bool{true, false} {
+		s := &relay.Server{
+			Ctx: context.Bg(),
+			C: &config.C{
+				AuthRequired: authRequired, HideMuted: true,
+			},
+			Lists: new(relay.Lists),
+		}
+		s.SetOwnersMuted([][]byte{hidden})
+		servers[authRequired] = s
+	}
+	conns := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				conn, err := socketapi.Upgrader.Upgrade(w, r, nil)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				conns <- conn
+			},
+		),
+	)
+	defer srv.Close()
+	client, _, err := websocket.DefaultDialer.Dial(
+		"ws"+strings.TrimPrefix(srv.URL, "http"), nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	listener := ws.NewListener(<-conns, httptest.NewRequest("GET", "/", nil), true)
+	// a public note delivered after each case marks the end of what the case
+	// delivered.
+	sentinel := &event.E{
+		ID:        make([]byte, 32),
+		Pubkey:    make([]byte, 32),
+		CreatedAt: timestamp.Now(),
+		Kind:      kind.TextNote,
+		Sig:       make([]byte, 64),
+	}
+	for _, c := range cases {
+		t.Run(
+			c.Name, func(t *testing.T) {
+				p := socketapi.New(servers[c.AuthRequired])
+				listener.SetAuthedPubkey(c.Reader)
+				p.Receive(
+					&socketapi.W{
+						Listener: listener, Id: "matrix",
+						Filters: filters.New(filter.New()),
+					},
+				)
+				defer p.Receive(
+					&socketapi.W{
+						Listener: listener, Id: "matrix", Cancel: true,
+					},
+				)
+				p.Deliver(c.Event)
+				p.Deliver(sentinel)
+				_ = client.SetReadDeadline(time.Now().Add(time.Second))
+				var msg []byte
+				if _, msg, err = client.ReadMessage(); err != nil {
+					t.Fatal(err)
+				}
+				if _, msg, err = envelopes.Identify(msg); err != nil {
+					t.Fatal(err)
+				}
+				res := eventenvelope.NewResult()
+				if _, err = res.Unmarshal(msg); err != nil {
+					t.Fatalf("%s: %s", err, msg)
+				}
+				delivered := utils.FastEqual(res.Event.ID, c.Event.ID)
+				if delivered {
+					// consume the sentinel.
+					if _, _, err = client.ReadMessage(); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if delivered != c.Allowed {
+					t.Fatalf("expected delivered %v, got %v", c.Allowed, delivered)
+				}
+			},
+		)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"orly.dev/pkg/utils/context"
 	"orly.dev/pkg/utils/iptracker"
 	"orly.dev/pkg/utils/log"
+	"orly.dev/pkg/utils/metrics"
 	"orly.dev/pkg/utils/units"
 	"strings"
 	"time"
@@ -25,6 +26,10 @@ const (
 	DefaultMaxMessageSize = 1 * units.Mb
 )
 
+var connections = metrics.NewGauge(
+	"orly_websocket_connections", "open websocket connections",
+)
+
 // A is a composite type that integrates a context, a websocket Listener, and a
 // server interface to manage WebSocket-based server communication. It is
 // designed to handle message processing, authentication, and event dispatching
@@ -76,17 +81,7 @@ func (a *A) Serve(w http.ResponseWriter, r *http.Request, s server.I) {
 		return
 	}
 
-	var whitelisted bool
-	if len(c.Whitelist) > 0 {
-		for _, addr := range c.Whitelist {
-			if strings.HasPrefix(remote, addr) {
-				whitelisted = true
-			}
-		}
-	} else {
-		whitelisted = true
-	}
-	if !whitelisted {
+	if len(c.Whitelist) > 0 && !helpers.IPListed(remote, c.Whitelist) {
 		return
 	}
 
@@ -101,7 +96,9 @@ func (a *A) Serve(w http.ResponseWriter, r *http.Request, s server.I) {
 		return
 	}
 	a.Listener = ws.NewListener(conn, r, a.I.AuthRequired())
+	connections.Inc()
 	defer func() {
+		connections.Dec()
 		cancel()
 		ticker.Stop()
 		a.Publisher().Receive(
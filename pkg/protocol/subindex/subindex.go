@@ -0,0 +1,277 @@
+// Package subindex provides an inverted index of live subscription filters so
+// that publishing an event only needs to evaluate the filters that could
+// possibly match it, rather than every filter of every connected client.
+//
+// Each filter is filed under exactly one of its fields, chosen in order of
+// selectivity: ids, authors, tags, kinds. Because every field of a filter must
+// match for the filter to match, an event can only match a filter if it
+// matches the field the filter was filed under, so the candidates returned for
+// an event are always a superset of the filters that actually match. Filters
+// with none of these fields go into a catch-all bucket that is evaluated for
+// every event.
+package subindex
+
+import (
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+)
+
+// TagPrefixLen is the maximum number of bytes of a tag value used as an index
+// key. Tag filters match event tag values by prefix (see tags.T ContainsAny),
+// so filter values are filed under at most this many leading bytes, and event
+// tag values are looked up under each of their prefixes up to this length.
+const TagPrefixLen = 8
+
+// which field of a filter an entry is filed under.
+const (
+	byAll = iota
+	byId
+	byAuthor
+	byTag
+	byKind
+)
+
+// entry is a single filter belonging to a subscription key.
+type entry[K comparable] struct {
+	key    K
+	f      *filter.F
+	by     int
+	tagKey byte
+	vals   []string
+	kinds  []uint16
+}
+
+type set[K comparable] map[*entry[K]]struct{}
+
+// Index is an inverted index from event fields to the subscription filters
+// that constrain them. K identifies a subscription, for example a connection
+// and subscription id pair.
+//
+// Index is not safe for concurrent use; callers guard it with the same lock
+// that protects their subscription map.
+type Index[K comparable] struct {
+	ids     map[string]set[K]
+	authors map[string]set[K]
+	tags    map[byte]map[string]set[K]
+	kinds   map[uint16]set[K]
+	all     set[K]
+	entries map[K][]*entry[K]
+}
+
+// New creates a new empty Index.
+func New[K comparable]() (x *Index[K]) {
+	return &Index[K]{
+		ids:     make(map[string]set[K]),
+		authors: make(map[string]set[K]),
+		tags:    make(map[byte]map[string]set[K]),
+		kinds:   make(map[uint16]set[K]),
+		all:     make(set[K]),
+		entries: make(map[K][]*entry[K]),
+	}
+}
+
+// Len returns the number of subscription keys in the Index.
+func (x *Index[K]) Len() int { return len(x.entries) }
+
+// Add files the filters of a subscription under key. If the key already has
+// filters they are replaced, as with a REQ reusing a subscription id.
+func (x *Index[K]) Add(key K, ff ...*filter.F) {
+	x.Remove(key)
+	entries := make([]*entry[K], 0, len(ff))
+	for _, f := range ff {
+		if f == nil {
+			continue
+		}
+		e := &entry[K]{key: key, f: f}
+		x.file(e)
+		entries = append(entries, e)
+	}
+	if len(entries) > 0 {
+		x.entries[key] = entries
+	}
+}
+
+// Remove deletes all filters filed under key.
+func (x *Index[K]) Remove(key K) {
+	entries, ok := x.entries[key]
+	if !ok {
+		return
+	}
+	for _, e := range entries {
+		x.unfile(e)
+	}
+	delete(x.entries, key)
+}
+
+// Matches calls fn once for each subscription key that has at least one
+// filter matching ev. Only filters filed under a field of ev, plus those in
+// the catch-all bucket, are evaluated with filter.F Matches.
+func (x *Index[K]) Matches(ev *event.E, fn func(key K)) {
+	if ev == nil {
+		return
+	}
+	seen := make(map[K]struct{})
+	check := func(s set[K]) {
+		for e := range s {
+			if _, ok := seen[e.key]; ok {
+				continue
+			}
+			if e.f.Matches(ev) {
+				seen[e.key] = struct{}{}
+				fn(e.key)
+			}
+		}
+	}
+	check(x.all)
+	if len(ev.ID) > 0 {
+		check(x.ids[string(ev.ID)])
+	}
+	if len(ev.Pubkey) > 0 {
+		check(x.authors[string(ev.Pubkey)])
+	}
+	if ev.Kind != nil {
+		check(x.kinds[ev.Kind.K])
+	}
+	if len(x.tags) > 0 && ev.Tags != nil {
+		for _, t := range ev.Tags.ToSliceOfTags() {
+			if t.Len() < 2 {
+				continue
+			}
+			k := t.Key()
+			if len(k) != 1 {
+				continue
+			}
+			byVal, ok := x.tags[k[0]]
+			if !ok {
+				continue
+			}
+			v := t.Value()
+			for l := 0; l <= len(v) && l <= TagPrefixLen; l++ {
+				check(byVal[string(v[:l])])
+			}
+		}
+	}
+}
+
+// file places an entry in the bucket of the most selective field of its
+// filter.
+func (x *Index[K]) file(e *entry[K]) {
+	f := e.f
+	ti := tagIndex(f)
+	switch {
+	case f.Ids.Len() > 0:
+		e.by = byId
+		for _, id := range f.Ids.ToSliceOfBytes() {
+			e.vals = append(e.vals, string(id))
+			add(x.ids, string(id), e)
+		}
+	case f.Authors.Len() > 0:
+		e.by = byAuthor
+		for _, pk := range f.Authors.ToSliceOfBytes() {
+			e.vals = append(e.vals, string(pk))
+			add(x.authors, string(pk), e)
+		}
+	case ti >= 0:
+		e.by = byTag
+		t := f.Tags.GetTagElement(ti)
+		e.tagKey = normalizeTagKey(t.Key())
+		byVal, ok := x.tags[e.tagKey]
+		if !ok {
+			byVal = make(map[string]set[K])
+			x.tags[e.tagKey] = byVal
+		}
+		for _, v := range t.ToSliceOfBytes()[1:] {
+			if len(v) > TagPrefixLen {
+				v = v[:TagPrefixLen]
+			}
+			e.vals = append(e.vals, string(v))
+			add(byVal, string(v), e)
+		}
+	case f.Kinds.Len() > 0:
+		e.by = byKind
+		for _, k := range f.Kinds.ToUint16() {
+			e.kinds = append(e.kinds, k)
+			add(x.kinds, k, e)
+		}
+	default:
+		e.by = byAll
+		x.all[e] = struct{}{}
+	}
+}
+
+// unfile removes an entry from the buckets it was filed in, deleting buckets
+// that become empty.
+func (x *Index[K]) unfile(e *entry[K]) {
+	switch e.by {
+	case byId:
+		for _, v := range e.vals {
+			del(x.ids, v, e)
+		}
+	case byAuthor:
+		for _, v := range e.vals {
+			del(x.authors, v, e)
+		}
+	case byTag:
+		if byVal, ok := x.tags[e.tagKey]; ok {
+			for _, v := range e.vals {
+				del(byVal, v, e)
+			}
+			if len(byVal) == 0 {
+				delete(x.tags, e.tagKey)
+			}
+		}
+	case byKind:
+		for _, k := range e.kinds {
+			del(x.kinds, k, e)
+		}
+	default:
+		delete(x.all, e)
+	}
+}
+
+// tagIndex returns the position of the first tag of a filter that can be used
+// as an index key, or -1 if there is none.
+func tagIndex(f *filter.F) int {
+	if f.Tags == nil {
+		return -1
+	}
+	for i, t := range f.Tags.ToSliceOfTags() {
+		if t.Len() < 2 {
+			continue
+		}
+		if normalizeTagKey(t.Key()) != 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// normalizeTagKey strips the leading # from a filter tag key, returning zero if
+// what remains is not a single character.
+func normalizeTagKey(k []byte) byte {
+	if len(k) == 2 && k[0] == '#' {
+		k = k[1:]
+	}
+	if len(k) != 1 {
+		return 0
+	}
+	return k[0]
+}
+
+func add[V comparable, K comparable](m map[V]set[K], v V, e *entry[K]) {
+	s, ok := m[v]
+	if !ok {
+		s = make(set[K])
+		m[v] = s
+	}
+	s[e] = struct{}{}
+}
+
+func del[V comparable, K comparable](m map[V]set[K], v V, e *entry[K]) {
+	if s, ok := m[v]; ok {
+		delete(s, e)
+		if len(s) == 0 {
+			delete(m, v)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package subindex
+
+import (
+	"sort"
+	"testing"
+
+	"lukechampine.com/frand"
+
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+)
+
+// testKinds are the kinds used for generated filters and events.
+var testKinds = []uint16{0, 1, 3, 4, 6, 7, 1059, 10002, 30023}
+
+type fixture struct {
+	pubkeys [][]byte
+	ids     [][]byte
+	hashtag []string
+}
+
+func newFixture(n int) (fx *fixture) {
+	fx = &fixture{}
+	for range n {
+		fx.pubkeys = append(fx.pubkeys, frand.Bytes(32))
+		fx.ids = append(fx.ids, frand.Bytes(32))
+	}
+	fx.hashtag = []string{"nostr", "bitcoin", "grownostr", "zap", "asknostr"}
+	return
+}
+
+func (fx *fixture) pubkey() []byte { return fx.pubkeys[frand.Intn(len(fx.pubkeys))] }
+
+func (fx *fixture) id() []byte { return fx.ids[frand.Intn(len(fx.ids))] }
+
+func (fx *fixture) kind() *kind.T { return kind.New(testKinds[frand.Intn(len(testKinds))]) }
+
+// filter generates a filter shaped like those clients commonly send: mostly
+// author lists and p tag mentions, with the occasional single event id,
+// hashtag, kind list, or wide open filter.
+func (fx *fixture) filter() (f *filter.F) {
+	f = filter.New()
+	switch n := frand.Intn(20); {
+	case n < 2:
+		f.Ids = f.Ids.Append(fx.id())
+	case n < 10:
+		for range 1 + frand.Intn(8) {
+			f.Authors = f.Authors.Append(fx.pubkey())
+		}
+		f.Kinds = kinds.New(fx.kind())
+	case n < 15:
+		f.Tags = f.Tags.AppendTags(
+			tag.New([]byte("#p"), hex.EncAppend(nil, fx.pubkey())),
+		)
+		f.Kinds = kinds.New(fx.kind())
+	case n < 17:
+		f.Tags = f.Tags.AppendTags(
+			tag.New("#t", fx.hashtag[frand.Intn(len(fx.hashtag))]),
+		)
+	case n < 19:
+		f.Kinds = kinds.New(fx.kind(), fx.kind())
+	default:
+		// wide open filter, only constrained by time
+		f.Since = timestamp.FromUnix(int64(frand.Intn(1000)))
+	}
+	return
+}
+
+func (fx *fixture) event() (ev *event.E) {
+	ev = &event.E{
+		ID:        fx.id(),
+		Pubkey:    fx.pubkey(),
+		CreatedAt: timestamp.FromUnix(int64(frand.Intn(2000))),
+		Kind:      fx.kind(),
+		Tags: tags.New(
+			tag.New([]byte("p"), hex.EncAppend(nil, fx.pubkey())),
+			tag.New("t", fx.hashtag[frand.Intn(len(fx.hashtag))]),
+		),
+	}
+	return
+}
+
+// linear is the reference implementation: every filter of every subscription
+// is evaluated.
+func linear(subs map[int][]*filter.F, ev *event.E) (keys []int) {
+	for k, ff := range subs {
+		for _, f := range ff {
+			if f.Matches(ev) {
+				keys = append(keys, k)
+				break
+			}
+		}
+	}
+	return
+}
+
+func build(fx *fixture, n int) (x *Index[int], subs map[int][]*filter.F) {
+	x = New[int]()
+	subs = make(map[int][]*filter.F)
+	for i := range n {
+		var ff []*filter.F
+		for range 1 + frand.Intn(3) {
+			ff = append(ff, fx.filter())
+		}
+		subs[i] = ff
+		x.Add(i, ff...)
+	}
+	return
+}
+
+func TestIndexMatchesLinearScan(t *testing.T) {
+	fx := newFixture(64)
+	x, subs := build(fx, 2000)
+	for range 1000 {
+		ev := fx.event()
+		expected := linear(subs, ev)
+		var got []int
+		x.Matches(ev, func(k int) { got = append(got, k) })
+		sort.Ints(expected)
+		sort.Ints(got)
+		if len(expected) != len(got) {
+			t.Fatalf(
+				"index returned %d subscriptions, linear scan %d", len(got),
+				len(expected),
+			)
+		}
+		for i := range expected {
+			if expected[i] != got[i] {
+				t.Fatalf("index returned %v, linear scan %v", got, expected)
+			}
+		}
+	}
+}
+
+func TestIndexTagPrefix(t *testing.T) {
+	x := New[string]()
+	f := filter.New()
+	f.Tags = f.Tags.AppendTags(tag.New("t", "nos"))
+	x.Add("short", f)
+	f = filter.New()
+	f.Tags = f.Tags.AppendTags(tag.New("t", "nostrich-longer-than-prefix"))
+	x.Add("long", f)
+	ev := &event.E{
+		ID:        frand.Bytes(32),
+		Pubkey:    frand.Bytes(32),
+		CreatedAt: timestamp.Now(),
+		Kind:      kind.TextNote,
+		Tags:      tags.New(tag.New("t", "nostrich-longer-than-prefix")),
+	}
+	got := make(map[string]bool)
+	x.Matches(ev, func(k string) { got[k] = true })
+	if !got["short"] || !got["long"] {
+		t.Fatalf("expected both tag prefix subscriptions to match, got %v", got)
+	}
+}
+
+func TestIndexRemove(t *testing.T) {
+	fx := newFixture(8)
+	x, subs := build(fx, 200)
+	for k := range subs {
+		x.Remove(k)
+	}
+	if x.Len() != 0 {
+		t.Fatalf("expected empty index, %d keys remain", x.Len())
+	}
+	if len(x.ids) != 0 || len(x.authors) != 0 || len(x.tags) != 0 ||
+		len(x.kinds) != 0 || len(x.all) != 0 {
+		t.Fatal("expected all buckets to be removed")
+	}
+	x.Matches(fx.event(), func(k int) { t.Fatalf("unexpected match %d", k) })
+}
+
+func benchmarkDeliver(b *testing.B, n int, indexed bool) {
+	fx := newFixture(1000)
+	x, subs := build(fx, n)
+	evs := make([]*event.E, 1024)
+	for i := range evs {
+		evs[i] = fx.event()
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ev := evs[i%len(evs)]
+		if indexed {
+			x.Matches(ev, func(int) {})
+		} else {
+			linear(subs, ev)
+		}
+	}
+}
+
+func BenchmarkLinear1k(b *testing.B)   { benchmarkDeliver(b, 1000, false) }
+func BenchmarkIndex1k(b *testing.B)    { benchmarkDeliver(b, 1000, true) }
+func BenchmarkLinear10k(b *testing.B)  { benchmarkDeliver(b, 10000, false) }
+func BenchmarkIndex10k(b *testing.B)   { benchmarkDeliver(b, 10000, true) }
+func BenchmarkLinear100k(b *testing.B) { benchmarkDeliver(b, 100000, false) }
+func BenchmarkIndex100k(b *testing.B)  { benchmarkDeliver(b, 100000, true) }
@@ -0,0 +1,266 @@
+package ws
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/puzpuzpuz/xsync/v3"
+
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/filters"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/log"
+	"orly.dev/pkg/utils/metrics"
+	"orly.dev/pkg/utils/normalize"
+)
+
+var (
+	cacheQueries = metrics.NewCounter(
+		"orly_pool_cache_queries_total",
+		"filters of pool queries, by whether they were served from the cache (hit), fetched since the last fetch (delta), fetched in full (miss) or passed through to the relays (pass)",
+		"result",
+	)
+	cacheEvents = metrics.NewCounter(
+		"orly_pool_cache_events_total",
+		"events of pool queries, by whether they came from the cache or a relay",
+		"source",
+	)
+)
+
+// WithCache makes the queries of the pool that end with their EOSE, which
+// are FetchMany, FetchManyReplaceable, SubManyEose and QuerySingle, read
+// through a local event store, such as a database.D in a temporary directory.
+//
+// The events that are fetched for the filters that are cached are verified
+// and saved in the store, and their results are queried from it, so they include what was fetched before, and
+// exclude what the deletions that were fetched delete. Events that come from
+// the store rather than a relay have no RelayEvent.Relay.
+//
+// A filter for replaceable or addressable kinds of a list of authors, without
+// ids, tags, search or until, is served from the store alone if all its
+// authors and kinds were fetched from the same relays within MaxAge, and
+// otherwise only asks the relays for the events, and deletions, since the
+// oldest of those fetches. Other filters are passed through to the relays.
+type WithCache struct {
+	Store store.I
+	// MaxAge is how long the fetched replaceable and addressable events are
+	// served from the store without asking the relays, 1h if it is 0.
+	MaxAge time.Duration
+}
+
+func (o WithCache) ApplyPoolOption(pool *SimplePool) {
+	maxAge := o.MaxAge
+	if maxAge <= 0 {
+		maxAge = time.Hour
+	}
+	pool.cache = &cache{
+		store:   o.Store,
+		maxAge:  maxAge,
+		fetched: xsync.NewMapOf[string, int64](),
+	}
+}
+
+var _ PoolOption = WithCache{}
+
+// cache is the store of WithCache and when the replaceable and addressable
+// events of each author and kind were last fetched.
+type cache struct {
+	store   store.I
+	maxAge  time.Duration
+	fetched *xsync.MapOf[string, int64]
+}
+
+// relaySet returns the normalized URLs of a set of relays, sorted and
+// joined, which the fetches of the cache are kept by, as what one set of
+// relays had says nothing about another.
+func relaySet(urls []string) string {
+	set := make([]string, 0, len(urls))
+	for _, u := range urls {
+		set = append(set, string(normalize.URL(u)))
+	}
+	slices.Sort(set)
+	return strings.Join(slices.Compact(set), ",")
+}
+
+// keys returns the keys of the author and kind pairs of a filter sent to a
+// set of relays, whose results can be served from the cache, or false if
+// they can't.
+func (c *cache) keys(relays string, f *filter.F) (keys []string, ok bool) {
+	if (f.Ids != nil && f.Ids.Len() > 0) ||
+		(f.Tags != nil && f.Tags.Len() > 0) || len(f.Search) > 0 ||
+		f.Until != nil || f.Authors == nil || f.Authors.Len() == 0 ||
+		f.Kinds == nil || f.Kinds.Len() == 0 {
+		return
+	}
+	for _, k := range f.Kinds.K {
+		if !k.IsReplaceable() && !k.IsParameterizedReplaceable() {
+			return
+		}
+	}
+	for _, pk := range f.Authors.ToSliceOfBytes() {
+		for _, k := range f.Kinds.K {
+			keys = append(
+				keys, relays+" "+hex.Enc(pk)+":"+strconv.Itoa(int(k.K)),
+			)
+		}
+	}
+	return keys, true
+}
+
+// since returns the oldest of the last fetches of keys, or 0 if one of them
+// was never fetched, and whether they are all within the maximum age.
+func (c *cache) since(keys []string, now int64) (since int64, fresh bool) {
+	since = now
+	for _, k := range keys {
+		t, ok := c.fetched.Load(k)
+		if !ok {
+			return 0, false
+		}
+		since = min(since, t)
+	}
+	return since, since >= now-int64(c.maxAge/time.Second)
+}
+
+// cachedSubManyEose is SubManyEose reading through the cache, see WithCache.
+//
+// Filters that can't be served from the cache are passed through to the
+// relays, and their events are emitted as they come without being saved, as
+// the store would drop some of them, such as ephemeral and expired events, or
+// not match them, as with search.
+func (pool *SimplePool) cachedSubManyEose(
+	ctx context.T,
+	urls []string,
+	ff *filters.T,
+	opts ...SubscriptionOption,
+) chan RelayEvent {
+	c := pool.cache
+	events := make(chan RelayEvent)
+	emitted := xsync.NewMapOf[string, struct{}]()
+	emit := func(ie RelayEvent) bool {
+		if _, ok := emitted.LoadOrStore(ie.IdString(), struct{}{}); ok {
+			return true
+		}
+		if ie.Relay != nil {
+			cacheEvents.Inc("relay")
+		} else {
+			cacheEvents.Inc("cache")
+		}
+		select {
+		case events <- ie:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	relaysKey := relaySet(urls)
+	now := time.Now().Unix()
+	var direct, remote, cached []*filter.F
+	var fetching []string
+	for _, f := range ff.F {
+		keys, ok := c.keys(relaysKey, f)
+		if !ok {
+			cacheQueries.Inc("pass")
+			direct = append(direct, f)
+			continue
+		}
+		cached = append(cached, f)
+		since, fresh := c.since(keys, now)
+		if fresh {
+			cacheQueries.Inc("hit")
+			continue
+		}
+		fetching = append(fetching, keys...)
+		if since == 0 {
+			cacheQueries.Inc("miss")
+			remote = append(remote, f)
+			continue
+		}
+		cacheQueries.Inc("delta")
+		// a shallow copy, as only the since is replaced.
+		rf := *f
+		if rf.Since == nil || rf.Since.I64() < since {
+			rf.Since = timestamp.New(since)
+		}
+		remote = append(
+			remote, &rf, &filter.F{
+				Kinds:   kinds.New(kind.Deletion),
+				Authors: f.Authors,
+				Since:   rf.Since,
+			},
+		)
+	}
+	var wg sync.WaitGroup
+	if len(direct) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ie := range pool.subManyEose(
+				ctx, urls, filters.New(direct...), opts...,
+			) {
+				if !emit(ie) {
+					return
+				}
+			}
+		}()
+	}
+	if len(cached) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			relays := make(map[string]*Client)
+			if len(remote) > 0 {
+				for ie := range pool.subManyEose(
+					ctx, urls, filters.New(remote...), opts...,
+				) {
+					if ie.Relay != nil && ie.Relay.AssumeValid {
+						if ok, err := ie.Verify(); !ok || err != nil {
+							continue
+						}
+					}
+					relays[ie.IdString()] = ie.Relay
+					if ser, err := c.store.GetSerialById(ie.ID); err == nil &&
+						ser != nil {
+						// already cached
+						continue
+					}
+					if _, _, err := c.store.SaveEvent(
+						ctx, ie.E, true, nil,
+					); err != nil && !errors.Is(err, store.ErrDupEvent) {
+						log.D.F("not caching %s: %v", ie.IdString(), err)
+					}
+				}
+				if ctx.Err() == nil {
+					for _, k := range fetching {
+						c.fetched.Store(k, now)
+					}
+				}
+			}
+			for _, f := range cached {
+				evs, err := c.store.QueryEvents(ctx, f)
+				if chk.E(err) {
+					continue
+				}
+				for _, ev := range evs {
+					if !emit(RelayEvent{E: ev, Relay: relays[ev.IdString()]}) {
+						return
+					}
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(events)
+	}()
+	return events
+}
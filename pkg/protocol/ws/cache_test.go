@@ -0,0 +1,206 @@
+//go:build !js
+
+package ws
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+
+	"orly.dev/pkg/crypto/p256k"
+	"orly.dev/pkg/database"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/utils/context"
+)
+
+// fakeRelay answers every REQ with the events it has that match, and an
+// EOSE, and keeps the filters of the REQs.
+type fakeRelay struct {
+	mx     sync.Mutex
+	events []*event.E
+	reqs   []*filter.F
+}
+
+func (r *fakeRelay) add(evs ...*event.E) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.events = append(r.events, evs...)
+}
+
+func (r *fakeRelay) handler(conn *websocket.Conn) {
+	for {
+		var raw []json.RawMessage
+		if err := websocket.JSON.Receive(conn, &raw); err != nil {
+			return
+		}
+		var label, id string
+		_ = json.Unmarshal(raw[0], &label)
+		if label != "REQ" {
+			continue
+		}
+		_ = json.Unmarshal(raw[1], &id)
+		r.mx.Lock()
+		var evs []*event.E
+		for _, b := range raw[2:] {
+			f := filter.New()
+			if _, err := f.Unmarshal(b); err != nil {
+				continue
+			}
+			r.reqs = append(r.reqs, f)
+			for _, ev := range r.events {
+				if f.Matches(ev) {
+					evs = append(evs, ev)
+				}
+			}
+		}
+		r.mx.Unlock()
+		for _, ev := range evs {
+			websocket.JSON.Send(
+				conn, []any{"EVENT", id, json.RawMessage(ev.Serialize())},
+			)
+		}
+		websocket.JSON.Send(conn, []any{"EOSE", id})
+	}
+}
+
+func (r *fakeRelay) requests() (reqs []*filter.F) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return append(reqs, r.reqs...)
+}
+
+func TestCache(t *testing.T) {
+	sign := &p256k.Signer{}
+	require.NoError(t, sign.Generate())
+	now := time.Now().Unix()
+	ev := func(k *kind.T, createdAt int64, tt ...*tag.T) (e *event.E) {
+		e = &event.E{
+			Kind: k, CreatedAt: timestamp.New(createdAt), Tags: tags.New(tt...),
+		}
+		require.NoError(t, e.Sign(sign))
+		return
+	}
+	profile := ev(kind.ProfileMetadata, now-100)
+	list := ev(kind.FollowSets, now-100, tag.New("d", "friends"))
+	relay := &fakeRelay{}
+	relay.add(profile, list)
+	ws := newWebsocketServer(relay.handler)
+	defer ws.Close()
+
+	c, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	db, err := database.New(c, cancel, t.TempDir(), "error")
+	require.NoError(t, err)
+	defer db.Close()
+	pool := NewSimplePool(c, WithCache{Store: db})
+	defer pool.Close("test ended")
+
+	f := &filter.F{
+		Kinds:   kinds.New(kind.ProfileMetadata, kind.FollowSets),
+		Authors: tag.New(sign.Pub()),
+	}
+	fetch := func() (ids map[string]bool) {
+		ids = make(map[string]bool)
+		for ie := range pool.FetchMany(c, []string{ws.URL}, f) {
+			ids[ie.IdString()] = ie.Relay != nil
+		}
+		return
+	}
+
+	// the first fetch asks the relay, and the second is served from the
+	// cache.
+	assert.Equal(
+		t, map[string]bool{profile.IdString(): true, list.IdString(): true},
+		fetch(),
+	)
+	assert.Len(t, relay.requests(), 1)
+	assert.Equal(
+		t, map[string]bool{profile.IdString(): false, list.IdString(): false},
+		fetch(),
+	)
+	assert.Len(t, relay.requests(), 1)
+
+	// once the fetch is older than the maximum age, only what is newer is
+	// asked for, which replaces the profile and deletes the list.
+	for _, k := range []string{"0", "30000"} {
+		pool.cache.fetched.Store(
+			relaySet([]string{ws.URL})+" "+hex.Enc(sign.Pub())+":"+k,
+			now-7200,
+		)
+	}
+	newProfile := ev(kind.ProfileMetadata, now)
+	relay.add(newProfile, ev(kind.Deletion, now, tag.New("e", list.IdString())))
+	assert.Equal(t, map[string]bool{newProfile.IdString(): true}, fetch())
+	reqs := relay.requests()
+	require.Len(t, reqs, 3)
+	for _, r := range reqs[1:] {
+		require.NotNil(t, r.Since)
+		assert.Equal(t, now-7200, r.Since.I64())
+	}
+	assert.True(t, reqs[2].Kinds.Contains(kind.Deletion))
+}
+
+func TestCachePassThrough(t *testing.T) {
+	sign := &p256k.Signer{}
+	require.NoError(t, sign.Generate())
+	ephemeral := &event.E{
+		Kind: kind.New(20001), CreatedAt: timestamp.Now(), Tags: tags.New(),
+	}
+	require.NoError(t, ephemeral.Sign(sign))
+	profile := &event.E{
+		Kind: kind.ProfileMetadata, CreatedAt: timestamp.Now(), Tags: tags.New(),
+	}
+	require.NoError(t, profile.Sign(sign))
+	relay, other := &fakeRelay{}, &fakeRelay{}
+	relay.add(ephemeral, profile)
+	other.add(profile)
+	ws := newWebsocketServer(relay.handler)
+	defer ws.Close()
+	ows := newWebsocketServer(other.handler)
+	defer ows.Close()
+
+	c, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	db, err := database.New(c, cancel, t.TempDir(), "error")
+	require.NoError(t, err)
+	defer db.Close()
+	pool := NewSimplePool(c, WithCache{Store: db})
+	defer pool.Close("test ended")
+
+	// an ephemeral event isn't kept by the store, so it is passed through
+	// from the relay.
+	var got []RelayEvent
+	for ie := range pool.FetchMany(
+		c, []string{ws.URL}, &filter.F{
+			Kinds: kinds.New(kind.New(20001)), Authors: tag.New(sign.Pub()),
+		},
+	) {
+		got = append(got, ie)
+	}
+	require.Len(t, got, 1)
+	assert.Equal(t, ephemeral.IdString(), got[0].IdString())
+	assert.NotNil(t, got[0].Relay)
+
+	// a profile fetched from one relay is still asked for from another.
+	f := &filter.F{
+		Kinds: kinds.New(kind.ProfileMetadata), Authors: tag.New(sign.Pub()),
+	}
+	for _, u := range []string{ws.URL, ows.URL} {
+		for range pool.FetchMany(c, []string{u}, f) {
+		}
+	}
+	assert.Len(t, relay.requests(), 2)
+	assert.Len(t, other.requests(), 1)
+}
@@ -59,6 +59,17 @@ type Client struct {
 	writeQueue                    chan writeRequest
 	subscriptionChannelCloseQueue chan *Subscription
 
+	tlsConfig    *tls.Config
+	reconnect    *WithReconnect               // see WithReconnect
+	stateHandler func(ConnectionState, error) // see WithConnectionStateHandler
+	state        atomic.Int32
+	dropped      atomic.Bool   // the socket failed and the client is reconnecting
+	challenged   chan struct{} // closed on the first challenge of the socket
+	closeOnce    sync.Once
+
+	authMutex  sync.Mutex
+	authSigner signer.I // the signer of the last successful AUTH
+
 	// custom things that aren't often used
 	//
 	AssumeValid bool // this will skip verifying signatures for events received from this relay
@@ -141,7 +152,10 @@ func (r *Client) String() string {
 func (r *Client) Context() context.Context { return r.connectionContext }
 
 // IsConnected returns true if the connection to this relay seems to be active.
-func (r *Client) IsConnected() bool { return r.connectionContext.Err() == nil }
+// It is false while a client with a reconnect policy is reconnecting.
+func (r *Client) IsConnected() bool {
+	return r.connectionContext.Err() == nil && !r.dropped.Load()
+}
 
 // Connect tries to establish a websocket connection to r.URL.
 // If the context expires before the connection is complete, an error is returned.
@@ -210,7 +224,24 @@ func (r *Client) ConnectWithTLS(
 	if err != nil {
 		return fmt.Errorf("error opening websocket to '%s': %w", r.URL, err)
 	}
+	r.tlsConfig = tlsConfig
+	r.serve(conn)
+	r.setState(StateConnected, nil)
+	return nil
+}
+
+// serve starts the writer and reader loops of a newly opened socket. When
+// the socket fails the client is closed, or, if it has a reconnect policy,
+// a new socket is opened in its place; see WithReconnect.
+func (r *Client) serve(conn *Connection) {
 	r.Connection = conn
+	// the socket context ends with the socket, which is before the
+	// connection context if the client reconnects.
+	sockCtx, sockCancel := context.WithCancelCause(r.connectionContext)
+	// closed when the relay sends its first AUTH challenge on this socket.
+	challenged := make(chan struct{})
+	r.challenged = challenged
+	r.challenge = nil
 
 	// ping every 29 seconds
 	ticker := time.NewTicker(29 * time.Second)
@@ -220,23 +251,15 @@ func (r *Client) ConnectWithTLS(
 		var err error
 		for {
 			select {
-			case <-r.connectionContext.Done():
+			case <-sockCtx.Done():
 				ticker.Stop()
-				r.Connection = nil
-
-				for _, sub := range r.Subscriptions.Range {
-					sub.unsub(
-						fmt.Errorf(
-							"relay connection closed: %w / %w",
-							context.Cause(r.connectionContext),
-							r.ConnectionError,
-						),
-					)
+				if r.connectionContext.Err() != nil {
+					r.closed()
 				}
 				return
 
 			case <-ticker.C:
-				err = r.Connection.Ping(r.connectionContext)
+				err = conn.Ping(sockCtx)
 				if err != nil && !strings.Contains(
 					err.Error(), "failed to wait for pong",
 				) {
@@ -244,16 +267,19 @@ func (r *Client) ConnectWithTLS(
 						"{%s} error writing ping: %v; closing websocket", r.URL,
 						err,
 					)
-					r.Close() // this should trigger a context cancelation
+					if r.reconnect != nil {
+						// the reader fails on the closed socket and reconnects
+						_ = conn.Close()
+					} else {
+						r.Close() // this should trigger a context cancelation
+					}
 					return
 				}
 
 			case wr := <-r.writeQueue:
 				// all write requests will go through this to prevent races
 				log.D.F("{%s} sending %v\n", r.URL, string(wr.msg))
-				if err = r.Connection.WriteMessage(
-					r.connectionContext, wr.msg,
-				); err != nil {
+				if err = conn.WriteMessage(sockCtx, wr.msg); err != nil {
 					wr.answer <- err
 				}
 				close(wr.answer)
@@ -263,116 +289,145 @@ func (r *Client) ConnectWithTLS(
 
 	// general message reader loop
 	go func() {
-
+		var err error
 		for {
+			// the decoded events point into the message, and are handed
+			// on to subscriptions, so every message needs its own buffer.
 			buf := new(bytes.Buffer)
-			for {
-				buf.Reset()
-				if err := conn.ReadMessage(
-					r.connectionContext, buf,
-				); err != nil {
-					r.ConnectionError = err
+			if err = conn.ReadMessage(sockCtx, buf); err != nil {
+				r.ConnectionError = err
+				if r.reconnect == nil || r.connectionContext.Err() != nil {
 					r.Close()
 					return
 				}
-				message := buf.Bytes()
-				var t string
-				if t, message, err = envelopes.Identify(message); chk.E(err) {
+				r.dropped.Store(true)
+				sockCancel(err)
+				_ = conn.Close()
+				r.setState(StateDisconnected, err)
+				go r.reconnectLoop(err)
+				return
+			}
+			message := buf.Bytes()
+			var t string
+			if t, message, err = envelopes.Identify(message); chk.E(err) {
+				continue
+			}
+			switch t {
+			default:
+				// see WithCustomHandler
+				if r.customHandler != nil {
+					r.customHandler(buf.String())
+				}
+			case noticeenvelope.L:
+				env := noticeenvelope.New()
+				if env, message, err = noticeenvelope.Parse(message); chk.E(err) {
 					continue
 				}
-				switch t {
-				case noticeenvelope.L:
-					env := noticeenvelope.New()
-					if env, message, err = noticeenvelope.Parse(message); chk.E(err) {
-						continue
-					}
-					// see WithNoticeHandler
-					if r.notices != nil {
-						r.notices <- env.Message
-					} else {
-						log.E.F("NOTICE from %s: '%s'\n", r.URL, env.Message)
-					}
-				case authenvelope.L:
-					env := authenvelope.NewChallenge()
-					if env, message, err = authenvelope.ParseChallenge(message); chk.E(err) {
-						continue
-					}
-					if len(env.Challenge) == 0 {
-						continue
-					}
-					r.challenge = env.Challenge
-				case eventenvelope.L:
-					env := eventenvelope.NewResult()
-					if env, message, err = eventenvelope.ParseResult(message); chk.E(err) {
-						continue
-					}
-					if len(env.Subscription.T) == 0 {
+				// see WithNoticeHandler
+				if r.notices != nil {
+					r.notices <- env.Message
+				} else {
+					log.E.F("NOTICE from %s: '%s'\n", r.URL, env.Message)
+				}
+			case authenvelope.L:
+				env := authenvelope.NewChallenge()
+				if env, message, err = authenvelope.ParseChallenge(message); chk.E(err) {
+					continue
+				}
+				if len(env.Challenge) == 0 {
+					continue
+				}
+				r.challenge = env.Challenge
+				select {
+				case <-challenged:
+				default:
+					close(challenged)
+				}
+			case eventenvelope.L:
+				env := eventenvelope.NewResult()
+				if env, message, err = eventenvelope.ParseResult(message); chk.E(err) {
+					continue
+				}
+				if len(env.Subscription.T) == 0 {
+					continue
+				}
+				if sub, ok := r.Subscriptions.Load(env.Subscription.String()); !ok {
+					log.D.F(
+						"{%s} no subscription with id '%s'\n", r.URL,
+						env.Subscription,
+					)
+					continue
+				} else {
+					// check if the event matches the desired filter, ignore otherwise
+					if !sub.Filters.Match(env.Event) {
 						continue
 					}
-					if sub, ok := r.Subscriptions.Load(env.Subscription.String()); !ok {
-						log.D.F(
-							"{%s} no subscription with id '%s'\n", r.URL,
-							env.Subscription,
-						)
-						continue
-					} else {
-						// check if the event matches the desired filter, ignore otherwise
-						if !sub.Filters.Match(env.Event) {
-							// log.D.F(
-							// 	"{%s} filter does not match: %v ~ %v\n", r.URL,
-							// 	sub.Filters, env.Event,
-							// )
+					// check signature, ignore invalid, except from trusted (AssumeValid) relays
+					if !r.AssumeValid {
+						if ok, err = env.Event.Verify(); !ok {
+							log.E.F(
+								"{%s} bad signature on %s\n", r.URL,
+								env.Event.IdString(),
+							)
 							continue
 						}
-						// check signature, ignore invalid, except from trusted (AssumeValid) relays
-						if !r.AssumeValid {
-							if ok, err = env.Event.Verify(); !ok {
-								log.E.F(
-									"{%s} bad signature on %s\n", r.URL,
-									env.Event.IdString(),
-								)
-								continue
-							}
-						}
-						// dispatch this to the internal .events channel of the subscription
-						sub.dispatchEvent(env.Event)
-					}
-				case eoseenvelope.L:
-					env := eoseenvelope.New()
-					if env, message, err = eoseenvelope.Parse(message); chk.E(err) {
-						continue
-					}
-					if subscription, ok := r.Subscriptions.Load(env.Subscription.String()); ok {
-						subscription.dispatchEose()
-					}
-				case closedenvelope.L:
-					env := closedenvelope.New()
-					if env, message, err = closedenvelope.Parse(message); chk.E(err) {
-						continue
-					}
-					if subscription, ok := r.Subscriptions.Load(env.Subscription.String()); ok {
-						subscription.handleClosed(env.ReasonString())
-					}
-				case okenvelope.L:
-					env := okenvelope.New()
-					if env, message, err = okenvelope.Parse(message); chk.E(err) {
-						continue
-					}
-					if okCallback, exist := r.okCallbacks.Load(env.EventID.String()); exist {
-						okCallback(env.OK, env.ReasonString())
-					} else {
-						log.I.F(
-							"{%s} got an unexpected OK message for event %s",
-							r.URL,
-							env.EventID,
-						)
 					}
+					// dispatch this to the internal .events channel of the subscription
+					sub.dispatchEvent(env.Event)
+				}
+			case eoseenvelope.L:
+				env := eoseenvelope.New()
+				if env, message, err = eoseenvelope.Parse(message); chk.E(err) {
+					continue
+				}
+				if subscription, ok := r.Subscriptions.Load(env.Subscription.String()); ok {
+					subscription.dispatchEose()
+				}
+			case closedenvelope.L:
+				env := closedenvelope.New()
+				if env, message, err = closedenvelope.Parse(message); chk.E(err) {
+					continue
+				}
+				if subscription, ok := r.Subscriptions.Load(env.Subscription.String()); ok {
+					subscription.handleClosed(env.ReasonString())
+				}
+			case okenvelope.L:
+				env := okenvelope.New()
+				if env, message, err = okenvelope.Parse(message); chk.E(err) {
+					continue
+				}
+				if okCallback, exist := r.okCallbacks.Load(env.EventID.String()); exist {
+					okCallback(env.OK, env.ReasonString())
+				} else {
+					log.I.F(
+						"{%s} got an unexpected OK message for event %s",
+						r.URL,
+						env.EventID,
+					)
 				}
 			}
 		}
 	}()
+}
 
-	return nil
+// closed ends all the subscriptions of a client whose connection context is
+// done, once.
+func (r *Client) closed() {
+	r.closeOnce.Do(
+		func() {
+			r.Connection = nil
+			for _, sub := range r.Subscriptions.Range {
+				sub.unsub(
+					fmt.Errorf(
+						"relay connection closed: %w / %w",
+						context.Cause(r.connectionContext),
+						r.ConnectionError,
+					),
+				)
+			}
+			r.setState(StateClosed, context.Cause(r.connectionContext))
+		},
+	)
 }
 
 // Write queues an arbitrary message to be sent to the relay.
@@ -412,61 +467,65 @@ func (r *Client) Auth(
 	if err = authEvent.Sign(sign); err != nil {
 		return fmt.Errorf("error signing auth event: %w", err)
 	}
-
-	return r.publish(
+	if err = r.publish(
 		ctx, authEvent.IdString(), authenvelope.NewResponseWith(authEvent),
-	)
+	); err != nil {
+		return
+	}
+	// remembered so a client that reconnects can authenticate again.
+	r.authMutex.Lock()
+	r.authSigner = sign
+	r.authMutex.Unlock()
+	return
 }
 
 func (r *Client) publish(
 	ctx context.Context, id string, env codec.Envelope,
 ) error {
-	var err error
-	var cancel context.CancelFunc
-
 	if _, ok := ctx.Deadline(); !ok {
 		// if no timeout is set, force it to 7 seconds
+		var cancel context.CancelFunc
 		ctx, cancel = context.WithTimeoutCause(
 			ctx, 7*time.Second, fmt.Errorf("given up waiting for an OK"),
 		)
 		defer cancel()
-	} else {
-		// otherwise make the context cancellable so we can stop everything upon receiving an "OK"
-		ctx, cancel = context.WithCancel(ctx)
-		defer cancel()
 	}
 
-	// listen for an OK callback
-	gotOk := false
+	// listen for an OK callback, which is called by the reader of the
+	// connection, so the result is passed back on a channel rather than
+	// through a variable that the write below also sets.
+	result := make(chan error, 1)
 	r.okCallbacks.Store(
 		id, func(ok bool, reason string) {
-			gotOk = true
+			var err error
 			if !ok {
 				err = fmt.Errorf("msg: %s", reason)
 			}
-			cancel()
+			select {
+			case result <- err:
+			default:
+			}
 		},
 	)
 	defer r.okCallbacks.Delete(id)
 
 	// publish event
 	envb := env.Marshal(nil)
-	if err = <-r.Write(envb); err != nil {
+	if err := <-r.Write(envb); err != nil {
 		return err
 	}
 
-	for {
-		select {
-		case <-ctx.Done():
-			// this will be called when we get an OK or when the context has been canceled
-			if gotOk {
-				return err
-			}
-			return ctx.Err()
-		case <-r.connectionContext.Done():
-			// this is caused when we lose connectivity
-			return err
-		}
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-r.connectionContext.Done():
+		// this is caused when we lose connectivity
+		return fmt.Errorf(
+			"connection closed before an OK: %w",
+			context.Cause(r.connectionContext),
+		)
 	}
 }
 
@@ -531,7 +590,9 @@ func (r *Client) PrepareSubscription(
 	buf = append(buf, ':')
 	buf = append(buf, label...)
 	defer subIdPool.Put(buf)
-	sub.id = &subscription.Id{T: buf}
+	// the id is copied out of buf, which is reused by later subscriptions once
+	// it is back in the pool.
+	sub.id = &subscription.Id{T: append([]byte(nil), buf...)}
 	r.Subscriptions.Store(string(buf), sub)
 	// start handling events, eose, unsub etc:
 	go sub.start()
@@ -13,13 +13,16 @@ import (
 	"orly.dev/pkg/encoders/filter"
 	"orly.dev/pkg/encoders/filters"
 	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/kinds"
 	"orly.dev/pkg/encoders/tag"
 	"orly.dev/pkg/encoders/tags"
 	"orly.dev/pkg/encoders/timestamp"
 	"orly.dev/pkg/utils"
 	"orly.dev/pkg/utils/chk"
 	"orly.dev/pkg/utils/normalize"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -131,6 +134,26 @@ func TestPublishWriteFailed(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestPublishConnectionClosed(t *testing.T) {
+	textNote := &event.E{
+		Kind: kind.TextNote, Content: []byte("hello"),
+		CreatedAt: timestamp.Now(),
+	}
+	textNote.ID = textNote.GetIDBytes()
+	// fake relay server that reads the event and hangs up without an OK
+	ws := newWebsocketServer(
+		func(conn *websocket.Conn) {
+			var raw []json.RawMessage
+			websocket.JSON.Receive(conn, &raw)
+			conn.Close()
+		},
+	)
+	defer ws.Close()
+	rl := mustRelayConnect(t, ws.URL)
+	err := rl.Publish(context.Background(), textNote)
+	assert.Error(t, err)
+}
+
 func TestConnectContext(t *testing.T) {
 	// fake relay server
 	var mu sync.Mutex // guards connected to satisfy go test -race
@@ -187,6 +210,33 @@ func TestConnectWithOrigin(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestCustomHandler(t *testing.T) {
+	// fake relay server that sends a message of a type the client doesn't
+	// know
+	ws := newWebsocketServer(
+		func(conn *websocket.Conn) {
+			websocket.Message.Send(conn, `["FOO","bar"]`)
+			io.ReadAll(conn)
+		},
+	)
+	defer ws.Close()
+	got := make(chan string, 1)
+	r := NewRelay(
+		context.Background(), string(normalize.URL(ws.URL)),
+		WithCustomHandler(func(data string) { got <- data }),
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	require.NoError(t, r.Connect(ctx))
+	defer r.Close()
+	select {
+	case data := <-got:
+		assert.Equal(t, `["FOO","bar"]`, data)
+	case <-ctx.Done():
+		t.Fatal("the custom handler got no message")
+	}
+}
+
 func discardingHandler(conn *websocket.Conn) {
 	io.ReadAll(conn) // discard all input
 }
@@ -276,3 +326,285 @@ func parseSubscriptionMessage(
 	}
 	return id, ff
 }
+
+func TestEventsKeepTheirMessage(t *testing.T) {
+	priv, _ := makeKeyPair(t)
+	sign := &p256k.Signer{}
+	require.NoError(t, sign.InitSec(priv))
+	var evs []*event.E
+	for _, content := range []string{"first", "other"} {
+		ev := &event.E{
+			Kind: kind.TextNote, Content: []byte(content),
+			CreatedAt: timestamp.Now(), Tags: tags.New(),
+		}
+		require.NoError(t, ev.Sign(sign))
+		evs = append(evs, ev)
+	}
+	ws := newWebsocketServer(
+		func(conn *websocket.Conn) {
+			var raw []json.RawMessage
+			if err := websocket.JSON.Receive(conn, &raw); err != nil {
+				return
+			}
+			var id string
+			if len(raw) < 2 || json.Unmarshal(raw[1], &id) != nil {
+				return
+			}
+			for _, ev := range evs {
+				websocket.Message.Send(
+					conn, `["EVENT","`+id+`",`+string(ev.Serialize())+`]`,
+				)
+			}
+			websocket.JSON.Send(conn, []any{"EOSE", id})
+			io.ReadAll(conn)
+		},
+	)
+	defer ws.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	r := mustRelayConnect(t, ws.URL)
+	defer r.Close()
+	sub, err := r.Subscribe(ctx, filters.New(filter.New()))
+	require.NoError(t, err)
+
+	// the events are decoded in place, so a later message must not overwrite
+	// the ones that were already delivered.
+	var received []*event.E
+	for len(received) < len(evs) {
+		select {
+		case ev := <-sub.Events:
+			received = append(received, ev)
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for the events")
+		}
+	}
+	<-sub.EndOfStoredEvents
+	for i, ev := range received {
+		assert.Equal(t, string(evs[i].Serialize()), string(ev.Serialize()))
+	}
+}
+
+func TestClosedAfterStoredEvents(t *testing.T) {
+	priv, _ := makeKeyPair(t)
+	sign := &p256k.Signer{}
+	require.NoError(t, sign.InitSec(priv))
+	var evs []*event.E
+	for i := range 100 {
+		ev := &event.E{
+			Kind: kind.TextNote, Content: []byte(strconv.Itoa(i)),
+			CreatedAt: timestamp.Now(), Tags: tags.New(),
+		}
+		require.NoError(t, ev.Sign(sign))
+		evs = append(evs, ev)
+	}
+	// fake relay server that closes the subscription right after its EOSE
+	ws := newWebsocketServer(
+		func(conn *websocket.Conn) {
+			var raw []json.RawMessage
+			if err := websocket.JSON.Receive(conn, &raw); err != nil {
+				return
+			}
+			var id string
+			if len(raw) < 2 || json.Unmarshal(raw[1], &id) != nil {
+				return
+			}
+			for _, ev := range evs {
+				websocket.Message.Send(
+					conn, `["EVENT","`+id+`",`+string(ev.Serialize())+`]`,
+				)
+			}
+			websocket.JSON.Send(conn, []any{"EOSE", id})
+			websocket.JSON.Send(conn, []any{"CLOSED", id, "done"})
+			io.ReadAll(conn)
+		},
+	)
+	defer ws.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	r := mustRelayConnect(t, ws.URL)
+	defer r.Close()
+
+	sub, err := r.Subscribe(ctx, filters.New(filter.New()))
+	require.NoError(t, err)
+
+	// the events the relay sent before the CLOSED are all delivered before
+	// it, even to a slow reader.
+	var n int
+	evc := sub.Events
+	for {
+		select {
+		case _, ok := <-evc:
+			if !ok {
+				evc = nil
+				continue
+			}
+			n++
+			time.Sleep(time.Millisecond)
+		case <-sub.ClosedReason:
+			assert.Equal(t, len(evs), n)
+			return
+		case <-ctx.Done():
+			t.Fatalf("timed out after %d events", n)
+		}
+	}
+}
+
+func TestSubscriptionIdsAreDistinct(t *testing.T) {
+	ws := newWebsocketServer(discardingHandler)
+	defer ws.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	r, err := RelayConnect(ctx, ws.URL)
+	require.NoError(t, err)
+	defer r.Close()
+
+	// the ids are built in a pooled buffer, which must not be shared with the
+	// subscriptions that were prepared before.
+	var subs []*Subscription
+	for range 3 {
+		subs = append(
+			subs, r.PrepareSubscription(ctx, filters.New(filter.New())),
+		)
+	}
+	for _, sub := range subs {
+		assert.Equal(t, strconv.FormatInt(sub.counter, 10)+":", sub.GetID())
+	}
+}
+
+// receiveReq reads a REQ with a single filter from conn.
+func receiveReq(t *testing.T, conn *websocket.Conn) (
+	id string, f *filter.F, err error,
+) {
+	var raw []json.RawMessage
+	if err = websocket.JSON.Receive(conn, &raw); err != nil {
+		return
+	}
+	require.Len(t, raw, 3)
+	require.NoError(t, json.Unmarshal(raw[1], &id))
+	f = filter.New()
+	_, err = f.Unmarshal(raw[2])
+	return
+}
+
+func TestReconnectResumesSubscriptions(t *testing.T) {
+	sign := &p256k.Signer{}
+	require.NoError(t, sign.Generate())
+	note := func(createdAt int64) (ev *event.E) {
+		ev = &event.E{
+			Kind: kind.TextNote, Content: []byte("hello"),
+			CreatedAt: timestamp.New(createdAt), Tags: tags.New(),
+		}
+		require.NoError(t, ev.Sign(sign))
+		return
+	}
+	now := time.Now().Unix()
+	// the missed event is older than the stored one, as it was published
+	// while the client was disconnected with a created_at in the overlap.
+	stored, missed := note(now-10), note(now-20)
+
+	// the first connection sends a stored event and the EOSE and drops, and
+	// the second gets the resumed REQ and sends the stored event again, which
+	// is dropped, and the event that was missed.
+	var connections atomic.Int32
+	resumed := make(chan *filter.F, 1)
+	ws := newWebsocketServer(
+		func(conn *websocket.Conn) {
+			id, f, err := receiveReq(t, conn)
+			if err != nil {
+				return
+			}
+			if connections.Add(1) == 1 {
+				websocket.JSON.Send(
+					conn, []any{"EVENT", id, json.RawMessage(stored.Serialize())},
+				)
+				websocket.JSON.Send(conn, []any{"EOSE", id})
+				return
+			}
+			resumed <- f
+			for _, ev := range []*event.E{stored, missed} {
+				websocket.JSON.Send(
+					conn, []any{"EVENT", id, json.RawMessage(ev.Serialize())},
+				)
+			}
+			io.ReadAll(conn)
+		},
+	)
+	defer ws.Close()
+
+	var mu sync.Mutex
+	var states []ConnectionState
+	r := NewRelay(
+		context.Background(), ws.URL,
+		WithReconnect{Backoff: 10 * time.Millisecond},
+		WithConnectionStateHandler(
+			func(state ConnectionState, _ error) {
+				mu.Lock()
+				states = append(states, state)
+				mu.Unlock()
+			},
+		),
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, r.Connect(ctx))
+	defer r.Close()
+	sub, err := r.Subscribe(
+		ctx, filters.New(&filter.F{Kinds: kinds.New(kind.TextNote)}),
+	)
+	require.NoError(t, err)
+
+	for _, want := range []*event.E{stored, missed} {
+		select {
+		case ev := <-sub.Events:
+			assert.Equal(t, want.IdString(), ev.IdString())
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for an event")
+		}
+	}
+	f := <-resumed
+	require.NotNil(t, f.Since)
+	assert.Equal(t, stored.CreatedAt.I64()-resumeOverlap, f.Since.I64())
+	select {
+	case ev := <-sub.Events:
+		t.Fatalf("event %s was dispatched again", ev.IdString())
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.NoError(t, sub.Context.Err())
+	assert.True(t, r.IsConnected())
+	// the client reports it is connected again after it resumed.
+	require.Eventually(
+		t, func() bool { return r.State() == StateConnected }, time.Second,
+		time.Millisecond,
+	)
+	mu.Lock()
+	assert.Equal(
+		t, []ConnectionState{
+			StateConnected, StateDisconnected, StateReconnecting, StateConnected,
+		}, states,
+	)
+	mu.Unlock()
+}
+
+func TestReconnectGivesUp(t *testing.T) {
+	ws := newWebsocketServer(func(conn *websocket.Conn) {})
+	r := NewRelay(
+		context.Background(), ws.URL,
+		WithReconnect{Backoff: 10 * time.Millisecond, MaxAttempts: 2},
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, r.Connect(ctx))
+	sub := r.PrepareSubscription(ctx, filters.New(filter.New()))
+	// the relay drops the connection at once, and is gone when the client
+	// tries to reconnect.
+	ws.Close()
+	select {
+	case <-sub.Context.Done():
+	case <-ctx.Done():
+		t.Fatal("the client didn't give up reconnecting")
+	}
+	assert.Equal(t, StateClosed, r.State())
+	assert.ErrorContains(
+		t, context.Cause(r.Context()), "gave up reconnecting after 2 attempts",
+	)
+}
@@ -0,0 +1,429 @@
+package ws
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/puzpuzpuz/xsync/v3"
+	"golang.org/x/sync/singleflight"
+
+	"orly.dev/pkg/crypto/ec/schnorr"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/log"
+	"orly.dev/pkg/utils/normalize"
+)
+
+const (
+	// relayListTTL is how long a relay list, or the lack of one, is kept in
+	// memory before it is looked up again.
+	relayListTTL = time.Hour
+	// relayListBatch is the number of authors in a REQ for relay lists.
+	relayListBatch = 256
+	// defaultMinCoverage is the number of write relays of each author that a
+	// query is sent to, if they have as many.
+	defaultMinCoverage = 2
+)
+
+// RelayList is the relays of a user from their NIP-65 relay list, kind
+// 10002. Write relays are where they publish their events, and read relays
+// are where they look for events that mention them.
+type RelayList struct {
+	Read, Write []string
+	CreatedAt   int64
+}
+
+// ParseRelayList returns the relay list in the "r" tags of a kind 10002
+// event. A relay without a marker is both read and write, and relays that
+// aren't websocket URLs are left out.
+func ParseRelayList(ev *event.E) (rl *RelayList) {
+	rl = &RelayList{CreatedAt: ev.CreatedAt.I64()}
+	if ev.Tags == nil {
+		return
+	}
+	for _, t := range ev.Tags.GetAll(tag.New("r")).ToSliceOfTags() {
+		u := string(normalize.URL(t.Value()))
+		if !strings.HasPrefix(u, "wss://") && !strings.HasPrefix(u, "ws://") {
+			continue
+		}
+		switch t.S(2) {
+		case "read":
+			rl.Read = appendNew(rl.Read, u)
+		case "write":
+			rl.Write = appendNew(rl.Write, u)
+		default:
+			rl.Read = appendNew(rl.Read, u)
+			rl.Write = appendNew(rl.Write, u)
+		}
+	}
+	return
+}
+
+func appendNew(urls []string, u string) []string {
+	if slices.Contains(urls, u) {
+		return urls
+	}
+	return append(urls, u)
+}
+
+// WithOutbox configures the outbox model (NIP-65) routing of the pool, which
+// is used by FetchManyOutbox, SubscribeManyOutbox and PublishOutbox to find
+// the relays of the authors and tagged users of filters and events.
+type WithOutbox struct {
+	// Store is searched for relay lists before they are fetched. If it is
+	// also a store.Saver the relay lists that are fetched are saved in it.
+	Store store.Querent
+	// IndexRelays are where relay lists are fetched from, and where the
+	// events of authors without one are queried and published.
+	IndexRelays []string
+	// MinCoverage is the number of write relays of each author that a query
+	// is sent to, if they have as many, 2 if it is 0.
+	MinCoverage int
+}
+
+func (o WithOutbox) ApplyPoolOption(pool *SimplePool) {
+	pool.outbox.Store = o.Store
+	pool.outbox.IndexRelays = nil
+	for _, u := range o.IndexRelays {
+		pool.outbox.IndexRelays = append(
+			pool.outbox.IndexRelays, string(normalize.URL(u)),
+		)
+	}
+	if o.MinCoverage > 0 {
+		pool.outbox.MinCoverage = o.MinCoverage
+	}
+}
+
+var _ PoolOption = WithOutbox{}
+
+// outbox is the configuration of WithOutbox and the relay lists that were
+// looked up.
+type outbox struct {
+	WithOutbox
+	lists *xsync.MapOf[string, relayListEntry]
+	// lookups joins concurrent lookups of the same batch of authors, so they
+	// don't fetch their relay lists more than once.
+	lookups singleflight.Group
+}
+
+// relayListEntry is a relay list in memory, which is nil for a user that
+// has none.
+type relayListEntry struct {
+	list    *RelayList
+	expires time.Time
+}
+
+func newOutbox() *outbox {
+	return &outbox{
+		WithOutbox: WithOutbox{MinCoverage: defaultMinCoverage},
+		lists:      xsync.NewMapOf[string, relayListEntry](),
+	}
+}
+
+// RelayLists returns the relay lists of the given pubkeys, keyed by the
+// pubkey as a string of its bytes. They are looked up in memory, then in the
+// store of WithOutbox, and then fetched from its index relays, and users that
+// have none are left out.
+//
+// Concurrent calls that miss the same batch of authors share one lookup,
+// which runs with the context of the first of them.
+func (pool *SimplePool) RelayLists(
+	ctx context.T, pubkeys ...[]byte,
+) (lists map[string]*RelayList) {
+	o := pool.outbox
+	lists = make(map[string]*RelayList)
+	now := time.Now()
+	var missing [][]byte
+	for _, pk := range pubkeys {
+		if e, ok := o.lists.Load(string(pk)); ok && now.Before(e.expires) {
+			if e.list != nil {
+				lists[string(pk)] = e.list
+			}
+			continue
+		}
+		missing = append(missing, pk)
+	}
+	// the batches of the same authors have the same key in any order.
+	slices.SortFunc(missing, bytes.Compare)
+	missing = slices.CompactFunc(missing, bytes.Equal)
+	for batch := range slices.Chunk(missing, relayListBatch) {
+		v, _, _ := o.lookups.Do(
+			string(bytes.Join(batch, nil)), func() (any, error) {
+				return pool.lookupRelayLists(ctx, batch), nil
+			},
+		)
+		for pk, rl := range v.(map[string]*RelayList) {
+			lists[pk] = rl
+		}
+	}
+	return
+}
+
+// lookupRelayLists looks up the relay lists of a batch of authors that are
+// not in memory, in the store and then from the index relays, and keeps them
+// in memory.
+func (pool *SimplePool) lookupRelayLists(
+	ctx context.T, batch [][]byte,
+) (lists map[string]*RelayList) {
+	o := pool.outbox
+	lists = make(map[string]*RelayList)
+	now := time.Now()
+	missing := batch
+	f := &filter.F{
+		Kinds:   kinds.New(kind.RelayListMetadata),
+		Authors: tag.New(batch...),
+	}
+	found := func(ev *event.E) {
+		rl := ParseRelayList(ev)
+		if cur, ok := lists[string(ev.Pubkey)]; ok &&
+			cur.CreatedAt >= rl.CreatedAt {
+			return
+		}
+		lists[string(ev.Pubkey)] = rl
+	}
+	if o.Store != nil {
+		evs, err := o.Store.QueryEvents(ctx, f)
+		if !chk.E(err) {
+			for _, ev := range evs {
+				found(ev)
+			}
+		}
+		missing = slices.DeleteFunc(
+			slices.Clone(missing), func(pk []byte) bool {
+				_, ok := lists[string(pk)]
+				if ok {
+					o.lists.Store(
+						string(pk), relayListEntry{
+							lists[string(pk)], now.Add(relayListTTL),
+						},
+					)
+				}
+				return ok
+			},
+		)
+	}
+	if len(o.IndexRelays) > 0 && len(missing) > 0 {
+		saver, _ := o.Store.(store.Saver)
+		results := pool.FetchManyReplaceable(
+			ctx, o.IndexRelays, &filter.F{
+				Kinds:   kinds.New(kind.RelayListMetadata),
+				Authors: tag.New(missing...),
+			},
+		)
+		for _, ev := range results.Range {
+			found(ev)
+			if saver != nil {
+				if _, _, err := saver.SaveEvent(
+					ctx, ev, true, nil,
+				); err != nil && !errors.Is(err, store.ErrDupEvent) {
+					log.D.F("failed to save relay list: %v", err)
+				}
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		// the lookup was cut short, so the missing lists are not known to be
+		// absent.
+		return
+	}
+	for _, pk := range missing {
+		o.lists.Store(
+			string(pk), relayListEntry{lists[string(pk)], now.Add(relayListTTL)},
+		)
+	}
+	return
+}
+
+// groupByRelay assigns authors to the write relays of their relay lists, so
+// each author is covered by minCoverage relays, or all of theirs if they have
+// fewer, while using as few relays as possible: the relay that covers the
+// most authors that need more coverage is picked until none do. Authors
+// without write relays are assigned to the fallback relays.
+func groupByRelay(
+	authors [][]byte, lists map[string]*RelayList, minCoverage int,
+	fallback []string,
+) (groups map[string][][]byte) {
+	groups = make(map[string][][]byte)
+	need := make(map[string]int)
+	assigned := make(map[string]map[string]bool)
+	for _, a := range authors {
+		rl := lists[string(a)]
+		if rl == nil || len(rl.Write) == 0 {
+			for _, u := range fallback {
+				groups[u] = append(groups[u], a)
+			}
+			continue
+		}
+		need[string(a)] = min(minCoverage, len(rl.Write))
+		assigned[string(a)] = make(map[string]bool)
+	}
+	for {
+		counts := make(map[string]int)
+		for a, n := range need {
+			if n == 0 {
+				continue
+			}
+			for _, u := range lists[a].Write {
+				if !assigned[a][u] {
+					counts[u]++
+				}
+			}
+		}
+		var best string
+		for u, n := range counts {
+			// ties go to the first URL, so the grouping is deterministic.
+			if n > counts[best] || (n == counts[best] && u < best) {
+				best = u
+			}
+		}
+		if best == "" {
+			return
+		}
+		for _, a := range authors {
+			k := string(a)
+			if need[k] == 0 || assigned[k][best] ||
+				!slices.Contains(lists[k].Write, best) {
+				continue
+			}
+			assigned[k][best] = true
+			need[k]--
+			groups[best] = append(groups[best], a)
+		}
+	}
+}
+
+// OutboxFilters splits a filter by the write relays of its authors, as in
+// groupByRelay, into a filter for each relay with the authors that are
+// queried there. A filter without authors is sent to the index relays.
+func (pool *SimplePool) OutboxFilters(
+	ctx context.T, f *filter.F,
+) (dfs []DirectedFilter) {
+	o := pool.outbox
+	if f.Authors == nil || f.Authors.Len() == 0 {
+		for _, u := range o.IndexRelays {
+			dfs = append(dfs, DirectedFilter{Filter: f, Relay: u})
+		}
+		return
+	}
+	authors := f.Authors.ToSliceOfBytes()
+	groups := groupByRelay(
+		authors, pool.RelayLists(ctx, authors...), o.MinCoverage,
+		o.IndexRelays,
+	)
+	for u, as := range groups {
+		// a shallow copy, as only the authors are replaced.
+		rf := *f
+		rf.Authors = tag.New(as...)
+		dfs = append(dfs, DirectedFilter{Filter: &rf, Relay: u})
+	}
+	slices.SortFunc(
+		dfs, func(a, b DirectedFilter) int {
+			return strings.Compare(a.Relay, b.Relay)
+		},
+	)
+	return
+}
+
+// FetchManyOutbox is like FetchMany, but sends the filter to the write relays
+// of its authors, as in OutboxFilters, rather than to a list of relays.
+func (pool *SimplePool) FetchManyOutbox(
+	ctx context.T, f *filter.F, opts ...SubscriptionOption,
+) chan RelayEvent {
+	return pool.BatchedSubManyEose(ctx, pool.OutboxFilters(ctx, f), opts...)
+}
+
+// SubscribeManyOutbox is like SubscribeMany, but sends the filter to the
+// write relays of its authors, as in OutboxFilters, rather than to a list of
+// relays. Events that come from more than one relay are only emitted once.
+func (pool *SimplePool) SubscribeManyOutbox(
+	ctx context.T, f *filter.F, opts ...SubscriptionOption,
+) chan RelayEvent {
+	events := make(chan RelayEvent)
+	seenAlready := xsync.NewMapOf[string, struct{}]()
+	dfs := pool.OutboxFilters(ctx, f)
+	wg := sync.WaitGroup{}
+	wg.Add(len(dfs))
+	for _, df := range dfs {
+		go func(df DirectedFilter) {
+			defer wg.Done()
+			for ie := range pool.SubscribeMany(
+				ctx, []string{df.Relay}, df.Filter, opts...,
+			) {
+				if _, exists := seenAlready.LoadOrStore(
+					ie.IdString(), struct{}{},
+				); exists {
+					if pool.duplicateMiddleware != nil {
+						pool.duplicateMiddleware(df.Relay, ie.IdString())
+					}
+					continue
+				}
+				select {
+				case events <- ie:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(df)
+	}
+	go func() {
+		wg.Wait()
+		close(events)
+	}()
+	return events
+}
+
+// OutboxRelays returns the relays an event is published to in the outbox
+// model: the write relays of its author, where their followers look for it,
+// and the read relays of the users in its "p" tags, so they see it. The index
+// relays stand in for an author without a relay list.
+func (pool *SimplePool) OutboxRelays(
+	ctx context.T, ev *event.E,
+) (urls []string) {
+	pubkeys := [][]byte{ev.Pubkey}
+	if ev.Tags != nil {
+		for _, t := range ev.Tags.GetAll(tag.New("p")).ToSliceOfTags() {
+			if len(t.Value()) != 2*schnorr.PubKeyBytesLen {
+				continue
+			}
+			pk := make([]byte, schnorr.PubKeyBytesLen)
+			if _, err := hex.DecBytes(pk, t.Value()); err != nil ||
+				bytes.Equal(pk, ev.Pubkey) {
+				continue
+			}
+			pubkeys = append(pubkeys, pk)
+		}
+	}
+	lists := pool.RelayLists(ctx, pubkeys...)
+	if rl := lists[string(ev.Pubkey)]; rl != nil && len(rl.Write) > 0 {
+		urls = append(urls, rl.Write...)
+	} else {
+		urls = append(urls, pool.outbox.IndexRelays...)
+	}
+	for _, pk := range pubkeys[1:] {
+		if rl := lists[string(pk)]; rl != nil {
+			for _, u := range rl.Read {
+				urls = appendNew(urls, u)
+			}
+		}
+	}
+	return
+}
+
+// PublishOutbox is like PublishMany, but publishes the event to the relays
+// of OutboxRelays rather than to a list of relays.
+func (pool *SimplePool) PublishOutbox(
+	ctx context.T, ev *event.E,
+) chan PublishResult {
+	return pool.PublishMany(ctx, pool.OutboxRelays(ctx, ev), ev)
+}
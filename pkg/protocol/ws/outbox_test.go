@@ -0,0 +1,218 @@
+package ws
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"orly.dev/pkg/crypto/p256k"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/utils"
+)
+
+// relayListStore is a store.Querent that has the given relay lists.
+type relayListStore []*event.E
+
+func (s relayListStore) QueryEvents(
+	_ context.Context, f *filter.F,
+) (evs event.S, err error) {
+	for _, ev := range s {
+		if f.Authors.Contains(ev.Pubkey) {
+			evs = append(evs, ev)
+		}
+	}
+	return
+}
+
+func relayList(t *testing.T, rr ...[]string) (pub []byte, ev *event.E) {
+	t.Helper()
+	sign := &p256k.Signer{}
+	require.NoError(t, sign.Generate())
+	ev = &event.E{
+		Kind: kind.RelayListMetadata, CreatedAt: timestamp.Now(),
+		Tags: tags.New(),
+	}
+	for _, r := range rr {
+		ev.Tags.AppendTags(tag.New(append([]string{"r"}, r...)...))
+	}
+	require.NoError(t, ev.Sign(sign))
+	return sign.Pub(), ev
+}
+
+func TestParseRelayList(t *testing.T) {
+	_, ev := relayList(
+		t, []string{"wss://both.example.com/"}, []string{"relay.example.com", "read"},
+		[]string{"wss://write.example.com", "write"}, []string{"https://web.example.com"},
+		[]string{"ftp://files.example.com"},
+	)
+	rl := ParseRelayList(ev)
+	assert.Equal(
+		t, []string{
+			"wss://both.example.com", "wss://relay.example.com",
+			"wss://web.example.com",
+		}, rl.Read,
+	)
+	assert.Equal(
+		t, []string{
+			"wss://both.example.com", "wss://write.example.com",
+			"wss://web.example.com",
+		}, rl.Write,
+	)
+}
+
+func TestGroupByRelay(t *testing.T) {
+	lists := map[string]*RelayList{
+		"a": {Write: []string{"wss://1", "wss://2", "wss://3"}},
+		"b": {Write: []string{"wss://2", "wss://3"}},
+		"c": {Write: []string{"wss://3", "wss://4"}},
+		"d": {Write: []string{"wss://4"}},
+	}
+	groups := groupByRelay(
+		[][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d"), []byte("e")},
+		lists, 2, []string{"wss://index"},
+	)
+	str := func(bb [][]byte) (s []string) {
+		for _, b := range bb {
+			s = append(s, string(b))
+		}
+		return
+	}
+	got := make(map[string][]string)
+	for u, as := range groups {
+		got[u] = str(as)
+	}
+	// 3 covers a, b and c, then 2 covers a and b and 4 covers c and d, which
+	// has only the one relay, and e has no relay list.
+	assert.Equal(
+		t, map[string][]string{
+			"wss://3":     {"a", "b", "c"},
+			"wss://2":     {"a", "b"},
+			"wss://4":     {"c", "d"},
+			"wss://index": {"e"},
+		}, got,
+	)
+}
+
+func TestOutboxRouting(t *testing.T) {
+	alice, aliceList := relayList(
+		t, []string{"wss://alice.example.com"},
+		[]string{"wss://inbox.example.com", "read"},
+	)
+	bob, bobList := relayList(
+		t, []string{"wss://bob.example.com", "write"},
+		[]string{"wss://bob-inbox.example.com", "read"},
+	)
+	carol := make([]byte, 32)
+	pool := NewSimplePool(
+		context.Background(), WithOutbox{
+			Store:       relayListStore{aliceList, bobList},
+			IndexRelays: []string{"wss://index.example.com"},
+		},
+	)
+	defer pool.Close("test ended")
+
+	dfs := pool.OutboxFilters(
+		context.Background(), &filter.F{Authors: tag.New(alice, bob, carol)},
+	)
+	got := make(map[string]int)
+	for _, df := range dfs {
+		got[df.Relay] = df.Filter.Authors.Len()
+	}
+	assert.Equal(
+		t, map[string]int{
+			"wss://alice.example.com": 1, "wss://bob.example.com": 1,
+			"wss://index.example.com": 1,
+		}, got,
+	)
+	for _, df := range dfs {
+		if df.Relay == "wss://index.example.com" {
+			assert.True(
+				t, utils.FastEqual(carol, df.Filter.Authors.ToSliceOfBytes()[0]),
+			)
+		}
+	}
+
+	// a note by alice that mentions bob goes to alice's write relays and
+	// bob's read relay.
+	note := &event.E{
+		Pubkey: alice, Kind: kind.TextNote,
+		Tags: tags.New(tag.New("p", hex.Enc(bob))),
+	}
+	assert.Equal(
+		t, []string{
+			"wss://alice.example.com", "wss://bob-inbox.example.com",
+		}, pool.OutboxRelays(context.Background(), note),
+	)
+}
+
+// blockingStore is a relayListStore whose queries wait until release is
+// closed, and counts them.
+type blockingStore struct {
+	relayListStore
+	queries atomic.Int32
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingStore) QueryEvents(
+	c context.Context, f *filter.F,
+) (evs event.S, err error) {
+	if s.queries.Add(1) == 1 {
+		close(s.entered)
+	}
+	<-s.release
+	return s.relayListStore.QueryEvents(c, f)
+}
+
+func TestRelayListsConcurrent(t *testing.T) {
+	alice, aliceList := relayList(t, []string{"wss://alice.example.com"})
+	bob, bobList := relayList(t, []string{"wss://bob.example.com"})
+	carol, carolList := relayList(t, []string{"wss://carol.example.com"})
+	st := &blockingStore{
+		relayListStore: relayListStore{aliceList, bobList, carolList},
+		entered:        make(chan struct{}), release: make(chan struct{}),
+	}
+	pool := NewSimplePool(context.Background(), WithOutbox{Store: st})
+	defer pool.Close("test ended")
+	// carol's relay list is in memory.
+	pool.outbox.lists.Store(
+		string(carol), relayListEntry{
+			ParseRelayList(carolList), time.Now().Add(relayListTTL),
+		},
+	)
+
+	var wg sync.WaitGroup
+	results := make([]map[string]*RelayList, 2)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		results[0] = pool.RelayLists(context.Background(), alice, bob)
+	}()
+	<-st.entered
+	// a lookup that is in memory doesn't wait for the one in flight.
+	require.Len(t, pool.RelayLists(context.Background(), carol), 1)
+	// a lookup of the same authors joins the one in flight.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		results[1] = pool.RelayLists(context.Background(), bob, alice)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(st.release)
+	wg.Wait()
+	assert.Equal(t, int32(1), st.queries.Load())
+	for _, lists := range results {
+		assert.Len(t, lists, 2)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math"
 	"net/http"
+	"orly.dev/pkg/database"
 	"orly.dev/pkg/encoders/event"
 	"orly.dev/pkg/encoders/filter"
 	"orly.dev/pkg/encoders/filters"
@@ -44,6 +45,8 @@ type SimplePool struct {
 	penaltyBoxMu sync.Mutex
 	penaltyBox   map[string][2]float64
 	relayOptions []RelayOption
+	outbox       *outbox // see WithOutbox
+	cache        *cache  // see WithCache
 }
 
 // DirectedFilter combines a Filter with a specific relay URL.
@@ -59,6 +62,10 @@ type RelayEvent struct {
 }
 
 func (ie RelayEvent) String() string {
+	if ie.Relay == nil {
+		// served from the cache, see WithCache
+		return fmt.Sprintf("[cache] >> %s", ie.E.Serialize())
+	}
 	return fmt.Sprintf(
 		"[%s] >> %s", ie.Relay.URL, ie.E.Serialize(),
 	)
@@ -78,6 +85,7 @@ func NewSimplePool(ctx context.T, opts ...PoolOption) *SimplePool {
 
 		Context: ctx,
 		cancel:  cancel,
+		outbox:  newOutbox(),
 	}
 
 	for _, opt := range opts {
@@ -218,6 +226,10 @@ func (pool *SimplePool) EnsureRelay(url string) (*Client, error) {
 	} else if ok && relay.IsConnected() {
 		// already connected, unlock and return
 		return relay, nil
+	} else if ok && relay.Context().Err() == nil {
+		// a relay with a reconnect policy that is reconnecting, which keeps
+		// its subscriptions, so it isn't replaced
+		return nil, fmt.Errorf("reconnecting to %s", nm)
 	}
 
 	// try to connect
@@ -369,9 +381,25 @@ func (pool *SimplePool) FetchManyReplaceable(
 	filter *filter.F,
 	opts ...SubscriptionOption,
 ) *xsync.MapOf[ReplaceableKey, *event.E] {
-	ctx, cancel := context.Cause(ctx)
-
 	results := xsync.NewMapOf[ReplaceableKey, *event.E]()
+	if pool.cache != nil {
+		for ie := range pool.cachedSubManyEose(
+			ctx, urls, filters.New(filter), opts...,
+		) {
+			results.Compute(
+				ReplaceableKey{ie.PubKeyString(), ie.Tags.GetD()},
+				func(cur *event.E, loaded bool) (*event.E, bool) {
+					// the same event wins as in the relay.
+					if loaded && !database.Supersedes(ie.E, cur) {
+						return cur, false
+					}
+					return ie.E, false
+				},
+			)
+		}
+		return results
+	}
+	ctx, cancel := context.Cause(ctx)
 
 	wg := sync.WaitGroup{}
 	wg.Add(len(urls))
@@ -666,6 +694,18 @@ func (pool *SimplePool) SubManyEose(
 	urls []string,
 	filters *filters.T,
 	opts ...SubscriptionOption,
+) chan RelayEvent {
+	if pool.cache != nil {
+		return pool.cachedSubManyEose(ctx, urls, filters, opts...)
+	}
+	return pool.subManyEose(ctx, urls, filters, opts...)
+}
+
+func (pool *SimplePool) subManyEose(
+	ctx context.T,
+	urls []string,
+	filters *filters.T,
+	opts ...SubscriptionOption,
 ) chan RelayEvent {
 	seenAlready := xsync.NewMapOf[string, struct{}]()
 	return pool.subManyEoseNonOverwriteCheckDuplicate(
@@ -0,0 +1,193 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"lukechampine.com/frand"
+
+	"orly.dev/pkg/utils/log"
+)
+
+// ConnectionState is the state of the connection of a Client, which is
+// reported to the handler given with WithConnectionStateHandler.
+type ConnectionState int32
+
+const (
+	// StateDisconnected is reported when the socket failed, with its error.
+	StateDisconnected ConnectionState = iota
+	// StateConnected is reported when the socket is opened, and when a
+	// client that reconnected has resumed its subscriptions.
+	StateConnected
+	// StateReconnecting is reported before every attempt to reconnect, with
+	// the error of the previous one.
+	StateReconnecting
+	// StateClosed is reported once when the client is closed, or gave up
+	// reconnecting, with the cause.
+	StateClosed
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateDisconnected:
+		return "disconnected"
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateClosed:
+		return "closed"
+	}
+	return fmt.Sprintf("ConnectionState(%d)", int32(s))
+}
+
+// WithReconnect is a policy for reopening the socket of a Client when it
+// fails, rather than closing the client and all its subscriptions.
+//
+// While the client reconnects IsConnected is false, and writes wait until it
+// has reconnected. Once it has, it authenticates again with the signer of its
+// last successful Auth, after the relay sent a new challenge, and sends the
+// REQ of every active subscription again. Subscriptions that had their EOSE
+// resume from a minute before the newest event they received, so the relay
+// only sends the events that were missed, and those of the overlap that were
+// already received are dropped.
+//
+// If MaxAttempts attempts in a row fail the client is closed, which ends its
+// subscriptions as without a policy.
+type WithReconnect struct {
+	// Backoff is the wait before the first attempt, which is doubled after
+	// each failed attempt up to MaxBackoff. The defaults are 1s and 1m.
+	Backoff, MaxBackoff time.Duration
+	// MaxAttempts is the number of attempts before giving up, 0 is no limit.
+	MaxAttempts int
+	// Jitter is the fraction of each wait it is randomly changed by, so the
+	// clients of a relay that went down don't all reconnect at once.
+	Jitter float64
+}
+
+func (p WithReconnect) ApplyRelayOption(r *Client) { r.reconnect = &p }
+
+// wait returns the jittered wait before the given attempt, counted from 1.
+func (p *WithReconnect) wait(attempt int) (d time.Duration) {
+	d, ceiling := p.Backoff, p.MaxBackoff
+	if d <= 0 {
+		d = time.Second
+	}
+	if ceiling <= 0 {
+		ceiling = time.Minute
+	}
+	for i := 1; i < attempt && d < ceiling; i++ {
+		d *= 2
+	}
+	d = min(d, ceiling)
+	if p.Jitter > 0 {
+		d += time.Duration(float64(d) * p.Jitter * (2*frand.Float64() - 1))
+	}
+	return
+}
+
+// WithConnectionStateHandler is called with every change of the state of the
+// connection of a Client, and the error that caused it, if any. It is called
+// from the goroutines of the connection, so it must not block.
+type WithConnectionStateHandler func(state ConnectionState, err error)
+
+func (h WithConnectionStateHandler) ApplyRelayOption(r *Client) {
+	r.stateHandler = h
+}
+
+var (
+	_ RelayOption = WithReconnect{}
+	_ RelayOption = (WithConnectionStateHandler)(nil)
+)
+
+// State returns the last state of the connection.
+func (r *Client) State() ConnectionState {
+	return ConnectionState(r.state.Load())
+}
+
+func (r *Client) setState(state ConnectionState, err error) {
+	r.state.Store(int32(state))
+	if r.stateHandler != nil {
+		r.stateHandler(state, err)
+	}
+}
+
+// reconnectLoop opens a new socket after the last one failed with err,
+// following the reconnect policy, and resumes the subscriptions once it did.
+func (r *Client) reconnectLoop(err error) {
+	p := r.reconnect
+	for attempt := 1; p.MaxAttempts == 0 || attempt <= p.MaxAttempts; attempt++ {
+		r.setState(StateReconnecting, err)
+		select {
+		case <-time.After(p.wait(attempt)):
+		case <-r.connectionContext.Done():
+			r.closed()
+			return
+		}
+		ctx, cancel := context.WithTimeoutCause(
+			r.connectionContext, 7*time.Second,
+			errors.New("connection took too long"),
+		)
+		var conn *Connection
+		conn, err = NewConnection(ctx, r.URL, r.requestHeader, r.tlsConfig)
+		cancel()
+		if r.connectionContext.Err() != nil {
+			if conn != nil {
+				_ = conn.Close()
+			}
+			r.closed()
+			return
+		}
+		if err != nil {
+			log.D.F("{%s} reconnect attempt %d failed: %v", r.URL, attempt, err)
+			continue
+		}
+		r.serve(conn)
+		r.dropped.Store(false)
+		r.resume()
+		r.setState(StateConnected, nil)
+		return
+	}
+	r.ConnectionError = err
+	_ = r.close(
+		fmt.Errorf(
+			"gave up reconnecting after %d attempts: %w", p.MaxAttempts, err,
+		),
+	)
+	r.closed()
+}
+
+// resume authenticates again if the client was authenticated, and sends the
+// REQs of the active subscriptions on the new socket.
+func (r *Client) resume() {
+	r.authMutex.Lock()
+	sign := r.authSigner
+	r.authMutex.Unlock()
+	if sign != nil {
+		// the relay sends a challenge when it requires authentication, which
+		// needs to be answered before the REQs that depend on it.
+		select {
+		case <-r.challenged:
+			ctx, cancel := context.WithTimeout(
+				r.connectionContext, 7*time.Second,
+			)
+			if err := r.Auth(ctx, sign); err != nil {
+				log.D.F("{%s} failed to authenticate again: %v", r.URL, err)
+			}
+			cancel()
+		case <-time.After(3 * time.Second):
+		case <-r.connectionContext.Done():
+			return
+		}
+	}
+	for _, sub := range r.Subscriptions.Range {
+		if !sub.live.Load() {
+			continue
+		}
+		if err := sub.resume(); err != nil {
+			log.D.F("{%s} failed to resume %s: %v", r.URL, sub.GetID(), err)
+		}
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"orly.dev/pkg/encoders/timestamp"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // Subscription represents a subscription to a relay.
@@ -52,8 +53,26 @@ type Subscription struct {
 	// this keeps track of the events we've received before the EOSE that we must dispatch before
 	// closing the EndOfStoredEvents channel
 	storedwg sync.WaitGroup
+
+	// this keeps track of the dispatch of the EOSE, which a CLOSED that came after it waits for
+	eosewg sync.WaitGroup
+
+	// the newest created_at seen, which a client that reconnects resumes the
+	// subscription from, the ids and created_at of the events seen within
+	// resumeOverlap of it, and the time of the EOSE
+	resumeMu sync.Mutex
+	lastSeen int64
+	seenIDs  map[string]int64
+	eoseAt   int64
 }
 
+// resumeOverlap is how many seconds before the newest created_at seen a
+// subscription resumes from, for the events that were published while the
+// client was disconnected with an older created_at, or by a relay whose clock
+// is behind. The events of the overlap that were already dispatched are
+// dropped.
+const resumeOverlap = 60
+
 // SubscriptionOption is the type of the argument passed when instantiating relay connections.
 // Some examples are WithLabel.
 type SubscriptionOption interface {
@@ -95,6 +114,9 @@ func (sub *Subscription) start() {
 func (sub *Subscription) GetID() string { return sub.id.String() }
 
 func (sub *Subscription) dispatchEvent(evt *event.E) {
+	if sub.Client.reconnect != nil && sub.seen(evt) {
+		return
+	}
 	added := false
 	if !sub.eosed.Load() {
 		sub.storedwg.Add(1)
@@ -116,10 +138,42 @@ func (sub *Subscription) dispatchEvent(evt *event.E) {
 	}()
 }
 
+// seen records the created_at of evt for resuming the subscription, and
+// returns true if it was already dispatched, which happens to the events of
+// the overlap the subscription resumed with.
+func (sub *Subscription) seen(evt *event.E) bool {
+	sub.resumeMu.Lock()
+	defer sub.resumeMu.Unlock()
+	ca, id := evt.CreatedAt.I64(), string(evt.ID)
+	if _, ok := sub.seenIDs[id]; ok {
+		return true
+	}
+	if ca > sub.lastSeen {
+		sub.lastSeen = ca
+		for k, t := range sub.seenIDs {
+			if t < ca-resumeOverlap {
+				delete(sub.seenIDs, k)
+			}
+		}
+	}
+	if ca >= sub.lastSeen-resumeOverlap {
+		if sub.seenIDs == nil {
+			sub.seenIDs = make(map[string]int64)
+		}
+		sub.seenIDs[id] = ca
+	}
+	return false
+}
+
 func (sub *Subscription) dispatchEose() {
 	if sub.eosed.CompareAndSwap(false, true) {
+		sub.resumeMu.Lock()
+		sub.eoseAt = time.Now().Unix()
+		sub.resumeMu.Unlock()
 		sub.match = sub.Filters.MatchIgnoringTimestampConstraints
+		sub.eosewg.Add(1)
 		go func() {
+			defer sub.eosewg.Done()
 			sub.storedwg.Wait()
 			sub.EndOfStoredEvents <- struct{}{}
 		}()
@@ -127,8 +181,14 @@ func (sub *Subscription) dispatchEose() {
 }
 
 // handleClosed handles the CLOSED message from a relay.
+//
+// The stored events and the EOSE that the relay sent before the CLOSED are
+// dispatched first, so a relay that closes a subscription right after its
+// EOSE, such as one for ids that can't get more results, doesn't lose them.
 func (sub *Subscription) handleClosed(reason string) {
 	go func() {
+		sub.storedwg.Wait()
+		sub.eosewg.Wait()
 		sub.ClosedReason <- reason
 		sub.live.Store(false) // set this so we don't send an unnecessary CLOSE to the relay
 		sub.unsub(fmt.Errorf("CLOSED received: %s", reason))
@@ -169,6 +229,40 @@ func (sub *Subscription) Sub(_ context.Context, ff *filters.T) {
 	sub.Fire()
 }
 
+// resume sends the "REQ" of a subscription again after its client
+// reconnected. Before the EOSE the filters are sent as they are, and after it
+// with a since of resumeOverlap before the newest created_at seen, so the
+// relay only sends what was missed while disconnected, and the events that
+// were already dispatched are dropped by seen. Only if no event was seen is
+// the time of the EOSE used, by the local clock.
+func (sub *Subscription) resume() (err error) {
+	ff := sub.Filters
+	if sub.eosed.Load() {
+		sub.resumeMu.Lock()
+		since := sub.lastSeen
+		if since == 0 {
+			since = sub.eoseAt
+		}
+		since -= resumeOverlap
+		sub.resumeMu.Unlock()
+		ff = filters.Make(len(sub.Filters.F))
+		for i, f := range sub.Filters.F {
+			// a shallow copy, as only the since is replaced.
+			c := *f
+			if c.Since == nil || c.Since.I64() < since {
+				c.Since = timestamp.New(since)
+			}
+			ff.F[i] = &c
+		}
+	}
+	if err = <-sub.Client.Write(
+		reqenvelope.NewFrom(sub.id, ff).Marshal(nil),
+	); err != nil {
+		err = fmt.Errorf("failed to write: %w", err)
+	}
+	return
+}
+
 // Fire sends the "REQ" command to the relay.
 func (sub *Subscription) Fire() (err error) {
 	var reqb []byte
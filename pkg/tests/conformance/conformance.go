@@ -0,0 +1,348 @@
+// Package conformance is an end to end test suite of the nostr protocol that
+// drives a relay at any URL through the behaviours of NIP-01, 09, 11, 40, 42,
+// 45 and 50 with a ws.Client, and reports whether each case passed.
+//
+// Each case uses its own newly generated keys, so the suite can be run against
+// a relay that already has events, and more than once. Cases of a NIP other
+// than 1 and 11 are skipped when the relay information document doesn't list
+// the NIP as supported.
+//
+// Events that don't match the filters of a subscription are discarded by the
+// ws.Client, so a relay that sends more events than were requested is not
+// detected, only one that sends fewer or the wrong versions.
+package conformance
+
+import (
+	"fmt"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"orly.dev/pkg/crypto/p256k"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/filters"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/protocol/relayinfo"
+	"orly.dev/pkg/protocol/ws"
+	"orly.dev/pkg/utils/context"
+)
+
+// Timeout is the time a case may take before it fails.
+const Timeout = 10 * time.Second
+
+// Status is the outcome of a case.
+type Status string
+
+const (
+	Pass Status = "pass"
+	Fail Status = "fail"
+	Skip Status = "skip"
+)
+
+// Case is a behaviour of a NIP that a relay is expected to have.
+type Case struct {
+	NIP  int
+	Name string
+	Run  func(t *T)
+}
+
+// Result is the outcome of running a Case against a relay.
+type Result struct {
+	NIP      int     `json:"nip"`
+	Case     string  `json:"case"`
+	Status   Status  `json:"status"`
+	Detail   string  `json:"detail,omitempty"`
+	Duration float64 `json:"duration_ms"`
+}
+
+func (r Result) String() string {
+	s := fmt.Sprintf("%-4s NIP-%02d %s", r.Status, r.NIP, r.Case)
+	if r.Detail != "" {
+		s += ": " + r.Detail
+	}
+	return s
+}
+
+// T is the state of a running case, with helpers to connect to the relay and
+// publish and query events that end the case with a failure when the relay
+// doesn't respond as expected.
+type T struct {
+	// C is the context of the case, which is canceled after the Timeout.
+	C context.T
+	// URL is the websocket URL of the relay.
+	URL string
+	// Info is the relay information document.
+	Info *relayinfo.T
+
+	mx      sync.Mutex
+	status  Status
+	detail  string
+	clients []*ws.Client
+}
+
+// Fatalf ends the case as failed, with the formatted detail.
+func (t *T) Fatalf(format string, args ...any) {
+	t.end(Fail, fmt.Sprintf(format, args...))
+}
+
+// Skipf ends the case as skipped, with the formatted reason.
+func (t *T) Skipf(format string, args ...any) {
+	t.end(Skip, fmt.Sprintf(format, args...))
+}
+
+func (t *T) end(status Status, detail string) {
+	t.mx.Lock()
+	t.status, t.detail = status, detail
+	t.mx.Unlock()
+	runtime.Goexit()
+}
+
+// Connect opens a new connection to the relay, which is closed at the end of
+// the case.
+func (t *T) Connect(opts ...ws.RelayOption) (rl *ws.Client) {
+	rl = ws.NewRelay(t.C, t.URL, opts...)
+	if err := rl.Connect(t.C); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.mx.Lock()
+	t.clients = append(t.clients, rl)
+	t.mx.Unlock()
+	return
+}
+
+// Signer generates a new key.
+func (t *T) Signer() (s *p256k.Signer) {
+	s = new(p256k.Signer)
+	if err := s.Generate(); err != nil {
+		t.Fatalf("failed to generate a key: %v", err)
+	}
+	return
+}
+
+// Event creates an event signed by s. A createdAt of zero is now.
+func (t *T) Event(
+	s *p256k.Signer, k uint16, createdAt int64, content string,
+	tt ...*tag.T,
+) (ev *event.E) {
+	if createdAt == 0 {
+		createdAt = time.Now().Unix()
+	}
+	ev = &event.E{
+		Kind:      kind.New(k),
+		CreatedAt: timestamp.FromUnix(createdAt),
+		Tags:      tags.New(tt...),
+		Content:   []byte(content),
+	}
+	if err := ev.Sign(s); err != nil {
+		t.Fatalf("failed to sign event: %v", err)
+	}
+	return
+}
+
+// Publish sends events to the relay and fails unless each is accepted.
+func (t *T) Publish(rl *ws.Client, evs ...*event.E) {
+	for _, ev := range evs {
+		if err := rl.Publish(t.C, ev); err != nil {
+			t.Fatalf(
+				"kind %d event %s was not accepted: %v", ev.Kind.K,
+				ev.IdString(), err,
+			)
+		}
+	}
+}
+
+// Rejected sends an event to the relay, which must refuse it with an OK
+// message whose reason starts with prefix, such as "invalid:".
+func (t *T) Rejected(rl *ws.Client, ev *event.E, prefix string) {
+	err := rl.Publish(t.C, ev)
+	if err == nil {
+		t.Fatalf("kind %d event was accepted", ev.Kind.K)
+	}
+	// a refusal is reported by the client as "msg: " and the reason.
+	reason, ok := strings.CutPrefix(err.Error(), "msg: ")
+	if !ok {
+		t.Fatalf("no OK message for the event: %v", err)
+	}
+	if !strings.HasPrefix(reason, prefix) {
+		t.Fatalf("reason '%s' doesn't start with '%s'", reason, prefix)
+	}
+}
+
+// Req subscribes to the filters and returns the stored events the relay sent
+// before EOSE, or the reason of a CLOSED message if it closed the
+// subscription instead.
+func (t *T) Req(rl *ws.Client, ff ...*filter.F) (evs event.S, closed string) {
+	c, cancel := context.Cancel(t.C)
+	defer cancel()
+	sub, err := rl.Subscribe(c, filters.New(ff...))
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	for {
+		select {
+		case ev := <-sub.Events:
+			if ev != nil {
+				evs = append(evs, ev)
+			}
+		case <-sub.EndOfStoredEvents:
+			return
+		case closed = <-sub.ClosedReason:
+			// a relay may close a subscription after its EOSE, such as one for
+			// ids, and the client dispatches the EOSE first.
+			select {
+			case <-sub.EndOfStoredEvents:
+				closed = ""
+				return
+			default:
+			}
+			if closed == "" {
+				closed = "(empty reason)"
+			}
+			return
+		case <-t.C.Done():
+			t.Fatalf("no EOSE or CLOSED before the timeout")
+		}
+	}
+}
+
+// Query returns the stored events that match the filters, and fails if the
+// relay closes the subscription.
+func (t *T) Query(rl *ws.Client, ff ...*filter.F) (evs event.S) {
+	var closed string
+	if evs, closed = t.Req(rl, ff...); closed != "" {
+		t.Fatalf("subscription was closed: %s", closed)
+	}
+	return
+}
+
+// Expect fails unless evs are the events of want, in any order.
+func (t *T) Expect(evs event.S, want ...*event.E) {
+	got := make(map[string]*event.E, len(evs))
+	for _, ev := range evs {
+		got[ev.IdString()] = ev
+	}
+	for _, ev := range want {
+		if got[ev.IdString()] == nil {
+			t.Fatalf(
+				"kind %d event created at %d is missing from the %d events "+
+					"returned", ev.Kind.K, ev.CreatedAt.I64(), len(evs),
+			)
+		}
+	}
+	if len(evs) != len(want) {
+		t.Fatalf("got %d events, want %d", len(evs), len(want))
+	}
+}
+
+// Cases are all the cases of the suite, in the order they are run.
+var Cases = join(nip01, nip09, nip11, nip40, nip42, nip45, nip50)
+
+func join(sets ...[]Case) (cases []Case) {
+	for _, s := range sets {
+		cases = append(cases, s...)
+	}
+	return
+}
+
+// Select returns the cases of the given NIPs whose NIP and name, such as
+// "NIP-01 publish and fetch by id", match the pattern. No NIPs is all of
+// them, and an empty pattern matches every case.
+func Select(pattern string, nips ...int) (cases []Case, err error) {
+	var re *regexp.Regexp
+	if re, err = regexp.Compile(pattern); err != nil {
+		return
+	}
+	for _, cs := range Cases {
+		if len(nips) > 0 && !contains(nips, cs.NIP) {
+			continue
+		}
+		if re.MatchString(fmt.Sprintf("NIP-%02d %s", cs.NIP, cs.Name)) {
+			cases = append(cases, cs)
+		}
+	}
+	return
+}
+
+func contains(nips []int, n int) bool {
+	for _, nip := range nips {
+		if nip == n {
+			return true
+		}
+	}
+	return false
+}
+
+// Run runs the cases against the relay at url one after the other.
+//
+// # Parameters
+//
+//   - c: The context of the run, which stops it when canceled.
+//
+//   - url: The websocket URL of the relay, ws:// or wss://.
+//
+//   - cases: The cases to run, such as Cases.
+//
+// # Return Values
+//
+//   - results: The result of each of the cases, in the same order.
+//
+//   - err: An error if the relay information document could not be fetched,
+//     in which case no cases are run.
+func Run(c context.T, url string, cases []Case) (
+	results []Result, err error,
+) {
+	var info *relayinfo.T
+	if info, _, err = fetchInfo(c, url); err != nil {
+		return
+	}
+	for _, cs := range cases {
+		if c.Err() != nil {
+			break
+		}
+		results = append(results, RunCase(c, url, info, cs))
+	}
+	return
+}
+
+// RunCase runs one case against the relay at url, which is skipped if it is
+// not of NIP-01 or NIP-11 and info doesn't list its NIP.
+func RunCase(c context.T, url string, info *relayinfo.T, cs Case) (r Result) {
+	r = Result{NIP: cs.NIP, Case: cs.Name, Status: Pass}
+	if cs.NIP != 1 && cs.NIP != 11 && !info.HasNIP(cs.NIP) {
+		r.Status = Skip
+		r.Detail = fmt.Sprintf("NIP-%02d is not supported", cs.NIP)
+		return
+	}
+	t := &T{URL: url, Info: info, status: Pass}
+	var cancel context.F
+	t.C, cancel = context.Timeout(c, Timeout)
+	defer cancel()
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() {
+			if p := recover(); p != nil {
+				t.mx.Lock()
+				t.status, t.detail = Fail, fmt.Sprintf("panic: %v", p)
+				t.mx.Unlock()
+			}
+		}()
+		cs.Run(t)
+	}()
+	<-done
+	r.Duration = float64(time.Since(start).Microseconds()) / 1000
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	for _, rl := range t.clients {
+		_ = rl.Close()
+	}
+	r.Status, r.Detail = t.status, t.detail
+	return
+}
@@ -0,0 +1,62 @@
+package conformance
+
+import (
+	"fmt"
+	"testing"
+
+	"orly.dev/pkg/app/relay"
+	"orly.dev/pkg/utils/context"
+)
+
+// startRelay starts an orly relay with the default configuration and its
+// event store in a temporary directory, and returns its websocket URL.
+func startRelay(t *testing.T) (url string) {
+	t.Helper()
+	c, cancel := context.Cancel(context.Bg())
+	t.Cleanup(cancel)
+	server, err := relay.StartEmbedded(c, t.TempDir(), 0, "error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Shutdown)
+	return "ws://" + server.Addr
+}
+
+func TestConformance(t *testing.T) {
+	url := startRelay(t)
+	info, _, err := fetchInfo(context.Bg(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cs := range Cases {
+		t.Run(
+			fmt.Sprintf("NIP-%02d %s", cs.NIP, cs.Name), func(t *testing.T) {
+				r := RunCase(context.Bg(), url, info, cs)
+				switch r.Status {
+				case Fail:
+					t.Error(r.Detail)
+				case Skip:
+					t.Skip(r.Detail)
+				}
+			},
+		)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	cases, err := Select("replaceable", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cases) != 3 {
+		t.Errorf("selected %d cases, want 3", len(cases))
+	}
+	for _, cs := range cases {
+		if cs.NIP != 1 {
+			t.Errorf("selected a case of NIP-%02d", cs.NIP)
+		}
+	}
+	if _, err = Select("("); err == nil {
+		t.Error("invalid pattern was accepted")
+	}
+}
@@ -0,0 +1,254 @@
+package conformance
+
+import (
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/filters"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/utils/context"
+)
+
+// byAuthor returns a filter for the events of kind k by the pubkey pk.
+func byAuthor(pk []byte, k ...uint16) (f *filter.F) {
+	f = &filter.F{Authors: tag.New(pk)}
+	if len(k) > 0 {
+		f.Kinds = kinds.FromIntSlice(ints(k))
+	}
+	return
+}
+
+func ints(k []uint16) (n []int) {
+	for _, v := range k {
+		n = append(n, int(v))
+	}
+	return
+}
+
+func limit(n uint) *uint { return &n }
+
+var nip01 = []Case{
+	{
+		1, "publish and fetch by id", func(t *T) {
+			rl := t.Connect()
+			ev := t.Event(t.Signer(), kind.TextNote.K, 0, "hello")
+			t.Publish(rl, ev)
+			evs := t.Query(rl, &filter.F{Ids: tag.New(ev.ID)})
+			t.Expect(evs, ev)
+			if string(evs[0].Content) != "hello" {
+				t.Fatalf("content is '%s', want 'hello'", evs[0].Content)
+			}
+		},
+	},
+	{
+		1, "invalid signature is rejected", func(t *T) {
+			rl := t.Connect()
+			ev := t.Event(t.Signer(), kind.TextNote.K, 0, "forged")
+			ev.Sig[0] ^= 0xff
+			t.Rejected(rl, ev, "invalid:")
+			t.Expect(t.Query(rl, &filter.F{Ids: tag.New(ev.ID)}))
+		},
+	},
+	{
+		1, "incorrect id is rejected", func(t *T) {
+			rl := t.Connect()
+			ev := t.Event(t.Signer(), kind.TextNote.K, 0, "original")
+			ev.Content = []byte("altered")
+			t.Rejected(rl, ev, "invalid:")
+		},
+	},
+	{
+		1, "duplicate is accepted", func(t *T) {
+			rl := t.Connect()
+			ev := t.Event(t.Signer(), kind.TextNote.K, 0, "twice")
+			t.Publish(rl, ev, ev)
+			t.Expect(t.Query(rl, &filter.F{Ids: tag.New(ev.ID)}), ev)
+		},
+	},
+	{
+		1, "replaceable event keeps the newest version", func(t *T) {
+			rl := t.Connect()
+			s := t.Signer()
+			now := timestamp.Now().I64()
+			older := t.Event(s, kind.ProfileMetadata.K, now-10, `{"name":"a"}`)
+			newer := t.Event(s, kind.ProfileMetadata.K, now, `{"name":"b"}`)
+			t.Publish(rl, older, newer)
+			t.Expect(
+				t.Query(rl, byAuthor(s.Pub(), kind.ProfileMetadata.K)), newer,
+			)
+		},
+	},
+	{
+		1, "older replaceable event doesn't replace a newer one",
+		func(t *T) {
+			rl := t.Connect()
+			s := t.Signer()
+			now := timestamp.Now().I64()
+			newer := t.Event(s, kind.FollowList.K, now, "")
+			older := t.Event(s, kind.FollowList.K, now-10, "")
+			t.Publish(rl, newer)
+			// the relay may refuse the older version or accept and discard it.
+			_ = rl.Publish(t.C, older)
+			t.Expect(t.Query(rl, byAuthor(s.Pub(), kind.FollowList.K)), newer)
+		},
+	},
+	{
+		1, "replaceable events of the same second keep the lowest id",
+		func(t *T) {
+			rl := t.Connect()
+			s := t.Signer()
+			now := timestamp.Now().I64()
+			a := t.Event(s, kind.ProfileMetadata.K, now, `{"name":"a"}`)
+			b := t.Event(s, kind.ProfileMetadata.K, now, `{"name":"b"}`)
+			lowest := a
+			if b.IdString() < a.IdString() {
+				lowest = b
+			}
+			_ = rl.Publish(t.C, a)
+			_ = rl.Publish(t.C, b)
+			t.Expect(
+				t.Query(rl, byAuthor(s.Pub(), kind.ProfileMetadata.K)), lowest,
+			)
+		},
+	},
+	{
+		1, "addressable event keeps the newest version of each d tag",
+		func(t *T) {
+			rl := t.Connect()
+			s := t.Signer()
+			now := timestamp.Now().I64()
+			k := kind.LongFormContent.K
+			a1 := t.Event(s, k, now-10, "a1", tag.New("d", "a"))
+			a2 := t.Event(s, k, now, "a2", tag.New("d", "a"))
+			b1 := t.Event(s, k, now-5, "b1", tag.New("d", "b"))
+			t.Publish(rl, a1, a2, b1)
+			t.Expect(t.Query(rl, byAuthor(s.Pub(), k)), a2, b1)
+			f := byAuthor(s.Pub(), k)
+			f.Tags = tags.New(tag.New("d", "a"))
+			t.Expect(t.Query(rl, f), a2)
+		},
+	},
+	{
+		1, "ephemeral event is not stored", func(t *T) {
+			rl := t.Connect()
+			s := t.Signer()
+			_ = rl.Publish(t.C, t.Event(s, 20001, 0, "gone"))
+			t.Expect(t.Query(rl, byAuthor(s.Pub(), 20001)))
+		},
+	},
+	{
+		1, "limit returns the newest events first", func(t *T) {
+			rl := t.Connect()
+			s := t.Signer()
+			now := timestamp.Now().I64()
+			var evs []*event.E
+			for i := range 5 {
+				evs = append(
+					evs, t.Event(s, kind.TextNote.K, now-int64(5-i), "note"),
+				)
+			}
+			t.Publish(rl, evs...)
+			f := byAuthor(s.Pub(), kind.TextNote.K)
+			f.Limit = limit(3)
+			got := t.Query(rl, f)
+			t.Expect(got, evs[2:]...)
+			for i := 1; i < len(got); i++ {
+				if got[i].CreatedAt.I64() > got[i-1].CreatedAt.I64() {
+					t.Fatalf("events are not in descending created_at order")
+				}
+			}
+		},
+	},
+	{
+		1, "since and until are inclusive", func(t *T) {
+			rl := t.Connect()
+			s := t.Signer()
+			now := timestamp.Now().I64()
+			var evs []*event.E
+			for i := range 3 {
+				evs = append(
+					evs, t.Event(s, kind.TextNote.K, now-int64(3-i), "note"),
+				)
+			}
+			t.Publish(rl, evs...)
+			f := byAuthor(s.Pub(), kind.TextNote.K)
+			f.Since = evs[1].CreatedAt
+			f.Until = evs[1].CreatedAt
+			t.Expect(t.Query(rl, f), evs[1])
+			f = byAuthor(s.Pub(), kind.TextNote.K)
+			f.Since = evs[1].CreatedAt
+			t.Expect(t.Query(rl, f), evs[1], evs[2])
+		},
+	},
+	{
+		1, "since after until matches nothing", func(t *T) {
+			rl := t.Connect()
+			s := t.Signer()
+			now := timestamp.Now().I64()
+			t.Publish(rl, t.Event(s, kind.TextNote.K, now-5, "note"))
+			f := byAuthor(s.Pub())
+			f.Since = timestamp.FromUnix(now)
+			f.Until = timestamp.FromUnix(now - 10)
+			t.Expect(t.Query(rl, f))
+		},
+	},
+	{
+		1, "tag filter values match any of them", func(t *T) {
+			rl := t.Connect()
+			s := t.Signer()
+			x := t.Event(s, kind.TextNote.K, 0, "x", tag.New("t", "x"))
+			y := t.Event(s, kind.TextNote.K, 0, "y", tag.New("t", "y"))
+			z := t.Event(s, kind.TextNote.K, 0, "z", tag.New("t", "z"))
+			t.Publish(rl, x, y, z)
+			f := byAuthor(s.Pub())
+			f.Tags = tags.New(tag.New("t", "x", "y"))
+			t.Expect(t.Query(rl, f), x, y)
+		},
+	},
+	{
+		1, "filters of a REQ are combined", func(t *T) {
+			rl := t.Connect()
+			s := t.Signer()
+			note := t.Event(s, kind.TextNote.K, 0, "note")
+			reaction := t.Event(s, kind.Reaction.K, 0, "+")
+			repost := t.Event(s, kind.Repost.K, 0, "")
+			t.Publish(rl, note, reaction, repost)
+			t.Expect(
+				t.Query(
+					rl, byAuthor(s.Pub(), kind.TextNote.K),
+					byAuthor(s.Pub(), kind.Reaction.K),
+				), note, reaction,
+			)
+		},
+	},
+	{
+		1, "new events are sent after EOSE", func(t *T) {
+			rl := t.Connect()
+			s := t.Signer()
+			c, cancel := context.Cancel(t.C)
+			defer cancel()
+			sub, err := rl.Subscribe(c, filters.New(byAuthor(s.Pub())))
+			if err != nil {
+				t.Fatalf("failed to subscribe: %v", err)
+			}
+			select {
+			case <-sub.EndOfStoredEvents:
+			case reason := <-sub.ClosedReason:
+				t.Fatalf("subscription was closed: %s", reason)
+			case <-t.C.Done():
+				t.Fatalf("no EOSE before the timeout")
+			}
+			ev := t.Event(s, kind.TextNote.K, 0, "live")
+			t.Publish(t.Connect(), ev)
+			select {
+			case got := <-sub.Events:
+				t.Expect(event.S{got}, ev)
+			case <-t.C.Done():
+				t.Fatalf("the new event was not sent to the subscription")
+			}
+		},
+	},
+}
@@ -0,0 +1,69 @@
+package conformance
+
+import (
+	"fmt"
+
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/timestamp"
+)
+
+var nip09 = []Case{
+	{
+		9, "deletion by the author removes the event", func(t *T) {
+			rl := t.Connect()
+			s := t.Signer()
+			note := t.Event(s, kind.TextNote.K, 0, "to delete")
+			kept := t.Event(s, kind.TextNote.K, 0, "to keep")
+			t.Publish(rl, note, kept)
+			t.Publish(
+				rl, t.Event(
+					s, kind.Deletion.K, 0, "", tag.New("e", note.IdString()),
+				),
+			)
+			t.Expect(t.Query(rl, byAuthor(s.Pub(), kind.TextNote.K)), kept)
+		},
+	},
+	{
+		9, "deletion by another author doesn't remove the event",
+		func(t *T) {
+			rl := t.Connect()
+			s := t.Signer()
+			note := t.Event(s, kind.TextNote.K, 0, "not yours")
+			t.Publish(rl, note)
+			// the relay may refuse the deletion or accept and ignore it.
+			_ = rl.Publish(
+				t.C, t.Event(
+					t.Signer(), kind.Deletion.K, 0, "",
+					tag.New("e", note.IdString()),
+				),
+			)
+			t.Expect(t.Query(rl, &filter.F{Ids: tag.New(note.ID)}), note)
+		},
+	},
+	{
+		9, "deletion by address removes the versions before it",
+		func(t *T) {
+			rl := t.Connect()
+			s := t.Signer()
+			now := timestamp.Now().I64()
+			k := kind.LongFormContent.K
+			old := t.Event(s, k, now-10, "old", tag.New("d", "article"))
+			t.Publish(rl, old)
+			t.Publish(
+				rl, t.Event(
+					s, kind.Deletion.K, now-5, "", tag.New(
+						"a", fmt.Sprintf("%d:%s:article", k, hex.Enc(s.Pub())),
+					),
+				),
+			)
+			t.Expect(t.Query(rl, byAuthor(s.Pub(), k)))
+			// a version newer than the deletion is not deleted.
+			newer := t.Event(s, k, now, "new", tag.New("d", "article"))
+			t.Publish(rl, newer)
+			t.Expect(t.Query(rl, byAuthor(s.Pub(), k)), newer)
+		},
+	},
+}
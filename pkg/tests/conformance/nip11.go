@@ -0,0 +1,69 @@
+package conformance
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"orly.dev/pkg/protocol/relayinfo"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/errorf"
+)
+
+// fetchInfo gets the relay information document of the relay at the
+// websocket URL u, and the headers of the response.
+func fetchInfo(c context.T, u string) (
+	info *relayinfo.T, header http.Header, err error,
+) {
+	// ws:// becomes http:// and wss:// https://.
+	u = "http" + strings.TrimPrefix(u, "ws")
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(
+		c, http.MethodGet, u, nil,
+	); err != nil {
+		return
+	}
+	req.Header.Set("Accept", "application/nostr+json")
+	req.Header.Set("Origin", "https://example.com")
+	var resp *http.Response
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		err = errorf.E("failed to fetch the relay information: %w", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = errorf.E(
+			"relay information request returned status %s", resp.Status,
+		)
+		return
+	}
+	var b []byte
+	if b, err = io.ReadAll(resp.Body); err != nil {
+		return
+	}
+	info = &relayinfo.T{}
+	if err = json.Unmarshal(b, info); err != nil {
+		err = errorf.E("relay information is not valid: %w", err)
+		return
+	}
+	header = resp.Header
+	return
+}
+
+var nip11 = []Case{
+	{
+		11, "relay information document", func(t *T) {
+			info, header, err := fetchInfo(t.C, t.URL)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			if !info.HasNIP(1) {
+				t.Fatalf("supported_nips %v doesn't list NIP-01", info.Nips)
+			}
+			if header.Get("Access-Control-Allow-Origin") == "" {
+				t.Fatalf("response has no Access-Control-Allow-Origin header")
+			}
+		},
+	},
+}
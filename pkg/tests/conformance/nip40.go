@@ -0,0 +1,42 @@
+package conformance
+
+import (
+	"strconv"
+
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/timestamp"
+)
+
+func expiration(at int64) *tag.T {
+	return tag.New("expiration", strconv.FormatInt(at, 10))
+}
+
+var nip40 = []Case{
+	{
+		40, "expired event is not served", func(t *T) {
+			rl := t.Connect()
+			s := t.Signer()
+			now := timestamp.Now().I64()
+			// the relay may refuse the event or accept and not serve it.
+			_ = rl.Publish(
+				t.C, t.Event(
+					s, kind.TextNote.K, now-20, "expired", expiration(now-10),
+				),
+			)
+			t.Expect(t.Query(rl, byAuthor(s.Pub())))
+		},
+	},
+	{
+		40, "event that expires later is served", func(t *T) {
+			rl := t.Connect()
+			s := t.Signer()
+			now := timestamp.Now().I64()
+			ev := t.Event(
+				s, kind.TextNote.K, now, "expires", expiration(now+3600),
+			)
+			t.Publish(rl, ev)
+			t.Expect(t.Query(rl, byAuthor(s.Pub())), ev)
+		},
+	},
+}
@@ -0,0 +1,72 @@
+package conformance
+
+import (
+	"strings"
+
+	"orly.dev/pkg/crypto/p256k"
+	"orly.dev/pkg/encoders/envelopes/authenvelope"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/protocol/ws"
+)
+
+// giftWraps returns a filter for the gift wraps addressed to s, which are
+// only served to their recipient, so a relay that implements NIP-42 requires
+// authentication to serve them.
+func giftWraps(s *p256k.Signer) *filter.F {
+	return &filter.F{
+		Kinds: kinds.New(kind.GiftWrap),
+		Tags:  tags.New(tag.New("p", hex.Enc(s.Pub()))),
+	}
+}
+
+// requireAuth sends a REQ for the gift wraps of s, and skips the case if the
+// relay serves them without authentication. Otherwise the relay must close
+// the subscription with an auth-required reason, and has sent a challenge.
+func (t *T) requireAuth(rl *ws.Client, s *p256k.Signer) {
+	_, closed := t.Req(rl, giftWraps(s))
+	if closed == "" {
+		t.Skipf("relay serves gift wraps without authentication")
+	}
+	if !strings.HasPrefix(closed, "auth-required:") {
+		t.Fatalf("CLOSED reason '%s' doesn't start with 'auth-required:'", closed)
+	}
+}
+
+var nip42 = []Case{
+	{
+		42, "authentication allows a REQ that required it", func(t *T) {
+			rl := t.Connect()
+			s := t.Signer()
+			t.requireAuth(rl, s)
+			if err := rl.Auth(t.C, s); err != nil {
+				t.Fatalf("authentication failed: %v", err)
+			}
+			t.Query(rl, giftWraps(s))
+		},
+	},
+	{
+		42, "authentication with the wrong challenge is refused",
+		func(t *T) {
+			rl := t.Connect()
+			s := t.Signer()
+			t.requireAuth(rl, s)
+			ev := t.Event(
+				s, kind.ClientAuthentication.K, 0, "",
+				tag.New("relay", t.URL), tag.New("challenge", "not-the-challenge"),
+			)
+			if err := <-rl.Write(
+				authenvelope.NewResponseWith(ev).Marshal(nil),
+			); err != nil {
+				t.Fatalf("failed to send AUTH: %v", err)
+			}
+			if _, closed := t.Req(rl, giftWraps(s)); closed == "" {
+				t.Fatalf("gift wraps were served after the AUTH")
+			}
+		},
+	},
+}
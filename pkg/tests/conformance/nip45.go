@@ -0,0 +1,73 @@
+package conformance
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/protocol/ws"
+)
+
+// count sends a COUNT for f and returns the count of the response. The
+// client doesn't handle COUNT responses, so they are read by a custom handler
+// of the connection.
+func (t *T) count(f *filter.F) (n int) {
+	responses := make(chan []json.RawMessage, 1)
+	rl := t.Connect(
+		ws.WithCustomHandler(
+			func(msg string) {
+				var env []json.RawMessage
+				if err := json.Unmarshal([]byte(msg), &env); err != nil ||
+					len(env) < 3 {
+					return
+				}
+				var label string
+				if json.Unmarshal(env[0], &label); label == "COUNT" ||
+					label == "CLOSED" {
+					responses <- env
+				}
+			},
+		),
+	)
+	req := fmt.Sprintf(`["COUNT","count",%s]`, f.Marshal(nil))
+	if err := <-rl.Write([]byte(req)); err != nil {
+		t.Fatalf("failed to send COUNT: %v", err)
+	}
+	var env []json.RawMessage
+	select {
+	case env = <-responses:
+	case <-t.C.Done():
+		t.Fatalf("no response to COUNT before the timeout")
+	}
+	var label string
+	_ = json.Unmarshal(env[0], &label)
+	if label == "CLOSED" {
+		t.Fatalf("COUNT was closed: %s", env[2])
+	}
+	var res struct {
+		Count *int `json:"count"`
+	}
+	if err := json.Unmarshal(env[2], &res); err != nil || res.Count == nil {
+		t.Fatalf("COUNT response '%s' has no count", env[2])
+	}
+	return *res.Count
+}
+
+var nip45 = []Case{
+	{
+		45, "count of the matching events", func(t *T) {
+			rl := t.Connect()
+			s := t.Signer()
+			for i := range 3 {
+				t.Publish(
+					rl, t.Event(s, kind.TextNote.K, 0, fmt.Sprint("note ", i)),
+				)
+			}
+			t.Publish(rl, t.Event(s, kind.Reaction.K, 0, "+"))
+			if n := t.count(byAuthor(s.Pub(), kind.TextNote.K)); n != 3 {
+				t.Fatalf("count is %d, want 3", n)
+			}
+		},
+	},
+}
@@ -0,0 +1,25 @@
+package conformance
+
+import (
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/encoders/kind"
+)
+
+var nip50 = []Case{
+	{
+		50, "search matches the content", func(t *T) {
+			rl := t.Connect()
+			s := t.Signer()
+			// words that are in no other event, from part of the pubkey.
+			word := "conformance" + hex.Enc(s.Pub()[:8])
+			other := "conformance" + hex.Enc(s.Pub()[8:16])
+			ev := t.Event(s, kind.TextNote.K, 0, "a note about "+word)
+			t.Publish(rl, ev, t.Event(s, kind.TextNote.K, 0, "another note"))
+			f := byAuthor(s.Pub())
+			f.Search = []byte(word)
+			t.Expect(t.Query(rl, f), ev)
+			f.Search = []byte(other)
+			t.Expect(t.Query(rl, f))
+		},
+	},
+}
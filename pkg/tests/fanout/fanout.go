@@ -0,0 +1,104 @@
+// Package fanout provides an in-memory relay for tests of clients that only
+// exchange events through a relay, such as signers talking to each other.
+package fanout
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/websocket"
+
+	"orly.dev/pkg/encoders/envelopes"
+	"orly.dev/pkg/encoders/envelopes/closeenvelope"
+	"orly.dev/pkg/encoders/envelopes/eoseenvelope"
+	"orly.dev/pkg/encoders/envelopes/eventenvelope"
+	"orly.dev/pkg/encoders/envelopes/okenvelope"
+	"orly.dev/pkg/encoders/envelopes/reqenvelope"
+	"orly.dev/pkg/encoders/filters"
+)
+
+// Relay is an in-memory relay that stores nothing and forwards each
+// published event to the matching subscriptions.
+type Relay struct {
+	mx   sync.Mutex
+	subs map[*websocket.Conn]map[string]*filters.T
+	// Reqs receives a value for each subscription the relay has answered
+	// with EOSE, so a test can wait for its clients to be listening.
+	Reqs chan struct{}
+}
+
+// New starts a Relay that is closed when the test ends, and returns it with
+// its websocket URL.
+func New(t testing.TB) (r *Relay, url string) {
+	r = &Relay{
+		subs: make(map[*websocket.Conn]map[string]*filters.T),
+		Reqs: make(chan struct{}, 16),
+	}
+	srv := httptest.NewServer(
+		&websocket.Server{
+			Handshake: func(*websocket.Config, *http.Request) error { return nil },
+			Handler:   r.serve,
+		},
+	)
+	t.Cleanup(srv.Close)
+	url = "ws" + strings.TrimPrefix(srv.URL, "http")
+	return
+}
+
+func (r *Relay) send(conn *websocket.Conn, b []byte) {
+	_ = websocket.Message.Send(conn, string(b))
+}
+
+func (r *Relay) serve(conn *websocket.Conn) {
+	defer func() {
+		r.mx.Lock()
+		delete(r.subs, conn)
+		r.mx.Unlock()
+	}()
+	for {
+		var msg []byte
+		if err := websocket.Message.Receive(conn, &msg); err != nil {
+			return
+		}
+		label, rem, err := envelopes.Identify(msg)
+		if err != nil {
+			continue
+		}
+		r.mx.Lock()
+		switch label {
+		case reqenvelope.L:
+			env := reqenvelope.New()
+			if _, err = env.Unmarshal(rem); err == nil {
+				if r.subs[conn] == nil {
+					r.subs[conn] = make(map[string]*filters.T)
+				}
+				r.subs[conn][env.Subscription.String()] = env.Filters
+				r.send(conn, eoseenvelope.NewFrom(env.Subscription).Marshal(nil))
+				r.Reqs <- struct{}{}
+			}
+		case closeenvelope.L:
+			env := closeenvelope.New()
+			if _, err = env.Unmarshal(rem); err == nil {
+				delete(r.subs[conn], env.ID.String())
+			}
+		case eventenvelope.L:
+			env := eventenvelope.NewSubmission()
+			if _, err = env.Unmarshal(rem); err == nil {
+				r.send(conn, okenvelope.NewFrom(env.E.ID, true).Marshal(nil))
+				for c, subs := range r.subs {
+					for id, ff := range subs {
+						if !ff.Match(env.E) {
+							continue
+						}
+						res, _ := eventenvelope.NewResultWith(id, env.E)
+						r.send(c, res.Marshal(nil))
+					}
+				}
+			}
+		}
+		r.mx.Unlock()
+	}
+}
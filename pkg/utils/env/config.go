@@ -28,6 +28,10 @@ func GetEnv(path string) (env Env, err error) {
 			continue
 		}
 		line = strings.TrimSpace(line)
+		// skip comments, and lines that are not KEY=value.
+		if strings.HasPrefix(line, "#") || !strings.Contains(line, "=") {
+			continue
+		}
 		split := strings.SplitN(line, "=", 2)
 		env[strings.TrimSpace(split[0])] = strings.TrimSpace(split[1])
 	}
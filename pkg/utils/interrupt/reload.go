@@ -0,0 +1,34 @@
+package interrupt
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+
+	"orly.dev/pkg/utils/log"
+)
+
+// reloadSignals is the list of signals that request a reload of the
+// configuration, empty on platforms that don't have SIGHUP.
+var reloadSignals []os.Signal
+
+// AddReloadHandler adds a handler to call when a SIGHUP is received, which
+// conventionally asks a server to reload its configuration. The handler is
+// called each time the signal is received. On platforms without SIGHUP it is
+// never called.
+func AddReloadHandler(handler func()) {
+	if len(reloadSignals) == 0 {
+		return
+	}
+	_, loc, line, _ := runtime.Caller(1)
+	source := fmt.Sprintf("%s:%d", loc, line)
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, reloadSignals...)
+	go func() {
+		for range ch {
+			log.I.F("reload requested, running handler from %s", source)
+			handler()
+		}
+	}()
+}
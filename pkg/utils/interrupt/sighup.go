@@ -0,0 +1,12 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+
+package interrupt
+
+import (
+	"os"
+	"syscall"
+)
+
+func init() {
+	reloadSignals = []os.Signal{syscall.SIGHUP}
+}
@@ -1,11 +1,14 @@
 package keys
 
 import (
+	"strings"
+
 	"orly.dev/pkg/crypto/ec/bech32"
 	"orly.dev/pkg/encoders/bech32encoding"
 	"orly.dev/pkg/encoders/hex"
 	"orly.dev/pkg/utils"
 	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/errorf"
 	"orly.dev/pkg/utils/log"
 )
 
@@ -14,7 +17,7 @@ func DecodeNpubOrHex(v string) (pk []byte, err error) {
 	var bits5 []byte
 	if prf, bits5, err = bech32.DecodeNoLimit([]byte(v)); chk.D(err) {
 		// try hex then
-		if _, err = hex.DecBytes(pk, []byte(v)); chk.E(err) {
+		if pk, err = hex.Dec(v); chk.E(err) {
 			log.W.F(
 				"owner key %s is neither bech32 npub nor hex",
 				v,
@@ -42,7 +45,7 @@ func DecodeNsecOrHex(v string) (sk []byte, err error) {
 	var bits5 []byte
 	if prf, bits5, err = bech32.DecodeNoLimit([]byte(v)); chk.D(err) {
 		// try hex then
-		if _, err = hex.DecBytes(sk, []byte(v)); chk.E(err) {
+		if sk, err = hex.Dec(v); chk.E(err) {
 			log.W.F(
 				"owner key %s is neither bech32 nsec nor hex",
 				v,
@@ -63,3 +66,37 @@ func DecodeNsecOrHex(v string) (sk []byte, err error) {
 	}
 	return
 }
+
+// DecodeSecret decodes a secret key given as a bech32 nsec, hex, or a NIP-49
+// ncryptsec. pass is only called for an ncryptsec, to get its passphrase, and
+// may be nil if encrypted keys are not accepted.
+func DecodeSecret(v string, pass func() (string, error)) (
+	sk []byte, err error,
+) {
+	v = strings.TrimSpace(v)
+	switch {
+	case bech32encoding.IsNcryptsec(v):
+		if pass == nil {
+			err = errorf.E("encrypted secret keys are not accepted here")
+			return
+		}
+		var p string
+		if p, err = pass(); err != nil {
+			return
+		}
+		if sk, _, err = bech32encoding.DecryptSecretKey([]byte(v), p); err != nil {
+			return
+		}
+	case strings.HasPrefix(v, string(bech32encoding.NsecHRP)):
+		if sk, err = bech32encoding.NsecToBytes([]byte(v)); err != nil {
+			err = errorf.E("invalid nsec: %v", err)
+			return
+		}
+	default:
+		if sk, err = hex.Dec(v); err != nil || len(sk) != 32 {
+			err = errorf.E("secret key is neither nsec, ncryptsec nor 64 character hex")
+			return
+		}
+	}
+	return
+}
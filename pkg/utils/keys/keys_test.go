@@ -0,0 +1,48 @@
+package keys
+
+import (
+	"bytes"
+	"testing"
+
+	"orly.dev/pkg/encoders/bech32encoding"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/utils/errorf"
+)
+
+func TestDecodeSecret(t *testing.T) {
+	sk := bytes.Repeat([]byte{7}, 32)
+	nsec, err := bech32encoding.BinToNsec(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := bech32encoding.EncryptSecretKey(
+		sk, "passphrase", 4, bech32encoding.KeySecuritySecure,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pass := func() (string, error) { return "passphrase", nil }
+	for _, v := range []string{hex.Enc(sk), string(nsec), string(enc)} {
+		var got []byte
+		if got, err = DecodeSecret(v, pass); err != nil {
+			t.Fatalf("%s: %v", v, err)
+		}
+		if !bytes.Equal(got, sk) {
+			t.Fatalf("%s: decoded %0x", v, got)
+		}
+	}
+	if _, err = DecodeSecret(string(enc), nil); err == nil {
+		t.Fatal("expected an ncryptsec without a passphrase source to fail")
+	}
+	wrong := func() (string, error) { return "wrong", nil }
+	if _, err = DecodeSecret(string(enc), wrong); err == nil {
+		t.Fatal("expected a wrong passphrase to fail")
+	}
+	failing := func() (string, error) { return "", errorf.E("no terminal") }
+	if _, err = DecodeSecret(string(enc), failing); err == nil {
+		t.Fatal("expected the passphrase error to be returned")
+	}
+	if _, err = DecodeSecret("abcd", nil); err == nil {
+		t.Fatal("expected a short hex key to fail")
+	}
+}
@@ -0,0 +1,338 @@
+// Package metrics provides counters, gauges and histograms with labels that
+// are registered by name when they are created, and written out together in
+// the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefBuckets are the default histogram buckets, in seconds, which suit the
+// latency of requests and queries.
+var DefBuckets = []float64{
+	.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10,
+}
+
+// metric is a registered metric.
+type metric interface {
+	write(w io.Writer)
+}
+
+var registry = struct {
+	sync.Mutex
+	metrics    map[string]metric
+	collectors map[string]func()
+}{
+	metrics:    make(map[string]metric),
+	collectors: make(map[string]func()),
+}
+
+// register adds m to the registry, replacing any metric with the same name.
+func register(name string, m metric) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.metrics[name] = m
+}
+
+// Collect registers fn to be called before the metrics are written, to set
+// gauges whose values are read from elsewhere, such as the size of a
+// database. A later call with the same name replaces fn.
+func Collect(name string, fn func()) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.collectors[name] = fn
+}
+
+// Write runs the collectors, and writes all the metrics that have values in
+// the Prometheus text exposition format, sorted by name.
+func Write(w io.Writer) {
+	registry.Lock()
+	collectors := make([]func(), 0, len(registry.collectors))
+	for _, fn := range registry.collectors {
+		collectors = append(collectors, fn)
+	}
+	names := make([]string, 0, len(registry.metrics))
+	for name := range registry.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metrics := make([]metric, len(names))
+	for i, name := range names {
+		metrics[i] = registry.metrics[name]
+	}
+	registry.Unlock()
+	for _, fn := range collectors {
+		fn()
+	}
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// desc is the name, help text, type and label names of a metric.
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+// header writes the HELP and TYPE lines of the metric.
+func (d *desc) header(w io.Writer) {
+	_, _ = fmt.Fprintf(
+		w, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, d.typ,
+	)
+}
+
+// labelString formats the label names of the metric with values, and any
+// extra name/value pairs, as {name="value",...}.
+func (d *desc) labelString(values []string, extra ...string) string {
+	if len(d.labels) == 0 && len(extra) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	pair := func(name, value string) {
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(escaper.Replace(value))
+		b.WriteByte('"')
+	}
+	for i, name := range d.labels {
+		var v string
+		if i < len(values) {
+			v = values[i]
+		}
+		pair(name, v)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pair(extra[i], extra[i+1])
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// key returns the key of a series in the map of a metric.
+func key(values []string) string { return strings.Join(values, "\xff") }
+
+// formatFloat formats a value the way Prometheus expects.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// series is the value of a counter or gauge for one set of label values.
+type series struct {
+	values []string
+	v      float64
+}
+
+// value is the shared implementation of Counter and Gauge.
+type value struct {
+	desc
+	mx     sync.Mutex
+	series map[string]*series
+}
+
+func newValue(typ, name, help string, labels []string) (v *value) {
+	v = &value{
+		desc: desc{
+			name: name, help: help, typ: typ, labels: labels,
+		},
+		series: make(map[string]*series),
+	}
+	register(name, v)
+	return
+}
+
+// get returns the series of the label values, creating it if it doesn't
+// exist. It must be called with mx locked.
+func (v *value) get(values []string) (s *series) {
+	k := key(values)
+	if s = v.series[k]; s == nil {
+		s = &series{values: append([]string(nil), values...)}
+		v.series[k] = s
+	}
+	return
+}
+
+func (v *value) write(w io.Writer) {
+	v.mx.Lock()
+	defer v.mx.Unlock()
+	if len(v.series) == 0 {
+		return
+	}
+	v.header(w)
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := v.series[k]
+		_, _ = fmt.Fprintf(
+			w, "%s%s %s\n", v.name, v.labelString(s.values), formatFloat(s.v),
+		)
+	}
+}
+
+// Counter is a value that only goes up, such as a number of events received.
+type Counter struct{ *value }
+
+// NewCounter creates and registers a counter with the given label names.
+func NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{newValue("counter", name, help, labels)}
+}
+
+// Add adds n, which must not be negative, to the counter of the label values.
+func (c *Counter) Add(n float64, values ...string) {
+	if n < 0 {
+		return
+	}
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.get(values).v += n
+}
+
+// Inc adds one to the counter of the label values.
+func (c *Counter) Inc(values ...string) { c.Add(1, values...) }
+
+// Gauge is a value that goes up and down, such as a number of connections.
+type Gauge struct{ *value }
+
+// NewGauge creates and registers a gauge with the given label names.
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{newValue("gauge", name, help, labels)}
+}
+
+// Set sets the gauge of the label values to n.
+func (g *Gauge) Set(n float64, values ...string) {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	g.get(values).v = n
+}
+
+// Add adds n to the gauge of the label values.
+func (g *Gauge) Add(n float64, values ...string) {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	g.get(values).v += n
+}
+
+// Inc adds one to the gauge of the label values.
+func (g *Gauge) Inc(values ...string) { g.Add(1, values...) }
+
+// Dec subtracts one from the gauge of the label values.
+func (g *Gauge) Dec(values ...string) { g.Add(-1, values...) }
+
+// histogram is the distribution of a Histogram for one set of label values.
+type histogram struct {
+	values []string
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Histogram counts observations, such as durations, in buckets.
+type Histogram struct {
+	desc
+	buckets []float64
+	mx      sync.Mutex
+	series  map[string]*histogram
+}
+
+// NewHistogram creates and registers a histogram with the given upper bounds
+// of its buckets, which must be sorted, and label names.
+func NewHistogram(
+	name, help string, buckets []float64, labels ...string,
+) (h *Histogram) {
+	h = &Histogram{
+		desc: desc{
+			name: name, help: help, typ: "histogram", labels: labels,
+		},
+		buckets: buckets,
+		series:  make(map[string]*histogram),
+	}
+	register(name, h)
+	return
+}
+
+// Observe adds v to the histogram of the label values.
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	k := key(values)
+	s := h.series[k]
+	if s == nil {
+		s = &histogram{
+			values: append([]string(nil), values...),
+			counts: make([]uint64, len(h.buckets)),
+		}
+		h.series[k] = s
+	}
+	for i, le := range h.buckets {
+		if v <= le {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+// Since observes the seconds since start, as in
+//
+//	defer h.Since(time.Now(), "label")
+func (h *Histogram) Since(start time.Time, values ...string) {
+	h.Observe(time.Since(start).Seconds(), values...)
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	if len(h.series) == 0 {
+		return
+	}
+	h.header(w)
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := h.series[k]
+		for i, le := range h.buckets {
+			_, _ = fmt.Fprintf(
+				w, "%s_bucket%s %d\n", h.name,
+				h.labelString(s.values, "le", formatFloat(le)), s.counts[i],
+			)
+		}
+		_, _ = fmt.Fprintf(
+			w, "%s_bucket%s %d\n", h.name,
+			h.labelString(s.values, "le", "+Inf"), s.count,
+		)
+		_, _ = fmt.Fprintf(
+			w, "%s_sum%s %s\n", h.name, h.labelString(s.values),
+			formatFloat(s.sum),
+		)
+		_, _ = fmt.Fprintf(
+			w, "%s_count%s %d\n", h.name, h.labelString(s.values), s.count,
+		)
+	}
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	c := NewCounter("test_events_total", "events", "kind")
+	c.Inc("1")
+	c.Add(2, "1")
+	c.Inc(`a"b`)
+	g := NewGauge("test_connections", "connections")
+	g.Inc()
+	g.Inc()
+	g.Dec()
+	h := NewHistogram("test_seconds", "durations", []float64{.1, 1})
+	h.Observe(.05)
+	h.Observe(.5)
+	h.Observe(5)
+	NewGauge("test_unused", "never set")
+	var collected bool
+	Collect("test", func() { collected = true })
+	var b bytes.Buffer
+	Write(&b)
+	out := b.String()
+	for _, line := range []string{
+		"# TYPE test_events_total counter",
+		`test_events_total{kind="1"} 3`,
+		`test_events_total{kind="a\"b"} 1`,
+		"test_connections 1",
+		`test_seconds_bucket{le="0.1"} 1`,
+		`test_seconds_bucket{le="1"} 2`,
+		`test_seconds_bucket{le="+Inf"} 3`,
+		"test_seconds_sum 5.55",
+		"test_seconds_count 3",
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %q in\n%s", line, out)
+		}
+	}
+	if strings.Contains(out, "test_unused") {
+		t.Errorf("metric without values was written:\n%s", out)
+	}
+	if !collected {
+		t.Error("collector was not run")
+	}
+}
@@ -0,0 +1,128 @@
+// Package passphrase reads the passphrases of encrypted keys from the
+// terminal without echoing them.
+package passphrase
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/errorf"
+)
+
+// Read prints the prompt to stderr and reads a passphrase from the terminal
+// without echo. If stdin is not a terminal a line is read from it instead, so
+// a passphrase can be piped in.
+func Read(prompt string) (pass string, err error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return readLine(os.Stdin)
+	}
+	_, _ = fmt.Fprint(os.Stderr, prompt)
+	var b []byte
+	b, err = term.ReadPassword(fd)
+	_, _ = fmt.Fprintln(os.Stderr)
+	if chk.E(err) {
+		return
+	}
+	pass = string(b)
+	return
+}
+
+// ReadNew reads a passphrase to encrypt with, asking for it twice on a
+// terminal to catch typing mistakes.
+func ReadNew(prompt string) (pass string, err error) {
+	if pass, err = Read(prompt); err != nil {
+		return
+	}
+	if pass == "" {
+		err = errorf.E("empty passphrase")
+		return
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return
+	}
+	var again string
+	if again, err = Read("repeat " + prompt); err != nil {
+		return
+	}
+	if again != pass {
+		err = errorf.E("passphrases do not match")
+		return
+	}
+	return
+}
+
+// FromFD reads a passphrase as a single line from the open file descriptor
+// fd, such as one a shell opens with 3<passfile, so it never appears in the
+// environment or the arguments of a process.
+func FromFD(fd int) (pass string, err error) {
+	f := os.NewFile(uintptr(fd), "passphrase")
+	if f == nil {
+		err = errorf.E("invalid passphrase file descriptor %d", fd)
+		return
+	}
+	if fd != int(os.Stdin.Fd()) {
+		defer f.Close()
+	}
+	return readLine(f)
+}
+
+// Get reads a passphrase from the file descriptor fd, or with Read and the
+// prompt if fd is negative.
+func Get(fd int, prompt string) (pass string, err error) {
+	if fd < 0 {
+		return Read(prompt)
+	}
+	return FromFD(fd)
+}
+
+// GetNew reads a passphrase to encrypt with from the file descriptor fd, or
+// with ReadNew and the prompt if fd is negative.
+func GetNew(fd int, prompt string) (pass string, err error) {
+	if fd < 0 {
+		return ReadNew(prompt)
+	}
+	if pass, err = FromFD(fd); err != nil {
+		return
+	}
+	if pass == "" {
+		err = errorf.E("empty passphrase")
+		return
+	}
+	return
+}
+
+// EnvFD returns the file descriptor number in the environment variable name,
+// or -1 if it is not set.
+func EnvFD(name string) (fd int, err error) {
+	v := os.Getenv(name)
+	if v == "" {
+		fd = -1
+		return
+	}
+	if fd, err = strconv.Atoi(v); err != nil || fd < 0 {
+		err = errorf.E("%s must be a file descriptor number, got '%s'", name, v)
+		return
+	}
+	return
+}
+
+// readLine reads a single line from r, without the line ending.
+func readLine(r io.Reader) (line string, err error) {
+	if line, err = bufio.NewReader(r).ReadString('\n'); err != nil {
+		if err != io.EOF || line == "" {
+			err = errorf.E("failed to read passphrase: %v", err)
+			return
+		}
+		err = nil
+	}
+	line = strings.TrimRight(line, "\r\n")
+	return
+}
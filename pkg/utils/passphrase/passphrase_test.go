@@ -0,0 +1,39 @@
+package passphrase
+
+import (
+	"os"
+	"testing"
+)
+
+func TestFromFD(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.WriteString("correct horse\r\nnext line\n"); err != nil {
+		t.Fatal(err)
+	}
+	_ = w.Close()
+	var pass string
+	if pass, err = Get(int(r.Fd()), ""); err != nil {
+		t.Fatal(err)
+	}
+	if pass != "correct horse" {
+		t.Fatalf("got passphrase %q", pass)
+	}
+}
+
+func TestEnvFD(t *testing.T) {
+	t.Setenv("TEST_PASSPHRASE_FD", "")
+	if fd, err := EnvFD("TEST_PASSPHRASE_FD"); err != nil || fd != -1 {
+		t.Fatalf("expected -1 for an unset variable, got %d %v", fd, err)
+	}
+	t.Setenv("TEST_PASSPHRASE_FD", "3")
+	if fd, err := EnvFD("TEST_PASSPHRASE_FD"); err != nil || fd != 3 {
+		t.Fatalf("expected 3, got %d %v", fd, err)
+	}
+	t.Setenv("TEST_PASSPHRASE_FD", "stdin")
+	if _, err := EnvFD("TEST_PASSPHRASE_FD"); err == nil {
+		t.Fatal("expected a non number to fail")
+	}
+}
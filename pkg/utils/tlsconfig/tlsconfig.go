@@ -0,0 +1,81 @@
+// Package tlsconfig builds TLS configurations that serve certificates from
+// files, falling back to certificates issued automatically by LetsEncrypt.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/errorf"
+	"orly.dev/pkg/utils/log"
+)
+
+// New returns a tls.Config that serves the provided certificates for the
+// domains they match, and gets the certificates of other domains from the
+// LetsEncrypt automatic SSL cert issuer m.
+//
+// # Parameters
+//
+//   - m: The autocert manager, or nil to only serve the provided certificates.
+//
+//   - certs: Certificates in the form "example.com:/path/to/cert", which loads
+//     /path/to/cert.crt and /path/to/cert.key, each PEM encoded.
+//
+// # Expected Behaviour
+//
+// An exact match of the server name is preferred, then a domain the server
+// name ends with, so a certificate for a subdomain takes priority over a
+// wildcard certificate for its parent. Without m, a single certificate is also
+// served to clients that don't send a matching server name.
+func New(m *autocert.Manager, certs ...string) (tc *tls.Config) {
+	certMap := make(map[string]*tls.Certificate)
+	for _, cert := range certs {
+		split := strings.SplitN(cert, ":", 2)
+		if len(split) != 2 {
+			log.E.F("invalid certificate parameter format: `%s`", cert)
+			continue
+		}
+		var err error
+		var c tls.Certificate
+		if c, err = tls.LoadX509KeyPair(
+			split[1]+".crt", split[1]+".key",
+		); chk.E(err) {
+			continue
+		}
+		certMap[split[0]] = &c
+	}
+	if m != nil {
+		tc = m.TLSConfig()
+	} else {
+		tc = &tls.Config{}
+	}
+	tc.GetCertificate = func(helo *tls.ClientHelloInfo) (
+		cert *tls.Certificate, err error,
+	) {
+		if cert = certMap[helo.ServerName]; cert != nil {
+			return
+		}
+		for name, c := range certMap {
+			// if it got to us and ends in the same name dot tld assume the
+			// subdomain was redirected or it's a wildcard certificate, thus only
+			// the ending needs to match.
+			if strings.HasSuffix(helo.ServerName, name) {
+				return c, nil
+			}
+		}
+		if m != nil {
+			return m.GetCertificate(helo)
+		}
+		if len(certMap) == 1 {
+			for _, c := range certMap {
+				return c, nil
+			}
+		}
+		err = errorf.E("no certificate for '%s'", helo.ServerName)
+		return
+	}
+	return
+}
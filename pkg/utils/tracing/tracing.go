@@ -0,0 +1,112 @@
+// Package tracing provides OpenTelemetry tracing spans for the request path of
+// the relay, which are only recorded and exported when Init has been called,
+// and otherwise cost next to nothing.
+package tracing
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+)
+
+// Name is the name of the tracer of the relay.
+const Name = "orly.dev"
+
+// Init makes exp the destination of the spans of the relay.
+//
+// # Parameters
+//
+//   - exp: The exporter, such as the one from OTLP, or an in-memory exporter
+//     in tests.
+//
+//   - service: The service name the spans are reported under.
+//
+//   - percent: The percentage of traces that are recorded, from 0 to 100.
+//
+// # Return Values
+//
+//   - tp: The tracer provider, whose Shutdown flushes and stops the exporter.
+//
+// # Expected Behaviour
+//
+// Spans are exported in batches. A span whose parent is sampled is always
+// sampled, so a trace is either recorded whole or not at all.
+func Init(
+	exp sdktrace.SpanExporter, service string, percent int,
+) (tp *sdktrace.TracerProvider) {
+	tp = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithSampler(
+			sdktrace.ParentBased(
+				sdktrace.TraceIDRatioBased(float64(percent)/100),
+			),
+		),
+		sdktrace.WithResource(
+			resource.NewSchemaless(semconv.ServiceName(service)),
+		),
+	)
+	otel.SetTracerProvider(tp)
+	return
+}
+
+// OTLP returns an exporter that sends spans to an OpenTelemetry collector
+// with OTLP over HTTP.
+//
+// # Parameters
+//
+//   - c: The context of the connection to the collector.
+//
+//   - endpoint: The URL of the collector, such as http://localhost:4318, to
+//     which /v1/traces is added.
+func OTLP(c context.T, endpoint string) (exp sdktrace.SpanExporter, err error) {
+	if exp, err = otlptracehttp.New(
+		c, otlptracehttp.WithEndpointURL(endpoint+"/v1/traces"),
+	); chk.E(err) {
+		return
+	}
+	return
+}
+
+// Start starts a span with the given attributes, whose context is returned to
+// be passed to the functions it calls. The caller must End the span.
+func Start(
+	c context.T, name string, attrs ...attribute.KeyValue,
+) (context.T, trace.Span) {
+	return otel.Tracer(Name).Start(c, name, trace.WithAttributes(attrs...))
+}
+
+// Error records err on span, if it is not nil.
+func Error(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// Filter returns attributes that describe the shape of a filter, the number of
+// each of its fields and whether it has a time range, search or limit, without
+// the values of the fields.
+func Filter(f *filter.F) (attrs []attribute.KeyValue) {
+	attrs = []attribute.KeyValue{
+		attribute.Int("filter.ids", f.Ids.Len()),
+		attribute.Int("filter.kinds", f.Kinds.Len()),
+		attribute.Int("filter.authors", f.Authors.Len()),
+		attribute.Int("filter.tags", f.Tags.Len()),
+		attribute.Bool("filter.since", f.Since != nil),
+		attribute.Bool("filter.until", f.Until != nil),
+		attribute.Bool("filter.search", len(f.Search) > 0),
+	}
+	if f.Limit != nil {
+		attrs = append(attrs, attribute.Int("filter.limit", int(*f.Limit)))
+	}
+	return
+}